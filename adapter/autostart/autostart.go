@@ -0,0 +1,225 @@
+package autostart
+
+// runs isetta non-interactively whenever the WSL distro starts. With systemd
+// running, a oneshot unit is installed, otherwise the boot command in
+// /etc/wsl.conf. The boot run has no sudo, so the environment of the calling
+// user is passed along: the config, the state and the log file are found in
+// the user's home like for 'sudo isetta'.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/inifile"
+	"org.samba/isetta/safefile"
+	"org.samba/isetta/shellquote"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/userdir"
+)
+
+const WslConfPath = "/etc/wsl.conf"
+const UnitPath = "/etc/systemd/system/isetta.service"
+const unitName = "isetta.service"
+
+// flag of the boot run, also identifies the boot command of isetta
+const NonInteractiveFlag = "-non-interactive"
+
+const systemctlTimeout = 30 * time.Second
+
+// exists if systemd is PID 1, see sd_booted(3)
+var systemdRuntimeDir = "/run/systemd/system"
+
+type Autostart struct {
+	// of the isetta binary, default: the running one
+	Executable  string
+	WslConfPath string
+	UnitPath    string
+}
+
+func New() Autostart {
+	return Autostart{WslConfPath: WslConfPath, UnitPath: UnitPath}
+}
+
+// Installs the unit or the boot command, whichever fits, and removes the
+// other one. Returns where isetta was installed
+func (a Autostart) Enable(ctx context.Context) (string, error) {
+	command, env, err := a.command()
+	if err != nil {
+		return "", err
+	}
+
+	if isSystemdRunning() {
+		err = a.enableUnit(ctx, command, env)
+		if err != nil {
+			return "", err
+		}
+		return a.UnitPath, a.removeBootCommand(ctx)
+	}
+
+	err = a.setBootCommand(ctx, bootCommand(command, env))
+	if err != nil {
+		return "", err
+	}
+	return a.WslConfPath, a.disableUnit(ctx)
+}
+
+// removes both the unit and the boot command
+func (a Autostart) Disable(ctx context.Context) error {
+	return errors.Join(a.disableUnit(ctx), a.removeBootCommand(ctx))
+}
+
+// e.g. '/usr/local/bin/isetta -non-interactive' and 'HOME=/home/peter', 'SUDO_USER=peter', ...
+func (a Autostart) command() (command string, env []string, err error) {
+	executable := a.Executable
+	if executable == "" {
+		executable, err = os.Executable()
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to determine the path of isetta: %w", err)
+		}
+	}
+
+	env, err = userdir.SudoEnv()
+	if err != nil {
+		return "", nil, err
+	}
+	return executable + " " + NonInteractiveFlag, env, nil
+}
+
+// WSL runs the boot command via a shell, e.g. a home with a space stays one
+// assignment
+func bootCommand(command string, env []string) string {
+	words := []string{"env"}
+	for _, v := range env {
+		words = append(words, shellquote.Quote(v))
+	}
+	return strings.Join(append(words, command), " ")
+}
+
+func isSystemdRunning() bool {
+	info, err := os.Stat(systemdRuntimeDir)
+	return err == nil && info.IsDir()
+}
+
+// WSL runs a single boot command, a foreign one is not replaced
+func (a Autostart) setBootCommand(ctx context.Context, command string) error {
+	file, err := loadIniFile(a.WslConfPath)
+	if err != nil {
+		return err
+	}
+	if existing, found := file.Get("boot", "command"); found && existing != "" && !isOwnBootCommand(existing) {
+		return fmt.Errorf("%v already runs the boot command '%v'. WSL supports only one, add '%v' to it manually", a.WslConfPath, existing, command)
+	}
+
+	log.FromContext(ctx).Debug("Setting boot command in %v", a.WslConfPath)
+	file.Set("boot", "command", command)
+	return safefile.Write(ctx, a.WslConfPath, file.Bytes(), 0644)
+}
+
+func (a Autostart) removeBootCommand(ctx context.Context) error {
+	file, err := loadIniFile(a.WslConfPath)
+	if err != nil {
+		return err
+	}
+	existing, found := file.Get("boot", "command")
+	if !found || !isOwnBootCommand(existing) {
+		return nil
+	}
+
+	log.FromContext(ctx).Debug("Removing boot command from %v", a.WslConfPath)
+	file.Delete("boot", "command")
+	return safefile.Write(ctx, a.WslConfPath, file.Bytes(), 0644)
+}
+
+func isOwnBootCommand(command string) bool {
+	return strings.Contains(command, "isetta") && strings.Contains(command, NonInteractiveFlag)
+}
+
+// a missing file is empty
+func loadIniFile(path string) (*inifile.File, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return inifile.Parse(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	return inifile.Parse(content), nil
+}
+
+// after the network and resolved are up, so the detection sees the final state
+func unit(command string, env []string) string {
+	lines := []string{
+		"# generated by 'isetta autostart enable'",
+		"[Unit]",
+		"Description=Configure the WSL network for the corporate network",
+		"Wants=network-online.target",
+		"After=network-online.target systemd-resolved.service",
+		"",
+		"[Service]",
+		"Type=oneshot",
+	}
+	for _, v := range env {
+		lines = append(lines, "Environment="+quoteEnvironment(v))
+	}
+	lines = append(lines,
+		"ExecStart="+command,
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// one quoted assignment, see systemd.exec(5). '%' starts a specifier in units
+func quoteEnvironment(assignment string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(assignment) + `"`
+}
+
+func (a Autostart) enableUnit(ctx context.Context, command string, env []string) error {
+	log.FromContext(ctx).Debug("Installing %v", a.UnitPath)
+	err := safefile.Write(ctx, a.UnitPath, []byte(unit(command, env)), 0644)
+	if err != nil {
+		return err
+	}
+	err = systemctl(ctx, "daemon-reload")
+	if err != nil {
+		return err
+	}
+	return systemctl(ctx, "enable", unitName)
+}
+
+// nothing to do without the unit
+func (a Autostart) disableUnit(ctx context.Context) error {
+	_, err := os.Stat(a.UnitPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	log.FromContext(ctx).Debug("Removing %v", a.UnitPath)
+	if isSystemdRunning() {
+		err = systemctl(ctx, "disable", unitName)
+		if err != nil {
+			return err
+		}
+	}
+	err = safefile.Remove(ctx, a.UnitPath)
+	if err != nil || !isSystemdRunning() {
+		return err
+	}
+	return systemctl(ctx, "daemon-reload")
+}
+
+func systemctl(ctx context.Context, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, systemctlTimeout)
+	defer cancel()
+	out, err := cmdrunner.Run(ctx, "systemctl", args...)
+	if err != nil {
+		return fmt.Errorf("systemctl %v failed: %v, error was: %w", strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package autostart
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/safefile"
+)
+
+// records the systemctl commands
+type fakeSystemctl struct {
+	commands []string
+}
+
+func (f *fakeSystemctl) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	f.commands = append(f.commands, cmd.String())
+	return nil, nil
+}
+
+// files, backups and systemd live in temporary directories
+func setupAutostart(t *testing.T, systemdRunning bool) (Autostart, *fakeSystemctl) {
+	original := safefile.Default
+	safefile.Default = safefile.Store{Dir: t.TempDir(), Keep: safefile.DefaultKeep}
+	t.Cleanup(func() { safefile.Default = original })
+
+	originalDir := systemdRuntimeDir
+	systemdRuntimeDir = filepath.Join(t.TempDir(), "system")
+	if systemdRunning {
+		os.Mkdir(systemdRuntimeDir, 0755)
+	}
+	t.Cleanup(func() { systemdRuntimeDir = originalDir })
+
+	fake := &fakeSystemctl{}
+	cmdrunner.Default = fake
+	t.Cleanup(func() { cmdrunner.Default = cmdrunner.ExecRunner{} })
+
+	t.Setenv("SUDO_USER", "")
+	dir := t.TempDir()
+	return Autostart{
+		Executable:  "/usr/local/bin/isetta",
+		WslConfPath: filepath.Join(dir, "wsl.conf"),
+		UnitPath:    filepath.Join(dir, "isetta.service"),
+	}, fake
+}
+
+func TestEnableSetsBootCommandWithoutSystemd(t *testing.T) {
+	a, fake := setupAutostart(t, false)
+	os.WriteFile(a.WslConfPath, []byte("[network]\ngenerateResolvConf = false\n"), 0644)
+
+	path, err := a.Enable(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, a.WslConfPath, path)
+
+	content, _ := os.ReadFile(a.WslConfPath)
+	assert.Contains(t, string(content), "[network]\ngenerateResolvConf = false\n\n[boot]\ncommand = env HOME=")
+	assert.Contains(t, string(content), " /usr/local/bin/isetta -non-interactive\n")
+	assert.NoFileExists(t, a.UnitPath)
+	assert.Empty(t, fake.commands)
+}
+
+func TestForeignBootCommandIsKept(t *testing.T) {
+	a, _ := setupAutostart(t, false)
+	os.WriteFile(a.WslConfPath, []byte("[boot]\ncommand=service docker start\n"), 0644)
+
+	_, err := a.Enable(context.Background())
+	assert.ErrorContains(t, err, "already runs the boot command 'service docker start'")
+
+	assert.NoError(t, a.Disable(context.Background()))
+	content, _ := os.ReadFile(a.WslConfPath)
+	assert.Equal(t, "[boot]\ncommand=service docker start\n", string(content))
+}
+
+func TestEnableInstallsUnitWithSystemd(t *testing.T) {
+	a, fake := setupAutostart(t, true)
+
+	path, err := a.Enable(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, a.UnitPath, path)
+
+	content, _ := os.ReadFile(a.UnitPath)
+	assert.Contains(t, string(content), "Type=oneshot\nEnvironment=\"HOME=")
+	assert.Contains(t, string(content), "ExecStart=/usr/local/bin/isetta -non-interactive\n")
+	assert.Equal(t, []string{"systemctl daemon-reload", "systemctl enable isetta.service"}, fake.commands)
+	assert.NoFileExists(t, a.WslConfPath)
+}
+
+func TestEnvironmentIsQuoted(t *testing.T) {
+	env := []string{"HOME=/home/o'neil smith", "SUDO_USER=peter"}
+
+	assert.Equal(t, `env 'HOME=/home/o'\''neil smith' SUDO_USER=peter /usr/local/bin/isetta -non-interactive`, bootCommand("/usr/local/bin/isetta -non-interactive", env))
+	assert.Contains(t, unit("/usr/local/bin/isetta -non-interactive", []string{`HOME=/home/50% "off"`}), "Environment=\"HOME=/home/50%% \\\"off\\\"\"\n")
+}
+
+func TestDisableRemovesUnitAndBootCommand(t *testing.T) {
+	a, fake := setupAutostart(t, true)
+	a.Enable(context.Background())
+	os.WriteFile(a.WslConfPath, []byte("[boot]\nsystemd=true\ncommand=env HOME=/root /usr/bin/isetta -non-interactive\n"), 0644)
+	fake.commands = nil
+
+	assert.NoError(t, a.Disable(context.Background()))
+
+	assert.NoFileExists(t, a.UnitPath)
+	content, _ := os.ReadFile(a.WslConfPath)
+	assert.Equal(t, "[boot]\nsystemd=true\n", string(content))
+	assert.Equal(t, []string{"systemctl disable isetta.service", "systemctl daemon-reload"}, fake.commands)
+}
@@ -0,0 +1,62 @@
+package dnsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+)
+
+// with systemd-resolved, the drop-in holds the DNS server instead
+func (DnsConfigurerImpl) BackupResolvConf(ctx context.Context) (model.FileBackup, error) {
+	if isManagedByResolved(ctx, ResolvConfPath) {
+		return backupFile(ResolvedDropInPath)
+	}
+	return backupFile(ResolvConfPath)
+}
+
+func (DnsConfigurerImpl) BackupWslConf() (model.FileBackup, error) {
+	return backupFile(WslConfPath)
+}
+
+func (DnsConfigurerImpl) RestoreFile(ctx context.Context, backup model.FileBackup) error {
+	err := restoreFile(ctx, backup)
+	if err == nil && backup.Path == ResolvedDropInPath {
+		err = restartResolved(ctx)
+	}
+	return err
+}
+
+func backupFile(path string) (model.FileBackup, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return model.FileBackup{Path: path, Existed: false}, nil
+	} else if err != nil {
+		return model.FileBackup{}, fmt.Errorf("unable to read file %v, Error was: %w", path, err)
+	}
+	target, _ := os.Readlink(path)
+	return model.FileBackup{Path: path, Content: content, Existed: true, Symlink: target}, nil
+}
+
+// writes through symlinks, e.g. a resolv.conf managed by WSL. A symlink
+// replaced in the meantime is recreated instead. The content before the
+// rollback is backed up as well
+func restoreFile(ctx context.Context, backup model.FileBackup) error {
+	if backup.Symlink != "" {
+		if target, err := os.Readlink(backup.Path); err != nil || target != backup.Symlink {
+			log.FromContext(ctx).Debug("Restoring symlink %v to %v", backup.Path, backup.Symlink)
+			return safefile.Symlink(ctx, backup.Symlink, backup.Path)
+		}
+	}
+	if !backup.Existed {
+		log.FromContext(ctx).Debug("Removing %v, it didn't exist before", backup.Path)
+		return safefile.Remove(ctx, backup.Path)
+	}
+
+	log.FromContext(ctx).Debug("Restoring previous content of %v", backup.Path)
+	return safefile.Write(ctx, backup.Path, backup.Content, 0644)
+}
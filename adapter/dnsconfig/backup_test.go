@@ -0,0 +1,36 @@
+package dnsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAndRestoreFile(t *testing.T) {
+	useTempBackups(t)
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644)
+
+	backup, err := backupFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, setServer(ctx, path, "8.8.8.8"))
+
+	assert.NoError(t, restoreFile(ctx, backup))
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "nameserver 1.1.1.1\n", string(content))
+}
+
+func TestRestoreRemovesFileWhichDidNotExist(t *testing.T) {
+	useTempBackups(t)
+	path := filepath.Join(t.TempDir(), "wsl.conf")
+
+	backup, err := backupFile(path)
+	assert.NoError(t, err)
+	assert.False(t, backup.Existed)
+	assert.NoError(t, disableResolvConfGenerationForFile(ctx, path))
+
+	assert.NoError(t, restoreFile(ctx, backup))
+	assert.NoFileExists(t, path)
+}
@@ -1,44 +1,91 @@
 package dnsconfig
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 
-	"gopkg.in/ini.v1"
-	"org.samba/isetta/helper"
+	"org.samba/isetta/inifile"
+	"org.samba/isetta/safefile"
 	log "org.samba/isetta/simplelogger"
 )
 
 const ResolvConfPath = "/etc/resolv.conf"
 const WslConfPath = "/etc/wsl.conf"
 
+const generatedHeader = "# generated by isetta"
+
 type DnsConfigurerImpl struct {}
 
 
-func (DnsConfigurerImpl) ActivateDnsServer(dnsServerip string) {
-	if !isDnsServerSet(dnsServerip, ResolvConfPath) {
-		setServer(ResolvConfPath, dnsServerip)
+func (DnsConfigurerImpl) ActivateDnsServer(ctx context.Context, dnsServerip string) error {
+	if isManagedByResolved(ctx, ResolvConfPath) {
+		set, err := isDnsServerSetInResolved(ctx, dnsServerip)
+		if err != nil || set {
+			return err
+		}
+		return setServerInResolved(ctx, ResolvedDropInPath, dnsServerip)
+	}
+	set, err := isDnsServerSet(ctx, dnsServerip, ResolvConfPath)
+	if err != nil || set {
+		return err
+	}
+	return setServer(ctx, ResolvConfPath, dnsServerip)
+}
+
+func (DnsConfigurerImpl) ReplaceDnsServers(ctx context.Context, dnsServerIp string) error {
+	if isManagedByResolved(ctx, ResolvConfPath) {
+		return setServerInResolved(ctx, ResolvedDropInPath, dnsServerIp)
+	}
+	return setServer(ctx, ResolvConfPath, dnsServerIp)
+}
+
+// with systemd-resolved, resolv.conf only names its local stub resolver, so
+// its global servers are returned
+func (DnsConfigurerImpl) Nameservers(ctx context.Context) ([]string, error) {
+	if isManagedByResolved(ctx, ResolvConfPath) {
+		return resolvedNameservers(ctx)
+	}
+	content, err := readResolveConf(ResolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseNameservers(content), nil
+}
+
+func parseNameservers(resolvConf string) []string {
+	nameservers := []string{}
+	for _, match := range regexp.MustCompile(`(?m)^nameserver[[:space:]]+(\S+)`).FindAllStringSubmatch(resolvConf, -1) {
+		nameservers = append(nameservers, match[1])
 	}
+	return nameservers
 }
 
-func isDnsServerSet(address string, resolvConfPath string) bool {
+func isDnsServerSet(ctx context.Context, address string, resolvConfPath string) (bool, error) {
 	content, err := readResolveConf(resolvConfPath)
-	helper.AssertNoError2(err)
+	if err != nil {
+		return false, err
+	}
 
 	// multiline match mode
 	// matches e.g. "nameserver 8.8.8.8"
-	regex := fmt.Sprintf("(?m:^nameserver[[:space:]]+%v[[:space:]]*$)", address)
+	regex := fmt.Sprintf("(?m:^nameserver[[:space:]]+%v[[:space:]]*$)", regexp.QuoteMeta(address))
 	matched, err := regexp.MatchString(regex, content)
-	helper.AssertNoError(err, "unable to access file %v.", ResolvConfPath)
+	if err != nil {
+		return false, fmt.Errorf("unable to check the nameservers of %v, error was: %w", resolvConfPath, err)
+	}
 
 	if matched {
-		log.Logger.Debug("DNS server %v already set in %v", address, resolvConfPath)
-		return true
+		log.FromContext(ctx).Debug("DNS server %v already set in %v", address, resolvConfPath)
+		return true, nil
 	} else {
-		log.Logger.Debug("DNS server %v is not set in %v", address, resolvConfPath)
-		return false
+		log.FromContext(ctx).Debug("DNS server %v is not set in %v", address, resolvConfPath)
+		return false, nil
 	}
 }
 
@@ -50,16 +97,47 @@ func readResolveConf(resolvConfPath string) (string, error) {
 	return string(content), nil
 }
 
-func setServer(path string, ip string) {
+// Only the nameserver lines change, e.g. search domains and comments are kept.
+// The previous content is backed up
+func setServer(ctx context.Context, path string, ip string) error {
 	err := isIpValid(ip)
-	helper.AssertNoError2(err)
+	if err != nil {
+		return err
+	}
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	helper.AssertNoError(err, "error accessing file %v", f)
-	defer f.Close()
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error accessing file %v, error was: %w", path, err)
+	}
+	replaced := []byte(replaceNameservers(string(content), ip))
 
-	log.Logger.Debug("Setting DNS server %v in %v", ip, path)
-	f.WriteString(fmt.Sprintf("# generated by isetta\nnameserver %v\n", ip))
+	log.FromContext(ctx).Debug("Setting DNS server %v in %v", ip, path)
+	if pointsIntoResolvedRuntimeDir(path) {
+		return replaceStaleResolvedSymlink(ctx, path, replaced)
+	}
+	return safefile.Write(ctx, path, replaced, 0644)
+}
+
+// the new nameserver takes the place of the first one
+func replaceNameservers(resolvConf string, ip string) string {
+	nameserverLine := regexp.MustCompile(`^nameserver[[:space:]]`)
+	lines := []string{}
+	replaced := false
+	for _, line := range strings.Split(strings.TrimSuffix(resolvConf, "\n"), "\n") {
+		if !nameserverLine.MatchString(line) {
+			lines = append(lines, line)
+		} else if !replaced {
+			lines = append(lines, "nameserver "+ip)
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(lines) == 1 && lines[0] == "" {
+			lines = []string{generatedHeader}
+		}
+		lines = append(lines, "nameserver "+ip)
+	}
+	return strings.Join(lines, "\n") + "\n"
 }
 
 func isIpValid(ip string) error {
@@ -70,43 +148,59 @@ func isIpValid(ip string) error {
 	}
 }
 
-func (DnsConfigurerImpl) DisableResolveAutoConfGeneration() {
-	log.Logger.Debug("Ensuring auto-generation of %v in %v is disabled", ResolvConfPath, WslConfPath)
-	disableResolvConfGenerationForFile(WslConfPath)
+func (DnsConfigurerImpl) DisableResolveAutoConfGeneration(ctx context.Context) error {
+	log.FromContext(ctx).Debug("Ensuring auto-generation of %v in %v is disabled", ResolvConfPath, WslConfPath)
+	return disableResolvConfGenerationForFile(ctx, WslConfPath)
 }
 
-func disableResolvConfGenerationForFile(path string) {
-	createIfNotExists(path)
-
-	cfg, err := ini.Load(path)
-	helper.AssertNoError(err, "fail to load file: %v", path)
-	
-	setToFalse(cfg, path)
-	err = cfg.SaveTo(path)
-	helper.AssertNoError(err, "fail to save file: %v", path)
+func (DnsConfigurerImpl) IsResolvConfGenerationDisabled() (bool, error) {
+	return isResolvConfGenerationDisabled(WslConfPath)
 }
 
-func createIfNotExists(path string) {
-	f, err := os.OpenFile(path, os.O_CREATE, 0644)
-	helper.AssertNoError(err, "error accessing file: %v", path)
-	f.Close()
+func isResolvConfGenerationDisabled(path string) (bool, error) {
+	file, err := loadIniFile(path)
+	if err != nil {
+		return false, err
+	}
+	value, found := file.Get("network", "generateResolvConf")
+	generateResolvConf, err := strconv.ParseBool(value)
+	return found && err == nil && !generateResolvConf, nil
 }
 
-func setToFalse(cfg *ini.File, path string) {
-	key := cfg.Section("network").Key("generateResolvConf")
+// a missing file is empty
+func loadIniFile(path string) (*inifile.File, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return inifile.Parse(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to load file: %v, error was: %w", path, err)
+	}
+	return inifile.Parse(content), nil
+}
 
-	generateResolvConf, err := key.Bool()
-	if generateResolvConf || err != nil {
-		log.Logger.Debug("Disabling auto-generation of %v in %v", ResolvConfPath, WslConfPath)
-		log.Logger.Trace("In %v key 'generateResolvConf' was 'true' or not set. Setting it to 'false'", WslConfPath)
-		key.SetValue("false")
-	} else {
-		log.Logger.Trace("Auto-generation of %v already disabled. Nothing to do", ResolvConfPath)
+// only the key is changed, the rest of the file keeps its formatting. The
+// previous content is backed up
+func disableResolvConfGenerationForFile(ctx context.Context, path string) error {
+	disabled, err := isResolvConfGenerationDisabled(path)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		log.FromContext(ctx).Trace("Auto-generation of %v already disabled. Nothing to do", ResolvConfPath)
+		return nil
 	}
 
-	// post-condition
-	generateResolvConf, err = key.Bool()
-	if generateResolvConf || err != nil {
-		log.Logger.Error("Failed to change 'generateResolvConf' in %v", path)
+	file, err := loadIniFile(path)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Debug("Disabling auto-generation of %v in %v", ResolvConfPath, path)
+	log.FromContext(ctx).Trace("In %v key 'generateResolvConf' was 'true' or not set. Setting it to 'false'", path)
+	file.Set("network", "generateResolvConf", "false")
+	err = safefile.Write(ctx, path, file.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("fail to save file: %v, error was: %w", path, err)
 	}
+	return nil
 }
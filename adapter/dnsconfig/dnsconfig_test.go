@@ -1,88 +1,104 @@
 package dnsconfig
 
 import (
+	"context"
 	"math/rand"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"gopkg.in/ini.v1"
+	"org.samba/isetta/safefile"
 )
 
+var ctx = context.Background()
+
+// backups go to a temporary directory instead of /var/lib/isetta
+func useTempBackups(t *testing.T) safefile.Store {
+	original := safefile.Default
+	safefile.Default = safefile.Store{Dir: t.TempDir(), Keep: safefile.DefaultKeep}
+	t.Cleanup(func() { safefile.Default = original })
+	return safefile.Default
+}
+
 func TestWslConfCreationIfNotExists(t *testing.T) {
+	useTempBackups(t)
 	tmpFileName := buildTmpFileName()
 	defer os.Remove(tmpFileName)
 
-	disableResolvConfGenerationForFile(tmpFileName)
+	assert.NoError(t, disableResolvConfGenerationForFile(ctx, tmpFileName))
 
 	content, err := os.ReadFile(tmpFileName)
 	assert.NoError(t, err)
-	assert.Regexp(t, "generateResolvConf = false", string(content))
+	assert.Equal(t, "[network]\ngenerateResolvConf=false\n", string(content))
 }
 
-func TestDisableResolvConfGenerationForFile(t *testing.T) {
-	file, err := os.CreateTemp("", "isetta")
-	assert.NoError(t, err)
-	defer os.Remove(file.Name())
-
-	file.WriteString(`
-	[miau]
-	foo  = bar
-	`)
-
-	disableResolvConfGenerationForFile(file.Name())
-
-	content, _ := os.ReadFile(file.Name())
-	assert.Regexp(t, "generateResolvConf = false", string(content))
-}
-
-func TestSetToFalse(t *testing.T) {
+func TestDisableResolvConfGenerationKeepsFormatting(t *testing.T) {
 	testCases := []struct {
 		name          string
 		contentBefore string
+		contentAfter  string
 	}{
 		{
-			name: "already false",
-			contentBefore: `
-			[network]
-			generateResolvConf  = false
-			`,
+			name:          "already false",
+			contentBefore: "# keep me\n[network]\ngenerateResolvConf  = false\n",
+			contentAfter:  "# keep me\n[network]\ngenerateResolvConf  = false\n",
 		},
 		{
-			name: "currently true",
-			contentBefore: `
-			[network]
-			generateResolvConf  = true
-			`,
+			name:          "currently true",
+			contentBefore: "[boot]\nsystemd = true\n\n# DNS\n[network]\ngenerateResolvConf  = true\nhostname = dev\n",
+			contentAfter:  "[boot]\nsystemd = true\n\n# DNS\n[network]\ngenerateResolvConf  = false\nhostname = dev\n",
 		},
 		{
-			name: "missing entry",
-			contentBefore: `
-			[miau]
-			foo  = bar
-			`,
+			name:          "missing entry",
+			contentBefore: "[automount]\n# mount Windows drives\nenabled = true\noptions = \"metadata\"\n",
+			contentAfter:  "[automount]\n# mount Windows drives\nenabled = true\noptions = \"metadata\"\n\n[network]\ngenerateResolvConf = false\n",
 		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.name, func(t *testing.T) {
-			cfg, _ := ini.Load([]byte(tC.contentBefore))
+			useTempBackups(t)
+			path := filepath.Join(t.TempDir(), "wsl.conf")
+			os.WriteFile(path, []byte(tC.contentBefore), 0644)
 
-			setToFalse(cfg, "foo")
+			assert.NoError(t, disableResolvConfGenerationForFile(ctx, path))
 
-			result, err := cfg.Section("network").Key("generateResolvConf").Bool()
-			assert.NoError(t, err)
-			assert.False(t, result)
+			content, _ := os.ReadFile(path)
+			assert.Equal(t, tC.contentAfter, string(content))
 		})
 	}
 }
 
+func TestChangesAreBackedUp(t *testing.T) {
+	store := useTempBackups(t)
+	path := filepath.Join(t.TempDir(), "wsl.conf")
+	os.WriteFile(path, []byte("[network]\ngenerateResolvConf = true\n"), 0644)
+
+	assert.NoError(t, disableResolvConfGenerationForFile(ctx, path))
+	assert.NoError(t, disableResolvConfGenerationForFile(ctx, path))
+
+	backups, err := store.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, path, backups[0].Path)
+}
+
+func TestReplaceNameserversKeepsOtherLines(t *testing.T) {
+	resolvConf := "# generated by WSL\nsearch corp.example.com\nnameserver 172.28.64.1\nnameserver 1.1.1.1\noptions ndots:2\n"
+
+	assert.Equal(t, "# generated by WSL\nsearch corp.example.com\nnameserver 10.0.0.1\noptions ndots:2\n", replaceNameservers(resolvConf, "10.0.0.1"))
+	assert.Equal(t, "# generated by isetta\nnameserver 10.0.0.1\n", replaceNameservers("", "10.0.0.1"))
+	assert.Equal(t, "search corp\nnameserver 10.0.0.1\n", replaceNameservers("search corp", "10.0.0.1"))
+}
+
 func TestSetSetDnsServer(t *testing.T) {
+	useTempBackups(t)
 	tmpFileName := buildTmpFileName()
 	defer os.Remove(tmpFileName)
 
-	setServer(tmpFileName, "1.2.3.4")
+	assert.NoError(t, setServer(ctx, tmpFileName, "1.2.3.4"))
 
 	content, err := os.ReadFile(tmpFileName)
 	assert.NoError(t, err)
@@ -135,7 +151,9 @@ func TestIsDnsServerSet(t *testing.T) {
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
 			os.WriteFile(tmpFileName, []byte(tC.content), 0644)
-			assert.Equal(t, tC.result, isDnsServerSet(tC.searchAddress, tmpFileName))
+			set, err := isDnsServerSet(ctx, tC.searchAddress, tmpFileName)
+			assert.NoError(t, err)
+			assert.Equal(t, tC.result, set)
 		})
 	}
 
@@ -149,3 +167,25 @@ func TestIsDnsServerSetErrorReadingFile(t *testing.T) {
 func buildTmpFileName() string {
 	return path.Join(os.TempDir(), "isetta-"+strconv.Itoa(rand.Int()))
 }
+
+func TestParseNameservers(t *testing.T) {
+	resolvConf := "# generated by isetta\nnameserver 8.8.8.8\n#nameserver 1.1.1.1\nnameserver  9.9.9.9\n"
+	assert.Equal(t, []string{"8.8.8.8", "9.9.9.9"}, parseNameservers(resolvConf))
+}
+
+func TestResolvConfGenerationIsNotDisabledWithoutWslConf(t *testing.T) {
+	disabled, err := isResolvConfGenerationDisabled(buildTmpFileName())
+	assert.NoError(t, err)
+	assert.False(t, disabled)
+}
+
+func TestResolvConfGenerationIsDisabled(t *testing.T) {
+	useTempBackups(t)
+	tmpFileName := buildTmpFileName()
+	defer os.Remove(tmpFileName)
+	assert.NoError(t, disableResolvConfGenerationForFile(ctx, tmpFileName))
+
+	disabled, err := isResolvConfGenerationDisabled(tmpFileName)
+	assert.NoError(t, err)
+	assert.True(t, disabled)
+}
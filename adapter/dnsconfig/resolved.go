@@ -0,0 +1,156 @@
+package dnsconfig
+
+// With systemd enabled, /etc/resolv.conf is usually a symlink into the
+// runtime directory of systemd-resolved. Writing through it changes a file
+// resolved regenerates, so the DNS server is set in a drop-in instead. Unlike
+// 'resolvectl dns eth0', the drop-in survives a restart of resolved and is
+// backed up like the other files isetta changes.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/inifile"
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+)
+
+const ResolvedDropInPath = "/etc/systemd/resolved.conf.d/isetta.conf"
+
+// where the files behind a symlinked resolv.conf of resolved live
+var resolvedRuntimeDir = "/run/systemd/resolve"
+
+const resolvedCommandTimeout = 10 * time.Second
+
+// resolved manages DNS if resolv.conf points into its runtime directory
+// and the service is running
+func isManagedByResolved(ctx context.Context, resolvConfPath string) bool {
+	if !pointsIntoResolvedRuntimeDir(resolvConfPath) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolvedCommandTimeout)
+	defer cancel()
+	out, err := cmdrunner.Run(ctx, "systemctl", "is-active", "systemd-resolved")
+	active := err == nil && strings.TrimSpace(string(out)) == "active"
+	log.FromContext(ctx).Debug("%v points into %v, systemd-resolved active: %v", resolvConfPath, resolvedRuntimeDir, active)
+	return active
+}
+
+func pointsIntoResolvedRuntimeDir(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return strings.HasPrefix(filepath.Clean(target), resolvedRuntimeDir+"/")
+}
+
+// the global servers, i.e. the ones of the drop-in. Per link servers, e.g.
+// the one WSL sets on eth0, aren't managed by isetta
+func resolvedNameservers(ctx context.Context) ([]string, error) {
+	out, err := resolvectlDns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseResolvectlGlobalDns(out), nil
+}
+
+func resolvectlDns(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolvedCommandTimeout)
+	defer cancel()
+	out, err := cmdrunner.Run(ctx, "resolvectl", "dns")
+	if err != nil {
+		return "", fmt.Errorf("unable to query systemd-resolved: %v, error was: %w", strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
+
+// the servers of the drop-in, a per link server doesn't route all domains.
+// e.g.
+//
+//	Global: 192.168.1.1
+//	Link 2 (eth0): 172.23.16.1 fe80::1%2
+func parseResolvectlGlobalDns(output string) []string {
+	for _, line := range strings.Split(output, "\n") {
+		servers, found := strings.CutPrefix(strings.TrimSpace(line), "Global:")
+		if found {
+			return strings.Fields(servers)
+		}
+	}
+	return []string{}
+}
+
+func isDnsServerSetInResolved(ctx context.Context, address string) (bool, error) {
+	out, err := resolvectlDns(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	set := slices.Contains(parseResolvectlGlobalDns(out), address)
+	log.FromContext(ctx).Debug("DNS server %v set globally in systemd-resolved: %v", address, set)
+	return set, nil
+}
+
+// '~.' routes all domains to the server, instead of the per link servers
+func setServerInResolved(ctx context.Context, path string, ip string) error {
+	err := isIpValid(ip)
+	if err != nil {
+		return err
+	}
+
+	file, err := loadIniFile(path)
+	if err != nil {
+		return err
+	}
+	if len(file.Bytes()) == 0 {
+		file = inifile.Parse([]byte(generatedHeader + "\n"))
+	}
+	file.Set("Resolve", "DNS", ip)
+	file.Set("Resolve", "Domains", "~.")
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory of %v, error was: %w", path, err)
+	}
+	log.FromContext(ctx).Debug("Setting DNS server %v in %v", ip, path)
+	err = safefile.Write(ctx, path, file.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return restartResolved(ctx)
+}
+
+// resolved reads its drop-ins on start only
+func restartResolved(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, resolvedCommandTimeout)
+	defer cancel()
+	out, err := cmdrunner.Run(ctx, "systemctl", "restart", "systemd-resolved")
+	if err != nil {
+		return fmt.Errorf("unable to restart systemd-resolved: %v, error was: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// A symlink into the runtime directory of a stopped resolved points to a
+// stale or missing file. It is replaced atomically by a regular file with the
+// new content, the backup of the link restores it
+func replaceStaleResolvedSymlink(ctx context.Context, path string, content []byte) error {
+	log.FromContext(ctx).Info("%v points into %v but systemd-resolved is not active. Replacing the symlink with a regular file", path, resolvedRuntimeDir)
+	err := safefile.ReplaceSymlink(ctx, path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to replace symlink %v, error was: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dnsconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+)
+
+// records the commands and answers them with a fixed output
+type fakeSystemd struct {
+	output   string
+	commands []string
+}
+
+func (f *fakeSystemd) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	f.commands = append(f.commands, cmd.String())
+	return []byte(f.output), nil
+}
+
+func useFakeSystemd(t *testing.T, output string) *fakeSystemd {
+	fake := &fakeSystemd{output: output}
+	cmdrunner.Default = fake
+	t.Cleanup(func() { cmdrunner.Default = cmdrunner.ExecRunner{} })
+	return fake
+}
+
+// a resolv.conf symlinked into a temporary runtime directory of resolved
+func setupResolvedSymlink(t *testing.T) string {
+	original := resolvedRuntimeDir
+	resolvedRuntimeDir = t.TempDir()
+	t.Cleanup(func() { resolvedRuntimeDir = original })
+
+	stub := filepath.Join(resolvedRuntimeDir, "stub-resolv.conf")
+	os.WriteFile(stub, []byte("nameserver 127.0.0.53\noptions edns0 trust-ad\n"), 0644)
+	link := filepath.Join(t.TempDir(), "resolv.conf")
+	os.Symlink(stub, link)
+	return link
+}
+
+func TestParseResolvectlGlobalDns(t *testing.T) {
+	output := "Global: 192.168.1.1\nLink 2 (eth0): 172.23.16.1 fe80::1%2\nLink 3 (loopback0):\nLink 4 (eth1): 192.168.1.1\n"
+
+	assert.Equal(t, []string{"192.168.1.1"}, parseResolvectlGlobalDns(output))
+}
+
+func TestPerLinkServerIsNotSetInResolved(t *testing.T) {
+	useFakeSystemd(t, "Global:\nLink 2 (eth0): 8.8.8.8\n")
+
+	set, err := isDnsServerSetInResolved(ctx, "8.8.8.8")
+	assert.NoError(t, err)
+	assert.False(t, set)
+}
+
+// the link server of WSL would otherwise be a drift which never goes away
+func TestPerLinkServersAreNoNameservers(t *testing.T) {
+	useFakeSystemd(t, "Global: 10.1.1.1\nLink 2 (eth0): 172.23.16.1\n")
+
+	nameservers, err := resolvedNameservers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.1.1.1"}, nameservers)
+}
+
+func TestResolvedManagesSymlinkedResolvConf(t *testing.T) {
+	link := setupResolvedSymlink(t)
+	fake := useFakeSystemd(t, "active\n")
+
+	assert.True(t, isManagedByResolved(ctx, link))
+	assert.Equal(t, []string{"systemctl is-active systemd-resolved"}, fake.commands)
+
+	fake.output = "inactive\n"
+	assert.False(t, isManagedByResolved(ctx, link))
+}
+
+func TestRegularResolvConfIsNotManagedByResolved(t *testing.T) {
+	fake := useFakeSystemd(t, "active\n")
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644)
+
+	assert.False(t, isManagedByResolved(ctx, path))
+	assert.Empty(t, fake.commands)
+}
+
+func TestSetServerInResolvedWritesDropIn(t *testing.T) {
+	useTempBackups(t)
+	fake := useFakeSystemd(t, "")
+	path := filepath.Join(t.TempDir(), "resolved.conf.d", "isetta.conf")
+
+	assert.NoError(t, setServerInResolved(ctx, path, "8.8.8.8"))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, generatedHeader+"\n\n[Resolve]\nDNS=8.8.8.8\nDomains=~.\n", string(content))
+	assert.Equal(t, []string{"systemctl restart systemd-resolved"}, fake.commands)
+
+	assert.NoError(t, setServerInResolved(ctx, path, "1.1.1.1"))
+
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, generatedHeader+"\n\n[Resolve]\nDNS=1.1.1.1\nDomains=~.\n", string(content))
+}
+
+func TestStaleResolvedSymlinkIsReplaced(t *testing.T) {
+	backups := useTempBackups(t)
+	link := setupResolvedSymlink(t)
+
+	assert.NoError(t, setServer(ctx, link, "8.8.8.8"))
+
+	info, err := os.Lstat(link)
+	assert.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	content, _ := os.ReadFile(link)
+	assert.Equal(t, "nameserver 8.8.8.8\noptions edns0 trust-ad\n", string(content))
+	stub, _ := os.ReadFile(filepath.Join(resolvedRuntimeDir, "stub-resolv.conf"))
+	assert.Equal(t, "nameserver 127.0.0.53\noptions edns0 trust-ad\n", string(stub))
+
+	saved, _ := backups.Backups()
+	assert.Len(t, saved, 1)
+	assert.True(t, saved[0].Link)
+}
+
+func TestRollbackRestoresTheReplacedSymlink(t *testing.T) {
+	useTempBackups(t)
+	link := setupResolvedSymlink(t)
+	backup, err := backupFile(link)
+	assert.NoError(t, err)
+	assert.NoError(t, setServer(ctx, link, "8.8.8.8"))
+
+	assert.NoError(t, restoreFile(ctx, backup))
+
+	target, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(resolvedRuntimeDir, "stub-resolv.conf"), target)
+}
@@ -0,0 +1,103 @@
+package envvars
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	"org.samba/isetta/pemfile"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/userdir"
+)
+
+// system wide CA bundles of common distributions
+var systemCaBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt", // Debian, Ubuntu
+	"/etc/pki/tls/certs/ca-bundle.crt",   // Fedora, RHEL
+	"/etc/ssl/ca-bundle.pem",             // openSUSE
+}
+
+// Determines the CA bundle the tool specific variables point to. Variables like
+// REQUESTS_CA_BUNDLE replace the tool's own bundle, so a system bundle which
+// already contains the corporate CA is preferred. Otherwise the system CAs and
+// the corporate CA are combined in combinedCaBundle, a corporate-only bundle
+// would break the access to public hosts. Returns an empty string if no
+// corporate CA bundle is available.
+func findCaBundle(ctx context.Context, corporateCaBundle string, combinedCaBundle string) string {
+	if corporateCaBundle == "" {
+		return ""
+	}
+	corporateCerts, err := pemfile.Certificates(corporateCaBundle)
+	if err != nil || len(corporateCerts) == 0 {
+		log.FromContext(ctx).Trace("No corporate CA bundle found at %v", corporateCaBundle)
+		return ""
+	}
+
+	var systemContent []byte
+	for _, systemCaBundle := range systemCaBundles {
+		systemCerts, err := pemfile.Certificates(systemCaBundle)
+		if err != nil {
+			continue
+		}
+		if containsAll(systemCerts, corporateCerts) {
+			log.FromContext(ctx).Trace("System CA bundle %v contains the corporate CA", systemCaBundle)
+			return systemCaBundle
+		}
+		if systemContent == nil {
+			systemContent, _ = os.ReadFile(systemCaBundle)
+		}
+	}
+	if systemContent == nil || combinedCaBundle == "" {
+		log.FromContext(ctx).Debug("No system CA bundle found, using the corporate CA bundle %v only", corporateCaBundle)
+		return corporateCaBundle
+	}
+
+	err = writeCombinedCaBundle(ctx, combinedCaBundle, systemContent, corporateCaBundle)
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to combine the system CAs and the corporate CA in %v: %v", combinedCaBundle, err)
+		return ""
+	}
+	return combinedCaBundle
+}
+
+// written as the calling user, only if the content changed
+func writeCombinedCaBundle(ctx context.Context, path string, systemContent []byte, corporateCaBundle string) error {
+	corporateContent, err := os.ReadFile(corporateCaBundle)
+	if err != nil {
+		return err
+	}
+	content := append(append([]byte{}, systemContent...), '\n')
+	content = append(content, corporateContent...)
+
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	err = userdir.MkdirAll(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Debug("Combining the system CAs and the corporate CA in %v", path)
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return err
+	}
+	return userdir.Chown(path)
+}
+
+func containsAll(certificates [][]byte, wanted [][]byte) bool {
+	for _, w := range wanted {
+		found := false
+		for _, c := range certificates {
+			if bytes.Equal(c, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package envvars
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/pemfile"
+)
+
+func TestNoCaBundleWhenNotConfigured(t *testing.T) {
+	assert.Equal(t, "", findCaBundle(ctx, "", ""))
+}
+
+func TestNoCaBundleWhenFileIsMissing(t *testing.T) {
+	assert.Equal(t, "", findCaBundle(ctx, "/non/existing/ca.pem", ""))
+}
+
+func TestCorporateCaIsCombinedWithTheSystemCas(t *testing.T) {
+	corporateCaBundle := writePemFile(t, "corporate.pem", "corporate")
+	useSystemCaBundles(t, "/non/existing/ca.pem", writePemFile(t, "system.pem", "public"))
+	combinedCaBundle := filepath.Join(t.TempDir(), "isetta", "ca-bundle.pem")
+
+	assert.Equal(t, combinedCaBundle, findCaBundle(ctx, corporateCaBundle, combinedCaBundle))
+
+	certificates, err := pemfile.Certificates(combinedCaBundle)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("public"), []byte("corporate")}, certificates)
+}
+
+func TestCorporateCaBundleIsUsedWithoutSystemCas(t *testing.T) {
+	corporateCaBundle := writePemFile(t, "corporate.pem", "corporate")
+	useSystemCaBundles(t)
+
+	assert.Equal(t, corporateCaBundle, findCaBundle(ctx, corporateCaBundle, filepath.Join(t.TempDir(), "ca-bundle.pem")))
+}
+
+func TestSystemCaBundleIsPreferredIfItContainsTheCorporateCa(t *testing.T) {
+	corporateCaBundle := writePemFile(t, "corporate.pem", "corporate")
+	systemCaBundle := writePemFile(t, "system.pem", "public", "corporate")
+	useSystemCaBundles(t, "/non/existing/ca.pem", systemCaBundle)
+
+	assert.Equal(t, systemCaBundle, findCaBundle(ctx, corporateCaBundle, filepath.Join(t.TempDir(), "ca-bundle.pem")))
+}
+
+func writePemFile(t *testing.T, name string, certificates ...string) string {
+	path := filepath.Join(t.TempDir(), name)
+	content := []byte{}
+	for _, certificate := range certificates {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(certificate)})...)
+	}
+	assert.NoError(t, os.WriteFile(path, content, 0644))
+	return path
+}
+
+func useSystemCaBundles(t *testing.T, paths ...string) {
+	original := systemCaBundles
+	systemCaBundles = paths
+	t.Cleanup(func() { systemCaBundles = original })
+}
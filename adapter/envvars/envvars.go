@@ -1,53 +1,115 @@
 package envvars
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/shellquote"
 	log "org.samba/isetta/simplelogger"
 )
 
-const exportHttpProxyVariables = `
-export HTTPS_PROXY=http://%[1]v:%[2]v
-export HTTP_PROXY=http://%[1]v:%[2]v
-export https_proxy=http://%[1]v:%[2]v
-export http_proxy=http://%[1]v:%[2]v
-`
+var httpProxyVariables = []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy"}
 
 const defaultNoProxyHosts = "localhost,127.0.0.1"
 
-const unsetEnvironmentVariables = `
-unset HTTPS_PROXY
-unset HTTP_PROXY
-unset https_proxy
-unset http_proxy
-unset NO_PROXY
-unset no_proxy
-`
+var noProxyVariables = []string{"NO_PROXY", "no_proxy"}
 
-type ConsoleEnvVarPrinter struct{
-	WindowsIp string
-	PxProxyPort int
-	NoProxy []string
+// no trustStorePassword, a PKCS12 store used for trust only is readable without it
+// and the password would end up on the command line of every JVM
+const javaToolOptions = `-Djavax.net.ssl.trustStore=%v -Djavax.net.ssl.trustStoreType=PKCS12`
+
+const javaToolOptionsVariable = "JAVA_TOOL_OPTIONS"
+
+type ConsoleEnvVarPrinter struct {
+	WindowsIp        string
+	PxProxyPort      int
+	Mirrored         bool // WSL mirrored networking, Px is reachable via 127.0.0.1
+	NoProxy          []string
+	JavaTruststore   string   // optional, standalone PKCS12 truststore
+	CaBundle         string   // corporate CA bundle
+	CaBundleEnvVars  []string // tool specific variables pointing to a CA bundle
+	CombinedCaBundle string   // system CAs and the corporate CA, written if needed
 }
 
-func (c *ConsoleEnvVarPrinter) PrintExportCommands() {
-	fmt.Println(c.buildPrintExportCommands())
+func (c *ConsoleEnvVarPrinter) PrintExportCommands(ctx context.Context) {
+	fmt.Println(c.buildPrintExportCommands(ctx))
 }
 
-func (c *ConsoleEnvVarPrinter) buildPrintExportCommands() string {
-	trimmedHttpVars := strings.Trim(exportHttpProxyVariables, "\n")
-	httpVars := fmt.Sprintf(trimmedHttpVars, c.WindowsIp, c.PxProxyPort)
-	
-	upperNoProxyVar := c.buildNoProxyEnvVar("NO_PROXY")
-	lowerNoProxyVar := c.buildNoProxyEnvVar("no_proxy")
+func (c *ConsoleEnvVarPrinter) buildPrintExportCommands(ctx context.Context) string {
+	var lines []string
+	for _, envVar := range c.ExportVars(ctx) {
+		lines = append(lines, fmt.Sprintf("export %v=%v", envVar.Name, shellquote.Quote(envVar.Value)))
+	}
+	return strings.Join(lines, "\n")
+}
 
-	return fmt.Sprintf("%v\n%v\n%v", httpVars, upperNoProxyVar, lowerNoProxyVar)
+func (c *ConsoleEnvVarPrinter) ExportVars(ctx context.Context) []model.EnvVar {
+	var envVars []model.EnvVar
+	proxyUrl := fmt.Sprintf("http://%v:%v", c.proxyHost(), c.PxProxyPort)
+	for _, name := range httpProxyVariables {
+		envVars = append(envVars, model.EnvVar{Name: name, Value: proxyUrl})
+	}
+	for _, name := range noProxyVariables {
+		envVars = append(envVars, model.EnvVar{Name: name, Value: c.buildNoProxyValue(name)})
+	}
+	if c.isJavaTruststoreAvailable(ctx) {
+		envVars = append(envVars, model.EnvVar{
+			Name:  javaToolOptionsVariable,
+			Value: strings.Join(append(c.userJavaToolOptions(), c.ownJavaToolOptions()...), " "),
+		})
+	}
+	if caBundle := findCaBundle(ctx, c.CaBundle, c.CombinedCaBundle); caBundle != "" {
+		for _, name := range c.CaBundleEnvVars {
+			envVars = append(envVars, model.EnvVar{Name: name, Value: caBundle})
+		}
+	}
+	return envVars
 }
 
-func (c *ConsoleEnvVarPrinter) buildNoProxyEnvVar(envVarName string) string {
-	out := fmt.Sprintf("export %v=%v,%v", envVarName, defaultNoProxyHosts, c.WindowsIp)
+func (c *ConsoleEnvVarPrinter) proxyHost() string {
+	if c.Mirrored {
+		return "127.0.0.1"
+	}
+	return c.WindowsIp
+}
+
+func (c *ConsoleEnvVarPrinter) isJavaTruststoreAvailable(ctx context.Context) bool {
+	if c.JavaTruststore == "" {
+		return false
+	}
+	_, err := os.Stat(c.JavaTruststore)
+	if err != nil {
+		log.FromContext(ctx).Debug("Java truststore %v not available. Run 'sudo isetta -java-truststore' to create it", c.JavaTruststore)
+		return false
+	}
+	return true
+}
+
+func (c *ConsoleEnvVarPrinter) ownJavaToolOptions() []string {
+	return strings.Fields(fmt.Sprintf(javaToolOptions, c.JavaTruststore))
+}
+
+// userJavaToolOptions returns the options set in the environment without the ones of isetta
+func (c *ConsoleEnvVarPrinter) userJavaToolOptions() []string {
+	own := c.ownJavaToolOptions()
+	var options []string
+	for _, option := range strings.Fields(os.Getenv(javaToolOptionsVariable)) {
+		if !slices.Contains(own, option) {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+func (c *ConsoleEnvVarPrinter) buildNoProxyValue(envVarName string) string {
+	out := defaultNoProxyHosts
+	if !c.Mirrored {
+		out += "," + c.WindowsIp
+	}
 	out += appendEnvVarIfSet(envVarName)
 	out += c.appendNoProxyConfigIfSet(envVarName)
 	return out
@@ -70,15 +132,53 @@ func (c *ConsoleEnvVarPrinter) appendNoProxyConfigIfSet(envVarName string) strin
 }
 
 func (c *ConsoleEnvVarPrinter) PrintUnsetCommands() {
-	fmt.Println(strings.Trim(unsetEnvironmentVariables, "\n"))
+	fmt.Println(c.buildUnsetCommands())
+}
+
+func (c *ConsoleEnvVarPrinter) buildUnsetCommands() string {
+	var lines []string
+	for _, name := range c.UnsetVars() {
+		lines = append(lines, "unset "+name)
+	}
+	for _, envVar := range c.RestoredVars() {
+		lines = append(lines, fmt.Sprintf("export %v=%v", envVar.Name, shellquote.Quote(envVar.Value)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *ConsoleEnvVarPrinter) UnsetVars() []string {
+	names := append(append([]string{}, httpProxyVariables...), noProxyVariables...)
+	if c.JavaTruststore != "" && len(c.userJavaToolOptions()) == 0 {
+		names = append(names, javaToolOptionsVariable)
+	}
+	// set before, even if the bundle is gone by now
+	names = append(names, c.CaBundleEnvVars...)
+	return names
 }
 
-func (c *ConsoleEnvVarPrinter) WarnIfProxyVarSet() {
+// RestoredVars returns the variables isetta only appended to. They keep the
+// values of the user once isetta's part is removed
+func (c *ConsoleEnvVarPrinter) RestoredVars() []model.EnvVar {
+	if c.JavaTruststore == "" {
+		return nil
+	}
+	options := c.userJavaToolOptions()
+	if len(options) == 0 {
+		return nil
+	}
+	return []model.EnvVar{{Name: javaToolOptionsVariable, Value: strings.Join(options, " ")}}
+}
+
+func (c *ConsoleEnvVarPrinter) WarnIfProxyVarSet(ctx context.Context) {
 	if c.areHttpEnvVarsSet() {
-		log.Logger.Warn("This shell still has one ore more http(s)_proxy environment variables set. You are directly connected, don't forget to unset them.")
+		log.FromContext(ctx).Warn("This shell still has one ore more http(s)_proxy environment variables set. You are directly connected, don't forget to unset them.")
 	}
 }
 
+func (c *ConsoleEnvVarPrinter) IsProxyVarSet() bool {
+	return c.areHttpEnvVarsSet()
+}
+
 func (c *ConsoleEnvVarPrinter) areHttpEnvVarsSet() bool {
 	proxyEnvVars := []string{
 		"http_proxy",
@@ -93,4 +193,4 @@ func (c *ConsoleEnvVarPrinter) areHttpEnvVarsSet() bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
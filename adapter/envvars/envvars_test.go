@@ -1,12 +1,17 @@
 package envvars
 
 import (
+	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
 )
 
+var ctx = context.Background()
+
 func TestBuildPrintExportCommands(t *testing.T) {
 
 	uut := ConsoleEnvVarPrinter{
@@ -14,7 +19,18 @@ func TestBuildPrintExportCommands(t *testing.T) {
 		PxProxyPort: 4242,
 	}
 
-	assert.Regexp(t, "^export HTTPS_PROXY=http://1.1.1.1:4242", uut.buildPrintExportCommands())	
+	assert.Regexp(t, "^export HTTPS_PROXY=http://1.1.1.1:4242", uut.buildPrintExportCommands(ctx))	
+}
+
+func TestProxyIsLocalhostInMirroredMode(t *testing.T) {
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp:   "1.1.1.1",
+		PxProxyPort: 4242,
+		Mirrored:    true,
+	}
+
+	assert.Regexp(t, "(?m)^export HTTPS_PROXY=http://127.0.0.1:4242$", uut.buildPrintExportCommands(ctx))
+	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1$", uut.buildPrintExportCommands(ctx))
 }
 
 func TestHttpEnvVarsAreNotSet(t *testing.T) {
@@ -39,8 +55,8 @@ func TestNoProxyEnvVarsAreAppended(t *testing.T) {
 		PxProxyPort: 4242,
 	}
 	
-	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands())	
-	assert.Regexp(t, "(?m)^export no_proxy=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands())	
+	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands(ctx))	
+	assert.Regexp(t, "(?m)^export no_proxy=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands(ctx))	
 	os.Unsetenv("NO_PROXY")
 	os.Unsetenv("no_proxy")
 }
@@ -51,7 +67,7 @@ func TestNoProxyWithoutEnvVar(t *testing.T) {
 		PxProxyPort: 4242,
 	}
 
-	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1$", uut.buildPrintExportCommands())	
+	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1$", uut.buildPrintExportCommands(ctx))	
 }
 
 func TestConfiguredNoProxyEntriesAreAppended(t *testing.T) {
@@ -64,7 +80,7 @@ func TestConfiguredNoProxyEntriesAreAppended(t *testing.T) {
 		},
 	}
 
-	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands())	
+	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,foo,bar$", uut.buildPrintExportCommands(ctx))	
 }	
 
 func TestNoProxyEnvVarAndConfiguredOneAreAppended(t *testing.T) {
@@ -79,6 +95,113 @@ func TestNoProxyEnvVarAndConfiguredOneAreAppended(t *testing.T) {
 		},
 	}
 
-	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,fooEnv,barEnv,fooConf,barConf$", uut.buildPrintExportCommands())	
+	assert.Regexp(t, "(?m)^export NO_PROXY=localhost,127.0.0.1,1.1.1.1,fooEnv,barEnv,fooConf,barConf$", uut.buildPrintExportCommands(ctx))	
 	os.Unsetenv("NO_PROXY")
 }	
+
+func TestJavaToolOptionsArePrintedWhenTruststoreExists(t *testing.T) {
+	truststore, err := os.CreateTemp("", "isetta-*.p12")
+	assert.NoError(t, err)
+	defer os.Remove(truststore.Name())
+
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		JavaTruststore: truststore.Name(),
+	}
+
+	assert.Regexp(t, "(?m)^export JAVA_TOOL_OPTIONS='-Djavax.net.ssl.trustStore="+truststore.Name()+" -Djavax.net.ssl.trustStoreType=PKCS12'$", uut.buildPrintExportCommands(ctx))
+	assert.NotContains(t, uut.buildPrintExportCommands(ctx), "trustStorePassword")
+	assert.Regexp(t, "(?m)^unset JAVA_TOOL_OPTIONS$", uut.buildUnsetCommands())
+}
+
+func TestJavaToolOptionsAreNotPrintedWhenTruststoreIsMissing(t *testing.T) {
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		JavaTruststore: "/non/existing/truststore.p12",
+	}
+
+	assert.NotContains(t, uut.buildPrintExportCommands(ctx), "JAVA_TOOL_OPTIONS")
+}
+
+func TestJavaToolOptionsAreNotUnsetWhenNotConfigured(t *testing.T) {
+	uut := ConsoleEnvVarPrinter{}
+
+	assert.NotContains(t, uut.buildUnsetCommands(), "JAVA_TOOL_OPTIONS")
+}
+
+func TestCaBundleVarsArePrinted(t *testing.T) {
+	caBundle := writePemFile(t, "corporate.pem", "corporate")
+	useSystemCaBundles(t)
+
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		CaBundle: caBundle,
+		CaBundleEnvVars: []string{"REQUESTS_CA_BUNDLE", "NODE_EXTRA_CA_CERTS"},
+	}
+
+	assert.Regexp(t, "(?m)^export REQUESTS_CA_BUNDLE="+caBundle+"$", uut.buildPrintExportCommands(ctx))
+	assert.Regexp(t, "(?m)^export NODE_EXTRA_CA_CERTS="+caBundle+"$", uut.buildPrintExportCommands(ctx))
+	assert.Regexp(t, "(?m)^unset REQUESTS_CA_BUNDLE$", uut.buildUnsetCommands())
+	assert.Regexp(t, "(?m)^unset NODE_EXTRA_CA_CERTS$", uut.buildUnsetCommands())
+}
+
+func TestOnlyConfiguredCaBundleVarsArePrinted(t *testing.T) {
+	caBundle := writePemFile(t, "corporate.pem", "corporate")
+	useSystemCaBundles(t)
+
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		CaBundle: caBundle,
+		CaBundleEnvVars: []string{"GIT_SSL_CAINFO"},
+	}
+
+	assert.Contains(t, uut.buildPrintExportCommands(ctx), "GIT_SSL_CAINFO")
+	assert.NotContains(t, uut.buildPrintExportCommands(ctx), "REQUESTS_CA_BUNDLE")
+}
+
+func TestCaBundleVarsAreUnsetWithoutCaBundle(t *testing.T) {
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		CaBundle: "/non/existing/ca.pem",
+		CaBundleEnvVars: []string{"REQUESTS_CA_BUNDLE"},
+	}
+
+	assert.NotContains(t, uut.buildPrintExportCommands(ctx), "REQUESTS_CA_BUNDLE")
+	assert.Regexp(t, "(?m)^unset REQUESTS_CA_BUNDLE$", uut.buildUnsetCommands())
+}
+
+func TestExportVarsMatchThePrintedCommands(t *testing.T) {
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp:   "1.1.1.1",
+		PxProxyPort: 4242,
+	}
+
+	envVars := uut.ExportVars(ctx)
+	assert.Equal(t, model.EnvVar{Name: "HTTPS_PROXY", Value: "http://1.1.1.1:4242"}, envVars[0])
+	assert.Equal(t, model.EnvVar{Name: "NO_PROXY", Value: "localhost,127.0.0.1,1.1.1.1"}, envVars[4])
+	assert.Equal(t, "export HTTPS_PROXY=http://1.1.1.1:4242", strings.Split(uut.buildPrintExportCommands(ctx), "\n")[0])
+	assert.Equal(t, []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy", "NO_PROXY", "no_proxy"}, uut.UnsetVars())
+}
+
+func TestJavaToolOptionsOfTheUserAreKept(t *testing.T) {
+	truststore, err := os.CreateTemp("", "isetta-*.p12")
+	assert.NoError(t, err)
+	defer os.Remove(truststore.Name())
+	uut := ConsoleEnvVarPrinter{
+		WindowsIp: "1.1.1.1",
+		PxProxyPort: 4242,
+		JavaTruststore: truststore.Name(),
+	}
+	own := "-Djavax.net.ssl.trustStore=" + truststore.Name() + " -Djavax.net.ssl.trustStoreType=PKCS12"
+	t.Setenv("JAVA_TOOL_OPTIONS", "-Xmx1g "+own)
+
+	assert.Regexp(t, "(?m)^export JAVA_TOOL_OPTIONS='-Xmx1g "+own+"'$", uut.buildPrintExportCommands(ctx))
+	assert.NotContains(t, uut.UnsetVars(), "JAVA_TOOL_OPTIONS")
+	assert.Equal(t, []model.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"}}, uut.RestoredVars())
+	assert.Regexp(t, "(?m)^export JAVA_TOOL_OPTIONS=-Xmx1g$", uut.buildUnsetCommands())
+}
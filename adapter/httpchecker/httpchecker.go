@@ -1,16 +1,21 @@
 package httpchecker
 
 import (
+	"context"
+	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"time"
 
+	"org.samba/isetta/cmdrunner"
 	log "org.samba/isetta/simplelogger"
 )
 
 type HttpCheckerImpl struct {
 	InternetAccessTestUrl        string
+	CaptivePortalTestUrl         string // returns 204 without captive portal, empty to disable the check
 	ProxyUrl                     *url.URL
 	DefaultTimeoutInMilliseconds int
 }
@@ -27,51 +32,59 @@ func New(InternetAccessTestUrl string, proxyUrl string) (HttpCheckerImpl, error)
 	}, nil
 }
 
-func (h *HttpCheckerImpl) HasDirectInternetAccess(timeoutInMilliseconds ...int) bool {
-	os.Unsetenv("https_proxy")
-	os.Unsetenv("HTTPS_PROXY")
+func (h *HttpCheckerImpl) HasDirectInternetAccess(ctx context.Context, timeoutInMilliseconds ...int) bool {
+	timeout := determineTimeout(timeoutInMilliseconds, h.DefaultTimeoutInMilliseconds)
+	return h.CheckDirectInternetAccess(ctx, timeout) == nil
+}
 
+func (h *HttpCheckerImpl) HasInternetAccessViaProxy(ctx context.Context, timeoutInMilliseconds ...int) bool {
 	timeout := determineTimeout(timeoutInMilliseconds, h.DefaultTimeoutInMilliseconds)
+	return h.CheckInternetAccessViaProxy(ctx, timeout) == nil
+}
+
+// ignores the proxy variables of the environment, they are still needed to
+// warn about a mismatch with the working path
+func (h *HttpCheckerImpl) CheckDirectInternetAccess(ctx context.Context, timeoutInMilliseconds int) error {
 	client := http.Client{
-		Timeout: time.Duration(timeout) * time.Millisecond,
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   time.Duration(timeoutInMilliseconds) * time.Millisecond,
 	}
 
-	resp, err := client.Get(h.InternetAccessTestUrl)
+	statusCode, err := status(ctx, &client, h.InternetAccessTestUrl)
 	if err != nil {
-		log.Logger.Debug("Unable to directly access %v", h.InternetAccessTestUrl)
-		log.Logger.Trace("Error was: %v", err)
-		return false
+		log.FromContext(ctx).Debug("Unable to directly access %v", h.InternetAccessTestUrl)
+		log.FromContext(ctx).Trace("Error was: %v", err)
+		return err
 	}
 
-	if resp.StatusCode == 200 {
-		log.Logger.Debug("Successfully connected directly to %v", h.InternetAccessTestUrl)
-		return true
+	if statusCode == 200 {
+		log.FromContext(ctx).Debug("Successfully connected directly to %v", h.InternetAccessTestUrl)
+		return nil
 	} else {
-		log.Logger.Debug("HTTP error when trying to directly connect to %v. HTTP status code was: %v", h.InternetAccessTestUrl, resp.StatusCode)
-		return false
+		log.FromContext(ctx).Debug("HTTP error when trying to directly connect to %v. HTTP status code was: %v", h.InternetAccessTestUrl, statusCode)
+		return fmt.Errorf("HTTP status code %v from %v", statusCode, h.InternetAccessTestUrl)
 	}
 }
 
-func (h *HttpCheckerImpl) HasInternetAccessViaProxy(timeoutInMilliseconds ...int) bool {
-	timeout := determineTimeout(timeoutInMilliseconds, h.DefaultTimeoutInMilliseconds)
+func (h *HttpCheckerImpl) CheckInternetAccessViaProxy(ctx context.Context, timeoutInMilliseconds int) error {
 	httpClientWithProxy := http.Client{
 		Transport: &http.Transport{Proxy: http.ProxyURL(h.ProxyUrl)},
-		Timeout:   time.Duration(timeout) * time.Millisecond,
+		Timeout:   time.Duration(timeoutInMilliseconds) * time.Millisecond,
 	}
-	resp, err := httpClientWithProxy.Get(h.InternetAccessTestUrl)
+	statusCode, err := status(ctx, &httpClientWithProxy, h.InternetAccessTestUrl, "--proxy", h.ProxyUrl.String())
 
 	if err != nil {
-		log.Logger.Debug("Unable to access %v via proxy", h.InternetAccessTestUrl)
-		log.Logger.Trace("Error was: %v", err)
-		return false
+		log.FromContext(ctx).Debug("Unable to access %v via proxy", h.InternetAccessTestUrl)
+		log.FromContext(ctx).Trace("Error was: %v", err)
+		return err
 	}
 
-	if resp.StatusCode == 200 {
-		log.Logger.Debug("Successfully connected to %v via proxy %v", h.InternetAccessTestUrl, h.ProxyUrl)
-		return true
+	if statusCode == 200 {
+		log.FromContext(ctx).Debug("Successfully connected to %v via proxy %v", h.InternetAccessTestUrl, h.ProxyUrl)
+		return nil
 	} else {
-		log.Logger.Debug("HTTP error when connecting to %v via proxy %v. HTTP status code was: %v", h.InternetAccessTestUrl, h.ProxyUrl, resp.StatusCode)
-		return false
+		log.FromContext(ctx).Debug("HTTP error when connecting to %v via proxy %v. HTTP status code was: %v", h.InternetAccessTestUrl, h.ProxyUrl, statusCode)
+		return fmt.Errorf("HTTP status code %v from %v via proxy %v", statusCode, h.InternetAccessTestUrl, h.ProxyUrl)
 	}
 }
 
@@ -83,11 +96,114 @@ func determineTimeout(timeoutInMilliseconds []int, defaultTimeoutInMilliseconds
 	}
 }
 
-func (h *HttpCheckerImpl) IsPxProxyReachable() bool {
+func (h *HttpCheckerImpl) IsPxProxyReachable(ctx context.Context) bool {
 	client := http.Client{
-		Timeout: time.Duration(h.DefaultTimeoutInMilliseconds) * time.Millisecond,
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   time.Duration(h.DefaultTimeoutInMilliseconds) * time.Millisecond,
 	}
 
-	_, err := client.Get(h.ProxyUrl.String())
+	_, err := status(ctx, &client, h.ProxyUrl.String())
 	return err == nil
 }
+
+func (h *HttpCheckerImpl) IsUrlReachable(ctx context.Context, url string) bool {
+	client := http.Client{
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   time.Duration(h.DefaultTimeoutInMilliseconds) * time.Millisecond,
+	}
+
+	statusCode, err := status(ctx, &client, url)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to directly access %v", url)
+		log.FromContext(ctx).Trace("Error was: %v", err)
+		return false
+	}
+	log.FromContext(ctx).Debug("Successfully connected directly to %v. HTTP status code was: %v", url, statusCode)
+	return true
+}
+
+// A captive portal answers the test URL with a redirect to its login page or
+// with its own HTML page instead of an empty 204
+func (h *HttpCheckerImpl) CaptivePortalLoginUrl(ctx context.Context) (string, bool) {
+	if h.CaptivePortalTestUrl == "" {
+		return "", false
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   time.Duration(h.DefaultTimeoutInMilliseconds) * time.Millisecond,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// the output is the login URL, empty without captive portal
+	request := func(ctx context.Context) ([]byte, error) {
+		resp, err := get(ctx, &client, h.CaptivePortalTestUrl)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		loginUrl, _ := parseCaptivePortalResponse(resp)
+		return []byte(loginUrl), nil
+	}
+	out, err := cmdrunner.RunFunc(ctx, request, "http", "--no-redirect", h.CaptivePortalTestUrl)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to access captive portal test URL %v", h.CaptivePortalTestUrl)
+		log.FromContext(ctx).Trace("Error was: %v", err)
+		return "", false
+	}
+	return string(out), len(out) > 0
+}
+
+// A redirect names the login page. A portal which serves its HTML page right
+// away is logged into at the test URL itself. Other answers, e.g. a 304 or a
+// 200 without HTML, are no portal
+func parseCaptivePortalResponse(resp *http.Response) (string, bool) {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		loginUrl, err := resp.Location()
+		if err != nil {
+			return "", false
+		}
+		return loginUrl.String(), true
+	case http.StatusOK:
+		mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "text/html" {
+			return "", false
+		}
+		return resp.Request.URL.String(), true
+	default:
+		return "", false
+	}
+}
+
+// Requests the URL via the runner, so recordings cover it like a command.
+// 'args' describe the client, e.g. its proxy. Returns the HTTP status code
+func status(ctx context.Context, client *http.Client, url string, args ...string) (int, error) {
+	request := func(ctx context.Context) ([]byte, error) {
+		resp, err := get(ctx, client, url)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return []byte(strconv.Itoa(resp.StatusCode)), nil
+	}
+	out, err := cmdrunner.RunFunc(ctx, request, "http", append(args, url)...)
+	if err != nil {
+		return 0, err
+	}
+	statusCode, err := strconv.Atoi(string(out))
+	if err != nil {
+		return 0, fmt.Errorf("invalid HTTP status code of %v: %w", url, err)
+	}
+	return statusCode, nil
+}
+
+// like http.Client.Get, but aborts the request once the context is done
+func get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -1,13 +1,16 @@
 package httpchecker
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
 )
 
 // TODO rename proxyUrl  -> pxProxyUrl
@@ -19,14 +22,28 @@ func TestDirectInternetAccess(t *testing.T) {
 	httpChecker, err := New(ts.URL, "")
 	assert.NoError(t, err)
 	httpChecker.DefaultTimeoutInMilliseconds = 100
-	assert.True(t, httpChecker.HasDirectInternetAccess())
+	assert.True(t, httpChecker.HasDirectInternetAccess(context.Background()))
+}
+
+// needed afterwards to warn about a mismatch with the working path
+func TestDirectAccessCheckKeepsTheProxyVars(t *testing.T) {
+	t.Setenv("https_proxy", "http://proxy.corp:3128")
+	t.Setenv("HTTPS_PROXY", "http://proxy.corp:3128")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	httpChecker, err := New(ts.URL, "")
+	assert.NoError(t, err)
+	assert.NoError(t, httpChecker.CheckDirectInternetAccess(context.Background(), 100))
+	assert.Equal(t, "http://proxy.corp:3128", os.Getenv("https_proxy"))
+	assert.Equal(t, "http://proxy.corp:3128", os.Getenv("HTTPS_PROXY"))
 }
 
 func TestExitOnWrongAddress(t *testing.T) {
 	httpChecker, err := New("http://non-existing", "")
 	assert.NoError(t, err)
 	httpChecker.DefaultTimeoutInMilliseconds = 100
-	assert.False(t, httpChecker.HasDirectInternetAccess())
+	assert.False(t, httpChecker.HasDirectInternetAccess(context.Background()))
 }
 
 func TestInternetAccessViaProxy(t *testing.T) {
@@ -46,7 +63,7 @@ func TestInternetAccessViaProxy(t *testing.T) {
 	httpChecker.DefaultTimeoutInMilliseconds = 100
 
 	// assert
-	assert.True(t, httpChecker.HasInternetAccessViaProxy())
+	assert.True(t, httpChecker.HasInternetAccessViaProxy(context.Background()))
 }
 
 func TestInternetAccessViaProxyFailed(t *testing.T) {
@@ -54,7 +71,7 @@ func TestInternetAccessViaProxyFailed(t *testing.T) {
 	assert.NoError(t, err)
 	httpChecker.DefaultTimeoutInMilliseconds = 100
 
-	assert.False(t, httpChecker.HasInternetAccessViaProxy())
+	assert.False(t, httpChecker.HasInternetAccessViaProxy(context.Background()))
 }
 
 func TestInvalidProxyAddress(t *testing.T) {
@@ -69,11 +86,121 @@ func TestPxProxyReachable(t *testing.T) {
 
 	httpChecker, err := New("", fakePxProxy.URL)
 	assert.NoError(t, err)
-	assert.True(t, httpChecker.IsPxProxyReachable())
+	assert.True(t, httpChecker.IsPxProxyReachable(context.Background()))
 }
 
 func TestPxProxyNotReachable(t *testing.T) {
 	httpChecker, err := New("", "http://127.0.0.1:9999")
 	assert.NoError(t, err)
-	assert.False(t, httpChecker.IsPxProxyReachable())
+	assert.False(t, httpChecker.IsPxProxyReachable(context.Background()))
+}
+
+func TestNoInternetAccessWhenCancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	httpChecker, err := New(ts.URL, "")
+	assert.NoError(t, err)
+	assert.False(t, httpChecker.HasDirectInternetAccess(ctx))
+}
+
+func TestUrlIsReachableOnHttpError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	httpChecker, err := New("", "")
+	assert.NoError(t, err)
+	httpChecker.DefaultTimeoutInMilliseconds = 100
+	assert.True(t, httpChecker.IsUrlReachable(context.Background(), ts.URL))
+	assert.False(t, httpChecker.IsUrlReachable(context.Background(), "http://non-existing"))
+}
+
+func TestNoCaptivePortal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	httpChecker := HttpCheckerImpl{CaptivePortalTestUrl: ts.URL, DefaultTimeoutInMilliseconds: 100}
+	_, found := httpChecker.CaptivePortalLoginUrl(context.Background())
+	assert.False(t, found)
+}
+
+func TestCaptivePortalRedirectsToLogin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://login.hotel-wifi.example/", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	httpChecker := HttpCheckerImpl{CaptivePortalTestUrl: ts.URL, DefaultTimeoutInMilliseconds: 100}
+	loginUrl, found := httpChecker.CaptivePortalLoginUrl(context.Background())
+	assert.True(t, found)
+	assert.Equal(t, "http://login.hotel-wifi.example/", loginUrl)
+}
+
+func TestCaptivePortalServesHtml(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>Please log in</html>"))
+	}))
+	defer ts.Close()
+
+	httpChecker := HttpCheckerImpl{CaptivePortalTestUrl: ts.URL, DefaultTimeoutInMilliseconds: 100}
+	loginUrl, found := httpChecker.CaptivePortalLoginUrl(context.Background())
+	assert.True(t, found)
+	assert.Equal(t, ts.URL, loginUrl)
+}
+
+func TestOtherAnswersAreNoCaptivePortals(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/not-modified" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	httpChecker := HttpCheckerImpl{CaptivePortalTestUrl: ts.URL, DefaultTimeoutInMilliseconds: 100}
+	_, found := httpChecker.CaptivePortalLoginUrl(context.Background())
+	assert.False(t, found)
+
+	httpChecker.CaptivePortalTestUrl = ts.URL + "/not-modified"
+	_, found = httpChecker.CaptivePortalLoginUrl(context.Background())
+	assert.False(t, found)
+}
+
+func TestCheckDirectInternetAccessReportsHttpError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	httpChecker, err := New(ts.URL, "")
+	assert.NoError(t, err)
+	assert.ErrorContains(t, httpChecker.CheckDirectInternetAccess(context.Background(), 100), "HTTP status code 403")
+}
+
+// a replay doesn't reach the network, an unrecorded request fails
+func TestRequestsAreRecordedAndReplayed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	httpChecker, err := New(ts.URL, "")
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	recorder, err := cmdrunner.NewRecorder(cmdrunner.ExecRunner{}, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, httpChecker.CheckDirectInternetAccess(cmdrunner.WithRunner(context.Background(), recorder), 100))
+	assert.NoError(t, recorder.Close())
+	ts.Close()
+
+	replayer, err := cmdrunner.NewReplayer(dir)
+	assert.NoError(t, err)
+	ctx := cmdrunner.WithRunner(context.Background(), replayer)
+	assert.NoError(t, httpChecker.CheckDirectInternetAccess(ctx, 100))
+	assert.False(t, httpChecker.IsPxProxyReachable(ctx))
 }
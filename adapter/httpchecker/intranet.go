@@ -0,0 +1,167 @@
+package httpchecker
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/core/model"
+	log "org.samba/isetta/simplelogger"
+)
+
+// Narrows down what's at fault: DNS, route or proxy. URLs are requested,
+// for host:port targets a TCP connection (via proxy: a CONNECT) is enough.
+func (h *HttpCheckerImpl) CheckIntranetTarget(ctx context.Context, target model.IntranetTarget) model.IntranetCheckResult {
+	result := model.IntranetCheckResult{Target: target}
+	result.Fault, result.Err = h.runIntranetCheck(ctx, target)
+	log.FromContext(ctx).Debug("Intranet check: %v", result)
+	return result
+}
+
+// checks via the runner, so recordings cover it like a command. The output
+// is the fault
+func (h *HttpCheckerImpl) runIntranetCheck(ctx context.Context, target model.IntranetTarget) (model.IntranetFault, error) {
+	check := func(ctx context.Context) ([]byte, error) {
+		fault, err := h.checkIntranetTarget(ctx, target)
+		return []byte(strconv.Itoa(int(fault))), err
+	}
+	args := []string{target.Target}
+	if target.ViaProxy {
+		args = append([]string{"--proxy", h.ProxyUrl.String()}, args...)
+	}
+	out, err := cmdrunner.RunFunc(ctx, check, "intranet", args...)
+	fault, parseErr := strconv.Atoi(string(out))
+	if parseErr != nil {
+		return model.FaultService, fmt.Errorf("invalid result of intranet check %v: %w", target, parseErr)
+	}
+	return model.IntranetFault(fault), err
+}
+
+func (h *HttpCheckerImpl) checkIntranetTarget(ctx context.Context, target model.IntranetTarget) (model.IntranetFault, error) {
+	timeout := time.Duration(h.DefaultTimeoutInMilliseconds) * time.Millisecond
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host, port, isUrl, err := parseIntranetTarget(target.Target)
+	if err != nil {
+		return model.FaultService, err
+	}
+	if target.ViaProxy {
+		if isUrl {
+			return h.requestViaProxy(ctx, target.Target)
+		}
+		return h.connectViaProxy(ctx, net.JoinHostPort(host, port))
+	}
+
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return model.FaultDns, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return model.FaultRoute, err
+	}
+	conn.Close()
+	if !isUrl {
+		return model.FaultNone, nil
+	}
+
+	client := http.Client{Transport: &http.Transport{Proxy: nil}}
+	return requestIntranetUrl(ctx, &client, target.Target, model.FaultService)
+}
+
+func (h *HttpCheckerImpl) requestViaProxy(ctx context.Context, targetUrl string) (model.IntranetFault, error) {
+	client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(h.ProxyUrl)}}
+	return requestIntranetUrl(ctx, &client, targetUrl, model.FaultProxy)
+}
+
+// any response counts, except gateway errors of the proxy and server errors.
+// A failed TLS handshake means the proxy or the route works
+func requestIntranetUrl(ctx context.Context, client *http.Client, targetUrl string, faultOnError model.IntranetFault) (model.IntranetFault, error) {
+	resp, err := get(ctx, client, targetUrl)
+	if isTlsError(err) {
+		return model.FaultTls, err
+	}
+	if err != nil {
+		return faultOnError, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusGatewayTimeout:
+		return model.FaultProxy, fmt.Errorf("HTTP status code %v", resp.StatusCode)
+	case resp.StatusCode >= 500:
+		return model.FaultService, fmt.Errorf("HTTP status code %v", resp.StatusCode)
+	default:
+		return model.FaultNone, nil
+	}
+}
+
+func (h *HttpCheckerImpl) connectViaProxy(ctx context.Context, hostPort string) (model.IntranetFault, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", h.ProxyUrl.Host)
+	if err != nil {
+		return model.FaultProxy, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: hostPort}, Host: hostPort, Header: http.Header{}}
+	err = req.Write(conn)
+	if err != nil {
+		return model.FaultProxy, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return model.FaultProxy, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return model.FaultProxy, fmt.Errorf("proxy answered CONNECT with HTTP status code %v", resp.StatusCode)
+	}
+	return model.FaultNone, nil
+}
+
+func isTlsError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+	return errors.As(err, &verificationErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.As(err, &recordHeaderErr)
+}
+
+// returns host and port of a URL or host:port target
+func parseIntranetTarget(target string) (string, string, bool, error) {
+	if !strings.Contains(target, "://") {
+		host, port, err := net.SplitHostPort(target)
+		return host, port, false, err
+	}
+
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return "", "", true, err
+	}
+	port := targetUrl.Port()
+	if port == "" {
+		port = "80"
+		if targetUrl.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return targetUrl.Hostname(), port, true, nil
+}
@@ -0,0 +1,111 @@
+package httpchecker
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
+)
+
+func newIntranetChecker(t *testing.T, proxyUrl string) HttpCheckerImpl {
+	parsedProxyUrl, err := url.Parse(proxyUrl)
+	assert.NoError(t, err)
+	return HttpCheckerImpl{ProxyUrl: parsedProxyUrl, DefaultTimeoutInMilliseconds: 500}
+}
+
+func TestIntranetUrlIsReachableDirectly(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	checker := newIntranetChecker(t, "")
+	result := checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: ts.URL})
+	assert.True(t, result.Ok())
+}
+
+func TestClosedPortIsARouteFault(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	checker := newIntranetChecker(t, "")
+	result := checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: address})
+	assert.Equal(t, model.FaultRoute, result.Fault)
+}
+
+func TestUnresolvableHostIsADnsFault(t *testing.T) {
+	checker := newIntranetChecker(t, "")
+	result := checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: "git.invalid:22"})
+	assert.Equal(t, model.FaultDns, result.Fault)
+}
+
+func TestConnectViaProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect && strings.HasPrefix(r.Host, "git.corp:") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	checker := newIntranetChecker(t, proxy.URL)
+	result := checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: "git.corp:22", ViaProxy: true})
+	assert.True(t, result.Ok())
+
+	result = checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: "artifacts.corp:443", ViaProxy: true})
+	assert.Equal(t, model.FaultProxy, result.Fault)
+}
+
+// tunnels CONNECT requests like Px proxy
+func tunnelingProxy(t *testing.T) *httptest.Server {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() { io.Copy(target, conn); target.Close() }()
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestUntrustedCertificateIsNoProxyFault(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	checker := newIntranetChecker(t, tunnelingProxy(t).URL)
+	result := checker.CheckIntranetTarget(context.Background(), model.IntranetTarget{Target: ts.URL, ViaProxy: true})
+	assert.Equal(t, model.FaultTls, result.Fault)
+}
+
+func TestParseIntranetTarget(t *testing.T) {
+	host, port, isUrl, err := parseIntranetTarget("https://artifacts.corp/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, []any{"artifacts.corp", "443", true}, []any{host, port, isUrl})
+
+	host, port, isUrl, err = parseIntranetTarget("git.corp:22")
+	assert.NoError(t, err)
+	assert.Equal(t, []any{"git.corp", "22", false}, []any{host, port, isUrl})
+
+	_, _, _, err = parseIntranetTarget("git.corp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,319 @@
+package java
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/pemfile"
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+)
+
+const jvmDir = "/usr/lib/jvm"
+
+// keytool reads the store password from this variable, so it doesn't show up
+// in the process list
+const storePasswordEnv = "ISETTA_STOREPASS"
+
+type JavaTruststoreConfigurerImpl struct {
+	AliasPrefix          string // all certificates imported by isetta carry this alias prefix
+	StorePassword        string
+	Pkcs12TruststorePath string
+}
+
+func (j *JavaTruststoreConfigurerImpl) FindJdks(ctx context.Context) []string {
+	candidates := []string{}
+	candidates = append(candidates, subDirs(ctx, jvmDir)...)
+	for _, sdkmanDir := range sdkmanDirs() {
+		candidates = append(candidates, subDirs(ctx, filepath.Join(sdkmanDir, "candidates", "java"))...)
+	}
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		candidates = append(candidates, javaHome)
+	}
+	return uniqueJdks(ctx, candidates)
+}
+
+// SDKMAN lives in the user's home. When running via sudo, also look into the home of the calling user
+func sdkmanDirs() []string {
+	dirs := []string{}
+	if sdkmanDir := os.Getenv("SDKMAN_DIR"); sdkmanDir != "" {
+		dirs = append(dirs, sdkmanDir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".sdkman"))
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		if u, err := user.Lookup(sudoUser); err == nil {
+			dirs = append(dirs, filepath.Join(u.HomeDir, ".sdkman"))
+		}
+	}
+	return dirs
+}
+
+func subDirs(ctx context.Context, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.FromContext(ctx).Trace("Unable to read %v: %v", dir, err)
+		return []string{}
+	}
+
+	dirs := []string{}
+	for _, entry := range entries {
+		dirs = append(dirs, filepath.Join(dir, entry.Name()))
+	}
+	return dirs
+}
+
+// filters for directories which look like a JDK. Since a lot of symlinks are involved
+// (e.g. SDKMAN's 'current' or Debian's shared 'cacerts'), JDKs are de-duplicated
+// by the real path of their truststore
+func uniqueJdks(ctx context.Context, candidates []string) []string {
+	jdks := []string{}
+	seenTruststores := map[string]bool{}
+
+	for _, candidate := range candidates {
+		cacerts, err := findCacerts(candidate)
+		if err != nil {
+			log.FromContext(ctx).Trace("Skipping %v: %v", candidate, err)
+			continue
+		}
+		if !isExecutable(keytool(candidate)) {
+			log.FromContext(ctx).Trace("Skipping %v: no keytool found", candidate)
+			continue
+		}
+
+		realCacerts, err := filepath.EvalSymlinks(cacerts)
+		if err != nil || seenTruststores[realCacerts] {
+			continue
+		}
+		seenTruststores[realCacerts] = true
+
+		log.FromContext(ctx).Debug("Found JDK %v", candidate)
+		jdks = append(jdks, candidate)
+	}
+	return jdks
+}
+
+func findCacerts(jdkHome string) (string, error) {
+	candidates := []string{
+		filepath.Join(jdkHome, "lib", "security", "cacerts"),
+		// Java 8 and older
+		filepath.Join(jdkHome, "jre", "lib", "security", "cacerts"),
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no 'cacerts' truststore found")
+}
+
+func keytool(jdkHome string) string {
+	return filepath.Join(jdkHome, "bin", "keytool")
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+// cacerts is only replaced once all certificates are imported, a failed
+// keytool call leaves it untouched
+func (j *JavaTruststoreConfigurerImpl) ImportCaCertificates(ctx context.Context, jdkHome string, caBundlePath string) error {
+	cacerts, err := findCacerts(jdkHome)
+	if err != nil {
+		return err
+	}
+
+	certificates, err := readCertificates(caBundlePath)
+	if err != nil {
+		return err
+	}
+
+	return replaceTruststore(ctx, cacerts, true, func(truststore string) error {
+		err := j.removeManagedAliases(ctx, jdkHome, truststore)
+		if err != nil {
+			return err
+		}
+		for _, certificate := range certificates {
+			err = j.importCertificate(ctx, jdkHome, truststore, certificate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The JVM uses the truststore instead of cacerts, so it starts with the
+// public CAs of the JDK. Otherwise hosts which aren't intercepted by the
+// corporate proxy would be untrusted
+func (j *JavaTruststoreConfigurerImpl) CreatePkcs12Truststore(ctx context.Context, jdkHome string, caBundlePath string) error {
+	cacerts, err := findCacerts(jdkHome)
+	if err != nil {
+		return err
+	}
+	certificates, err := readCertificates(caBundlePath)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(j.Pkcs12TruststorePath), 0755)
+	if err != nil {
+		return err
+	}
+	// created from scratch, this drops certificates which are no longer exported
+	return replaceTruststore(ctx, j.Pkcs12TruststorePath, false, func(truststore string) error {
+		err := j.copyCertificates(ctx, jdkHome, cacerts, truststore)
+		if err != nil {
+			return err
+		}
+		// cacerts might contain the certificates of an earlier import
+		err = j.removeManagedAliases(ctx, jdkHome, truststore)
+		if err != nil {
+			return err
+		}
+		for _, certificate := range certificates {
+			err := j.importCertificate(ctx, jdkHome, truststore, certificate, "-storetype", "PKCS12")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Builds the truststore in a temporary directory, starting from a copy of the
+// current one if 'copyCurrent' is set. The result is written via safefile,
+// i.e. atomically and backed up. New truststores are readable by the normal
+// user, e.g. via JAVA_TOOL_OPTIONS, existing ones keep their mode
+func replaceTruststore(ctx context.Context, path string, copyCurrent bool, build func(truststore string) error) error {
+	dir, err := os.MkdirTemp("", "isetta-truststore-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	truststore := filepath.Join(dir, filepath.Base(path))
+	if copyCurrent {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read truststore %v: %w", path, err)
+		}
+		err = os.WriteFile(truststore, content, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = build(truststore)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(truststore)
+	if err != nil {
+		return err
+	}
+	return safefile.Write(ctx, path, content, 0644)
+}
+
+// into a new PKCS12 truststore, cacerts uses the same password
+func (j *JavaTruststoreConfigurerImpl) copyCertificates(ctx context.Context, jdkHome string, cacerts string, truststore string) error {
+	log.FromContext(ctx).Debug("Copying the certificates of %v into %v", cacerts, truststore)
+	_, err := j.runKeytoolWithPasswords(ctx, jdkHome, "-importkeystore", "-noprompt",
+		"-srckeystore", cacerts, "-srcstorepass:env", storePasswordEnv,
+		"-destkeystore", truststore, "-deststoretype", "PKCS12", "-deststorepass:env", storePasswordEnv)
+	return err
+}
+
+// removes certificates of previous runs, e.g. when a CA was renewed
+func (j *JavaTruststoreConfigurerImpl) removeManagedAliases(ctx context.Context, jdkHome string, truststore string) error {
+	out, err := j.runKeytool(ctx, jdkHome, "-list", "-keystore", truststore)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range parseAliases(out, j.AliasPrefix) {
+		log.FromContext(ctx).Trace("Removing alias %v from %v", alias, truststore)
+		_, err = j.runKeytool(ctx, jdkHome, "-delete", "-alias", alias, "-keystore", truststore)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JavaTruststoreConfigurerImpl) importCertificate(ctx context.Context, jdkHome string, truststore string, certificate []byte, extraArgs ...string) error {
+	certFile, err := os.CreateTemp("", "isetta-*.pem")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certFile.Name())
+
+	err = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	certFile.Close()
+	if err != nil {
+		return err
+	}
+
+	alias := buildAlias(j.AliasPrefix, certificate)
+	log.FromContext(ctx).Debug("Importing certificate as alias %v into %v", alias, truststore)
+	args := []string{"-importcert", "-noprompt", "-alias", alias, "-file", certFile.Name(), "-keystore", truststore}
+	_, err = j.runKeytool(ctx, jdkHome, append(args, extraArgs...)...)
+	return err
+}
+
+func (j *JavaTruststoreConfigurerImpl) runKeytool(ctx context.Context, jdkHome string, args ...string) (string, error) {
+	return j.runKeytoolWithPasswords(ctx, jdkHome, append(args, "-storepass:env", storePasswordEnv)...)
+}
+
+// the arguments refer to the password via storePasswordEnv
+func (j *JavaTruststoreConfigurerImpl) runKeytoolWithPasswords(ctx context.Context, jdkHome string, args ...string) (string, error) {
+	log.FromContext(ctx).Trace("Running keytool %v", strings.Join(args, " "))
+
+	env := []string{storePasswordEnv + "=" + j.StorePassword}
+	out, err := cmdrunner.RunWithEnv(ctx, env, keytool(jdkHome), args...)
+	if err != nil {
+		return "", fmt.Errorf("keytool %v failed: %w, output was: %v", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func readCertificates(caBundlePath string) ([][]byte, error) {
+	certificates, err := pemfile.Certificates(caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle %v: %w", caBundlePath, err)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("CA bundle %v contains no certificates", caBundlePath)
+	}
+	return certificates, nil
+}
+
+// the alias is derived from the certificate's fingerprint, so re-imports are stable
+func buildAlias(prefix string, certificate []byte) string {
+	fingerprint := sha256.Sum256(certificate)
+	return prefix + hex.EncodeToString(fingerprint[:8])
+}
+
+// parses 'keytool -list' output lines like:
+// isetta-0123456789abcdef, Jan 2, 2024, trustedCertEntry,
+func parseAliases(keytoolListOutput string, prefix string) []string {
+	regex := regexp.MustCompile(fmt.Sprintf("(?m)^(%v[^,]*),", regexp.QuoteMeta(prefix)))
+
+	aliases := []string{}
+	for _, match := range regex.FindAllStringSubmatch(keytoolListOutput, -1) {
+		aliases = append(aliases, match[1])
+	}
+	return aliases
+}
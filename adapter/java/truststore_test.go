@@ -0,0 +1,172 @@
+package java
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/safefile"
+)
+
+// appends the imported aliases to the keystore file instead of running keytool
+type fakeKeytool struct {
+	failImport bool
+}
+
+func (f *fakeKeytool) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	if slices.Contains(cmd.Args, "changeit") || !slices.Contains(cmd.Env, storePasswordEnv+"=changeit") {
+		return []byte("password not passed via the environment"), errors.New("exit status 1")
+	}
+	switch cmd.Args[0] {
+	case "-importcert":
+		if f.failImport {
+			return []byte("keytool error"), errors.New("exit status 1")
+		}
+		keystore := argument(cmd, "-keystore")
+		content, _ := os.ReadFile(keystore)
+		return nil, os.WriteFile(keystore, append(content, []byte(argument(cmd, "-alias")+"\n")...), 0600)
+	case "-importkeystore":
+		content, _ := os.ReadFile(argument(cmd, "-srckeystore"))
+		return nil, os.WriteFile(argument(cmd, "-destkeystore"), content, 0600)
+	}
+	return nil, nil
+}
+
+func argument(cmd cmdrunner.Command, option string) string {
+	return cmd.Args[slices.Index(cmd.Args, option)+1]
+}
+
+func setupFakeKeytool(t *testing.T, fake *fakeKeytool) safefile.Store {
+	cmdrunner.Default = fake
+	original := safefile.Default
+	safefile.Default = safefile.Store{Dir: t.TempDir(), Keep: safefile.DefaultKeep}
+	t.Cleanup(func() {
+		cmdrunner.Default = cmdrunner.ExecRunner{}
+		safefile.Default = original
+	})
+	return safefile.Default
+}
+
+func writeCaBundle(t *testing.T) string {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("corporate")}), 0644)
+	return bundle
+}
+
+func TestParseAliases(t *testing.T) {
+	output := `Keystore type: JKS
+Keystore provider: SUN
+
+Your keystore contains 3 entries
+
+isetta-0123456789abcdef, Jan 2, 2024, trustedCertEntry,
+Certificate fingerprint (SHA-256): 01:23
+digicertglobalrootca [jdk], Jan 2, 2024, trustedCertEntry,
+Certificate fingerprint (SHA-256): 45:67
+isetta-fedcba9876543210, Jan 2, 2024, trustedCertEntry,
+`
+
+	assert.Equal(t, []string{"isetta-0123456789abcdef", "isetta-fedcba9876543210"}, parseAliases(output, "isetta-"))
+}
+
+func TestBuildAliasIsStable(t *testing.T) {
+	alias := buildAlias("isetta-", []byte("certificate"))
+
+	assert.Regexp(t, "^isetta-[0-9a-f]{16}$", alias)
+	assert.Equal(t, alias, buildAlias("isetta-", []byte("certificate")))
+	assert.NotEqual(t, alias, buildAlias("isetta-", []byte("other certificate")))
+}
+
+func TestReadCertificatesFromEmptyBundle(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bundle, []byte{}, 0644)
+
+	_, err := readCertificates(bundle)
+	assert.Error(t, err)
+}
+
+func TestUniqueJdks(t *testing.T) {
+	jvmDir := t.TempDir()
+	jdk17 := createFakeJdk(t, jvmDir, "jdk-17", "lib/security/cacerts")
+	jdk8 := createFakeJdk(t, jvmDir, "jdk-8", "jre/lib/security/cacerts")
+	// e.g. SDKMAN's 'current' symlink
+	current := filepath.Join(jvmDir, "current")
+	os.Symlink(jdk17, current)
+	noJdk := filepath.Join(jvmDir, "no-jdk")
+	os.Mkdir(noJdk, 0755)
+
+	assert.Equal(t, []string{jdk17, jdk8}, uniqueJdks(context.Background(), []string{jdk17, jdk8, current, noJdk}))
+}
+
+func createFakeJdk(t *testing.T, dir string, name string, cacerts string) string {
+	jdkHome := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Join(jdkHome, filepath.Dir(cacerts)), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(jdkHome, cacerts), []byte{}, 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(jdkHome, "bin"), 0755))
+	assert.NoError(t, os.WriteFile(keytool(jdkHome), []byte{}, 0755))
+	return jdkHome
+}
+
+func TestCacertsIsReplacedOnceAllCertificatesAreImported(t *testing.T) {
+	backups := setupFakeKeytool(t, &fakeKeytool{})
+	jdkHome := createFakeJdk(t, t.TempDir(), "jdk-17", "lib/security/cacerts")
+	cacerts := filepath.Join(jdkHome, "lib/security/cacerts")
+	os.WriteFile(cacerts, []byte("jdk\n"), 0644)
+	j := JavaTruststoreConfigurerImpl{AliasPrefix: "isetta-", StorePassword: "changeit"}
+
+	assert.NoError(t, j.ImportCaCertificates(context.Background(), jdkHome, writeCaBundle(t)))
+
+	content, _ := os.ReadFile(cacerts)
+	assert.Equal(t, "jdk\n"+buildAlias("isetta-", []byte("corporate"))+"\n", string(content))
+	saved, _ := backups.Backups()
+	assert.Len(t, saved, 1)
+}
+
+func TestFailedImportLeavesCacertsUntouched(t *testing.T) {
+	backups := setupFakeKeytool(t, &fakeKeytool{failImport: true})
+	jdkHome := createFakeJdk(t, t.TempDir(), "jdk-17", "lib/security/cacerts")
+	cacerts := filepath.Join(jdkHome, "lib/security/cacerts")
+	os.WriteFile(cacerts, []byte("jdk\n"), 0644)
+	j := JavaTruststoreConfigurerImpl{AliasPrefix: "isetta-", StorePassword: "changeit"}
+
+	assert.ErrorContains(t, j.ImportCaCertificates(context.Background(), jdkHome, writeCaBundle(t)), "keytool error")
+
+	content, _ := os.ReadFile(cacerts)
+	assert.Equal(t, "jdk\n", string(content))
+	saved, _ := backups.Backups()
+	assert.Empty(t, saved)
+}
+
+func TestPkcs12TruststoreIsReadableByTheUser(t *testing.T) {
+	setupFakeKeytool(t, &fakeKeytool{})
+	truststore := filepath.Join(t.TempDir(), "java", "truststore.p12")
+	j := JavaTruststoreConfigurerImpl{AliasPrefix: "isetta-", StorePassword: "changeit", Pkcs12TruststorePath: truststore}
+
+	jdkHome := createFakeJdk(t, t.TempDir(), "jdk-17", "lib/security/cacerts")
+
+	assert.NoError(t, j.CreatePkcs12Truststore(context.Background(), jdkHome, writeCaBundle(t)))
+
+	info, err := os.Stat(truststore)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+// public hosts which aren't intercepted still need the CAs of the JDK
+func TestPkcs12TruststoreStartsWithTheCasOfTheJdk(t *testing.T) {
+	setupFakeKeytool(t, &fakeKeytool{})
+	jdkHome := createFakeJdk(t, t.TempDir(), "jdk-17", "lib/security/cacerts")
+	os.WriteFile(filepath.Join(jdkHome, "lib/security/cacerts"), []byte("jdk\n"), 0644)
+	truststore := filepath.Join(t.TempDir(), "truststore.p12")
+	j := JavaTruststoreConfigurerImpl{AliasPrefix: "isetta-", StorePassword: "changeit", Pkcs12TruststorePath: truststore}
+
+	assert.NoError(t, j.CreatePkcs12Truststore(context.Background(), jdkHome, writeCaBundle(t)))
+
+	content, _ := os.ReadFile(truststore)
+	assert.Equal(t, "jdk\n"+buildAlias("isetta-", []byte("corporate"))+"\n", string(content))
+}
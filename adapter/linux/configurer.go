@@ -1,65 +1,235 @@
 package linux
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
-	"os/exec"
-	"strings"
+	"strconv"
 
 	"github.com/3th1nk/cidr"
-	"org.samba/isetta/helper"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/core/model"
 	log "org.samba/isetta/simplelogger"
 )
 
+// configures addresses and routes via rtnetlink
 type LinuxConfigurerImpl struct {
-	WindowsIp        string
-	LinuxIp          string
-	SubnetMask       string
+	WindowsIp  string
+	LinuxIp    string
+	SubnetMask string
+	Interface  string // e.g. eth0. The P2P address is labeled <Interface>:1
 }
 
-func (l *LinuxConfigurerImpl) SetP2pInterface() {
-	linuxIpCidr := getCidrNotation(l.LinuxIp, l.SubnetMask)
-	broadcast := getBroadcast(l.LinuxIp, l.SubnetMask)
-	err := exec.Command("ip", "addr", "change", linuxIpCidr, "broadcast", broadcast, "dev", "eth0", "label", "eth0:1").Run()
-	helper.AssertNoError2(err)
+func (l *LinuxConfigurerImpl) SetP2pInterface(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	index, err := l.interfaceIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	address, err := l.p2pAddress()
+	if err != nil {
+		return err
+	}
+	broadcast, err := getBroadcast(l.LinuxIp, l.SubnetMask)
+	if err != nil {
+		return err
+	}
+	err = addAddress(ctx, index, address, net.ParseIP(broadcast), l.p2pLabel(), true)
+	if err != nil {
+		return fmt.Errorf("error setting P2P address %v on %v: %w", l.LinuxIp, l.Interface, err)
+	}
+	return nil
+}
+
+func (l *LinuxConfigurerImpl) p2pLabel() string {
+	return l.Interface + ":1"
+}
+
+// the host address with the mask of the P2P subnet
+func (l *LinuxConfigurerImpl) p2pAddress() (*net.IPNet, error) {
+	return parseIpNet(l.LinuxIp, l.SubnetMask)
+}
+
+// looked up via the runner, so recordings cover it like the netlink requests
+func (l *LinuxConfigurerImpl) interfaceIndex(ctx context.Context) (int, error) {
+	lookup := func(ctx context.Context) ([]byte, error) {
+		iface, err := net.InterfaceByName(l.Interface)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(iface.Index)), nil
+	}
+	out, err := cmdrunner.RunFunc(ctx, lookup, "interface", l.Interface)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInterfaceMissing, l.Interface)
+	}
+	index, err := strconv.Atoi(string(out))
+	if err != nil {
+		return 0, fmt.Errorf("invalid index of interface %v: %w", l.Interface, err)
+	}
+	return index, nil
 }
 
 // returns IP address in CIDR notation like 192.168.2.1/24
-func getCidrNotation(ip string, subnetMask string) string {
+func getCidrNotation(ip string, subnetMask string) (string, error) {
+	ipNet, err := parseIpNet(ip, subnetMask)
+	if err != nil {
+		return "", err
+	}
+	return ipNet.String(), nil
+}
+
+func getBroadcast(ip string, subnetMask string) (string, error) {
+	cidrNotation, err := getCidrNotation(ip, subnetMask)
+	if err != nil {
+		return "", err
+	}
+	cidr2, err := cidr.Parse(cidrNotation)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %v: %w", cidrNotation, err)
+	}
+	return cidr2.Broadcast().String(), nil
+}
+
+func parseIpNet(ip string, subnetMask string) (*net.IPNet, error) {
 	ip2 := net.ParseIP(ip)
-	assertNotNil(ip2)
+	if ip2 == nil {
+		return nil, fmt.Errorf("error parsing IP address '%v'", ip)
+	}
 	subnetMask2 := net.ParseIP(subnetMask)
-	assertNotNil(subnetMask2)
+	if subnetMask2 == nil {
+		return nil, fmt.Errorf("error parsing subnet mask '%v'", subnetMask)
+	}
+	return &net.IPNet{IP: ip2, Mask: net.IPMask(subnetMask2.To4())}, nil
+}
+
+func (l *LinuxConfigurerImpl) DeleteDefaultGateway(ctx context.Context) {
+	err := deleteRoute(ctx, nil)
+	if err == nil {
+		log.FromContext(ctx).Trace("Deleted existing default route")
+	} else if errors.Is(err, ErrNotPresent) {
+		log.FromContext(ctx).Trace("No default route to delete")
+	} else {
+		log.FromContext(ctx).Debug("Failed to delete default route: %v", err)
+	}
+}
 
-	ipNet := net.IPNet{IP: ip2, Mask: net.IPMask(subnetMask2.To4())}
-	return ipNet.String()
+func (l *LinuxConfigurerImpl) AddDefaultGateway(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err := addRoute(ctx, nil, net.ParseIP(l.WindowsIp), false)
+	if err != nil {
+		return fmt.Errorf("error configuring default gateway %v on Linux side: %w", l.WindowsIp, err)
+	}
+	return nil
 }
 
-func getBroadcast(ip string, subnetMask string) string {
-	cidr2, err := cidr.Parse(getCidrNotation(ip, subnetMask))
-	helper.AssertNoError2(err)
-	return cidr2.Broadcast().String()
+func (l *LinuxConfigurerImpl) RemoveP2pInterface(ctx context.Context) error {
+	index, err := l.interfaceIndex(ctx)
+	if err != nil {
+		return err
+	}
+	address, err := l.p2pAddress()
+	if err != nil {
+		return err
+	}
+	err = deleteAddress(ctx, index, address)
+	if err != nil {
+		return fmt.Errorf("error removing %v from %v: %w", address, l.Interface, err)
+	}
+	return nil
 }
 
+func (l *LinuxConfigurerImpl) P2pAddress(ctx context.Context) (model.InterfaceAddress, error) {
+	index, err := l.interfaceIndex(ctx)
+	if err != nil {
+		return model.InterfaceAddress{}, err
+	}
+	addresses, err := listAddresses(ctx)
+	if err != nil {
+		return model.InterfaceAddress{}, fmt.Errorf("error reading addresses of %v: %w", l.Interface, err)
+	}
+	return findP2pAddress(addresses, index, l.p2pLabel()), nil
+}
 
-func assertNotNil(ip net.IP) {
-	if ip == nil {
-		log.Logger.Error("Error parsing IP address %v", ip)
+func findP2pAddress(addresses []address, index int, label string) model.InterfaceAddress {
+	for _, a := range addresses {
+		if a.index == index && a.label == label {
+			return model.InterfaceAddress{Cidr: a.cidr, Broadcast: a.broadcast}
+		}
 	}
+	return model.InterfaceAddress{}
 }
 
-func (l *LinuxConfigurerImpl) DeleteDefaultGateway() {
-	cmd := exec.Command("ip", "route", "delete", "default")
-	out, err := cmd.CombinedOutput()
-	if err == nil {
-		log.Logger.Trace("Deleted existing default route")
-	} else {
-		tmp := strings.TrimSpace(string(out))		
-		log.Logger.Trace("Failed to deleted default route. Maybe it wasn't set? Output was: %v", tmp)
+func (l *LinuxConfigurerImpl) DefaultGateway(ctx context.Context) (string, error) {
+	routes, err := listRoutes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error reading default route: %w", err)
 	}
+	return parseDefaultGateway(routes)
 }
 
-func (l *LinuxConfigurerImpl) AddDefaultGateway() {
-	cmd := exec.Command("ip", "route", "add", "default", "via", l.WindowsIp)
-	out, err := cmd.CombinedOutput()
-	helper.AssertNoError(err, "error configuring default gateway on Linux side: %v", string(out))
+func (l *LinuxConfigurerImpl) RestoreDefaultGateway(ctx context.Context, gateway string) error {
+	l.DeleteDefaultGateway(ctx)
+	if gateway == "" {
+		return nil
+	}
+
+	err := addRoute(ctx, nil, net.ParseIP(gateway), false)
+	if err != nil {
+		return fmt.Errorf("error restoring default gateway %v: %w", gateway, err)
+	}
+	return nil
+}
+
+func (l *LinuxConfigurerImpl) AddRoute(ctx context.Context, subnet string, gateway string) error {
+	_, destination, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %v: %w", subnet, err)
+	}
+	err = addRoute(ctx, destination, net.ParseIP(gateway), true)
+	if err != nil {
+		return fmt.Errorf("error routing %v via %v: %w", subnet, gateway, err)
+	}
+	return nil
+}
+
+func (l *LinuxConfigurerImpl) Route(ctx context.Context, subnet string) (model.Route, error) {
+	_, destination, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return model.Route{}, fmt.Errorf("invalid subnet %v: %w", subnet, err)
+	}
+	routes, err := listRoutes(ctx)
+	if err != nil {
+		return model.Route{}, fmt.Errorf("error reading route to %v: %w", subnet, err)
+	}
+	r, err := parseRoute(routes, destination)
+	if err != nil || !r.found {
+		return model.Route{}, err
+	}
+	return model.Route{Cidr: destination.String(), Gateway: r.gateway, InterfaceIndex: r.index}, nil
+}
+
+func (l *LinuxConfigurerImpl) RestoreRoute(ctx context.Context, subnet string, previous model.Route) error {
+	_, destination, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %v: %w", subnet, err)
+	}
+	if previous.Cidr == "" {
+		err = deleteRoute(ctx, destination)
+		if errors.Is(err, ErrNotPresent) {
+			return nil
+		}
+	} else {
+		err = restoreRoute(ctx, destination, route{found: true, gateway: previous.Gateway, index: previous.InterfaceIndex})
+	}
+	if err != nil {
+		return fmt.Errorf("error restoring route to %v: %w", subnet, err)
+	}
+	return nil
 }
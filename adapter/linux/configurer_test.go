@@ -1,17 +1,42 @@
 package linux
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
 )
 
 func TestGetCidr(t *testing.T) {
-	ipWithCidr := getCidrNotation("192.168.1.1", "255.255.255.0")
+	ipWithCidr, err := getCidrNotation("192.168.1.1", "255.255.255.0")
+	assert.NoError(t, err)
 	assert.Equal(t, "192.168.1.1/24", ipWithCidr)
 }
 
 func TestGetBroadcastAddress(t *testing.T) {
-	broadcast := getBroadcast("192.168.1.1", "255.255.255.0")
+	broadcast, err := getBroadcast("192.168.1.1", "255.255.255.0")
+	assert.NoError(t, err)
 	assert.Equal(t, "192.168.1.255", broadcast)
 }
+
+func TestInvalidIpIsReported(t *testing.T) {
+	_, err := getBroadcast("192.168.1", "255.255.255.0")
+	assert.ErrorContains(t, err, "192.168.1")
+}
+
+func TestFindP2pAddress(t *testing.T) {
+	addresses := []address{
+		{index: 2, cidr: "172.28.70.5/20", broadcast: "172.28.79.255", label: "eth0"},
+		{index: 2, cidr: "192.168.99.2/24", broadcast: "192.168.99.255", label: "eth0:1"},
+	}
+	assert.Equal(t, model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, findP2pAddress(addresses, 2, "eth0:1"))
+	assert.Equal(t, model.InterfaceAddress{}, findP2pAddress(addresses, 3, "eth0:1"))
+}
+
+func TestMissingInterfaceIsReported(t *testing.T) {
+	configurer := LinuxConfigurerImpl{LinuxIp: "192.168.99.2", SubnetMask: "255.255.255.0", Interface: "isetta-missing0"}
+	_, err := configurer.P2pAddress(context.Background())
+	assert.ErrorIs(t, err, ErrInterfaceMissing)
+	assert.ErrorContains(t, err, "isetta-missing0")
+}
@@ -0,0 +1,395 @@
+package linux
+
+// minimal rtnetlink client for the few address and route operations isetta
+// needs, based on the syscall package only. Requests are answered by an
+// acknowledgement carrying the errno, dumps via syscall.NetlinkRIB. Both run
+// via cmdrunner like the external commands, e.g. as 'netlink route delete
+// default', so they are recorded and a replay doesn't change the machine.
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"org.samba/isetta/cmdrunner"
+)
+
+var (
+	ErrInterfaceMissing   = errors.New("interface missing")
+	ErrAddressExists      = errors.New("address already present")
+	ErrRouteExists        = errors.New("route already present")
+	ErrGatewayUnreachable = errors.New("gateway unreachable")
+	ErrNotPresent         = errors.New("not present")
+)
+
+const (
+	sizeofIfAddrmsg = 8
+	sizeofRtMsg     = 12
+	// matches routes of any scope when deleting
+	scopeNowhere = 255
+)
+
+// waiting for an acknowledgement if the context has no deadline
+const netlinkTimeout = 10 * time.Second
+
+var netlinkSeq uint32
+
+// translates the errno of an acknowledgement. 'exists' is returned for EEXIST,
+// it depends on whether an address or a route was added. Replayed requests
+// carry the errno as exit code
+func netlinkError(err error, exists error) error {
+	var errno syscall.Errno
+	var exitErr *cmdrunner.ExitError
+	if errors.As(err, &exitErr) && exitErr.Code > 0 {
+		errno = syscall.Errno(exitErr.Code)
+	} else if !errors.As(err, &errno) {
+		return err
+	}
+	switch errno {
+	case syscall.ENODEV:
+		return fmt.Errorf("%w (%v)", ErrInterfaceMissing, errno)
+	case syscall.EEXIST:
+		return fmt.Errorf("%w (%v)", exists, errno)
+	case syscall.ENETUNREACH:
+		return fmt.Errorf("%w (%v)", ErrGatewayUnreachable, errno)
+	case syscall.ESRCH, syscall.EADDRNOTAVAIL:
+		return fmt.Errorf("%w (%v)", ErrNotPresent, errno)
+	}
+	return err
+}
+
+// sends a single request, described by args, e.g. 'route delete default'
+func netlinkRequest(ctx context.Context, msgType uint16, flags uint16, body []byte, args ...string) error {
+	request := func(ctx context.Context) ([]byte, error) {
+		return nil, sendNetlinkRequest(ctx, msgType, flags, body)
+	}
+	_, err := cmdrunner.RunFunc(ctx, request, "netlink", args...)
+	return err
+}
+
+// sends the request and waits for its acknowledgement until the context is done
+func sendNetlinkRequest(ctx context.Context, msgType uint16, flags uint16, body []byte) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+
+	seq := atomic.AddUint32(&netlinkSeq, 1)
+	msg := encodeMessage(msgType, flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK, seq, body)
+	err = syscall.Sendto(fd, msg, 0, kernel)
+	if err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+
+	err = setReceiveTimeout(ctx, fd)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if errors.Is(err, syscall.EAGAIN) && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, syscall.EAGAIN) {
+			return errors.New("no netlink acknowledgement received in time")
+		}
+		if err != nil {
+			return os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq == seq && m.Header.Type == syscall.NLMSG_ERROR {
+				return parseAck(m.Data)
+			}
+		}
+	}
+}
+
+// SO_RCVTIMEO from the deadline of the context, a receive after it fails with EAGAIN
+func setReceiveTimeout(ctx context.Context, fd int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	timeout := netlinkTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(time.Until(deadline), time.Millisecond)
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	return os.NewSyscallError("setsockopt", err)
+}
+
+// an acknowledgement is an error message with errno 0
+func parseAck(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("truncated netlink acknowledgement")
+	}
+	code := int32(binary.NativeEndian.Uint32(data[:4]))
+	if code == 0 {
+		return nil
+	}
+	return syscall.Errno(-code)
+}
+
+func encodeMessage(msgType uint16, flags uint16, seq uint32, body []byte) []byte {
+	length := syscall.NLMSG_HDRLEN + len(body)
+	b := make([]byte, syscall.NLMSG_HDRLEN, length)
+	binary.NativeEndian.PutUint32(b[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(b[4:6], msgType)
+	binary.NativeEndian.PutUint16(b[6:8], flags)
+	binary.NativeEndian.PutUint32(b[8:12], seq)
+	return append(b, body...)
+}
+
+func encodeAttr(attrType uint16, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, rtaAlign(length))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(b[2:4], attrType)
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
+
+func rtaAlign(length int) int {
+	return (length + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+}
+
+// body of RTM_NEWADDR and RTM_DELADDR, an empty label keeps the interface name
+func addressMessage(index int, address *net.IPNet, broadcast net.IP, label string) []byte {
+	prefixLen, _ := address.Mask.Size()
+	b := []byte{syscall.AF_INET, byte(prefixLen), 0, syscall.RT_SCOPE_UNIVERSE, 0, 0, 0, 0}
+	binary.NativeEndian.PutUint32(b[4:8], uint32(index))
+	b = append(b, encodeAttr(syscall.IFA_LOCAL, address.IP.To4())...)
+	b = append(b, encodeAttr(syscall.IFA_ADDRESS, address.IP.To4())...)
+	if broadcast != nil {
+		b = append(b, encodeAttr(syscall.IFA_BROADCAST, broadcast.To4())...)
+	}
+	if label != "" {
+		b = append(b, encodeAttr(syscall.IFA_LABEL, append([]byte(label), 0))...)
+	}
+	return b
+}
+
+// body of RTM_NEWROUTE and RTM_DELROUTE in the main table. A nil destination
+// is the default route
+func routeMessage(destination *net.IPNet, gateway net.IP, protocol byte, scope byte) []byte {
+	dstLen := 0
+	if destination != nil {
+		dstLen, _ = destination.Mask.Size()
+	}
+	b := []byte{syscall.AF_INET, byte(dstLen), 0, 0, syscall.RT_TABLE_MAIN, protocol, scope, syscall.RTN_UNICAST, 0, 0, 0, 0}
+	if destination != nil {
+		b = append(b, encodeAttr(syscall.RTA_DST, destination.IP.To4())...)
+	}
+	if gateway != nil {
+		b = append(b, encodeAttr(syscall.RTA_GATEWAY, gateway.To4())...)
+	}
+	return b
+}
+
+func addAddress(ctx context.Context, index int, address *net.IPNet, broadcast net.IP, label string, replace bool) error {
+	flags, verb := uint16(syscall.NLM_F_CREATE|syscall.NLM_F_EXCL), "add"
+	if replace {
+		flags, verb = syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, "replace"
+	}
+	err := netlinkRequest(ctx, syscall.RTM_NEWADDR, flags, addressMessage(index, address, broadcast, label),
+		"address", verb, address.String(), "label", label)
+	return netlinkError(err, ErrAddressExists)
+}
+
+func deleteAddress(ctx context.Context, index int, address *net.IPNet) error {
+	err := netlinkRequest(ctx, syscall.RTM_DELADDR, 0, addressMessage(index, address, nil, ""),
+		"address", "delete", address.String())
+	return netlinkError(err, ErrAddressExists)
+}
+
+func addRoute(ctx context.Context, destination *net.IPNet, gateway net.IP, replace bool) error {
+	if gateway.To4() == nil {
+		return fmt.Errorf("invalid gateway '%v'", gateway)
+	}
+	flags, verb := uint16(syscall.NLM_F_CREATE|syscall.NLM_F_EXCL), "add"
+	if replace {
+		flags, verb = syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, "replace"
+	}
+	body := routeMessage(destination, gateway, syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)
+	err := netlinkRequest(ctx, syscall.RTM_NEWROUTE, flags, body, "route", verb, routeDestination(destination), "via", gateway.String())
+	return netlinkError(err, ErrRouteExists)
+}
+
+// recreates a route to a subnet as it was read before, also one via a device only
+func restoreRoute(ctx context.Context, destination *net.IPNet, r route) error {
+	body := routeMessage(destination, net.ParseIP(r.gateway), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)
+	args := []string{"route", "replace", routeDestination(destination)}
+	if r.gateway != "" {
+		args = append(args, "via", r.gateway)
+	}
+	if r.index > 0 {
+		oif := make([]byte, 4)
+		binary.NativeEndian.PutUint32(oif, uint32(r.index))
+		body = append(body, encodeAttr(syscall.RTA_OIF, oif)...)
+		args = append(args, "dev", fmt.Sprint(r.index))
+	}
+	if r.gateway == "" {
+		// scope link, like the routes the kernel adds for a device
+		body[6] = syscall.RT_SCOPE_LINK
+	}
+	err := netlinkRequest(ctx, syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, body, args...)
+	return netlinkError(err, ErrRouteExists)
+}
+
+func deleteRoute(ctx context.Context, destination *net.IPNet) error {
+	err := netlinkRequest(ctx, syscall.RTM_DELROUTE, 0, routeMessage(destination, nil, 0, scopeNowhere),
+		"route", "delete", routeDestination(destination))
+	return netlinkError(err, ErrRouteExists)
+}
+
+func routeDestination(destination *net.IPNet) string {
+	if destination == nil {
+		return "default"
+	}
+	return destination.String()
+}
+
+// the dump is hex encoded, so recordings keep it as text
+func dump(ctx context.Context, msgType int, args ...string) ([]syscall.NetlinkMessage, error) {
+	request := func(ctx context.Context) ([]byte, error) {
+		rib, err := syscall.NetlinkRIB(msgType, syscall.AF_INET)
+		if err != nil {
+			return nil, os.NewSyscallError("netlinkrib", err)
+		}
+		return []byte(hex.EncodeToString(rib)), nil
+	}
+	out, err := cmdrunner.RunFunc(ctx, request, "netlink", args...)
+	if err != nil {
+		return nil, err
+	}
+	rib, err := hex.DecodeString(string(out))
+	if err != nil {
+		return nil, fmt.Errorf("invalid netlink dump: %w", err)
+	}
+	return syscall.ParseNetlinkMessage(rib)
+}
+
+type address struct {
+	index     int
+	cidr      string
+	broadcast string
+	label     string
+}
+
+func listAddresses(ctx context.Context) ([]address, error) {
+	msgs, err := dump(ctx, syscall.RTM_GETADDR, "address", "show")
+	if err != nil {
+		return nil, err
+	}
+	return parseAddresses(msgs)
+}
+
+func parseAddresses(msgs []syscall.NetlinkMessage) ([]address, error) {
+	addresses := []address{}
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < sizeofIfAddrmsg {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixLen := int(m.Data[1])
+		a := address{index: int(binary.NativeEndian.Uint32(m.Data[4:8]))}
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_LOCAL:
+				a.cidr = fmt.Sprintf("%v/%v", net.IP(attr.Value), prefixLen)
+			case syscall.IFA_BROADCAST:
+				a.broadcast = net.IP(attr.Value).String()
+			case syscall.IFA_LABEL:
+				a.label = string(trimNul(attr.Value))
+			}
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, nil
+}
+
+func listRoutes(ctx context.Context) ([]syscall.NetlinkMessage, error) {
+	return dump(ctx, syscall.RTM_GETROUTE, "route", "show")
+}
+
+// gateway of the default route in the main table, empty if there is none
+func parseDefaultGateway(msgs []syscall.NetlinkMessage) (string, error) {
+	r, err := parseRoute(msgs, nil)
+	return r.gateway, err
+}
+
+type route struct {
+	found   bool
+	gateway string // empty for a route via a device only
+	index   int    // of the outgoing interface, 0 if not set
+}
+
+// the route to the destination in the main table. A nil destination is the default route
+func parseRoute(msgs []syscall.NetlinkMessage, destination *net.IPNet) (route, error) {
+	dstLen, wantedDst := 0, net.IP(nil)
+	if destination != nil {
+		dstLen, _ = destination.Mask.Size()
+		wantedDst = destination.IP.To4()
+	}
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < sizeofRtMsg {
+			continue
+		}
+		if int(m.Data[1]) != dstLen || m.Data[4] != syscall.RT_TABLE_MAIN {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(m)
+		if err != nil {
+			return route{}, err
+		}
+		r, dst := route{found: true}, net.IP(nil)
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_DST:
+				dst = net.IP(attr.Value)
+			case syscall.RTA_GATEWAY:
+				r.gateway = net.IP(attr.Value).String()
+			case syscall.RTA_OIF:
+				r.index = int(binary.NativeEndian.Uint32(attr.Value))
+			}
+		}
+		if wantedDst == nil || wantedDst.Equal(dst) {
+			return r, nil
+		}
+	}
+	return route{}, nil
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package linux
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+)
+
+func netlinkMessage(msgType uint16, body []byte) syscall.NetlinkMessage {
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Len: uint32(syscall.NLMSG_HDRLEN + len(body)), Type: msgType},
+		Data:   body,
+	}
+}
+
+func TestAddressMessageCanBeParsed(t *testing.T) {
+	ip, subnet, _ := net.ParseCIDR("192.168.99.2/24")
+	body := addressMessage(2, &net.IPNet{IP: ip, Mask: subnet.Mask}, net.ParseIP("192.168.99.255"), "eth0:1")
+
+	addresses, err := parseAddresses([]syscall.NetlinkMessage{netlinkMessage(syscall.RTM_NEWADDR, body)})
+	assert.NoError(t, err)
+	assert.Equal(t, []address{{index: 2, cidr: "192.168.99.2/24", broadcast: "192.168.99.255", label: "eth0:1"}}, addresses)
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	msgs := []syscall.NetlinkMessage{
+		netlinkMessage(syscall.RTM_NEWROUTE, routeMessage(internal, net.ParseIP("192.168.99.1"), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)),
+		netlinkMessage(syscall.RTM_NEWROUTE, routeMessage(nil, net.ParseIP("172.28.64.1"), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)),
+	}
+
+	gateway, err := parseDefaultGateway(msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, "172.28.64.1", gateway)
+}
+
+func TestParseMissingDefaultGateway(t *testing.T) {
+	gateway, err := parseDefaultGateway(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", gateway)
+}
+
+func TestParseRouteToSubnet(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	_, other, _ := net.ParseCIDR("11.0.0.0/8")
+	msgs := []syscall.NetlinkMessage{
+		netlinkMessage(syscall.RTM_NEWROUTE, routeMessage(nil, net.ParseIP("172.28.64.1"), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)),
+		netlinkMessage(syscall.RTM_NEWROUTE, routeMessage(other, net.ParseIP("172.28.64.2"), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)),
+		netlinkMessage(syscall.RTM_NEWROUTE, routeMessage(internal, net.ParseIP("192.168.99.1"), syscall.RTPROT_BOOT, syscall.RT_SCOPE_UNIVERSE)),
+	}
+
+	r, err := parseRoute(msgs, internal)
+	assert.NoError(t, err)
+	assert.Equal(t, route{found: true, gateway: "192.168.99.1"}, r)
+
+	_, missing, _ := net.ParseCIDR("12.0.0.0/8")
+	r, err = parseRoute(msgs, missing)
+	assert.NoError(t, err)
+	assert.False(t, r.found)
+}
+
+func TestEncodedAttributesAreAligned(t *testing.T) {
+	attr := encodeAttr(syscall.IFA_LABEL, []byte("eth0:1\x00"))
+	assert.Len(t, attr, 12)
+	assert.Equal(t, uint16(11), binary.NativeEndian.Uint16(attr[0:2]))
+}
+
+func ack(errno syscall.Errno) []byte {
+	code := -int32(errno)
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, uint32(code))
+	return b
+}
+
+func TestAcknowledgementErrors(t *testing.T) {
+	assert.NoError(t, parseAck(ack(0)))
+	assert.ErrorIs(t, netlinkError(parseAck(ack(syscall.ENETUNREACH)), ErrRouteExists), ErrGatewayUnreachable)
+	assert.ErrorIs(t, netlinkError(parseAck(ack(syscall.EEXIST)), ErrAddressExists), ErrAddressExists)
+}
+
+// answers netlink requests like a replay, without running them
+type fakeNetlink struct {
+	commands []string
+	err      error
+}
+
+func (f *fakeNetlink) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	f.commands = append(f.commands, cmd.String())
+	return nil, f.err
+}
+
+func TestNetlinkRequestsRunViaCmdRunner(t *testing.T) {
+	fake := &fakeNetlink{err: &cmdrunner.ExitError{Code: int(syscall.ESRCH)}}
+	cmdrunner.Default = fake
+	t.Cleanup(func() { cmdrunner.Default = cmdrunner.ExecRunner{} })
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+
+	err := deleteRoute(context.Background(), internal)
+	assert.ErrorIs(t, err, ErrNotPresent)
+	fake.err = nil
+	assert.NoError(t, addRoute(context.Background(), nil, net.ParseIP("192.168.99.1"), false))
+	assert.Equal(t, []string{"netlink route delete 10.0.0.0/8", "netlink route add default via 192.168.99.1"}, fake.commands)
+}
@@ -1,17 +1,34 @@
 package linux
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/go-ping/ping"
-	"org.samba/isetta/helper"
+	"org.samba/isetta/cmdrunner"
+	log "org.samba/isetta/simplelogger"
 )
 
 type LinuxPingerImpl struct{}
 
-func (LinuxPingerImpl) Ping(host string) bool {
+// pings via the runner, so recordings cover them like commands
+func (LinuxPingerImpl) Ping(ctx context.Context, host string) bool {
+	_, err := cmdrunner.RunFunc(ctx, func(ctx context.Context) ([]byte, error) {
+		return nil, sendPings(ctx, host)
+	}, "ping", host)
+	if err != nil {
+		log.FromContext(ctx).Trace("Ping of %v failed: %v", host, err)
+	}
+	return ctx.Err() == nil && err == nil
+}
+
+func sendPings(ctx context.Context, host string) error {
 	pinger, err := ping.NewPinger(host)
-	helper.AssertNoError2(err)
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to ping %v: %v", host, err)
+		return err
+	}
 
 	// required to use ICMP
 	pinger.SetPrivileged(true)
@@ -19,6 +36,23 @@ func (LinuxPingerImpl) Ping(host string) bool {
 	pinger.Interval = 300 * time.Millisecond
 	pinger.Timeout = 2 * time.Second
 
+	// the pinger has no context support
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pinger.Stop()
+		case <-done:
+		}
+	}()
+
 	err = pinger.Run()
-	return err == nil && pinger.Statistics().PacketsRecv != 0
+	if err != nil {
+		return err
+	}
+	if pinger.Statistics().PacketsRecv == 0 {
+		return errors.New("no reply")
+	}
+	return nil
 }
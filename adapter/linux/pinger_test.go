@@ -1,6 +1,7 @@
 package linux
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -13,7 +14,7 @@ func TestPingOk(t *testing.T) {
 	}
 
 	pinger := LinuxPingerImpl{}
-	assert.True(t, pinger.Ping("127.0.0.1"))
+	assert.True(t, pinger.Ping(context.Background(), "127.0.0.1"))
 }
 
 func TestPingNotOk(t *testing.T) {
@@ -22,7 +23,7 @@ func TestPingNotOk(t *testing.T) {
 	}
 
 	pinger := LinuxPingerImpl{}
-	assert.False(t, pinger.Ping("42.42.42.42"))
+	assert.False(t, pinger.Ping(context.Background(), "42.42.42.42"))
 }
 
 func TestPingNotOkSinceNetworkIsNotReachable(t *testing.T) {
@@ -30,7 +31,7 @@ func TestPingNotOkSinceNetworkIsNotReachable(t *testing.T) {
 		t.Skip("Test requires root rights")
 	}
 	pinger := LinuxPingerImpl{}
-	assert.False(t, pinger.Ping("42.43.44.45"))
+	assert.False(t, pinger.Ping(context.Background(), "42.43.44.45"))
 }
 
 
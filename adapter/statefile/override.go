@@ -0,0 +1,80 @@
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/userdir"
+)
+
+// Override as JSON file in the state directory of the calling user, e.g.
+// {"scenario":"proxy","until":"2026-10-19T15:04:05+02:00"}
+type OverrideStoreImpl struct {
+	Path string
+}
+
+type overrideFile struct {
+	Scenario string     `json:"scenario"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+func (s *OverrideStoreImpl) Load() (model.Override, bool, error) {
+	content, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return model.Override{}, false, nil
+	}
+	if err != nil {
+		return model.Override{}, false, err
+	}
+
+	var file overrideFile
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return model.Override{}, false, fmt.Errorf("error parsing %v: %w", s.Path, err)
+	}
+	scenario, err := model.ParseScenario(file.Scenario)
+	if err != nil {
+		return model.Override{}, false, fmt.Errorf("error parsing %v: %w", s.Path, err)
+	}
+
+	override := model.Override{Scenario: scenario}
+	if file.Until != nil {
+		override.Until = *file.Until
+	}
+	return override, true, nil
+}
+
+func (s *OverrideStoreImpl) Save(override model.Override) error {
+	file := overrideFile{Scenario: override.Scenario.String()}
+	if !override.Until.IsZero() {
+		file.Until = &override.Until
+	}
+	content, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	err = userdir.MkdirAll(filepath.Dir(s.Path))
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.Path, content, 0644)
+	if err != nil {
+		return err
+	}
+	return userdir.Chown(s.Path)
+}
+
+func (s *OverrideStoreImpl) Clear() error {
+	err := os.Remove(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
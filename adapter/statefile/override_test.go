@@ -0,0 +1,44 @@
+package statefile
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
+)
+
+func TestNoOverrideWithoutFile(t *testing.T) {
+	store := OverrideStoreImpl{Path: filepath.Join(t.TempDir(), "override.json")}
+
+	_, found, err := store.Load()
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.NoError(t, store.Clear())
+}
+
+func TestSaveAndLoadOverride(t *testing.T) {
+	store := OverrideStoreImpl{Path: filepath.Join(t.TempDir(), "state", "override.json")}
+	until := time.Date(2026, 10, 19, 15, 4, 0, 0, time.UTC)
+
+	assert.NoError(t, store.Save(model.Override{Scenario: model.ScenarioViaProxy, Until: until}))
+	override, found, err := store.Load()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, model.ScenarioViaProxy, override.Scenario)
+	assert.True(t, until.Equal(override.Until))
+
+	assert.NoError(t, store.Clear())
+	_, found, _ = store.Load()
+	assert.False(t, found)
+}
+
+func TestOverrideWithoutExpiry(t *testing.T) {
+	store := OverrideStoreImpl{Path: filepath.Join(t.TempDir(), "override.json")}
+
+	assert.NoError(t, store.Save(model.Override{Scenario: model.ScenarioDirect}))
+	override, _, err := store.Load()
+	assert.NoError(t, err)
+	assert.True(t, override.Until.IsZero())
+}
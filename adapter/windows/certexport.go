@@ -0,0 +1,93 @@
+package windows
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+)
+
+type CaCertificateExporterImpl struct {
+	SubjectFilters []string // substrings of the certificate subjects to export
+	CaBundlePath   string
+}
+
+func (c *CaCertificateExporterImpl) ExportCaCertificates(ctx context.Context) (string, error) {
+	if len(c.SubjectFilters) == 0 {
+		return "", errors.New("no corporate CA configured. Set 'windows_ca_subjects' in section [certificates]")
+	}
+
+	output, err := runInPowerShell(ctx, buildCertificateExportCommand(c.SubjectFilters))
+	if err != nil {
+		return "", err
+	}
+	bundle, err := toPemBundle(ctx, output)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.CaBundlePath), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	log.FromContext(ctx).Debug("Writing CA bundle to %v", c.CaBundlePath)
+	err = safefile.Write(ctx, c.CaBundlePath, bundle, 0644)
+	if err != nil {
+		return "", fmt.Errorf("unable to write CA bundle %v: %w", c.CaBundlePath, err)
+	}
+	return c.CaBundlePath, nil
+}
+
+// prints the DER encoded certificates base64 encoded, one per line
+func buildCertificateExportCommand(subjectFilters []string) string {
+	conditions := []string{}
+	for _, filter := range subjectFilters {
+		// single quotes are escaped by doubling them in Powershell
+		escapedFilter := strings.ReplaceAll(filter, "'", "''")
+		conditions = append(conditions, fmt.Sprintf("$_.Subject -like '*%v*'", escapedFilter))
+	}
+
+	return fmt.Sprintf("Get-ChildItem -Path Cert:\\LocalMachine\\Root | "+
+		"Where-Object { %v } | "+
+		"ForEach-Object { [Convert]::ToBase64String($_.RawData) }",
+		strings.Join(conditions, " -or "))
+}
+
+func toPemBundle(ctx context.Context, output string) ([]byte, error) {
+	var bundle bytes.Buffer
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		der, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode exported certificate: %w", err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse exported certificate: %w", err)
+		}
+		log.FromContext(ctx).Debug("Exporting certificate '%v'", cert.Subject)
+
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	if bundle.Len() == 0 {
+		return nil, errors.New("no matching CA certificate found in the Windows certificate store")
+	}
+	return bundle.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+package windows
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCertificateExportCommand(t *testing.T) {
+	cmd := buildCertificateExportCommand([]string{"Corp Root CA", "Bob's CA"})
+
+	assert.Contains(t, cmd, "Cert:\\LocalMachine\\Root")
+	assert.Contains(t, cmd, "$_.Subject -like '*Corp Root CA*' -or $_.Subject -like '*Bob''s CA*'")
+}
+
+func TestToPemBundle(t *testing.T) {
+	output := base64.StdEncoding.EncodeToString(createCertificate(t, "Corp Root CA")) + "\r\n" +
+		base64.StdEncoding.EncodeToString(createCertificate(t, "Corp Issuing CA")) + "\r\n"
+
+	bundle, err := toPemBundle(context.Background(), output)
+	assert.NoError(t, err)
+
+	block, rest := pem.Decode(bundle)
+	assert.Equal(t, "CERTIFICATE", block.Type)
+	block, _ = pem.Decode(rest)
+	assert.Equal(t, "CERTIFICATE", block.Type)
+}
+
+func TestToPemBundleWithoutCertificates(t *testing.T) {
+	_, err := toPemBundle(context.Background(), "\r\n")
+	assert.Error(t, err)
+}
+
+func TestToPemBundleWithGarbage(t *testing.T) {
+	_, err := toPemBundle(context.Background(), "Get-ChildItem : Cannot find path")
+	assert.Error(t, err)
+}
+
+func createCertificate(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return der
+}
@@ -1,58 +1,157 @@
 package windows
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
-	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"golang.org/x/text/encoding/unicode"
-	"org.samba/isetta/helper"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/core/model"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
 type WindowsCheckerImpl struct {
 	PxProxyPort int
+	Adapter     *WslAdapter
 }
 
-func (WindowsCheckerImpl) IsPingable(host string) bool {
-	log.Logger.Trace("Checking if reachable inside Windows")
+func (WindowsCheckerImpl) IsPingable(ctx context.Context, host string) bool {
+	log.FromContext(ctx).Trace("Checking if reachable inside Windows")
 	cmd := fmt.Sprintf("ping.exe -n 2 -w 100 %v > $null; $LASTEXITCODE", host)
-	exitCode := runInPowerShell(cmd)
-	return exitCode == "0"
+	return outputOfCheck(ctx, cmd) == "0"
 }
 
-func (w *WindowsCheckerImpl) IsPxProxyRunning() bool {
+func (w *WindowsCheckerImpl) IsPxProxyRunning(ctx context.Context) bool {
 	pxProxyPort := fmt.Sprint(w.PxProxyPort)
-	return isPortOpenOnWindows(pxProxyPort)
+	return isPortOpenOnWindows(ctx, pxProxyPort)
 }
 
-func (WindowsCheckerImpl) IsRunningOnWsl2() bool {
-	log.Logger.Trace("Checking if running inside WSL 2")
-	resultUtf16 := runInPowerShell("wsl.exe --list --verbose")
+func (WindowsCheckerImpl) IsRunningOnWsl2(ctx context.Context) bool {
+	log.FromContext(ctx).Trace("Checking if running inside WSL 2")
+	resultUtf16 := outputOfCheck(ctx, "wsl.exe --list --verbose")
 
 	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
 	result, err := decoder.String(string(resultUtf16))
-	helper.AssertNoError(err, "Error decoding cmd output")
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to decode the WSL distro list: %v", err)
+		return false
+	}
 
 	return parseListOutput(result)
 }
 
+func (w *WindowsCheckerImpl) HasP2pAddress(ctx context.Context, ip string) bool {
+	log.FromContext(ctx).Trace("Checking if %v is assigned on Windows", ip)
+	adapter, err := w.Adapter.Resolve(ctx)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to check the P2P address: %v", err)
+		return false
+	}
+	cmd := fmt.Sprintf("$null -ne (Get-NetIPAddress -InterfaceAlias %v -IPAddress %v -ErrorAction SilentlyContinue)", quotePowerShell(adapter), ip)
+	return outputOfCheck(ctx, cmd) == "True"
+}
+
+func (WindowsCheckerImpl) PortProxies(ctx context.Context) ([]model.PortProxy, error) {
+	output, err := runInPowerShell(ctx, "netsh interface portproxy show v4tov4")
+	if err != nil {
+		return nil, err
+	}
+	return parsePortProxies(output), nil
+}
+
+func (WindowsCheckerImpl) DnsSuffixes(ctx context.Context) []string {
+	log.FromContext(ctx).Trace("Reading connection specific DNS suffixes")
+	output := outputOfCheck(ctx, "Get-DnsClient | Where-Object ConnectionSpecificSuffix | ForEach-Object ConnectionSpecificSuffix")
+	return splitLines(output)
+}
+
+// names and descriptions of all adapters which are up
+func (WindowsCheckerImpl) NetworkAdapters(ctx context.Context) []string {
+	log.FromContext(ctx).Trace("Reading network adapters")
+	output := outputOfCheck(ctx, "Get-NetAdapter | Where-Object Status -eq 'Up' | ForEach-Object { $_.Name; $_.InterfaceDescription }")
+	return splitLines(output)
+}
+
+func (WindowsCheckerImpl) WifiSsids(ctx context.Context) []string {
+	log.FromContext(ctx).Trace("Reading Wi-Fi SSIDs")
+	// fails if there is no Wi-Fi adapter, which simply means no SSID
+	output := outputOfCheck(ctx, "netsh wlan show interfaces; exit 0")
+	return parseWifiSsids(output)
+}
+
+func (WindowsCheckerImpl) IsUrlReachable(ctx context.Context, url string) bool {
+	log.FromContext(ctx).Trace("Checking if %v is reachable from Windows", url)
+	cmd := fmt.Sprintf("try { $null = Invoke-WebRequest -Uri %v -Method Head -UseBasicParsing -TimeoutSec 3; $true } catch { $false }", quotePowerShell(url))
+	return outputOfCheck(ctx, cmd) == "True"
+}
+
+func (WindowsCheckerImpl) IsTcpPortOpen(ctx context.Context, host string, port int) bool {
+	log.FromContext(ctx).Trace("Checking if %v:%v is reachable from Windows", host, port)
+	return isTcpPortOpen(ctx, host, fmt.Sprint(port))
+}
+
+// parses the SSID lines of 'netsh wlan show interfaces', e.g.
+// SSID                   : corp-wifi
+// BSSID lines are skipped
+func parseWifiSsids(output string) []string {
+	regex := regexp.MustCompile(`(?m)^\s*SSID\s*:\s*(.+?)\s*$`)
+	ssids := []string{}
+	for _, match := range regex.FindAllStringSubmatch(output, -1) {
+		ssids = append(ssids, match[1])
+	}
+	return ssids
+}
+
+func splitLines(output string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parses the table rows of 'netsh interface portproxy show v4tov4', e.g.
+// 192.168.99.1    3128        127.0.0.1       3128
+func parsePortProxies(output string) []model.PortProxy {
+	regex := regexp.MustCompile(`(?m)^(\S+)\s+(\d+)\s+(\S+)\s+(\d+)\s*$`)
+	portProxies := []model.PortProxy{}
+	for _, match := range regex.FindAllStringSubmatch(output, -1) {
+		listenPort, _ := strconv.Atoi(match[2])
+		connectPort, _ := strconv.Atoi(match[4])
+		portProxies = append(portProxies, model.PortProxy{
+			ListenAddress:  match[1],
+			ListenPort:     listenPort,
+			ConnectAddress: match[3],
+			ConnectPort:    connectPort,
+		})
+	}
+	return portProxies
+}
+
 // find the WSL2 version in the output
 // (?m) is for multiline match
 // example:
 // * Ubuntu    Running         2 
 func parseListOutput(output string) bool {
-	versionRegexLine := "(?m)^* .+ 2\\s*$"
-	match, err := regexp.MatchString(versionRegexLine, output)
-	helper.AssertNoError(err, "Error executing regex")
-	return match
+	versionRegexLine := regexp.MustCompile("(?m)^* .+ 2\\s*$")
+	return versionRegexLine.MatchString(output)
+}
+
+func isPortOpenOnWindows(ctx context.Context, port string) bool {
+	return isTcpPortOpen(ctx, "127.0.0.1", port)
 }
 
-func isPortOpenOnWindows(port string) bool {
-	command := fmt.Sprintf("Test-NetConnection -ComputerName 127.0.0.1 -Port %v -InformationLevel Quiet", port)
-	result := runInPowerShell(command)
+func isTcpPortOpen(ctx context.Context, host string, port string) bool {
+	command := fmt.Sprintf("Test-NetConnection -ComputerName %v -Port %v -InformationLevel Quiet", host, port)
+	result := outputOfCheck(ctx, command)
 	if result == "True" {
 		return true
 	} else {
@@ -60,10 +159,28 @@ func isPortOpenOnWindows(port string) bool {
 	}
 }
 
-func runInPowerShell(command string) string {
-	log.Logger.Trace("Running in Powershell: %v", command)
-	result, err := exec.Command("powershell.exe", "-NoProfile", "-Command", command).CombinedOutput()
-	helper.AssertNoError(err, "Error executing Powershell command: %v", command)
+// returns the error of the context if the command was aborted since the context is done
+func runInPowerShell(ctx context.Context, command string) (string, error) {
+	defer timing.Start("Running Powershell command").End()
+	log.FromContext(ctx).Trace("Running in Powershell: %v", command)
+	result, err := cmdrunner.Run(ctx, "powershell.exe", "-NoProfile", "-Command", command)
+	if ctx.Err() != nil {
+		log.FromContext(ctx).Debug("Aborted Powershell command: %v", command)
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", fmt.Errorf("error executing Powershell command: %v, output was: %v, error was: %w", command, strings.TrimSpace(string(result)), err)
+	}
+
+	return strings.TrimSuffix(string(result), "\r\n"), nil
+}
 
-	return strings.TrimSuffix(string(result), "\r\n")
+// for checks which can't report errors: a failed command is logged and
+// yields an empty output, i.e. the check fails
+func outputOfCheck(ctx context.Context, command string) string {
+	output, err := runInPowerShell(ctx, command)
+	if err != nil && ctx.Err() == nil {
+		log.FromContext(ctx).Warn("%v", err)
+	}
+	return output
 }
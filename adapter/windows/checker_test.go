@@ -3,9 +3,12 @@ package windows
 // tests here run with all unit tests and don't need a Windows/ WSL underneath
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
 )
 
 func TestParseListOutputOk(t *testing.T) {
@@ -26,4 +29,59 @@ NAME      STATE           VERSION
 `
 
 	assert.False(t, parseListOutput(output))
-}
\ No newline at end of file
+}
+
+func TestParsePortProxies(t *testing.T) {
+	output := "\r\nListen on ipv4:             Connect to ipv4:\r\n\r\n" +
+		"Address         Port        Address         Port\r\n" +
+		"--------------- ----------  --------------- ----------\r\n" +
+		"192.168.99.1    3128        127.0.0.1       3128\r\n"
+
+	assert.Equal(t, []model.PortProxy{
+		{ListenAddress: "192.168.99.1", ListenPort: 3128, ConnectAddress: "127.0.0.1", ConnectPort: 3128},
+	}, parsePortProxies(output))
+}
+
+func TestParseEmptyPortProxies(t *testing.T) {
+	assert.Empty(t, parsePortProxies(""))
+}
+
+func TestParseWifiSsids(t *testing.T) {
+	output := "\r\nThere is 1 interface on the system:\r\n\r\n" +
+		"    Name                   : Wi-Fi\r\n" +
+		"    State                  : connected\r\n" +
+		"    SSID                   : corp wifi\r\n" +
+		"    BSSID                  : 00:11:22:33:44:55\r\n"
+
+	assert.Equal(t, []string{"corp wifi"}, parseWifiSsids(output))
+}
+
+func TestParseWifiSsidsWithoutWifi(t *testing.T) {
+	output := "The Wireless AutoConfig Service (wlansvc) is not running.\r\n"
+
+	assert.Empty(t, parseWifiSsids(output))
+}
+
+func TestSplitLines(t *testing.T) {
+	assert.Equal(t, []string{"corp.example.com", "home"}, splitLines("corp.example.com\r\n\r\n home \r\n"))
+}
+
+func TestFailedPowerShellCommandIsReportedInsteadOfExiting(t *testing.T) {
+	fake := useFakePowerShell(t, map[string]string{})
+	fake.err = errors.New("exit status 1")
+	checker := WindowsCheckerImpl{}
+
+	_, err := checker.PortProxies(context.Background())
+	assert.ErrorContains(t, err, "powershell failed")
+	assert.False(t, checker.IsUrlReachable(context.Background(), "https://intranet.example.com"))
+
+	adapter := WslAdapter{Name: "vEthernet (WSL)"}
+	_, err = adapter.Resolve(context.Background())
+	assert.Error(t, err)
+}
+
+func TestUrlIsQuotedForPowerShell(t *testing.T) {
+	useFakePowerShell(t, map[string]string{"-Uri 'https://intranet.example.com/?q=''x'''": "True"})
+
+	assert.True(t, WindowsCheckerImpl{}.IsUrlReachable(context.Background(), "https://intranet.example.com/?q='x'"))
+}
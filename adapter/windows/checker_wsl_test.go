@@ -5,23 +5,25 @@
 package windows
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRunInPowershellOk(t *testing.T) {
-	result := runInPowerShell("echo HELLOWORLD")
+	result, err := runInPowerShell(context.Background(), "echo HELLOWORLD")
+	assert.NoError(t, err)
 	assert.Equal(t, "HELLOWORLD", result)
 }
 
 func TestIsPortOpenOnWindows(t *testing.T) {
-	assert.True(t, isPortOpenOnWindows("445"))
-	assert.False(t, isPortOpenOnWindows("42"))
+	assert.True(t, isPortOpenOnWindows(context.Background(), "445"))
+	assert.False(t, isPortOpenOnWindows(context.Background(), "42"))
 }
 
 func TestIsPingableOnWindows(t *testing.T) {
 	checker := WindowsCheckerImpl{PxProxyPort: 3128}
-	assert.True(t, checker.IsPingable("127.0.0.1"))
-	assert.False(t, checker.IsPingable("42.42.42.42"))
+	assert.True(t, checker.IsPingable(context.Background(), "127.0.0.1"))
+	assert.False(t, checker.IsPingable(context.Background(), "42.42.42.42"))
 }
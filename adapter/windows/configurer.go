@@ -1,11 +1,15 @@
 package windows
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"org.samba/isetta/core/model"
 	"org.samba/isetta/gsudo"
 	"org.samba/isetta/helper"
+	log "org.samba/isetta/simplelogger"
 )
 
 
@@ -13,51 +17,122 @@ type WindowsConfigurerImpl struct {
 	WindowsIp string
 	SubnetMask string
 	PxProxyPort int
+	Adapter *WslAdapter
 	Gsudo *gsudo.Gsudo	
+	// defers all changes which need elevation instead of prompting, unless
+	// isetta runs elevated already
+	NonInteractive bool
 }
 
-func (w *WindowsConfigurerImpl) Init() {
-	w.Gsudo.Init()
+func (w *WindowsConfigurerImpl) Init(ctx context.Context) error {
+	if w.NonInteractive && !w.Gsudo.Elevated {
+		return model.ErrElevationDeferred
+	}
+	return w.Gsudo.Init(ctx)
 }
 
-func (w *WindowsConfigurerImpl) Cleanup() {
-	w.Gsudo.Cleanup()
+func (w *WindowsConfigurerImpl) Cleanup(ctx context.Context) {
+	if w.NonInteractive && !w.Gsudo.Elevated {
+		return
+	}
+	w.Gsudo.Cleanup(ctx)
 }
 
-func (w *WindowsConfigurerImpl) AddP2pAddress(successChecker func() bool) error {
-	cmd := fmt.Sprintf("netsh interface ip add address \"vEthernet (WSL)\" %v %v", w.WindowsIp, w.SubnetMask)
-	w.Gsudo.RunElevated(cmd, false)
+// netsh fails if the address is already assigned, so it is only added if
+// Windows doesn't report it, whatever the language of netsh. Its output is
+// part of the error if the address doesn't come up
+func (w *WindowsConfigurerImpl) AddP2pAddress(ctx context.Context, successChecker func() bool) error {
+	adapter, err := w.Adapter.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	out := ""
+	checker := WindowsCheckerImpl{Adapter: w.Adapter}
+	if checker.HasP2pAddress(ctx, w.WindowsIp) {
+		log.FromContext(ctx).Debug("P2P address %v is already assigned to '%v'", w.WindowsIp, adapter)
+	} else {
+		cmd := fmt.Sprintf("netsh interface ip add address \"%v\" %v %v", adapter, w.WindowsIp, w.SubnetMask)
+		out, err = w.Gsudo.RunElevated(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("error adding P2P address %v to '%v': %w", w.WindowsIp, adapter, err)
+		}
+	}
 	
 	// letting config change settle
-	return helper.Retry(helper.RetryParams{
+	err = helper.Retry(ctx, helper.RetryParams{
 		Description: "Setting Windows P2P address",
 		Attempts:    10,
 		Sleep:       100 * time.Millisecond,
 		Func:        successChecker,
 	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("P2P address %v on '%v' not reachable, netsh output was: %v: %w", w.WindowsIp, adapter, strings.TrimSpace(out), err)
+	}
+	return err
+}
+
+// the address might not be there, e.g. when adding it failed. So the exit code is ignored
+func (w *WindowsConfigurerImpl) RemoveP2pAddress(ctx context.Context) error {
+	adapter, err := w.Adapter.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf("netsh interface ip delete address \"%v\" %v", adapter, w.WindowsIp)
+	_, err = w.Gsudo.RunElevated(ctx, cmd, false)
+	return err
 }
 
-func (w *WindowsConfigurerImpl) SetPortProxy(successChecker func() bool) error {
+func (w *WindowsConfigurerImpl) SetPortProxy(ctx context.Context, successChecker func() bool) error {
 	// re-run config when last config attempt had issues
+	var lastErr error
 	configFunc := func () bool  {
-		w.resetPortProxy()
-		w.addPortProxy()
-		return successChecker()
+		lastErr = w.deletePortProxy(ctx)
+		if lastErr == nil {
+			lastErr = w.addPortProxy(ctx)
+		}
+		return lastErr == nil && successChecker()
 	}
 	
-	return helper.Retry(helper.RetryParams{
+	err := helper.Retry(ctx, helper.RetryParams{
 		Description: "Setting Windows portproxy",
 		Attempts:    10,
 		Sleep:       200 * time.Millisecond,
 		Func:        configFunc,
 	})
+	if err != nil && lastErr != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w, last error was: %v", err, lastErr)
+	}
+	return err
+}
+
+// only the entry of isetta, other portproxies of the user are kept. netsh
+// fails if the entry is missing, so its exit code is not checked
+func (w *WindowsConfigurerImpl) deletePortProxy(ctx context.Context) error {
+	cmd := fmt.Sprintf("netsh interface portproxy delete v4tov4 listenaddress=%v listenport=%v", w.WindowsIp, w.PxProxyPort)
+	_, err := w.Gsudo.RunElevated(ctx, cmd, false)
+	return err
 }
 
-func (w *WindowsConfigurerImpl) resetPortProxy() {
-	w.Gsudo.RunElevated("netsh interface portproxy reset")
+func (w *WindowsConfigurerImpl) addPortProxy(ctx context.Context) error {
+	return w.addPortProxyEntry(ctx, model.PortProxy{ListenAddress: w.WindowsIp, ListenPort: w.PxProxyPort, ConnectAddress: "127.0.0.1", ConnectPort: w.PxProxyPort})
+}
+
+func (w *WindowsConfigurerImpl) RestorePortProxy(ctx context.Context, previous []model.PortProxy) error {
+	err := w.deletePortProxy(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range previous {
+		err = w.addPortProxyEntry(ctx, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (w *WindowsConfigurerImpl) addPortProxy() {
-	cmd := fmt.Sprintf("netsh interface portproxy add v4tov4 listenaddress=%[1]v listenport=%[2]v connectaddress=127.0.0.1 connectport=%[2]v", w.WindowsIp, w.PxProxyPort)
-	w.Gsudo.RunElevated(cmd)
+func (w *WindowsConfigurerImpl) addPortProxyEntry(ctx context.Context, p model.PortProxy) error {
+	cmd := fmt.Sprintf("netsh interface portproxy add v4tov4 listenaddress=%v listenport=%v connectaddress=%v connectport=%v", p.ListenAddress, p.ListenPort, p.ConnectAddress, p.ConnectPort)
+	_, err := w.Gsudo.RunElevated(ctx, cmd)
+	return err
 }
@@ -0,0 +1,62 @@
+package windows
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/gsudo"
+)
+
+func TestAssignedP2pAddressIsNotAddedAgain(t *testing.T) {
+	useFakePowerShell(t, map[string]string{
+		"-Name 'vEthernet (WSL)'": "True",
+		"Get-NetIPAddress":        "True",
+	})
+	commands := []string{}
+	recorder := recordingRunner{runner: cmdrunner.Default, commands: &commands}
+	configurer := WindowsConfigurerImpl{
+		WindowsIp:  "192.168.99.1",
+		SubnetMask: "255.255.255.0",
+		Adapter:    &WslAdapter{Name: "vEthernet (WSL)"},
+		Gsudo:      &gsudo.Gsudo{Elevated: true},
+	}
+
+	err := configurer.AddP2pAddress(cmdrunner.WithRunner(context.Background(), recorder), func() bool { return true })
+	assert.NoError(t, err)
+	assert.NotEmpty(t, commands)
+	for _, command := range commands {
+		assert.NotContains(t, command, "netsh")
+	}
+}
+
+func TestMissingP2pAddressIsAdded(t *testing.T) {
+	useFakePowerShell(t, map[string]string{
+		"-Name 'vEthernet (WSL)'": "True",
+	})
+	commands := []string{}
+	recorder := recordingRunner{runner: cmdrunner.Default, commands: &commands}
+	configurer := WindowsConfigurerImpl{
+		WindowsIp:  "192.168.99.1",
+		SubnetMask: "255.255.255.0",
+		Adapter:    &WslAdapter{Name: "vEthernet (WSL)"},
+		Gsudo:      &gsudo.Gsudo{Elevated: true},
+	}
+
+	err := configurer.AddP2pAddress(cmdrunner.WithRunner(context.Background(), recorder), func() bool { return true })
+	assert.NoError(t, err)
+	assert.Contains(t, commands, `/c netsh interface ip add address "vEthernet (WSL)" 192.168.99.1 255.255.255.0`)
+}
+
+// keeps the commands run via another runner
+type recordingRunner struct {
+	runner   cmdrunner.Runner
+	commands *[]string
+}
+
+func (r recordingRunner) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	*r.commands = append(*r.commands, strings.Join(cmd.Args, " "))
+	return r.runner.Run(ctx, cmd)
+}
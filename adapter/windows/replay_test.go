@@ -0,0 +1,34 @@
+package windows
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/core/model"
+)
+
+// replays the commands of a run connected via proxy, see fixture/recordings
+func replayProxyRecording(t *testing.T) {
+	replayer, err := cmdrunner.NewReplayer("../../fixture/recordings/proxy")
+	assert.NoError(t, err)
+	cmdrunner.Default = replayer
+	t.Cleanup(func() { cmdrunner.Default = cmdrunner.ExecRunner{} })
+}
+
+func TestCheckerWithRecordedCommands(t *testing.T) {
+	replayProxyRecording(t)
+	ctx := context.Background()
+	checker := WindowsCheckerImpl{PxProxyPort: 3128}
+
+	assert.Equal(t, []string{"corp.example.com"}, checker.DnsSuffixes(ctx))
+	assert.Contains(t, checker.NetworkAdapters(ctx), "vEthernet (WSL)")
+	assert.True(t, checker.IsPxProxyRunning(ctx))
+
+	portProxies, err := checker.PortProxies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.PortProxy{
+		{ListenAddress: "169.254.254.1", ListenPort: 3128, ConnectAddress: "127.0.0.1", ConnectPort: 3128},
+	}, portProxies)
+}
@@ -0,0 +1,306 @@
+package windows
+
+// A Windows scheduled task which runs isetta inside the distro on logon and
+// whenever Windows connects to or disconnects from a network, e.g. the VPN.
+// It runs even if no WSL terminal is open. The task is registered from an XML
+// definition, the only way schtasks supports event triggers.
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/gsudo"
+	"org.samba/isetta/shellquote"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/userdir"
+)
+
+const TaskName = "isetta"
+
+const taskXmlFileName = "isetta-task.xml"
+
+// Windows reports this last run time for tasks which never ran
+const neverRunYear = 1999
+
+type ScheduledTask struct {
+	Gsudo *gsudo.Gsudo
+	// WSL distro isetta runs in, default: the current one
+	Distro string
+	// of the isetta binary inside the distro, default: the running one
+	Executable string
+	// of the runs, e.g. to run non-interactively
+	Flags []string
+}
+
+type TaskStatus struct {
+	Installed  bool
+	State      string // e.g. Ready, Running or Disabled
+	LastRun    time.Time
+	LastResult int // exit code of isetta
+}
+
+// replaces an existing task
+func (s *ScheduledTask) Install(ctx context.Context) error {
+	definition, err := s.definition(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer s.Gsudo.Cleanup(ctx)
+	err = s.Gsudo.Init(ctx)
+	if err != nil {
+		return err
+	}
+
+	// schtasks expects the file in the encoding it declares
+	windowsTempDir, wslTempDir := s.Gsudo.TempDir()
+	xmlFile := filepath.Join(wslTempDir, taskXmlFileName)
+	err = os.WriteFile(xmlFile, encodeUtf16(definition), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write task definition %v: %w", xmlFile, err)
+	}
+	defer os.Remove(xmlFile)
+
+	log.FromContext(ctx).Debug("Registering scheduled task '%v'", TaskName)
+	out, err := s.Gsudo.RunElevated(ctx, fmt.Sprintf(`schtasks /Create /TN %v /XML "%v\%v" /F`, TaskName, windowsTempDir, taskXmlFileName), false)
+	if err != nil {
+		return err
+	}
+
+	status, err := s.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if !status.Installed {
+		return fmt.Errorf("failed to register scheduled task '%v', schtasks output was: %v", TaskName, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// a missing task is fine
+func (s *ScheduledTask) Uninstall(ctx context.Context) error {
+	status, err := s.Status(ctx)
+	if err != nil || !status.Installed {
+		return err
+	}
+
+	defer s.Gsudo.Cleanup(ctx)
+	err = s.Gsudo.Init(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Debug("Removing scheduled task '%v'", TaskName)
+	out, err := s.Gsudo.RunElevated(ctx, fmt.Sprintf("schtasks /Delete /TN %v /F", TaskName), false)
+	if err != nil {
+		return err
+	}
+
+	status, err = s.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Installed {
+		return fmt.Errorf("failed to remove scheduled task '%v', schtasks output was: %v", TaskName, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// needs no elevation
+func (s *ScheduledTask) Status(ctx context.Context) (TaskStatus, error) {
+	command := fmt.Sprintf("$t = Get-ScheduledTask -TaskName %v -ErrorAction SilentlyContinue; "+
+		"if ($t) { $i = $t | Get-ScheduledTaskInfo; '{0}|{1:yyyy-MM-dd HH:mm:ss}|{2}' -f $t.State, $i.LastRunTime, $i.LastTaskResult }",
+		quotePowerShell(TaskName))
+	out, err := cmdrunner.Run(ctx, "powershell.exe", "-NoProfile", "-Command", command)
+	if err != nil {
+		return TaskStatus{}, fmt.Errorf("unable to query scheduled task '%v': %v, error was: %w", TaskName, strings.TrimSpace(string(out)), err)
+	}
+	return parseTaskStatus(strings.TrimSpace(string(out)))
+}
+
+// e.g. 'Ready|2024-05-01 10:15:00|0', empty if the task is missing
+func parseTaskStatus(line string) (TaskStatus, error) {
+	if line == "" {
+		return TaskStatus{}, nil
+	}
+	fields := strings.Split(line, "|")
+	if len(fields) != 3 {
+		return TaskStatus{}, fmt.Errorf("unexpected status of scheduled task '%v': %v", TaskName, line)
+	}
+
+	status := TaskStatus{Installed: true, State: fields[0]}
+	lastRun, err := time.ParseInLocation(time.DateTime, fields[1], time.Local)
+	if err == nil && lastRun.Year() > neverRunYear {
+		status.LastRun = lastRun
+	}
+	status.LastResult, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return TaskStatus{}, fmt.Errorf("unexpected result of scheduled task '%v': %v", TaskName, fields[2])
+	}
+	return status, nil
+}
+
+// conhost without a window keeps the runs quiet. isetta runs as root
+// without sudo, so the environment of the calling user is passed along.
+// The task runs with the highest privileges of the user, so isetta changes
+// the Windows side without the prompt of gsudo
+func (s *ScheduledTask) definition(ctx context.Context) (string, error) {
+	distro := s.Distro
+	if distro == "" {
+		var err error
+		distro, err = currentDistro(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	executable := s.Executable
+	if executable == "" {
+		var err error
+		executable, err = os.Executable()
+		if err != nil {
+			return "", fmt.Errorf("unable to determine the path of isetta: %w", err)
+		}
+	}
+	env, err := userdir.SudoEnv()
+	if err != nil {
+		return "", err
+	}
+	userSid, err := windowsUserSid(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// wsl.exe hands the words after the distro to the shell of root, e.g. a
+	// home with a space stays one assignment
+	words := append(append([]string{"env"}, env...), executable)
+	words = append(words, s.Flags...)
+	for i, word := range words {
+		words[i] = quoteWindowsArgument(shellquote.Quote(word))
+	}
+	arguments := fmt.Sprintf("--headless wsl.exe -d %v -u root %v", quoteWindowsArgument(distro), strings.Join(words, " "))
+	return fmt.Sprintf(taskTemplate, escapeXml(userSid), escapeXml(arguments)), nil
+}
+
+// The task is registered elevated, via gsudo possibly by another account
+// like the one of an administrator. It has to run as the user of WSL
+func windowsUserSid(ctx context.Context) (string, error) {
+	out, err := cmdrunner.Run(ctx, "powershell.exe", "-NoProfile", "-Command", "[System.Security.Principal.WindowsIdentity]::GetCurrent().User.Value")
+	sid := strings.TrimSpace(string(out))
+	if err != nil || sid == "" {
+		return "", fmt.Errorf("unable to determine the Windows user: %v, error was: %v", sid, err)
+	}
+	return sid, nil
+}
+
+// Network profile events 10000 and 10001: connected and disconnected. The
+// delay lets e.g. the VPN settle
+const taskTemplate = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>Configures the WSL network via isetta on logon and when the network changes</Description>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger>
+      <Enabled>true</Enabled>
+    </LogonTrigger>
+    <EventTrigger>
+      <Enabled>true</Enabled>
+      <Subscription>&lt;QueryList&gt;&lt;Query Id="0" Path="Microsoft-Windows-NetworkProfile/Operational"&gt;&lt;Select Path="Microsoft-Windows-NetworkProfile/Operational"&gt;*[System[(EventID=10000 or EventID=10001)]]&lt;/Select&gt;&lt;/Query&gt;&lt;/QueryList&gt;</Subscription>
+      <Delay>PT10S</Delay>
+    </EventTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>%v</UserId>
+      <LogonType>InteractiveToken</LogonType>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <ExecutionTimeLimit>PT10M</ExecutionTimeLimit>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>conhost.exe</Command>
+      <Arguments>%v</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`
+
+// WSL sets WSL_DISTRO_NAME, which sudo drops. The UNC path of the root
+// directory, e.g. \\wsl.localhost\Ubuntu\, contains the name as well
+func currentDistro(ctx context.Context) (string, error) {
+	if distro := os.Getenv("WSL_DISTRO_NAME"); distro != "" {
+		return distro, nil
+	}
+	out, err := cmdrunner.Run(ctx, "wslpath", "-w", "/")
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the WSL distro: %v, error was: %w", strings.TrimSpace(string(out)), err)
+	}
+	return distroFromUncPath(strings.TrimSpace(string(out)))
+}
+
+func distroFromUncPath(path string) (string, error) {
+	parts := strings.Split(strings.Trim(path, `\`), `\`)
+	if len(parts) < 2 || parts[1] == "" {
+		return "", errors.New("unable to determine the WSL distro from " + path)
+	}
+	return parts[1], nil
+}
+
+// quotes for the Windows command line, following the rules of CommandLineToArgvW:
+// backslashes only need escaping in front of a double quote
+func quoteWindowsArgument(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	// the backslashes written so far, doubled in front of a quote
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes+1))
+			backslashes = 0
+		default:
+			backslashes = 0
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+func escapeXml(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// little endian with byte order mark, with Windows line endings
+func encodeUtf16(s string) []byte {
+	s = strings.ReplaceAll(s, "\n", "\r\n")
+	buf := []byte{0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		buf = append(buf, byte(unit), byte(unit>>8))
+	}
+	return buf
+}
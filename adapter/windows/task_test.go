@@ -0,0 +1,91 @@
+package windows
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/shellquote"
+)
+
+func TestTaskStatus(t *testing.T) {
+	useFakePowerShell(t, map[string]string{
+		"Get-ScheduledTask -TaskName 'isetta'": "Ready|2024-05-01 10:15:00|5",
+	})
+	task := ScheduledTask{}
+
+	status, err := task.Status(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, TaskStatus{
+		Installed:  true,
+		State:      "Ready",
+		LastRun:    time.Date(2024, 5, 1, 10, 15, 0, 0, time.Local),
+		LastResult: 5,
+	}, status)
+}
+
+func TestStatusOfMissingTask(t *testing.T) {
+	useFakePowerShell(t, map[string]string{})
+	task := ScheduledTask{}
+
+	status, err := task.Status(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, status.Installed)
+}
+
+func TestTaskWhichNeverRan(t *testing.T) {
+	status, err := parseTaskStatus("Ready|1999-11-30 00:00:00|267011")
+	assert.NoError(t, err)
+	assert.True(t, status.LastRun.IsZero())
+}
+
+func TestDistroFromUncPath(t *testing.T) {
+	distro, err := distroFromUncPath(`\\wsl.localhost\Ubuntu-22.04\`)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ubuntu-22.04", distro)
+
+	_, err = distroFromUncPath(`C:\`)
+	assert.Error(t, err)
+}
+
+func TestTaskDefinitionRunsIsettaQuietlyAndElevated(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+	useFakePowerShell(t, map[string]string{
+		"WindowsIdentity": "S-1-5-21-1004336348-1177238915-682003330-512",
+	})
+	task := ScheduledTask{Distro: "Ubuntu", Executable: "/usr/local/bin/isetta", Flags: []string{"-non-interactive", "-windows-elevated"}}
+
+	definition, err := task.definition(context.Background())
+	assert.NoError(t, err)
+
+	var parsed struct {
+		Arguments string `xml:"Actions>Exec>Arguments"`
+		Command   string `xml:"Actions>Exec>Command"`
+		RunLevel  string `xml:"Principals>Principal>RunLevel"`
+		UserId    string `xml:"Principals>Principal>UserId"`
+	}
+	// the declared UTF-16 is only true for the encoded file
+	decoder := xml.NewDecoder(strings.NewReader(definition))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	assert.NoError(t, decoder.Decode(&parsed))
+	assert.Equal(t, "conhost.exe", parsed.Command)
+	assert.Equal(t, "HighestAvailable", parsed.RunLevel)
+	assert.Equal(t, "S-1-5-21-1004336348-1177238915-682003330-512", parsed.UserId)
+	assert.Regexp(t, `^--headless wsl.exe -d Ubuntu -u root env HOME=\S+ SUDO_USER=\S+ SUDO_UID=\d+ SUDO_GID=\d+ /usr/local/bin/isetta -non-interactive -windows-elevated$`, parsed.Arguments)
+}
+
+func TestTaskArgumentsAreQuotedForTheShellAndTheWindowsCommandLine(t *testing.T) {
+	assert.Equal(t, "HOME=/home/peter", quoteWindowsArgument(shellquote.Quote("HOME=/home/peter")))
+	assert.Equal(t, `"'HOME=/home/my dir'"`, quoteWindowsArgument(shellquote.Quote("HOME=/home/my dir")))
+	assert.Equal(t, `"'HOME=/home/\"$x\"'"`, quoteWindowsArgument(shellquote.Quote(`HOME=/home/"$x"`)))
+	assert.Equal(t, `'it'\''s'`, quoteWindowsArgument(shellquote.Quote("it's")))
+	assert.Equal(t, `"Ubuntu 22.04"`, quoteWindowsArgument("Ubuntu 22.04"))
+}
+
+func TestEncodeUtf16(t *testing.T) {
+	assert.Equal(t, []byte{0xff, 0xfe, 'a', 0, '\r', 0, '\n', 0}, encodeUtf16("a\n"))
+}
@@ -0,0 +1,101 @@
+package windows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "org.samba/isetta/simplelogger"
+)
+
+var ErrWslAdapterNotFound = errors.New("WSL network adapter not found on Windows")
+
+// the Hyper-V adapter connecting Windows and WSL. Its name differs between
+// WSL versions, e.g. 'vEthernet (WSL)' or 'vEthernet (WSL (Hyper-V firewall))',
+// and on localized or customised systems. It is found via the addresses
+// Windows holds on it: the Linux default gateway, or the Windows P2P address
+// once isetta routes via Windows
+type WslAdapter struct {
+	// configured adapter name, skips the discovery
+	Name           string
+	WindowsIp      string
+	DefaultGateway func(ctx context.Context) (string, error)
+
+	mu       sync.Mutex
+	resolved string
+}
+
+// the adapter name, discovered once per run
+func (a *WslAdapter) Resolve(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.resolved != "" {
+		return a.resolved, nil
+	}
+
+	name, err := a.find(ctx)
+	if err != nil {
+		return "", err
+	}
+	log.FromContext(ctx).Debug("WSL adapter on Windows: %v", name)
+	a.resolved = name
+	return name, nil
+}
+
+func (a *WslAdapter) find(ctx context.Context) (string, error) {
+	if a.Name != "" {
+		cmd := fmt.Sprintf("$null -ne (Get-NetAdapter -IncludeHidden -Name %v -ErrorAction SilentlyContinue)", quotePowerShell(a.Name))
+		output, err := runInPowerShell(ctx, cmd)
+		if err != nil {
+			return "", err
+		}
+		if output != "True" {
+			return "", fmt.Errorf("%w: configured windows_adapter '%v' does not exist", ErrWslAdapterNotFound, a.Name)
+		}
+		return a.Name, nil
+	}
+
+	addresses := a.hostAddresses(ctx)
+	for _, address := range addresses {
+		name := adapterNameOf(ctx, address)
+		if name != "" {
+			return name, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return "", fmt.Errorf("%w: no adapter holds %v, set 'windows_adapter' in the [network] section", ErrWslAdapterNotFound, strings.Join(addresses, " or "))
+}
+
+func (a *WslAdapter) hostAddresses(ctx context.Context) []string {
+	addresses := []string{}
+	if a.DefaultGateway != nil {
+		gateway, err := a.DefaultGateway(ctx)
+		if err != nil {
+			log.FromContext(ctx).Debug("Unable to read the Linux default gateway: %v", err)
+		} else if gateway != "" {
+			addresses = append(addresses, gateway)
+		}
+	}
+	if a.WindowsIp != "" && (len(addresses) == 0 || addresses[0] != a.WindowsIp) {
+		addresses = append(addresses, a.WindowsIp)
+	}
+	return addresses
+}
+
+// the WSL adapter is hidden on newer WSL versions, so Get-NetAdapter needs -IncludeHidden
+func adapterNameOf(ctx context.Context, address string) string {
+	log.FromContext(ctx).Trace("Looking for the Windows adapter holding %v", address)
+	cmd := fmt.Sprintf("Get-NetIPAddress -AddressFamily IPv4 -IPAddress %v -ErrorAction SilentlyContinue | "+
+		"ForEach-Object { Get-NetAdapter -IncludeHidden -InterfaceIndex $_.InterfaceIndex -ErrorAction SilentlyContinue } | "+
+		"Select-Object -First 1 -ExpandProperty Name", quotePowerShell(address))
+	return strings.TrimSpace(outputOfCheck(ctx, cmd))
+}
+
+// single quoted PowerShell string, embedded quotes are doubled
+func quotePowerShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,98 @@
+package windows
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/cmdrunner"
+)
+
+// answers Powershell commands containing a key with its output, or fails all with err
+type fakePowerShell struct {
+	outputs map[string]string
+	calls   int
+	err     error
+}
+
+func (f *fakePowerShell) Run(ctx context.Context, cmd cmdrunner.Command) ([]byte, error) {
+	f.calls++
+	if f.err != nil {
+		return []byte("powershell failed\r\n"), f.err
+	}
+	command := cmd.Args[len(cmd.Args)-1]
+	for key, output := range f.outputs {
+		if strings.Contains(command, key) {
+			return []byte(output + "\r\n"), nil
+		}
+	}
+	return []byte("\r\n"), nil
+}
+
+func useFakePowerShell(t *testing.T, outputs map[string]string) *fakePowerShell {
+	fake := &fakePowerShell{outputs: outputs}
+	cmdrunner.Default = fake
+	t.Cleanup(func() { cmdrunner.Default = cmdrunner.ExecRunner{} })
+	return fake
+}
+
+func gateway(ip string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return ip, nil }
+}
+
+func TestWslAdapterIsFoundViaDefaultGateway(t *testing.T) {
+	fake := useFakePowerShell(t, map[string]string{
+		"-IPAddress '172.28.80.1'": "vEthernet (WSL (Hyper-V firewall))",
+	})
+	adapter := WslAdapter{WindowsIp: "169.254.254.1", DefaultGateway: gateway("172.28.80.1")}
+
+	name, err := adapter.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "vEthernet (WSL (Hyper-V firewall))", name)
+
+	// cached for the run
+	name, err = adapter.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "vEthernet (WSL (Hyper-V firewall))", name)
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestWslAdapterIsFoundViaWindowsP2pAddress(t *testing.T) {
+	useFakePowerShell(t, map[string]string{
+		"-IPAddress '169.254.254.1'": "vEthernet (WSL)",
+	})
+	failingGateway := func(context.Context) (string, error) { return "", errors.New("no route") }
+	adapter := WslAdapter{WindowsIp: "169.254.254.1", DefaultGateway: failingGateway}
+
+	name, err := adapter.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "vEthernet (WSL)", name)
+}
+
+func TestMissingWslAdapterIsReported(t *testing.T) {
+	useFakePowerShell(t, map[string]string{})
+	adapter := WslAdapter{WindowsIp: "169.254.254.1", DefaultGateway: gateway("172.28.80.1")}
+
+	_, err := adapter.Resolve(context.Background())
+	assert.ErrorIs(t, err, ErrWslAdapterNotFound)
+	assert.ErrorContains(t, err, "172.28.80.1 or 169.254.254.1")
+	assert.ErrorContains(t, err, "windows_adapter")
+}
+
+func TestConfiguredWslAdapter(t *testing.T) {
+	useFakePowerShell(t, map[string]string{
+		"-Name 'vEthernet (Ubuntu''s WSL)'": "True",
+	})
+
+	adapter := WslAdapter{Name: "vEthernet (Ubuntu's WSL)", DefaultGateway: gateway("172.28.80.1")}
+	name, err := adapter.Resolve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "vEthernet (Ubuntu's WSL)", name)
+
+	adapter = WslAdapter{Name: "vEthernet (typo)"}
+	_, err = adapter.Resolve(context.Background())
+	assert.ErrorIs(t, err, ErrWslAdapterNotFound)
+	assert.ErrorContains(t, err, "'vEthernet (typo)' does not exist")
+}
@@ -0,0 +1,173 @@
+package wslconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+)
+
+// setting of the [wsl2] section which isetta recommends a value for
+type recommendation struct {
+	key          string
+	defaultValue string // of recent WSL versions
+	recommended  string
+	reason       string
+	natOnly      bool     // doesn't matter in mirrored networking mode
+	accepted     []string // further values which don't conflict
+	hintOnly     bool     // reported, but never applied
+}
+
+var recommendations = []recommendation{
+	{
+		key:          "autoProxy",
+		defaultValue: "true",
+		recommended:  "false",
+		reason:       "WSL sets proxy variables of its own, which compete with the ones of 'isetta -env-settings'",
+	},
+	{
+		key:          "dnsTunneling",
+		defaultValue: "true",
+		recommended:  "false",
+		reason:       "WSL answers DNS requests via Windows and manages resolv.conf, which interferes with the nameserver isetta sets",
+	},
+	{
+		key:          "firewall",
+		defaultValue: "true",
+		recommended:  "false",
+		reason:       "the Hyper-V firewall can block the traffic from WSL to Px on the Windows P2P address. Disabling it lowers the protection of Windows, allowing the traffic in the firewall rules is the safer choice",
+		natOnly:      true,
+		hintOnly:     true,
+	},
+	{
+		key:          "networkingMode",
+		defaultValue: "nat",
+		recommended:  "nat",
+		reason:       "isetta supports the nat and mirrored networking modes only",
+		accepted:     []string{"mirrored"},
+	},
+}
+
+type WslConfigImpl struct {
+	NetworkingMode model.NetworkingMode
+	mu             sync.Mutex
+	path           string
+}
+
+func (w *WslConfigImpl) Conflicts(ctx context.Context) ([]model.WslConfigConflict, error) {
+	path, err := w.resolvePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []model.WslConfigConflict{}
+	for _, r := range w.recommendations() {
+		actual, found := file.Get("wsl2", r.key)
+		if !found {
+			actual = r.defaultValue
+		}
+		if r.isAccepted(actual) {
+			continue
+		}
+		if !found {
+			actual += " (default)"
+		}
+		conflicts = append(conflicts, model.WslConfigConflict{Key: r.key, Actual: actual, Recommended: r.recommended, Reason: r.reason, HintOnly: r.hintOnly})
+	}
+	return conflicts, nil
+}
+
+// The file is written by the Windows user, so its mode is kept. Hints, e.g.
+// disabling the firewall, are left to the user
+func (w *WslConfigImpl) ApplyRecommended(ctx context.Context) ([]model.WslConfigConflict, error) {
+	conflicts, err := w.Conflicts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applicable := []model.WslConfigConflict{}
+	for _, c := range conflicts {
+		if !c.HintOnly {
+			applicable = append(applicable, c)
+		}
+	}
+	if len(applicable) == 0 {
+		return applicable, nil
+	}
+
+	file, err := load(w.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range applicable {
+		log.FromContext(ctx).Info("Setting %v=%v in %v", c.Key, c.Recommended, w.path)
+		file.Set("wsl2", c.Key, c.Recommended)
+	}
+
+	err = safefile.Write(ctx, w.path, file.Bytes(), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write %v: %w", w.path, err)
+	}
+	return applicable, nil
+}
+
+// only a hint, nothing depends on it
+func (w *WslConfigImpl) WarnIfModePending(ctx context.Context) {
+	if w.NetworkingMode == model.NetworkingModeMirrored {
+		return
+	}
+	path, err := w.resolvePath(ctx)
+	if err == nil {
+		var mode string
+		mode, err = configuredNetworkingMode(path)
+		if mode == "mirrored" {
+			log.FromContext(ctx).Warn("networkingMode=mirrored in %v is not active yet. Run 'wsl.exe --shutdown' to apply it", path)
+		}
+	}
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to read %v: %v", FileName, err)
+	}
+}
+
+func (w *WslConfigImpl) recommendations() []recommendation {
+	applicable := []recommendation{}
+	for _, r := range recommendations {
+		if r.natOnly && w.NetworkingMode == model.NetworkingModeMirrored {
+			continue
+		}
+		applicable = append(applicable, r)
+	}
+	return applicable
+}
+
+// WSL compares the values case-insensitively
+func (r recommendation) isAccepted(value string) bool {
+	for _, accepted := range append([]string{r.recommended}, r.accepted...) {
+		if strings.EqualFold(value, accepted) {
+			return true
+		}
+	}
+	return false
+}
+
+// determined once, it requires running cmd.exe
+func (w *WslConfigImpl) resolvePath(ctx context.Context) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.path != "" {
+		return w.path, nil
+	}
+	path, err := Path(ctx)
+	if err != nil {
+		return "", err
+	}
+	w.path = path
+	return path, nil
+}
@@ -0,0 +1,85 @@
+package wslconfig
+
+// reads %USERPROFILE%\.wslconfig, the settings of the WSL 2 VM shared by all
+// distros, via the Windows path mapping of WSL
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/inifile"
+	log "org.samba/isetta/simplelogger"
+)
+
+const FileName = ".wslconfig"
+
+// interface of the WSL VM in mirrored networking mode, carries the loopback
+// addresses shared with Windows
+var mirroredInterface = "loopback0"
+
+// WSL path of .wslconfig, e.g. /mnt/c/Users/jane/.wslconfig
+func Path(ctx context.Context) (string, error) {
+	// cmd.exe warns about UNC paths if started inside the Linux file system
+	out, err := cmdrunner.RunIn(ctx, "/mnt/c/", "cmd.exe", "/c", "echo %USERPROFILE%")
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the Windows user profile, output was: %v: %w", string(out), err)
+	}
+	userProfile := strings.TrimRight(string(out), "\r\n")
+
+	out, err = cmdrunner.Run(ctx, "wslpath", "-u", userProfile)
+	if err != nil {
+		return "", fmt.Errorf("unable to map %v to a WSL path: %w", userProfile, err)
+	}
+	return path.Join(strings.TrimSpace(string(out)), FileName), nil
+}
+
+// a missing file is empty
+func load(path string) (*inifile.File, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return inifile.Parse(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	return inifile.Parse(content), nil
+}
+
+// the networkingMode in the [wsl2] section, empty if not set
+func configuredNetworkingMode(path string) (string, error) {
+	file, err := load(path)
+	if err != nil {
+		return "", err
+	}
+	mode, _ := file.Get("wsl2", "networkingMode")
+	return strings.ToLower(mode), nil
+}
+
+// The interfaces show the active mode. A mode configured in .wslconfig only
+// applies once the WSL VM was restarted, see WarnIfModePending. 'configured'
+// is the mode of the isetta config: auto, nat or mirrored
+func DetectNetworkingMode(ctx context.Context, configured string) model.NetworkingMode {
+	switch configured {
+	case "mirrored":
+		return model.NetworkingModeMirrored
+	case "nat":
+		return model.NetworkingModeNat
+	}
+
+	// looked up via the runner, so recordings cover it
+	lookup := func(ctx context.Context) ([]byte, error) {
+		_, err := net.InterfaceByName(mirroredInterface)
+		return nil, err
+	}
+	if _, err := cmdrunner.RunFunc(ctx, lookup, "interface", mirroredInterface); err == nil {
+		log.FromContext(ctx).Debug("Interface %v exists, WSL runs in mirrored networking mode", mirroredInterface)
+		return model.NetworkingModeMirrored
+	}
+	return model.NetworkingModeNat
+}
@@ -0,0 +1,110 @@
+package wslconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/safefile"
+)
+
+func writeWslConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), FileName)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func useTempBackups(t *testing.T) {
+	original := safefile.Default
+	safefile.Default = safefile.Store{Dir: t.TempDir(), Keep: safefile.DefaultKeep}
+	t.Cleanup(func() { safefile.Default = original })
+}
+
+func TestConfiguredNetworkingMode(t *testing.T) {
+	path := writeWslConfig(t, `
+# comment
+[wsl2]
+memory=8GB
+networkingMode=Mirrored
+`)
+	mode, err := configuredNetworkingMode(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "mirrored", mode)
+}
+
+func TestNetworkingModeOfMissingFile(t *testing.T) {
+	mode, err := configuredNetworkingMode(filepath.Join(t.TempDir(), FileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "", mode)
+}
+
+func TestConfiguredModeWinsOverDetection(t *testing.T) {
+	assert.Equal(t, model.NetworkingModeMirrored, DetectNetworkingMode(context.Background(), "mirrored"))
+	assert.Equal(t, model.NetworkingModeNat, DetectNetworkingMode(context.Background(), "nat"))
+}
+
+func TestMirroredModeIsDetectedViaInterface(t *testing.T) {
+	original := mirroredInterface
+	mirroredInterface = "lo"
+	t.Cleanup(func() { mirroredInterface = original })
+
+	assert.Equal(t, model.NetworkingModeMirrored, DetectNetworkingMode(context.Background(), "auto"))
+}
+
+func TestConflictsOfDefaultSettings(t *testing.T) {
+	w := WslConfigImpl{path: writeWslConfig(t, "[wsl2]\nmemory=8GB\n")}
+
+	conflicts, err := w.Conflicts(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []model.WslConfigConflict{
+		{Key: "autoProxy", Actual: "true (default)", Recommended: "false", Reason: recommendations[0].reason},
+		{Key: "dnsTunneling", Actual: "true (default)", Recommended: "false", Reason: recommendations[1].reason},
+		{Key: "firewall", Actual: "true (default)", Recommended: "false", Reason: recommendations[2].reason, HintOnly: true},
+	}, conflicts)
+}
+
+func TestNoConflictsInMirroredMode(t *testing.T) {
+	w := WslConfigImpl{
+		NetworkingMode: model.NetworkingModeMirrored,
+		path:           writeWslConfig(t, "[wsl2]\nnetworkingMode=mirrored\nautoProxy=False\ndnsTunneling=false\n"),
+	}
+
+	conflicts, err := w.Conflicts(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
+
+func TestApplyRecommendedKeepsTheRestOfTheFile(t *testing.T) {
+	useTempBackups(t)
+	path := writeWslConfig(t, "# my settings\r\n[wsl2]\r\nmemory=8GB\r\nautoProxy = true\r\nnetworkingMode=virtioproxy\r\n")
+	w := WslConfigImpl{path: path}
+
+	applied, err := w.ApplyRecommended(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, applied, 3)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# my settings\r\n[wsl2]\r\nmemory=8GB\r\nautoProxy = false\r\nnetworkingMode=nat\r\ndnsTunneling=false\r\n", string(content))
+
+	conflicts, err := w.Conflicts(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 1)
+	assert.True(t, conflicts[0].HintOnly)
+}
+
+func TestApplyRecommendedCreatesTheFile(t *testing.T) {
+	useTempBackups(t)
+	path := filepath.Join(t.TempDir(), FileName)
+	w := WslConfigImpl{path: path}
+
+	_, err := w.ApplyRecommended(context.Background())
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[wsl2]\nautoProxy=false\ndnsTunneling=false\n", string(content))
+}
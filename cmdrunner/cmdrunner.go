@@ -0,0 +1,126 @@
+package cmdrunner
+
+// runs the external commands of the adapters, e.g. powershell.exe, gsudo
+// or ip. The runner is taken from the context, so another one records all
+// commands of a run or replays a recording on any Linux box. In-process operations which change
+// or probe the machine, e.g. netlink requests, pings and HTTP requests, run
+// as commands too, so a replay doesn't execute them.
+//
+// usage:
+//
+//	out, err := cmdrunner.Run(ctx, "ip", "route", "show", "default")
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+type Command struct {
+	Name string
+	Args []string
+	Dir  string // optional working directory
+	// optional additional environment, e.g. a password which must not show
+	// up in the process list. Not part of recordings
+	Env []string
+	// optional in-process implementation, run instead of an executable.
+	// Name and Args describe it in recordings
+	Func func(ctx context.Context) ([]byte, error)
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+type Runner interface {
+	// returns the combined output of stdout and stderr. A non-zero exit
+	// code is an error
+	Run(ctx context.Context, cmd Command) ([]byte, error)
+}
+
+// global runner, used without one in the context, e.g. by tests
+var Default Runner = ExecRunner{}
+
+type contextKey struct{}
+
+// the runner of a run, e.g. a recorder or replayer of an isetta.Client
+func WithRunner(ctx context.Context, runner Runner) context.Context {
+	return context.WithValue(ctx, contextKey{}, runner)
+}
+
+func FromContext(ctx context.Context) Runner {
+	if runner, ok := ctx.Value(contextKey{}).(Runner); ok {
+		return runner
+	}
+	return Default
+}
+
+func Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return FromContext(ctx).Run(ctx, Command{Name: name, Args: args})
+}
+
+// runs the command inside the given working directory
+func RunIn(ctx context.Context, dir string, name string, args ...string) ([]byte, error) {
+	return FromContext(ctx).Run(ctx, Command{Name: name, Args: args, Dir: dir})
+}
+
+// runs the command with additional environment variables like 'NAME=value'
+func RunWithEnv(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	return FromContext(ctx).Run(ctx, Command{Name: name, Args: args, Env: env})
+}
+
+// runs the in-process implementation f as the command 'name args'
+func RunFunc(ctx context.Context, f func(ctx context.Context) ([]byte, error), name string, args ...string) ([]byte, error) {
+	return FromContext(ctx).Run(ctx, Command{Name: name, Args: args, Func: f})
+}
+
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	if cmd.Func != nil {
+		return cmd.Func(ctx)
+	}
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+	return c.CombinedOutput()
+}
+
+// error of a replayed command which failed when it was recorded
+type ExitError struct {
+	Code    int
+	Message string
+}
+
+func (e *ExitError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// -1 if the command did not run at all, e.g. since it wasn't found. The
+// errno of a failed in-process command, e.g. a netlink request
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode()
+	}
+	var replayErr *ExitError
+	if errors.As(err, &replayErr) {
+		return replayErr.Code
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return int(errno)
+	}
+	return -1
+}
@@ -0,0 +1,163 @@
+package cmdrunner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "org.samba/isetta/simplelogger"
+)
+
+// one JSON line per command
+const RecordingFile = "commands.jsonl"
+
+// credentials are redacted from commands and outputs before they are
+// written, so replaying matches the redacted commands
+type entry struct {
+	Command  string `json:"command"`
+	Dir      string `json:"dir,omitempty"`
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Runs the commands via another runner and appends them with their
+// outputs and exit codes to the recording in Dir
+type Recorder struct {
+	Runner Runner
+	mu     sync.Mutex
+	file   *os.File
+}
+
+func NewRecorder(runner Runner, dir string) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create recording directory %v: %w", dir, err)
+	}
+	path := filepath.Join(dir, RecordingFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open recording %v: %w", path, err)
+	}
+	return &Recorder{Runner: runner, file: file}, nil
+}
+
+func (r *Recorder) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	out, err := r.Runner.Run(ctx, cmd)
+	// commands killed by a cancelled context don't reproduce anything
+	if ctx.Err() != nil {
+		return out, err
+	}
+
+	e := entry{
+		Command:  log.Redact(cmd.String()),
+		Dir:      cmd.Dir,
+		Output:   log.Redact(string(out)),
+		ExitCode: exitCode(err),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.write(ctx, e)
+	return out, err
+}
+
+func (r *Recorder) write(ctx context.Context, e entry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to record command %v: %v", e.Command, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to record command %v: %v", e.Command, err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// Answers commands from a recording instead of running them. Commands are
+// matched in the recorded order. Since probes run concurrently, a command
+// doesn't need to be the next one in the recording. If a command runs more
+// often than recorded, e.g. in a retry loop, its last recording is repeated.
+// Pings, HTTP requests and interface lookups are answered like commands, an
+// unrecorded one fails. Files are not recorded, so a replay runs as a dry run
+// which reads the files of the replaying box and doesn't write any, see
+// safefile.Store
+type Replayer struct {
+	mu      sync.Mutex
+	entries []entry
+	used    []bool
+}
+
+func NewReplayer(dir string) (*Replayer, error) {
+	path := filepath.Join(dir, RecordingFile)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open recording %v: %w", path, err)
+	}
+	defer file.Close()
+
+	replayer := &Replayer{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var e entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recording %v, line %v: %w", path, lineNo, err)
+		}
+		replayer.entries = append(replayer.entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read recording %v: %w", path, err)
+	}
+	replayer.used = make([]bool, len(replayer.entries))
+	return replayer, nil
+}
+
+func (r *Replayer) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	e, found := r.next(log.Redact(cmd.String()))
+	if !found {
+		return nil, fmt.Errorf("command was not recorded: %v", log.Redact(cmd.String()))
+	}
+	log.FromContext(ctx).Trace("Replaying: %v", e.Command)
+	if e.ExitCode != 0 || e.Error != "" {
+		return []byte(e.Output), &ExitError{Code: e.ExitCode, Message: e.Error}
+	}
+	return []byte(e.Output), nil
+}
+
+func (r *Replayer) next(command string) (entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, e := range r.entries {
+		if e.Command != command {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return e, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return entry{}, false
+	}
+	return r.entries[last], true
+}
@@ -0,0 +1,133 @@
+package cmdrunner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+type fakeRunner struct {
+	outputs map[string]string
+	calls   int
+}
+
+func (f *fakeRunner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	f.calls++
+	out, found := f.outputs[cmd.String()]
+	if !found {
+		return []byte("unknown command\n"), &ExitError{Code: 127}
+	}
+	return []byte(out), nil
+}
+
+func TestRecordingIsReplayed(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(&fakeRunner{outputs: map[string]string{"ip route show default": "default via 172.28.64.1 dev eth0\n"}}, dir)
+	assert.NoError(t, err)
+	recorder.Run(ctx, Command{Name: "ip", Args: []string{"route", "show", "default"}})
+	recorder.Run(ctx, Command{Name: "ip", Args: []string{"route", "del", "10.0.0.0/8"}})
+	assert.NoError(t, recorder.Close())
+
+	replayer, err := NewReplayer(dir)
+	assert.NoError(t, err)
+	out, err := replayer.Run(ctx, Command{Name: "ip", Args: []string{"route", "show", "default"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "default via 172.28.64.1 dev eth0\n", string(out))
+
+	out, err = replayer.Run(ctx, Command{Name: "ip", Args: []string{"route", "del", "10.0.0.0/8"}})
+	assert.Equal(t, "unknown command\n", string(out))
+	var exitErr *ExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 127, exitErr.Code)
+}
+
+func TestRepeatedCommandsAreReplayedInOrder(t *testing.T) {
+	replayer := &Replayer{entries: []entry{
+		{Command: "gsudo status", Output: "Available for this process: False"},
+		{Command: "gsudo status", Output: "Available for this process: True"},
+	}}
+	replayer.used = make([]bool, len(replayer.entries))
+	status := Command{Name: "gsudo", Args: []string{"status"}}
+
+	for _, expected := range []string{"False", "True", "True"} {
+		out, err := replayer.Run(ctx, status)
+		assert.NoError(t, err)
+		assert.Equal(t, "Available for this process: "+expected, string(out))
+	}
+}
+
+func TestUnrecordedCommandFails(t *testing.T) {
+	replayer := &Replayer{}
+	_, err := replayer.Run(ctx, Command{Name: "ip", Args: []string{"route", "show", "default"}})
+	assert.ErrorContains(t, err, "not recorded: ip route show default")
+}
+
+func TestCredentialsAreNotRecorded(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(&fakeRunner{}, dir)
+	assert.NoError(t, err)
+	recorder.Run(ctx, Command{Name: "keytool", Args: []string{"-list", "-storepass", "changeit"}})
+	recorder.Close()
+
+	content, err := os.ReadFile(filepath.Join(dir, RecordingFile))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "changeit")
+
+	// replaying matches the redacted command
+	replayer, err := NewReplayer(dir)
+	assert.NoError(t, err)
+	_, err = replayer.Run(ctx, Command{Name: "keytool", Args: []string{"-list", "-storepass", "changeit"}})
+	assert.NotContains(t, err.Error(), "not recorded")
+}
+
+func TestCancelledCommandsAreNotRecorded(t *testing.T) {
+	dir := t.TempDir()
+	runner := &fakeRunner{}
+	recorder, err := NewRecorder(runner, dir)
+	assert.NoError(t, err)
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	recorder.Run(cancelledCtx, Command{Name: "ip", Args: []string{"route"}})
+	recorder.Close()
+
+	content, _ := os.ReadFile(filepath.Join(dir, RecordingFile))
+	assert.Equal(t, 1, runner.calls)
+	assert.Empty(t, content)
+}
+
+func TestInProcessCommandIsRecordedAndNotReplayed(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(ExecRunner{}, dir)
+	assert.NoError(t, err)
+	calls := 0
+	deleteRoute := func(ctx context.Context) ([]byte, error) {
+		calls++
+		return nil, syscall.ESRCH
+	}
+	_, err = recorder.Run(ctx, Command{Name: "netlink", Args: []string{"route", "delete", "default"}, Func: deleteRoute})
+	assert.ErrorIs(t, err, syscall.ESRCH)
+	assert.NoError(t, recorder.Close())
+
+	replayer, err := NewReplayer(dir)
+	assert.NoError(t, err)
+	_, err = replayer.Run(ctx, Command{Name: "netlink", Args: []string{"route", "delete", "default"}, Func: deleteRoute})
+	var exitErr *ExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, int(syscall.ESRCH), exitErr.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRunnerOfTheContextIsUsed(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{"ip route show default": "default via 172.28.64.1 dev eth0\n"}}
+	out, err := Run(WithRunner(ctx, runner), "ip", "route", "show", "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "default via 172.28.64.1 dev eth0\n", string(out))
+	assert.Equal(t, 1, runner.calls)
+}
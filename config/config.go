@@ -3,6 +3,8 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"net/url"
+	"strconv"
 
 	"github.com/3th1nk/cidr"
 	"github.com/spf13/viper"
@@ -11,30 +13,76 @@ import (
 	log "org.samba/isetta/simplelogger"
 )
 
-var defaults = map[string]string{
-	"general.internet_access_test_url": "https://www.google.com/",
-	"general.log_level":                "info",
-	"network.wsl_to_windows_subnet":    "169.254.254.0/24",
-	"network.px_proxy_port":            "3128",
-	"dns.public_server":                "8.8.8.8",
+var defaults = map[string]any{
+	"general.internet_access_test_url":      "https://www.google.com/",
+	"general.captive_portal_test_url":       "http://connectivitycheck.gstatic.com/generate_204",
+	"general.progress":                      "auto",
+	"general.log_level":                     "info",
+	"general.log_format":                    "plain",
+	"general.log_file":                      false,
+	"general.log_file_level":                "debug",
+	"general.log_file_max_size_kb":          1024,
+	"general.log_file_max_backups":          3,
+	"general.backup_dir":                    "/var/lib/isetta/backups",
+	"general.backups_to_keep":               10,
+	"network.wsl_to_windows_subnet":         "169.254.254.0/24",
+	"network.px_proxy_port":                 "3128",
+	"network.linux_interface":               "eth0",
+	"network.windows_adapter":               "",
+	"network.networking_mode":               "auto",
+	"dns.public_server":                     "8.8.8.8",
+	"detection.detectors":                   []string{"override", "dns"},
+	"detection.min_confidence":              0.5,
+	"detection.dns_suffix_scenario":         "proxy",
+	"detection.vpn_adapter_scenario":        "proxy",
+	"detection.ssid_scenario":               "proxy",
+	"detection.intranet_url_scenario":       "proxy",
+	"certificates.ca_bundle":                "/etc/isetta/corporate-ca.pem",
+	"certificates.java_alias_prefix":        "isetta-",
+	"certificates.java_truststore_password": "changeit",
+	"certificates.ca_bundle_env_vars": []string{
+		"REQUESTS_CA_BUNDLE",
+		"SSL_CERT_FILE",
+		"NODE_EXTRA_CA_CERTS",
+		"CURL_CA_BUNDLE",
+		"GIT_SSL_CAINFO",
+	},
 }
 
 type Config struct {
-	General General
-	Network Network
-	Dns     Dns
+	General        General
+	Network        Network
+	Dns            Dns
+	Detection      Detection
+	SplitTunnel    SplitTunnel     `mapstructure:"split_tunnel"`
+	IntranetChecks []IntranetCheck `mapstructure:"intranet_checks" validate:"dive"`
+	Certificates   Certificates
 }
 
 type General struct {
 	InternetAccessTestUrl string `mapstructure:"internet_access_test_url" validate:"url"`
+	CaptivePortalTestUrl  string `mapstructure:"captive_portal_test_url" validate:"omitempty,url"` // empty: no captive portal detection
+	PreferFasterPath      bool   `mapstructure:"prefer_faster_path"`
+	Progress              string `mapstructure:"progress" validate:"oneof=auto plain off"` // auto: step list on a terminal, plain lines otherwise
 	LogLevel              string `mapstructure:"log_level" validate:"alpha"`
+	LogFormat             string `mapstructure:"log_format" validate:"oneof=plain text json"`
+	LogFile               bool   `mapstructure:"log_file"`
+	LogFilePath           string `mapstructure:"log_file_path"` // empty: isetta.log in the user's state dir
+	LogFileLevel          string `mapstructure:"log_file_level" validate:"alpha"`
+	LogFileMaxSizeKb      int    `mapstructure:"log_file_max_size_kb" validate:"min=1"`
+	LogFileMaxBackups     int    `mapstructure:"log_file_max_backups" validate:"min=0"`
+	BackupDir             string `mapstructure:"backup_dir" validate:"required"`   // of resolv.conf and wsl.conf, see 'isetta restore-file'
+	BackupsToKeep         int    `mapstructure:"backups_to_keep" validate:"min=1"` // per file
 }
 
 type Network struct {
 	WslToWindowsSubnet string `mapstructure:"wsl_to_windows_subnet" validate:"cidrv4"`
 	PxProxyPort        int    `mapstructure:"px_proxy_port" validate:"min=1,max=65535"`
+	LinuxInterface     string `mapstructure:"linux_interface" validate:"required"` // carries the Linux P2P address
+	WindowsAdapter     string `mapstructure:"windows_adapter"`                     // empty: discovered via the Linux default gateway
+	NetworkingMode     string `mapstructure:"networking_mode" validate:"oneof=auto nat mirrored"`
 	P2p                P2p
-	NoProxy   []string `mapstructure:"no_proxy"`
+	NoProxy            []string `mapstructure:"no_proxy"`
 }
 
 type P2p struct {
@@ -48,75 +96,162 @@ type Dns struct {
 	PublicServer   string `mapstructure:"public_server" validate:"ip4_addr"`
 }
 
-func init() {
-	viper.SetConfigName(".isetta")
-	viper.SetConfigType("toml")
-	for k, v := range defaults {
-		viper.SetDefault(k, v)
+// detectors are asked in the configured order, see core.ScenarioChain
+type Detection struct {
+	Detectors     []string `mapstructure:"detectors" validate:"min=1,dive,oneof=override dns_suffix vpn_adapter ssid intranet_url dns"`
+	MinConfidence float64  `mapstructure:"min_confidence" validate:"min=0,max=1"`
+	Override      string   `mapstructure:"override" validate:"omitempty,oneof=proxy direct split-tunnel offline"`
+	DnsSuffixes   []string `mapstructure:"dns_suffixes"`
+	VpnAdapters   []string `mapstructure:"vpn_adapters"`
+	Ssids         []string `mapstructure:"ssids"`
+	IntranetUrl   string   `mapstructure:"intranet_url" validate:"omitempty,url"`
+	// scenario the detectors above report on a match
+	DnsSuffixScenario   string `mapstructure:"dns_suffix_scenario" validate:"oneof=proxy direct split-tunnel"`
+	VpnAdapterScenario  string `mapstructure:"vpn_adapter_scenario" validate:"oneof=proxy direct split-tunnel"`
+	SsidScenario        string `mapstructure:"ssid_scenario" validate:"oneof=proxy direct split-tunnel"`
+	IntranetUrlScenario string `mapstructure:"intranet_url_scenario" validate:"oneof=proxy direct split-tunnel"`
+}
+
+type SplitTunnel struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	InternalCidrs   []string `mapstructure:"internal_cidrs" validate:"dive,cidrv4"`
+	IntranetTestUrl string   `mapstructure:"intranet_test_url" validate:"omitempty,url"`
+}
+
+// checked after the configuration, except when directly connected
+type IntranetCheck struct {
+	Target   string `mapstructure:"target" validate:"required,url|hostname_port"` // URL or host:port
+	Via      string `mapstructure:"via" validate:"omitempty,oneof=proxy direct"`  // default: direct
+	Required bool   `mapstructure:"required"`
+}
+
+type Certificates struct {
+	WindowsCaSubjects      []string `mapstructure:"windows_ca_subjects"`
+	CaBundle               string   `mapstructure:"ca_bundle"`
+	CaBundleEnvVars        []string `mapstructure:"ca_bundle_env_vars"`
+	JavaAliasPrefix        string   `mapstructure:"java_alias_prefix" validate:"required"`
+	JavaPkcs12Truststore   string   `mapstructure:"java_pkcs12_truststore"`
+	JavaTruststorePassword string   `mapstructure:"java_truststore_password"`
+}
+
+// every read uses its own viper instance, so configs of several isetta
+// clients in one process don't interfere
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetConfigName(".isetta")
+	v.SetConfigType("toml")
+	for k, value := range defaults {
+		v.SetDefault(k, value)
 	}
+	return v
 }
 
 func FromConfigFile(configPath string, validLogLevels []string) Config {
-	conf := readConfigFromFile(configPath)
-	validateAnDetermineIps(&conf, validLogLevels)
+	conf, err := Load(configPath, validLogLevels)
+	helper.AssertNoError2(err)
 	return conf
 }
 
-func readConfigFromFile(configPath string) Config {
-	viper.AddConfigPath(configPath)
-	err := viper.ReadInConfig()
+// reads and validates .isetta.toml from configPath. Without a config file,
+// the defaults are validated
+func Load(configPath string, validLogLevels []string) (Config, error) {
+	conf, err := readConfigFromFile(configPath)
+	if err != nil {
+		return conf, err
+	}
+	err = Validate(&conf, validLogLevels)
+	return conf, err
+}
+
+// the built-in defaults, not validated. The internal DNS server is missing
+func Default() (Config, error) {
+	conf, err := unmarshalConfig(newViper())
+	if err != nil {
+		return conf, fmt.Errorf("error parsing default config: %w", err)
+	}
+	return conf, nil
+}
+
+func readConfigFromFile(configPath string) (Config, error) {
+	v := newViper()
+	v.AddConfigPath(configPath)
+	err := v.ReadInConfig()
 	if err != nil {
 		log.Logger.Info("Error reading config file from %v, error was: %v", configPath, err)
 	}
-	return unmarshalConfig()
+	return unmarshalConfig(v)
 }
 
-func GetProxyUrl(conf Config) string {
+// in mirrored networking mode, Px is reachable via Windows' localhost
+func GetProxyUrl(conf Config, mirrored bool) string {
+	if mirrored {
+		return fmt.Sprintf("http://127.0.0.1:%v", conf.Network.PxProxyPort)
+	}
 	return fmt.Sprintf("http://%v:%v", conf.Network.P2p.WindowsIp, conf.Network.PxProxyPort)
 }
 
+// host and port of the internet access test URL, for probing direct access without HTTP
+func GetInternetAccessTestAddress(conf Config) (string, int, error) {
+	testUrl, err := url.Parse(conf.General.InternetAccessTestUrl)
+	if err != nil {
+		return "", 0, fmt.Errorf("error parsing internet_access_test_url: %w", err)
+	}
+	port, err := strconv.Atoi(testUrl.Port())
+	if err != nil {
+		port = 80
+		if testUrl.Scheme == "https" {
+			port = 443
+		}
+	}
+	return testUrl.Hostname(), port, nil
+}
+
 // for testing
 func FromByteBuffer(buffer *bytes.Buffer, validLogLevels []string) Config {
 	conf := readConfigFromBuffer(buffer)
-	validateAnDetermineIps(&conf, validLogLevels)
+	err := Validate(&conf, validLogLevels)
+	helper.AssertNoError2(err)
 	return conf
 }
 
 func readConfigFromBuffer(buffer *bytes.Buffer) Config {
-	err := viper.ReadConfig(buffer)
+	v := newViper()
+	err := v.ReadConfig(buffer)
 	helper.AssertNoError(err, "error loading config")
-	return unmarshalConfig()
+	conf, err := unmarshalConfig(v)
+	helper.AssertNoError2(err)
+	return conf
 }
 
-func validateAnDetermineIps(conf *Config, validLogLevels []string) {
+// validates the config and determines the P2P addresses
+func Validate(conf *Config, validLogLevels []string) error {
 	myValidator := NewValidator(conf, validLogLevels)
 	err := myValidator.DoValidate()
-	helper.AssertNoError2(err)
-
-	err = determineP2pAddresses(conf)
-	helper.AssertNoError2(err)
+	if err != nil {
+		return err
+	}
+	return determineP2pAddresses(conf)
 }
 
-func unmarshalConfig() Config {
+func unmarshalConfig(v *viper.Viper) (Config, error) {
 	var conf Config
-	err := viper.Unmarshal(&conf)
-	helper.AssertNoError(err, "error parsing config")
-	return conf
+	err := v.Unmarshal(&conf)
+	if err != nil {
+		return conf, fmt.Errorf("error parsing config: %w", err)
+	}
+	return conf, nil
 }
 
 func determineP2pAddresses(conf *Config) error {
-	subnet := parseCidr(conf.Network.WslToWindowsSubnet)
+	subnet, err := cidr.Parse(conf.Network.WslToWindowsSubnet)
+	if err != nil {
+		return fmt.Errorf("error parsing wsl_to_windows_subnet %v: %w", conf.Network.WslToWindowsSubnet, err)
+	}
 	conf.Network.P2p.SubnetMask = subnet.Mask().String()
 	conf.Network.P2p.WindowsIp, conf.Network.P2p.LinuxIp = determineFirstTwoIps(subnet)
 	return nil
 }
 
-func parseCidr(subnet string) *cidr.CIDR {
-	c, err := cidr.Parse(subnet)
-	helper.AssertNoError(err, "error parsing wsl_to_windows_subnet %v.", subnet)
-	return c
-}
-
 func determineFirstTwoIps(subnet *cidr.CIDR) (string, string) {
 	firstIp := ""
 	secondIp := ""
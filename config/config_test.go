@@ -52,8 +52,13 @@ internal_server = "1.2.3.4"
 	assert.Contains(t, cfg.General.InternetAccessTestUrl, "https://")
 	assert.NotEmpty(t, cfg.General.LogLevel)
 	assert.NotEmpty(t, cfg.Network.PxProxyPort)
+	assert.Equal(t, "eth0", cfg.Network.LinuxInterface)
 	assert.NotEmpty(t, cfg.Dns.InternalServer)
 	assert.NotEmpty(t, cfg.Dns.PublicServer)
+	assert.Contains(t, cfg.Certificates.CaBundleEnvVars, "REQUESTS_CA_BUNDLE")
+	assert.Equal(t, []string{"override", "dns"}, cfg.Detection.Detectors)
+	assert.Equal(t, 0.5, cfg.Detection.MinConfidence)
+	assert.Equal(t, "proxy", cfg.Detection.VpnAdapterScenario)
 }
 
 func TestSubnetSplitting(t *testing.T) {
@@ -94,8 +99,41 @@ internal_server = "1.2.3.4"
 `
 
 	cfg := FromByteBuffer(bytes.NewBufferString(exampleConfig), validLogLevels)
-	proxyUrl := GetProxyUrl(cfg)
+	proxyUrl := GetProxyUrl(cfg, false)
 	assert.Equal(t, "http://1.1.1.1:3128", proxyUrl)
+	assert.Equal(t, "http://127.0.0.1:3128", GetProxyUrl(cfg, true))
+}
+
+func TestGetInternetAccessTestAddress(t *testing.T) {
+	host, port, err := GetInternetAccessTestAddress(Config{General: General{InternetAccessTestUrl: "https://www.google.com/"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "www.google.com", host)
+	assert.Equal(t, 443, port)
+
+	host, port, err = GetInternetAccessTestAddress(Config{General: General{InternetAccessTestUrl: "http://example.com:8080/x"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", host)
+	assert.Equal(t, 8080, port)
+
+	_, _, err = GetInternetAccessTestAddress(Config{General: General{InternetAccessTestUrl: "http://%zz"}})
+	assert.ErrorContains(t, err, "internet_access_test_url")
+}
+
+func TestSplitTunnel(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[split_tunnel]
+enabled = true
+internal_cidrs = ["10.0.0.0/8", "1.2.3.4/32"]
+intranet_test_url = "https://intranet.corp/"
+`
+
+	cfg := FromByteBuffer(bytes.NewBufferString(exampleConfig), validLogLevels)
+	assert.True(t, cfg.SplitTunnel.Enabled)
+	assert.Equal(t, []string{"10.0.0.0/8", "1.2.3.4/32"}, cfg.SplitTunnel.InternalCidrs)
+	assert.Equal(t, "https://intranet.corp/", cfg.SplitTunnel.IntranetTestUrl)
 }
 
 // TODO
@@ -118,3 +156,35 @@ func getTestConfigFileDir() string {
 	thisPackageDir := filepath.Dir(pathToThisFile)
 	return filepath.Join(thisPackageDir, "../fixture/")
 }
+
+func TestIntranetChecks(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[[intranet_checks]]
+target = "git.corp:22"
+
+[[intranet_checks]]
+target = "https://artifacts.corp/"
+via = "proxy"
+required = true
+`
+
+	cfg := FromByteBuffer(bytes.NewBufferString(exampleConfig), validLogLevels)
+	assert.Equal(t, []IntranetCheck{
+		{Target: "git.corp:22"},
+		{Target: "https://artifacts.corp/", Via: "proxy", Required: true},
+	}, cfg.IntranetChecks)
+}
+
+func TestDefaultConfigIsValidOnceTheInternalDnsServerIsSet(t *testing.T) {
+	conf, err := Default()
+	assert.NoError(t, err)
+	assert.Equal(t, "8.8.8.8", conf.Dns.PublicServer)
+	assert.Error(t, Validate(&conf, validLogLevels))
+
+	conf.Dns.InternalServer = "10.0.0.1"
+	assert.NoError(t, Validate(&conf, validLogLevels))
+	assert.Equal(t, "169.254.254.1", conf.Network.P2p.WindowsIp)
+}
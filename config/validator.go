@@ -14,11 +14,15 @@ import (
 )
 
 var humanReadableValidationMessages = map[string]string{
-	"required": "{0} is missing",
-	"url":      "{0}: {1} is an an invalid URL",
-	"cidrv4":   "{0}: {1} is not a valid CIDR address",
-	"ip4_addr": "{0}: {1} is not a valid IPv4 address",
-	"alpha":    "{0}: {1} is not a letters-only string",
+	"required":          "{0} is missing",
+	"url":               "{0}: {1} is an an invalid URL",
+	"cidrv4":            "{0}: {1} is not a valid CIDR address",
+	"ip4_addr":          "{0}: {1} is not a valid IPv4 address",
+	"alpha":             "{0}: {1} is not a letters-only string",
+	"oneof":             "{0}: {1} is not one of the allowed values",
+	"min":               "{0}: {1} is too small",
+	"max":               "{0}: {1} is too large",
+	"url|hostname_port": "{0}: {1} is neither a URL nor host:port",
 }
 
 type MyValidator struct {
@@ -78,12 +82,17 @@ func (v MyValidator) DoValidate() error {
 }
 
 func (v MyValidator) validateLogLevel() error {
-	logLevel := v.Config.General.LogLevel
-	if slices.Contains(v.ValidLogLevels, logLevel) {
-		return nil
-	} else {		
-		return fmt.Errorf("log level '%v' is invalid. Valid log levels: %v", logLevel, strings.Join(v.ValidLogLevels, ", "))
+	logLevels := []string{v.Config.General.LogLevel}
+	if v.Config.General.LogFile {
+		logLevels = append(logLevels, v.Config.General.LogFileLevel)
 	}
+
+	for _, logLevel := range logLevels {
+		if !slices.Contains(v.ValidLogLevels, logLevel) {
+			return fmt.Errorf("log level '%v' is invalid. Valid log levels: %v", logLevel, strings.Join(v.ValidLogLevels, ", "))
+		}
+	}
+	return nil
 }
 
 func (v MyValidator) validateSubnetSize() error {
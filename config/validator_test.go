@@ -77,3 +77,82 @@ func validate(config string) error {
 	err := myValidator.DoValidate()
 	return err
 }
+
+func TestInvalidLogFormat(t *testing.T) {
+	var exampleConfig = `
+[general]
+log_format = "xml"
+
+[dns]
+internal_server = "1.2.3.4"
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "LogFormat: xml is not one of the allowed values")
+}
+
+func TestInvalidLogFileLevel(t *testing.T) {
+	var exampleConfig = `
+[general]
+log_file = true
+log_file_level = "verbose"
+
+[dns]
+internal_server = "1.2.3.4"
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "log level 'verbose' is invalid")
+}
+
+func TestUnknownScenarioDetector(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[detection]
+detectors = ["override", "crystal_ball"]
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Detectors[1]: crystal_ball is not one of the allowed values")
+}
+
+func TestOfflineIsNoScenarioOfADetector(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[detection]
+vpn_adapter_scenario = "offline"
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "VpnAdapterScenario: offline is not one of the allowed values")
+}
+
+func TestTooHighMinConfidence(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[detection]
+min_confidence = 1.5
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "MinConfidence: 1.5 is too large")
+}
+
+func TestInvalidIntranetCheckTarget(t *testing.T) {
+	var exampleConfig = `
+[dns]
+internal_server = "1.2.3.4"
+
+[[intranet_checks]]
+target = "git.corp"
+`
+	err := validate(exampleConfig)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Target: git.corp is neither a URL nor host:port")
+}
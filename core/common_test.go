@@ -1,8 +1,13 @@
 package core
 
-import "org.samba/isetta/mocks"
+import (
+	"context"
+
+	"org.samba/isetta/mocks"
+)
 
 // shared between the tests of this package :-/
+var ctx = context.Background()
 var mockWinChecker *mocks.WindowsChecker
 var mockWinConfigurer *mocks.WindowsConfigurer
 var mockHttpChecker *mocks.HttpChecker
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
+	"org.samba/isetta/timing"
+)
+
+type Detector struct {
+	RunningAsRoot     bool
+	InternalDnsServer string
+	PublicDnsServer   string
+	LinuxP2pIp        string
+	WindowsP2pIp      string
+	NetworkingMode    model.NetworkingMode // determined once at startup
+	WindowsChecker    WindowsChecker
+	LinuxPinger       LinuxPinger
+	HttpChecker       HttpChecker
+	InternetChecker   InternetChecker
+	ScenarioDetector  ScenarioDetector
+}
+
+// Runs all independent probes concurrently. As soon as the scenario is
+// decided, results of the remaining probes are no longer waited for. The
+// scenario is decided even if the internet is already accessible, e.g. direct
+// access works with a split tunnel which still needs the internal DNS server.
+func (d *Detector) Detect(ctx context.Context) model.Snapshot {
+	defer timing.StartParallel("Detecting network").End()
+
+	// abandoned probes are cancelled once detection is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	internetAccess := probeInternetAccess(ctx, d.InternetChecker)
+	captivePortal := probeCaptivePortal(ctx, d.HttpChecker)
+	runningOnWsl2 := probe(func() bool { return d.WindowsChecker.IsRunningOnWsl2(ctx) })
+	scenario := probeScenario(ctx, d.ScenarioDetector)
+	pxProxyRunning := probe(func() bool { return d.WindowsChecker.IsPxProxyRunning(ctx) })
+	pxProxyReachable := probe(func() bool { return d.HttpChecker.IsPxProxyReachable(ctx) })
+	// ICMP from within Linux requires root, without root it's not going to be configured anyway
+	linuxP2pIpUp := d.probeLinuxPing(ctx, d.LinuxP2pIp)
+	windowsP2pIpUp := d.probeLinuxPing(ctx, d.WindowsP2pIp)
+	internalDnsServerUp := d.probeLinuxPing(ctx, d.InternalDnsServer)
+	publicDnsServerUp := d.probeLinuxPing(ctx, d.PublicDnsServer)
+
+	snapshot := model.Snapshot{NetworkingMode: d.NetworkingMode}
+	snapshot.InternetPaths = <-internetAccess
+	snapshot.InternetAccess = snapshot.InternetPaths.Any()
+
+	snapshot.RunningOnWsl2 = <-runningOnWsl2
+	result := <-scenario
+	// e.g. a corporate proxy redirects the test URL as well, so a redirect or an
+	// HTML page only means a portal if nothing else works
+	if result.Scenario == model.ScenarioOffline && !snapshot.InternetAccess {
+		portal := <-captivePortal
+		if portal.found {
+			snapshot.Scenario = model.ScenarioCaptivePortal
+			snapshot.CaptivePortalLoginUrl = portal.loginUrl
+			return snapshot
+		}
+	}
+	snapshot.Scenario = result.Scenario
+	snapshot.ScenarioConfidence = result.Confidence
+	progress.EmitScenarioDetected(result.Scenario.String(), result.Detector, result.Confidence)
+
+	switch snapshot.Scenario {
+	case model.ScenarioViaProxy:
+		snapshot.PxProxyRunning = <-pxProxyRunning
+		snapshot.PxProxyReachable = <-pxProxyReachable
+		snapshot.LinuxP2pIpUp = <-linuxP2pIpUp
+		snapshot.WindowsP2pIpUp = <-windowsP2pIpUp
+		snapshot.InternalDnsServerUp = <-internalDnsServerUp
+	case model.ScenarioSplitTunnel:
+		snapshot.LinuxP2pIpUp = <-linuxP2pIpUp
+		snapshot.WindowsP2pIpUp = <-windowsP2pIpUp
+		snapshot.InternalDnsServerUp = <-internalDnsServerUp
+	case model.ScenarioDirect:
+		snapshot.PublicDnsServerUp = <-publicDnsServerUp
+	}
+	return snapshot
+}
+
+func (d *Detector) CheckInternetAccess(ctx context.Context) model.InternetPaths {
+	defer timing.StartParallel("Checking internet access").End()
+	return d.InternetChecker.CheckInternetAccess(ctx)
+}
+
+// Only decides the scenario, e.g. for printing the environment variables
+func (d *Detector) DetectScenario(ctx context.Context) model.Scenario {
+	defer timing.StartParallel("Detecting scenario").End()
+	result := d.ScenarioDetector.DetectScenario(ctx)
+	progress.EmitScenarioDetected(result.Scenario.String(), result.Detector, result.Confidence)
+	return result.Scenario
+}
+
+func (d *Detector) probeLinuxPing(ctx context.Context, host string) <-chan bool {
+	if !d.RunningAsRoot {
+		return probe(func() bool { return false })
+	}
+	return probe(func() bool { return d.LinuxPinger.Ping(ctx, host) })
+}
+
+func probeScenario(ctx context.Context, detector ScenarioDetector) <-chan model.ScenarioResult {
+	ch := make(chan model.ScenarioResult, 1)
+	go func() {
+		ch <- detector.DetectScenario(ctx)
+	}()
+	return ch
+}
+
+func probeInternetAccess(ctx context.Context, internetChecker InternetChecker) <-chan model.InternetPaths {
+	ch := make(chan model.InternetPaths, 1)
+	go func() {
+		ch <- internetChecker.CheckInternetAccess(ctx)
+	}()
+	return ch
+}
+
+type captivePortalResult struct {
+	loginUrl string
+	found    bool
+}
+
+func probeCaptivePortal(ctx context.Context, httpChecker HttpChecker) <-chan captivePortalResult {
+	ch := make(chan captivePortalResult, 1)
+	go func() {
+		loginUrl, found := httpChecker.CaptivePortalLoginUrl(ctx)
+		ch <- captivePortalResult{loginUrl, found}
+	}()
+	return ch
+}
+
+// runs the check in the background. The channel is buffered, so abandoned
+// probes don't block
+func probe(check func() bool) <-chan bool {
+	ch := make(chan bool, 1)
+	go func() {
+		ch <- check()
+	}()
+	return ch
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+var detector Detector
+
+func setupDetector(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockLinuxPinger = mocks.NewLinuxPinger(t)
+	mockHttpChecker = mocks.NewHttpChecker(t)
+
+	detector = Detector{
+		RunningAsRoot:     true,
+		InternalDnsServer: "42.42.42.42",
+		PublicDnsServer:   "8.8.8.8",
+		LinuxP2pIp:        "linux-ip",
+		WindowsP2pIp:      "windows-ip",
+		WindowsChecker:    mockWinChecker,
+		LinuxPinger:       mockLinuxPinger,
+		HttpChecker:       mockHttpChecker,
+		InternetChecker: InternetChecker{
+			HttpChecker:           mockHttpChecker,
+			TimeoutInMilliseconds: 100,
+		},
+		ScenarioDetector: &DnsDetector{
+			InternalDnsServer: "42.42.42.42",
+			PublicDnsServer:   "8.8.8.8",
+			WindowsChecker:    mockWinChecker,
+		},
+	}
+}
+
+// all probes are started upfront, results which are not needed are abandoned
+func setupProbes(internetAccess bool, internalDnsPingable bool, publicDnsPingable bool) {
+	mockHttpChecker.On("CheckDirectInternetAccess", mock.Anything, 100).Return(errorUnless(internetAccess))
+	mockHttpChecker.On("CheckInternetAccessViaProxy", mock.Anything, 100).Return(errorUnless(false))
+	mockHttpChecker.On("CaptivePortalLoginUrl", mock.Anything).Return("", false).Maybe()
+	mockWinChecker.On("IsRunningOnWsl2", mock.Anything).Return(true).Maybe()
+	mockWinChecker.On("IsPingable", mock.Anything, "42.42.42.42").Return(internalDnsPingable).Maybe()
+	mockWinChecker.On("IsPingable", mock.Anything, "8.8.8.8").Return(publicDnsPingable).Maybe()
+	mockWinChecker.On("IsPxProxyRunning", mock.Anything).Return(true).Maybe()
+	mockHttpChecker.On("IsPxProxyReachable", mock.Anything).Return(true).Maybe()
+	mockLinuxPinger.On("Ping", mock.Anything, "linux-ip").Return(true).Maybe()
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(false).Maybe()
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false).Maybe()
+	mockLinuxPinger.On("Ping", mock.Anything, "8.8.8.8").Return(true).Maybe()
+}
+
+func TestDetectInternetAccess(t *testing.T) {
+	setupDetector(t)
+	setupProbes(true, false, true)
+
+	snapshot := detector.Detect(ctx)
+	assert.True(t, snapshot.InternetAccess)
+}
+
+func TestDetectProxyScenario(t *testing.T) {
+	setupDetector(t)
+	setupProbes(false, true, false)
+
+	assert.Equal(t, model.Snapshot{
+		RunningOnWsl2:       true,
+		Scenario:            model.ScenarioViaProxy,
+		ScenarioConfidence:  0.9,
+		PxProxyRunning:      true,
+		PxProxyReachable:    true,
+		LinuxP2pIpUp:        true,
+		WindowsP2pIpUp:      false,
+		InternalDnsServerUp: false,
+	}, detectIgnoringPaths())
+}
+
+func TestDetectDirectScenario(t *testing.T) {
+	setupDetector(t)
+	setupProbes(false, false, true)
+
+	assert.Equal(t, model.Snapshot{
+		RunningOnWsl2:      true,
+		Scenario:           model.ScenarioDirect,
+		ScenarioConfidence: 0.8,
+		PublicDnsServerUp:  true,
+	}, detectIgnoringPaths())
+}
+
+func TestDetectOfflineScenario(t *testing.T) {
+	setupDetector(t)
+	setupProbes(false, false, false)
+
+	assert.Equal(t, model.ScenarioOffline, detector.Detect(ctx).Scenario)
+}
+
+func TestLinuxIsNotPingedWithoutRoot(t *testing.T) {
+	setupDetector(t)
+	detector.RunningAsRoot = false
+	setupProbes(false, true, false)
+
+	snapshot := detector.Detect(ctx)
+	assert.False(t, snapshot.LinuxP2pIpUp)
+	mockLinuxPinger.AssertNotCalled(t, "Ping", mock.Anything, "linux-ip")
+}
+
+func TestDetectScenario(t *testing.T) {
+	setupDetector(t)
+	mockWinChecker.On("IsPingable", mock.Anything, "42.42.42.42").Return(false)
+	mockWinChecker.On("IsPingable", mock.Anything, "8.8.8.8").Return(true)
+
+	assert.Equal(t, model.ScenarioDirect, detector.DetectScenario(ctx))
+}
+
+func TestDetectCaptivePortal(t *testing.T) {
+	setupDetector(t)
+	mockHttpChecker.On("CaptivePortalLoginUrl", mock.Anything).Return("http://login.hotel-wifi.example/", true)
+	setupProbes(false, false, false)
+
+	assert.Equal(t, model.Snapshot{
+		RunningOnWsl2:         true,
+		Scenario:              model.ScenarioCaptivePortal,
+		CaptivePortalLoginUrl: "http://login.hotel-wifi.example/",
+	}, detectIgnoringPaths())
+}
+
+// e.g. a corporate proxy redirecting the test URL
+func TestRedirectIsNoCaptivePortalIfAScenarioWorks(t *testing.T) {
+	setupDetector(t)
+	mockHttpChecker.On("CaptivePortalLoginUrl", mock.Anything).Return("http://proxy.corp.example/blocked", true).Maybe()
+	setupProbes(false, true, false)
+
+	assert.Equal(t, model.ScenarioViaProxy, detectIgnoringPaths().Scenario)
+}
+
+// latencies and errors of the internet access checks are not deterministic
+func detectIgnoringPaths() model.Snapshot {
+	snapshot := detector.Detect(ctx)
+	snapshot.InternetPaths = model.InternetPaths{}
+	return snapshot
+}
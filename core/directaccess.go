@@ -1,8 +1,13 @@
 package core
 
 import (
+	"context"
 	"errors"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
 type DirectAccess struct {
@@ -14,15 +19,20 @@ type DirectAccess struct {
 	EnvVarPrinter   EnvVarPrinter
 }
 
-func (d *DirectAccess) Configure() error {
-	d.DnsConfigurer.ActivateDnsServer(d.PublicDnsServer)
-	d.EnvVarPrinter.WarnIfProxyVarSet()
-	err := d.configureDefaultGatewayIfNeeded()
+func (d *DirectAccess) Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Configuring direct access").End()
+
+	err := d.activateDnsServer(ctx, tx)
+	if err != nil {
+		return err
+	}
+	d.EnvVarPrinter.WarnIfProxyVarSet(ctx)
+	err = d.configureDefaultGatewayIfNeeded(ctx, snapshot, tx)
 	if err != nil {
 		return err
 	}
 
-	err = d.checkDirectAccess()
+	err = d.checkDirectAccess(ctx)
 	if err != nil {
 		return err
 	}
@@ -30,33 +40,52 @@ func (d *DirectAccess) Configure() error {
 	return nil
 }
 
-func (d *DirectAccess) configureDefaultGatewayIfNeeded() error {
-	if !d.isPublicDnsServerUp() {
-		log.Logger.Debug("Configuring default gateway")
-		d.LinuxConfigurer.DeleteDefaultGateway()
-		d.LinuxConfigurer.AddDefaultGateway()
+func (d *DirectAccess) activateDnsServer(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Activating public DNS server").End()
+	err := registerResolvConfRollback(ctx, tx, d.DnsConfigurer)
+	if err != nil {
+		return err
+	}
+	return d.DnsConfigurer.ActivateDnsServer(ctx, d.PublicDnsServer)
+}
 
-		if !d.isPublicDnsServerUp() {
-			return errors.New("failed to adjust default gateway 🤔")
-		}
+func (d *DirectAccess) configureDefaultGatewayIfNeeded(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	if snapshot.PublicDnsServerUp {
+		log.FromContext(ctx).Trace("Public DNS server %v can be reached from within Linux. Default gateway works", d.PublicDnsServer)
+		return nil
 	}
 
+	defer timing.Start("Configuring default gateway").End()
+	log.FromContext(ctx).Debug("Configuring default gateway")
+	err := registerDefaultGatewayRollback(ctx, tx, d.LinuxConfigurer)
+	if err != nil {
+		return err
+	}
+	d.LinuxConfigurer.DeleteDefaultGateway(ctx)
+	err = d.LinuxConfigurer.AddDefaultGateway(ctx)
+	if err != nil {
+		return err
+	}
+	if !d.isPublicDnsServerUp(ctx) {
+		return errors.New("failed to adjust default gateway 🤔")
+	}
 	return nil
 }
 
-func (d *DirectAccess) isPublicDnsServerUp() bool {
-	if d.LinuxPinger.Ping(d.PublicDnsServer) {
-		log.Logger.Trace("Public DNS server %v can be reached from within Linux. Default gateway works", d.PublicDnsServer)
+func (d *DirectAccess) isPublicDnsServerUp(ctx context.Context) bool {
+	if d.LinuxPinger.Ping(ctx, d.PublicDnsServer) {
+		log.FromContext(ctx).Trace("Public DNS server %v can be reached from within Linux. Default gateway works", d.PublicDnsServer)
 		return true
 	} else {
-		log.Logger.Trace("Public DNS server %v can't be reached from within Linux", d.PublicDnsServer)
+		log.FromContext(ctx).Trace("Public DNS server %v can't be reached from within Linux", d.PublicDnsServer)
 		return false
 	}
 }
 
-func (d *DirectAccess) checkDirectAccess() error {
-	if d.HttpChecker.HasDirectInternetAccess() {
-		log.Logger.Info("Done setting up WSL network for direct internet access")
+func (d *DirectAccess) checkDirectAccess(ctx context.Context) error {
+	defer timing.Start("Checking direct access").End()
+	if d.HttpChecker.HasDirectInternetAccess(ctx) {
+		progress.EmitMessage("Done setting up WSL network for direct internet access")
 		return nil
 	} else {
 		return errors.New("failed setting up WSL network for direct internet access")
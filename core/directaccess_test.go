@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
 	"org.samba/isetta/mocks"
 )
 
@@ -28,43 +30,66 @@ func setupDirect(t *testing.T) {
 
 func TestConfigureDirectInternetAccess(t *testing.T) {
 	setupDirect(t)
-	mockDnsConfigurer.On("ActivateDnsServer", "8.8.8.8").Return()
-	mockEnvVarPrinter.On("WarnIfProxyVarSet").Return()
-	// default gateway is ok as we have access to public DNS server
-	mockLinuxPinger.On("Ping", "8.8.8.8").Return(true)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "8.8.8.8").Return(nil)
+	mockEnvVarPrinter.On("WarnIfProxyVarSet", mock.Anything).Return()
 
 	// test url can be reached directly
-	mockHttpChecker.On("HasDirectInternetAccess").Return(true)
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
 
-	assert.NoError(t, direct.Configure())
+	// default gateway is ok as we have access to public DNS server
+	assert.NoError(t, direct.Configure(ctx, model.Snapshot{PublicDnsServerUp: true}, &model.Transaction{}))
 }
 
 func TestConfigureDirectInternetAccessWithDefaultGatewaySetup(t *testing.T) {
 	setupDirect(t)
-	mockDnsConfigurer.On("ActivateDnsServer", "8.8.8.8").Return()
-	mockEnvVarPrinter.On("WarnIfProxyVarSet").Return()
-	// default gateway is ok as we have access to public DNS server
-	mockLinuxPinger.On("Ping", "8.8.8.8").Return(false).Once()
-	mockLinuxConfigurer.On("DeleteDefaultGateway")
-	mockLinuxConfigurer.On("AddDefaultGateway")
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "8.8.8.8").Return(nil)
+	mockEnvVarPrinter.On("WarnIfProxyVarSet", mock.Anything).Return()
+	// public DNS server is not reachable, default gateway needs setup
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything)
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
 	// default GW setup was ok
-	mockLinuxPinger.On("Ping", "8.8.8.8").Return(true).Once()
+	mockLinuxPinger.On("Ping", mock.Anything, "8.8.8.8").Return(true).Once()
 
 	// test url can be reached directly
-	mockHttpChecker.On("HasDirectInternetAccess").Return(true)
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
 
-	assert.NoError(t, direct.Configure())
+	assert.NoError(t, direct.Configure(ctx, model.Snapshot{PublicDnsServerUp: false}, &model.Transaction{}))
 }
 
 func TestCheckHasDirectAccess(t *testing.T) {
 	setupDirect(t)
-	mockHttpChecker.On("HasDirectInternetAccess").Return(true)
-	assert.NoError(t, direct.checkDirectAccess())
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
+	assert.NoError(t, direct.checkDirectAccess(ctx))
 }
 
 func TestCheckHasNoDirectAccess(t *testing.T) {
 	setupDirect(t)
-	mockHttpChecker.On("HasDirectInternetAccess").Return(false)
-	assert.Error(t, direct.checkDirectAccess())
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(false)
+	assert.Error(t, direct.checkDirectAccess(ctx))
+}
+
+func TestErrorWhenDirectDefaultGatewayConfigFailed(t *testing.T) {
+	setupDirect(t)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything)
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "8.8.8.8").Return(false)
+
+	assert.Error(t, direct.configureDefaultGatewayIfNeeded(ctx, model.Snapshot{PublicDnsServerUp: false}, &model.Transaction{}))
 }
 
+func TestPreviousDefaultGatewayIsRestoredOnRollback(t *testing.T) {
+	setupDirect(t)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything)
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "8.8.8.8").Return(false)
+	mockLinuxConfigurer.On("RestoreDefaultGateway", mock.Anything, "172.28.64.1").Return(nil)
+
+	tx := &model.Transaction{}
+	assert.Error(t, direct.configureDefaultGatewayIfNeeded(ctx, model.Snapshot{PublicDnsServerUp: false}, tx))
+	assert.NoError(t, tx.Rollback(ctx))
+}
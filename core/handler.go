@@ -1,76 +1,381 @@
 package core
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
+// no failures of isetta, callers might react differently, e.g. with a distinct exit code
+var ErrOffline = errors.New("neither the internal nor the public DNS server is reachable - are you offline?")
+var ErrCaptivePortal = errors.New("captive portal detected")
+var ErrElevationDeferred = model.ErrElevationDeferred
+var ErrIntranetUnreachable = errors.New("required intranet targets failed")
+
 type Handler struct {
-	RunningAsRoot     bool
-	InternalDnsServer string
-	PublicDnsServer   string
-	WindowsChecker    WindowsChecker
-	DnsConfigurer     DnsConfigurer
-	EnvVarPrinter     EnvVarPrinter
-	DirectAccess      NetworkConfigurer
-	ViaProxy          NetworkConfigurer
-	InternetChecker   InternetChecker
-}
-
-func (h *Handler) PrintEnvVars() {
-	log.Logger.CurrentLogLevel = log.LevelError
-	if h.WindowsChecker.IsPingable(h.InternalDnsServer) {
-		h.EnvVarPrinter.PrintExportCommands()
-	} else if h.WindowsChecker.IsPingable(h.PublicDnsServer) {
-		h.EnvVarPrinter.PrintUnsetCommands()
+	RunningAsRoot    bool
+	PublicDnsServer  string // nameserver while offline
+	KeepPartialState bool // skips the rollback on failure, for debugging
+	PreferFasterPath bool // if both, direct and proxy access work
+	DnsConfigurer   DnsConfigurer
+	EnvVarPrinter   EnvVarPrinter
+	Configurers     map[model.Scenario]NetworkConfigurer // one per supported scenario
+	NetworkDetector NetworkDetector
+	Reconciler      NetworkReconciler
+	OverrideStore   OverrideStore
+	WslConfig       WslConfig
+	// intranet services which should work after the configuration
+	IntranetVerifier IntranetVerifier
+}
+
+func (h *Handler) PrintEnvVars(ctx context.Context) {
+	useProxy, decided := h.useProxy(ctx)
+	if decided {
+		h.printEnvVarsFor(ctx, useProxy)
 	}
 }
 
-func (h *Handler) ConfigureNetwork() error {
-	log.Logger.Info("Checking if internet can already by reached via HTTP")
-	if h.InternetChecker.HasInternetAccess() {
-		log.Logger.Info("Internet is already accessible. No further setup needed")
-		return nil
+func (h *Handler) EnvVars(ctx context.Context) model.EnvVarChanges {
+	useProxy, decided := h.useProxy(ctx)
+	if !decided {
+		return model.EnvVarChanges{}
+	}
+	if useProxy {
+		return model.EnvVarChanges{Export: h.EnvVarPrinter.ExportVars(ctx)}
+	}
+	return model.EnvVarChanges{Export: h.EnvVarPrinter.RestoredVars(), Unset: h.EnvVarPrinter.UnsetVars()}
+}
+
+// whether the shell should use the proxy, undecided when offline
+func (h *Handler) useProxy(ctx context.Context) (useProxy bool, decided bool) {
+	if h.PreferFasterPath && !h.isScenarioPinned() {
+		useProxy, decided := h.NetworkDetector.CheckInternetAccess(ctx).UseProxy(true)
+		if decided {
+			return useProxy, true
+		}
+	}
+
+	switch h.NetworkDetector.DetectScenario(ctx) {
+	case model.ScenarioViaProxy:
+		return true, true
+	case model.ScenarioOffline:
+		return false, false
+	default:
+		return false, true
+	}
+}
+
+// Detects the network without changing anything
+func (h *Handler) Detect(ctx context.Context) (model.Snapshot, error) {
+	snapshot := h.NetworkDetector.Detect(ctx)
+	return snapshot, ctx.Err()
+}
+
+func (h *Handler) ConfigureNetwork(ctx context.Context) error {
+	progress.EmitMessage("Detecting network connection")
+	snapshot := h.NetworkDetector.Detect(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// a pinned scenario wins, also if an internet path works already
+	if override, pinned := h.activeOverrideOrNone(); pinned && override.Scenario != snapshot.Scenario {
+		log.FromContext(ctx).Debug("Scenario is pinned to %v, detected was %v", override, snapshot.Scenario)
+		snapshot.Scenario = override.Scenario
+	}
+	if snapshot.InternetAccess {
+		configured, err := h.isConfigured(ctx, snapshot.Scenario)
+		if err != nil {
+			return err
+		}
+		if configured {
+			progress.EmitMessage("Internet is already accessible. No further setup needed")
+			h.warnOnProxyVarMismatch(ctx, snapshot.InternetPaths)
+			return h.verifyIntranet(ctx, snapshot.Scenario)
+		}
+		progress.EmitMessage("Internet is accessible, but the network isn't configured for scenario '%v' yet", snapshot.Scenario)
+	}
+	if snapshot.Scenario == model.ScenarioCaptivePortal {
+		return fmt.Errorf("%w, log in at %v and run isetta again", ErrCaptivePortal, snapshot.CaptivePortalLoginUrl)
 	}
 
 	if !h.RunningAsRoot {
 		return errors.New("to configure the network 'isetta' needs to run as root. Try running via sudo")
 	}
 	
-	err := h.checkRunningOnWsl()
+	err := h.checkRunningOnWsl(ctx, snapshot)
 	if err != nil {
 		return err
 	}
-	
-	h.DnsConfigurer.DisableResolveAutoConfGeneration()
+	h.WslConfig.WarnIfModePending(ctx)
 
-	log.Logger.Info("Detecting network connection")
-	if h.WindowsChecker.IsPingable(h.InternalDnsServer) {
-		log.Logger.Debug("Internal DNS server is reachable")
-		log.Logger.Info("Found internet access via proxy")
-		err = h.ViaProxy.Configure()
-		if err != nil {
-			return err
-		}
+	if snapshot.Scenario == model.ScenarioOffline {
+		h.resetNameservers(ctx)
+		return ErrOffline
+	}
 
-	} else if h.WindowsChecker.IsPingable(h.PublicDnsServer) {
-		log.Logger.Debug("Public DNS server is reachable")
-		log.Logger.Info("Found direct internet connection")
-		err = h.DirectAccess.Configure()
-		if err != nil {
-			return err
-		}
-	} else {
-		return errors.New("neither the internal nor the public DNS server is reachable - are you offline?")
+	tx := &model.Transaction{}
+	err = h.configure(ctx, snapshot, tx)
+	if err != nil {
+		return h.rollback(ctx, tx, err)
+	}
+	// the changes made so far stay, an interactive run completes them
+	if tx.HasDeferred() {
+		return fmt.Errorf("%w: %v. Run 'sudo isetta' to complete the configuration", ErrElevationDeferred, strings.Join(tx.Deferred(), ", "))
+	}
+	return h.verifyIntranet(ctx, snapshot.Scenario)
+}
+
+// With internet access, the scenario only needs configuring if the network
+// drifted from its desired state, e.g. direct access works with a split tunnel
+// but the internal DNS server is missing. The scenario is the pinned one, if any
+func (h *Handler) isConfigured(ctx context.Context, scenario model.Scenario) (bool, error) {
+	if scenario == model.ScenarioOffline {
+		return true, nil
+	}
+	drifts, err := h.Reconciler.Verify(ctx, scenario)
+	if err != nil {
+		return false, err
+	}
+	for _, drift := range drifts {
+		log.FromContext(ctx).Debug("Drift from scenario '%v': %v", scenario, drift)
 	}
+	return len(drifts) == 0, nil
+}
 
+// runs after the configuration, which is kept even if a target fails. The
+// intranet is out of reach when directly connected
+func (h *Handler) verifyIntranet(ctx context.Context, scenario model.Scenario) error {
+	if scenario == model.ScenarioDirect || scenario == model.ScenarioOffline || ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err := h.IntranetVerifier.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("%w. The network configuration was kept", err)
+	}
 	return nil
 }
 
-func (h *Handler) checkRunningOnWsl() error {
-	if h.WindowsChecker.IsRunningOnWsl2() {
-		log.Logger.Debug("Running on WSL2")
+func (h *Handler) printEnvVarsFor(ctx context.Context, useProxy bool) {
+	if useProxy {
+		h.EnvVarPrinter.PrintExportCommands(ctx)
+	} else {
+		h.EnvVarPrinter.PrintUnsetCommands()
+	}
+}
+
+// The network works, but maybe not for the current shell
+func (h *Handler) warnOnProxyVarMismatch(ctx context.Context, paths model.InternetPaths) {
+	logPathCheck(ctx, "Direct", paths.Direct)
+	logPathCheck(ctx, "Proxy", paths.Proxy)
+	useProxy, reason, decided := h.preferredPath(paths)
+	if !decided {
+		return
+	}
+
+	proxyVarSet := h.EnvVarPrinter.IsProxyVarSet()
+	if useProxy && !proxyVarSet && !h.RunningAsRoot {
+		// sudo usually drops the variables, so only warn without root
+		log.FromContext(ctx).Warn("This shell has no http(s)_proxy environment variables set, but %v. Run 'source <(isetta -env-settings)'", reason)
+	} else if !useProxy && proxyVarSet {
+		log.FromContext(ctx).Warn("This shell has http(s)_proxy environment variables set, but %v. Run 'source <(isetta -env-settings)'", reason)
+	}
+}
+
+// a pinned scenario wins over the working paths
+func (h *Handler) preferredPath(paths model.InternetPaths) (useProxy bool, reason string, decided bool) {
+	if override, pinned := h.activeOverrideOrNone(); pinned {
+		return override.Scenario == model.ScenarioViaProxy, fmt.Sprintf("the scenario is pinned to %v", override), true
+	}
+
+	useProxy, decided = paths.UseProxy(h.PreferFasterPath)
+	reason = "only the proxy works"
+	if !useProxy {
+		reason = "only the direct connection works"
+	}
+	if paths.Direct.Ok && paths.Proxy.Ok {
+		reason = fmt.Sprintf("direct access takes %v, access via proxy %v", paths.Direct.Latency, paths.Proxy.Latency)
+	}
+	return useProxy, reason, decided
+}
+
+// broken override files are reported by the detection already
+func (h *Handler) activeOverrideOrNone() (model.Override, bool) {
+	override, active, _ := h.ActiveOverride()
+	return override, active
+}
+
+func (h *Handler) isScenarioPinned() bool {
+	_, pinned := h.activeOverrideOrNone()
+	return pinned
+}
+
+func logPathCheck(ctx context.Context, path string, check model.PathCheck) {
+	if check.Ok {
+		log.FromContext(ctx).Debug("%v internet access works, took %v", path, check.Latency)
+	} else {
+		log.FromContext(ctx).Debug("%v internet access failed after %v: %v", path, check.Latency, check.Err)
+	}
+}
+
+func (h *Handler) configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	configurer, found := h.Configurers[snapshot.Scenario]
+	if !found {
+		return fmt.Errorf("scenario '%v' is not supported", snapshot.Scenario)
+	}
+
+	err := h.disableResolveAutoConfGeneration(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	progress.EmitMessage("Configuring network for scenario '%v'", snapshot.Scenario)
+	err = configurer.Configure(ctx, snapshot, tx)
+	if err != nil {
+		return err
+	}
+
+	// corrects drift which doesn't break connectivity, e.g. a stale portproxy
+	return h.Reconciler.Configure(ctx, snapshot, tx)
+}
+
+// Returns the drift between the actual and the desired network state. Changes nothing
+func (h *Handler) VerifyNetwork(ctx context.Context) ([]model.Drift, error) {
+	progress.EmitMessage("Detecting network connection")
+	scenario := h.NetworkDetector.DetectScenario(ctx)
+	if scenario == model.ScenarioOffline {
+		return nil, ErrOffline
+	}
+	progress.EmitMessage("Verifying network state for scenario '%v'", scenario)
+	return h.Reconciler.Verify(ctx, scenario)
+}
+
+// Reports the scenario, the working internet paths, the pinned scenario, the
+// conflicting .wslconfig settings and the drift from the desired state.
+// Changes nothing
+func (h *Handler) Status(ctx context.Context) (model.Status, error) {
+	var status model.Status
+	var err error
+	status.Override, status.Pinned, err = h.ActiveOverride()
+	if err != nil {
+		return status, err
+	}
+
+	// .wslconfig is a hint only, it doesn't change the status itself
+	h.WslConfig.WarnIfModePending(ctx)
+	status.WslConfigConflicts, err = h.WslConfig.Conflicts(ctx)
+	if err != nil {
+		log.FromContext(ctx).Warn("Unable to check .wslconfig: %v", err)
+	}
+
+	status.InternetPaths = h.NetworkDetector.CheckInternetAccess(ctx)
+	status.Scenario = h.NetworkDetector.DetectScenario(ctx)
+	if ctx.Err() != nil || status.Scenario == model.ScenarioOffline {
+		return status, ctx.Err()
+	}
+	status.Drift, err = h.Reconciler.Verify(ctx, status.Scenario)
+	return status, err
+}
+
+// Sets the recommended values in .wslconfig, returns the corrected settings.
+// They only apply once WSL was restarted
+func (h *Handler) ApplyWslConfig(ctx context.Context) ([]model.WslConfigConflict, error) {
+	applied, err := h.WslConfig.ApplyRecommended(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to update .wslconfig: %w", err)
+	}
+	return applied, nil
+}
+
+// Pins the scenario for the given duration, 0 means until cleared. "auto"
+// clears the override, the scenario is detected again
+func (h *Handler) UseScenario(name string, duration time.Duration) error {
+	if name == "auto" {
+		progress.EmitMessage("Scenario is detected again")
+		return h.OverrideStore.Clear()
+	}
+
+	scenario, err := model.ParseScenario(name)
+	if err != nil {
+		return err
+	}
+	if _, supported := h.Configurers[scenario]; !supported {
+		return fmt.Errorf("scenario '%v' can't be pinned", scenario)
+	}
+
+	override := model.Override{Scenario: scenario}
+	if duration > 0 {
+		override.Until = time.Now().Add(duration)
+	}
+	progress.EmitMessage("Pinning scenario %v", override)
+	return h.OverrideStore.Save(override)
+}
+
+// returns the override which is currently active, if any
+func (h *Handler) ActiveOverride() (model.Override, bool, error) {
+	override, found, err := h.OverrideStore.Load()
+	if err != nil || !found {
+		return model.Override{}, false, err
+	}
+	return override, override.ActiveAt(time.Now()), nil
+}
+
+// reports both, the original error and the result of the rollback
+func (h *Handler) rollback(ctx context.Context, tx *model.Transaction, cause error) error {
+	if tx.Len() == 0 {
+		return cause
+	}
+	if h.KeepPartialState {
+		log.FromContext(ctx).Warn("Keeping the partial configuration, %v step(s) were not rolled back", tx.Len())
+		return cause
+	}
+
+	defer timing.Start("Rolling back").End()
+	log.FromContext(ctx).Warn("Configuration failed, rolling back: %v", cause)
+	err := tx.Rollback(ctx)
+	if err != nil {
+		return fmt.Errorf("%w. Rollback failed as well, the configuration might be broken: %v", cause, err)
+	}
+	return fmt.Errorf("%w. All changes were rolled back", cause)
+}
+
+func (h *Handler) disableResolveAutoConfGeneration(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Disabling resolv.conf generation").End()
+	backup, err := h.DnsConfigurer.BackupWslConf()
+	err = registerFileRollback(tx, backup, err, h.DnsConfigurer)
+	if err != nil {
+		return err
+	}
+	return h.DnsConfigurer.DisableResolveAutoConfGeneration(ctx)
+}
+
+// A leftover internal DNS server causes confusing failures once the network is
+// back, the public one works at least for direct access. Best effort.
+func (h *Handler) resetNameservers(ctx context.Context) {
+	nameservers, err := h.DnsConfigurer.Nameservers(ctx)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to read nameservers: %v", err)
+		return
+	}
+	if len(nameservers) == 1 && nameservers[0] == h.PublicDnsServer {
+		return
+	}
+	progress.EmitMessage("Resetting nameserver to %v while offline", h.PublicDnsServer)
+	err = h.DnsConfigurer.ReplaceDnsServers(ctx, h.PublicDnsServer)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to reset nameservers: %v", err)
+	}
+}
+
+func (h *Handler) checkRunningOnWsl(ctx context.Context, snapshot model.Snapshot) error {
+	if snapshot.RunningOnWsl2 {
+		log.FromContext(ctx).Debug("Running on WSL2")
 		return nil
 	} else {
 		return errors.New("isetta requires WSL2 but this Linux environment is running in something else. Run 'wsl.exe --list --verbose' for details")
@@ -1,132 +1,481 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
 	"org.samba/isetta/mocks"
+	"org.samba/isetta/progress"
 )
 
 var mockDirectAccess *mocks.NetworkConfigurer
 var mockViaProxy *mocks.NetworkConfigurer
+var mockNetworkDetector *mocks.NetworkDetector
+var mockReconciler *mocks.NetworkReconciler
+var mockOverrideStore *mocks.OverrideStore
+var mockWslConfig *mocks.WslConfig
 
 var handler Handler
 
 func setupHandler(t *testing.T) {
-	mockWinChecker = mocks.NewWindowsChecker(t)
 	mockDnsConfigurer = mocks.NewDnsConfigurer(t)
 	mockEnvVarPrinter = mocks.NewEnvVarPrinter(t)
 	mockDirectAccess = mocks.NewNetworkConfigurer(t)
 	mockViaProxy = mocks.NewNetworkConfigurer(t)
-	mockHttpChecker = mocks.NewHttpChecker(t)
+	mockNetworkDetector = mocks.NewNetworkDetector(t)
+	mockReconciler = mocks.NewNetworkReconciler(t)
+	mockOverrideStore = mocks.NewOverrideStore(t)
+	mockWslConfig = mocks.NewWslConfig(t)
 
 	handler = Handler{
-		RunningAsRoot:     true,
-		WindowsChecker:    mockWinChecker,
-		DnsConfigurer:     mockDnsConfigurer,
-		EnvVarPrinter:     mockEnvVarPrinter,
-		InternalDnsServer: "42.42.42.42",
-		PublicDnsServer:   "8.8.8.8",
-		DirectAccess:      mockDirectAccess,
-		ViaProxy:          mockViaProxy,
-		InternetChecker:   InternetChecker{
-			HttpChecker: mockHttpChecker,
-			TimeoutInMilliseconds: 100,
+		RunningAsRoot:   true,
+		PublicDnsServer: "8.8.8.8",
+		DnsConfigurer:   mockDnsConfigurer,
+		EnvVarPrinter:   mockEnvVarPrinter,
+		Configurers: map[model.Scenario]NetworkConfigurer{
+			model.ScenarioViaProxy: mockViaProxy,
+			model.ScenarioDirect:   mockDirectAccess,
 		},
+		NetworkDetector: mockNetworkDetector,
+		Reconciler:      mockReconciler,
+		OverrideStore:   mockOverrideStore,
+		WslConfig:       mockWslConfig,
 	}
+	mockOverrideStore.On("Load").Return(model.Override{}, false, nil).Maybe()
+	mockWslConfig.On("WarnIfModePending", mock.Anything).Return().Maybe()
 }
 
-func setupNoInternetConnection() {
-	mockHttpChecker.On("HasDirectInternetAccess", 100).Return(false)
-	mockHttpChecker.On("HasInternetAccessViaProxy", 100).Return(false)
+func TestShortCircuitIfHttpConnectionAlreadyPossible(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{InternetAccess: true})
+
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
 }
 
+func TestStepMessagesAreProgressEvents(t *testing.T) {
+	setupHandler(t)
+	original := progress.Default
+	progress.Default = &progress.Dispatcher{}
+	t.Cleanup(func() { progress.Default = original })
+	messages := []string{}
+	progress.Subscribe(progress.ObserverFunc(func(e progress.Event) {
+		if e.Kind == progress.Message {
+			messages = append(messages, e.Text)
+		}
+	}))
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{InternetAccess: true})
 
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
+	assert.Equal(t, []string{"Detecting network connection", "Internet is already accessible. No further setup needed"}, messages)
+}
 
-func TestShortCircuitIfHttpConnectionAlreadyPossible(t *testing.T) {
+func TestNoConfigurationWhenCancelledDuringDetection(t *testing.T) {
 	setupHandler(t)
-	mockHttpChecker.On("HasDirectInternetAccess", 100).Return(true)
-	mockHttpChecker.On("HasInternetAccessViaProxy", 100).Return(false)
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{})
 
-	assert.NoError(t, handler.ConfigureNetwork())
+	assert.ErrorIs(t, handler.ConfigureNetwork(cancelledCtx), context.Canceled)
 }
 
-
 func TestNetworkConfigRequiresRoot(t *testing.T) {
 	setupHandler(t)
-	setupNoInternetConnection()
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioViaProxy})
 
 	handler.RunningAsRoot = false
-	assert.Error(t, handler.ConfigureNetwork())
+	assert.Error(t, handler.ConfigureNetwork(ctx))
 }
 
 func TestErrorWhenNotOnWsl(t *testing.T) {
 	setupHandler(t)
-	setupNoInternetConnection()
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{RunningOnWsl2: false, Scenario: model.ScenarioViaProxy})
 
-	mockWinChecker.On("IsRunningOnWsl2").Return(false)
-	assert.Error(t, handler.ConfigureNetwork())
+	assert.Error(t, handler.ConfigureNetwork(ctx))
 }
 
 func TestErrorWhenNoDnsServerIsReached(t *testing.T) {
 	setupHandler(t)
-	setupNoInternetConnection()
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioOffline})
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"8.8.8.8"}, nil)
+
+	assert.ErrorIs(t, handler.ConfigureNetwork(ctx), ErrOffline)
+}
+
+func TestNameserverIsResetWhenOffline(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioOffline})
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("ReplaceDnsServers", mock.Anything, "8.8.8.8").Return(nil)
+
+	assert.ErrorIs(t, handler.ConfigureNetwork(ctx), ErrOffline)
+}
+
+func TestNoNetworkChangesBehindCaptivePortal(t *testing.T) {
+	setupHandler(t)
+	snapshot := model.Snapshot{Scenario: model.ScenarioCaptivePortal, CaptivePortalLoginUrl: "http://login.hotel-wifi.example/"}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorIs(t, err, ErrCaptivePortal)
+	assert.ErrorContains(t, err, "http://login.hotel-wifi.example/")
+}
+
+func TestChangesAreRolledBackOnFailure(t *testing.T) {
+	setupHandler(t)
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioDirect}
+	wslConf := model.FileBackup{Path: "/etc/wsl.conf", Existed: false}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(wslConf, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockDirectAccess.On("Configure", mock.Anything, snapshot, mock.Anything).Return(errors.New("no route"))
+	mockDnsConfigurer.On("RestoreFile", mock.Anything, wslConf).Return(nil)
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorContains(t, err, "no route")
+	assert.ErrorContains(t, err, "rolled back")
+}
+
+func setupRequiredIntranetTarget(t *testing.T) model.IntranetTarget {
+	mockIntranetChecker := mocks.NewIntranetChecker(t)
+	target := model.IntranetTarget{Target: "git.corp:22", Required: true}
+	handler.IntranetVerifier = IntranetVerifier{Targets: []model.IntranetTarget{target}, Checker: mockIntranetChecker}
+	mockIntranetChecker.On("CheckIntranetTarget", mock.Anything, target).
+		Return(model.IntranetCheckResult{Target: target, Fault: model.FaultDns, Err: errors.New("no such host")})
+	return target
+}
+
+func TestFailedRequiredIntranetTargetKeepsTheConfiguration(t *testing.T) {
+	setupHandler(t)
+	setupRequiredIntranetTarget(t)
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioViaProxy}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockViaProxy.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+	mockReconciler.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
 
-	mockWinChecker.On("IsRunningOnWsl2").Return(true)
-	mockDnsConfigurer.On("DisableResolveAutoConfGeneration").Return()
-	mockWinChecker.On("IsPingable", "42.42.42.42").Return(false)
-	mockWinChecker.On("IsPingable", "8.8.8.8").Return(false)
-	assert.Error(t, handler.ConfigureNetwork())
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorIs(t, err, ErrIntranetUnreachable)
+	assert.ErrorContains(t, err, "git.corp:22")
+	assert.ErrorContains(t, err, "configuration was kept")
+	mockDnsConfigurer.AssertNotCalled(t, "RestoreFile", mock.Anything, mock.Anything)
+}
+
+func TestIntranetIsVerifiedIfInternetIsAlreadyAccessible(t *testing.T) {
+	setupHandler(t)
+	setupRequiredIntranetTarget(t)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{InternetAccess: true, Scenario: model.ScenarioViaProxy})
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioViaProxy).Return([]model.Drift{}, nil)
+	mockEnvVarPrinter.On("IsProxyVarSet").Return(true).Maybe()
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorIs(t, err, ErrIntranetUnreachable)
+}
+
+// direct access works with a split tunnel, the internal DNS server and routes are still needed
+func TestSplitTunnelIsConfiguredAlthoughDirectAccessWorks(t *testing.T) {
+	setupHandler(t)
+	mockSplitTunnel := mocks.NewNetworkConfigurer(t)
+	handler.Configurers[model.ScenarioSplitTunnel] = mockSplitTunnel
+	snapshot := model.Snapshot{
+		InternetAccess: true,
+		InternetPaths:  model.InternetPaths{Direct: model.PathCheck{Ok: true}},
+		RunningOnWsl2:  true,
+		Scenario:       model.ScenarioSplitTunnel,
+	}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioSplitTunnel).
+		Return([]model.Drift{{Resource: "nameservers in resolv.conf", Actual: "172.23.16.1", Desired: "42.42.42.42"}}, nil)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockSplitTunnel.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+	mockReconciler.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
+}
+
+func TestConfiguredScenarioIsKeptWithInternetAccess(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{InternetAccess: true, Scenario: model.ScenarioSplitTunnel})
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioSplitTunnel).Return([]model.Drift{}, nil)
+
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
+}
+
+func TestDeferredWindowsSideKeepsTheLinuxChanges(t *testing.T) {
+	setupHandler(t)
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioViaProxy}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockViaProxy.On("Configure", mock.Anything, snapshot, mock.Anything).
+		Run(func(args mock.Arguments) { args.Get(2).(*model.Transaction).Defer("adding the Windows P2P address") }).
+		Return(nil)
+	mockReconciler.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorIs(t, err, ErrElevationDeferred)
+	assert.ErrorContains(t, err, "adding the Windows P2P address")
+	mockDnsConfigurer.AssertNotCalled(t, "RestoreFile", mock.Anything, mock.Anything)
+}
+
+// the drift of the deferred Windows side doesn't fail the post condition
+func TestDeferredWindowsSideIsNoRemainingDrift(t *testing.T) {
+	setupHandler(t)
+	setupReconciler(t)
+	handler.DnsConfigurer = mockDnsConfigurer
+	handler.Reconciler = &reconciler
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioViaProxy}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockViaProxy.On("Configure", mock.Anything, snapshot, mock.Anything).
+		Run(func(args mock.Arguments) { args.Get(2).(*model.Transaction).Defer("adding the Windows P2P address") }).
+		Return(nil)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockLinuxConfigurer.On("P2pAddress", mock.Anything).Return(model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, nil)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("192.168.99.1", nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(false)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil)
+	mockWinConfigurer.On("Init", mock.Anything).Return(ErrElevationDeferred)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorIs(t, err, ErrElevationDeferred)
+	assert.NotContains(t, err.Error(), "drift remains")
+	mockDnsConfigurer.AssertNotCalled(t, "RestoreFile", mock.Anything, mock.Anything)
+	mockWinConfigurer.AssertNotCalled(t, "AddP2pAddress", mock.Anything, mock.Anything)
+}
+
+func TestRollbackFailureIsReported(t *testing.T) {
+	setupHandler(t)
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioDirect}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockDirectAccess.On("Configure", mock.Anything, snapshot, mock.Anything).Return(errors.New("no route"))
+	mockDnsConfigurer.On("RestoreFile", mock.Anything, mock.Anything).Return(errors.New("read-only file system"))
+
+	err := handler.ConfigureNetwork(ctx)
+	assert.ErrorContains(t, err, "no route")
+	assert.ErrorContains(t, err, "read-only file system")
+}
+
+func TestPartialStateIsKeptOnRequest(t *testing.T) {
+	setupHandler(t)
+	handler.KeepPartialState = true
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioDirect}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockDirectAccess.On("Configure", mock.Anything, snapshot, mock.Anything).Return(errors.New("no route"))
+
+	assert.EqualError(t, handler.ConfigureNetwork(ctx), "no route")
+	mockDnsConfigurer.AssertNotCalled(t, "RestoreFile", mock.Anything, mock.Anything)
 }
 
 func TestPerformsDirectConfigWhenPublicDnsIsReachable(t *testing.T) {
 	setupHandler(t)
-	setupNoInternetConnection()
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioDirect}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockDirectAccess.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+	mockReconciler.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
 
-	mockWinChecker.On("IsRunningOnWsl2").Return(true)
-	mockDnsConfigurer.On("DisableResolveAutoConfGeneration").Return()
-	mockWinChecker.On("IsPingable", "42.42.42.42").Return(false)
-	mockWinChecker.On("IsPingable", "8.8.8.8").Return(true)
-	mockDirectAccess.On("Configure").Return(nil)
-	assert.NoError(t, handler.ConfigureNetwork())
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
 }
 
 func TestPerformsConfigViaProxyWhenPublicDnsIsReachable(t *testing.T) {
 	setupHandler(t)
-	setupNoInternetConnection()
+	snapshot := model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioViaProxy}
+	mockNetworkDetector.On("Detect", mock.Anything).Return(snapshot)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockViaProxy.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
+	mockReconciler.On("Configure", mock.Anything, snapshot, mock.Anything).Return(nil)
 
-	mockWinChecker.On("IsRunningOnWsl2").Return(true)
-	mockDnsConfigurer.On("DisableResolveAutoConfGeneration").Return()
-	mockWinChecker.On("IsPingable", "42.42.42.42").Return(true)
-	mockViaProxy.On("Configure").Return(nil)
-	assert.NoError(t, handler.ConfigureNetwork())
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
 }
 
 func TestWhenInternalDnsIsReachableExportStatementsArePrinted(t *testing.T) {
 	setupHandler(t)
 
-	mockWinChecker.On("IsPingable", "42.42.42.42").Return(true)
-	mockEnvVarPrinter.On("PrintExportCommands")
-	handler.PrintEnvVars()
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockEnvVarPrinter.On("PrintExportCommands", mock.Anything)
+	handler.PrintEnvVars(ctx)
 }
 
 func TestWhenPublicDnsIsReachableUnsetStatementsArePrinted(t *testing.T) {
 	setupHandler(t)
-	
-	mockWinChecker.
-		// internal DNS not reachable
-		On("IsPingable", "42.42.42.42").Return(false).
-		// public DNS reachable
-		On("IsPingable", "8.8.8.8").Return(true)
 
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioDirect)
+	mockEnvVarPrinter.On("PrintUnsetCommands")
+	handler.PrintEnvVars(ctx)
+}
+
+func TestFasterPathDecidesWhichEnvVarsArePrinted(t *testing.T) {
+	setupHandler(t)
+	handler.PreferFasterPath = true
+	mockNetworkDetector.On("CheckInternetAccess", mock.Anything).Return(model.InternetPaths{
+		Direct: model.PathCheck{Ok: true, Latency: 50 * time.Millisecond},
+		Proxy:  model.PathCheck{Ok: true, Latency: 400 * time.Millisecond},
+	})
 	mockEnvVarPrinter.On("PrintUnsetCommands")
-	handler.PrintEnvVars()
+	handler.PrintEnvVars(ctx)
+	mockNetworkDetector.AssertNotCalled(t, "DetectScenario", mock.Anything)
+}
+
+func TestWarningWhenShellUsesProxyButOnlyDirectAccessWorks(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{
+		InternetAccess: true,
+		InternetPaths:  model.InternetPaths{Direct: model.PathCheck{Ok: true}, Proxy: model.PathCheck{Err: errors.New("timeout")}},
+	})
+	mockEnvVarPrinter.On("IsProxyVarSet").Return(true)
+
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
 }
 
 func TestEnvVarsArePrintedIfNonRoot(t *testing.T) {
 	setupHandler(t)
 	handler.RunningAsRoot = false
-	mockWinChecker.On("IsPingable", "42.42.42.42").Return(true)
-	mockEnvVarPrinter.On("PrintExportCommands")
-	handler.PrintEnvVars()
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockEnvVarPrinter.On("PrintExportCommands", mock.Anything)
+	handler.PrintEnvVars(ctx)
+}
+
+func TestEnvVarsAreExportedForProxyScenario(t *testing.T) {
+	setupHandler(t)
+	envVars := []model.EnvVar{{Name: "HTTPS_PROXY", Value: "http://192.168.99.1:3128"}}
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockEnvVarPrinter.On("ExportVars", mock.Anything).Return(envVars)
+
+	assert.Equal(t, model.EnvVarChanges{Export: envVars}, handler.EnvVars(ctx))
+}
+
+func TestEnvVarsAreLeftAsTheyAreWhenOffline(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioOffline)
+
+	assert.Equal(t, model.EnvVarChanges{}, handler.EnvVars(ctx))
+}
+
+func TestStatusContainsScenarioPathsAndDrift(t *testing.T) {
+	setupHandler(t)
+	paths := model.InternetPaths{Proxy: model.PathCheck{Ok: true}}
+	drift := []model.Drift{{Resource: "default gateway", Actual: "172.28.64.1", Desired: "192.168.99.1"}}
+	mockNetworkDetector.On("CheckInternetAccess", mock.Anything).Return(paths)
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioViaProxy).Return(drift, nil)
+	conflicts := []model.WslConfigConflict{{Key: "autoProxy", Actual: "true (default)", Recommended: "false"}}
+	mockWslConfig.On("Conflicts", mock.Anything).Return(conflicts, nil)
+
+	status, err := handler.Status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Status{Scenario: model.ScenarioViaProxy, InternetPaths: paths, Drift: drift, WslConfigConflicts: conflicts}, status)
+	mockWslConfig.AssertCalled(t, "WarnIfModePending", ctx)
+}
+
+// e.g. when cmd.exe is not available
+func TestStatusWithUnreadableWslConfig(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("CheckInternetAccess", mock.Anything).Return(model.InternetPaths{})
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioOffline)
+	mockWslConfig.On("Conflicts", mock.Anything).Return(nil, errors.New("cmd.exe not found"))
+
+	status, err := handler.Status(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, status.WslConfigConflicts)
+}
+
+func TestVerifyReturnsDrift(t *testing.T) {
+	setupHandler(t)
+	drift := []model.Drift{{Resource: "default gateway", Actual: "172.28.64.1", Desired: "192.168.99.1"}}
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioViaProxy).Return(drift, nil)
+
+	actual, err := handler.VerifyNetwork(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, drift, actual)
+}
+
+func TestVerifyFailsWhenOffline(t *testing.T) {
+	setupHandler(t)
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioOffline)
+
+	_, err := handler.VerifyNetwork(ctx)
+	assert.ErrorIs(t, err, ErrOffline)
+}
+
+func TestErrorWhenScenarioIsNotSupported(t *testing.T) {
+	setupHandler(t)
+	delete(handler.Configurers, model.ScenarioDirect)
+	mockNetworkDetector.On("Detect", mock.Anything).Return(model.Snapshot{RunningOnWsl2: true, Scenario: model.ScenarioDirect})
+
+	assert.ErrorContains(t, handler.ConfigureNetwork(ctx), "not supported")
+}
+
+func TestUseScenarioPinsItForTheGivenDuration(t *testing.T) {
+	setupHandler(t)
+	mockOverrideStore.On("Save", mock.MatchedBy(func(o model.Override) bool {
+		return o.Scenario == model.ScenarioViaProxy && time.Until(o.Until) > 119*time.Minute
+	})).Return(nil)
+
+	assert.NoError(t, handler.UseScenario("proxy", 2*time.Hour))
+}
+
+func TestUseAutoClearsTheOverride(t *testing.T) {
+	setupHandler(t)
+	mockOverrideStore.On("Clear").Return(nil)
+
+	assert.NoError(t, handler.UseScenario("auto", 0))
+}
+
+func TestOfflineCanNotBePinned(t *testing.T) {
+	setupHandler(t)
+	assert.Error(t, handler.UseScenario("offline", 0))
+	assert.Error(t, handler.UseScenario("moon", 0))
+}
+
+func TestPinnedScenarioWinsOverFasterPath(t *testing.T) {
+	setupHandler(t)
+	handler.PreferFasterPath = true
+	mockOverrideStore.ExpectedCalls = nil
+	mockOverrideStore.On("Load").Return(model.Override{Scenario: model.ScenarioViaProxy}, true, nil)
+	mockNetworkDetector.On("DetectScenario", mock.Anything).Return(model.ScenarioViaProxy)
+	mockEnvVarPrinter.On("PrintExportCommands", mock.Anything)
+
+	handler.PrintEnvVars(ctx)
+	mockNetworkDetector.AssertNotCalled(t, "CheckInternetAccess", mock.Anything)
+}
+
+func TestPinnedScenarioIsConfiguredAlthoughDirectAccessWorks(t *testing.T) {
+	setupHandler(t)
+	mockOverrideStore.ExpectedCalls = nil
+	mockOverrideStore.On("Load").Return(model.Override{Scenario: model.ScenarioViaProxy}, true, nil)
+	detected := model.Snapshot{
+		InternetAccess: true,
+		InternetPaths:  model.InternetPaths{Direct: model.PathCheck{Ok: true}},
+		RunningOnWsl2:  true,
+		Scenario:       model.ScenarioDirect,
+	}
+	pinned := detected
+	pinned.Scenario = model.ScenarioViaProxy
+	mockNetworkDetector.On("Detect", mock.Anything).Return(detected)
+	mockReconciler.On("Verify", mock.Anything, model.ScenarioViaProxy).
+		Return([]model.Drift{{Resource: "default gateway", Actual: "172.23.16.1", Desired: "192.168.99.1"}}, nil)
+	mockDnsConfigurer.On("BackupWslConf").Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("DisableResolveAutoConfGeneration", mock.Anything).Return(nil)
+	mockViaProxy.On("Configure", mock.Anything, pinned, mock.Anything).Return(nil)
+	mockReconciler.On("Configure", mock.Anything, pinned, mock.Anything).Return(nil)
+
+	assert.NoError(t, handler.ConfigureNetwork(ctx))
+	mockDirectAccess.AssertNotCalled(t, "Configure", mock.Anything, mock.Anything, mock.Anything)
 }
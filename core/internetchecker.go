@@ -1,6 +1,11 @@
 package core
 
-import "sync"
+import (
+	"context"
+	"time"
+
+	"org.samba/isetta/core/model"
+)
 
 type InternetChecker struct {
 	HttpChecker           HttpChecker
@@ -14,25 +19,28 @@ func NewInternetChecker(HttpChecker HttpChecker) InternetChecker {
 	}
 }
 
-func (c *InternetChecker) HasInternetAccess() bool {
-	var wg sync.WaitGroup
-
-	ch := make(chan bool, 2)
-	wg.Add(2)
+// checks both paths concurrently
+func (c *InternetChecker) CheckInternetAccess(ctx context.Context) model.InternetPaths {
+	direct := make(chan model.PathCheck, 1)
+	proxy := make(chan model.PathCheck, 1)
 
 	go func() {
-		defer wg.Done()
-		ch <- c.HttpChecker.HasDirectInternetAccess(c.TimeoutInMilliseconds)
+		direct <- checkPath(func() error { return c.HttpChecker.CheckDirectInternetAccess(ctx, c.TimeoutInMilliseconds) })
 	}()
 
 	go func() {
-		defer wg.Done()
-		ch <- c.HttpChecker.HasInternetAccessViaProxy(c.TimeoutInMilliseconds)
+		proxy <- checkPath(func() error { return c.HttpChecker.CheckInternetAccessViaProxy(ctx, c.TimeoutInMilliseconds) })
 	}()
 
-	wg.Wait()
-	close(ch)
+	return model.InternetPaths{Direct: <-direct, Proxy: <-proxy}
+}
+
+func (c *InternetChecker) HasInternetAccess(ctx context.Context) bool {
+	return c.CheckInternetAccess(ctx).Any()
+}
 
-	result1, result2 := <-ch, <-ch
-	return result1 || result2
+func checkPath(check func() error) model.PathCheck {
+	start := time.Now()
+	err := check()
+	return model.PathCheck{Ok: err == nil, Latency: time.Since(start), Err: err}
 }
@@ -1,9 +1,11 @@
 package core
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"org.samba/isetta/mocks"
 )
 
@@ -47,9 +49,28 @@ func TestInternetChecker(t *testing.T) {
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
 			setupInternetChecker(t)
-			mockHttpChecker.On("HasDirectInternetAccess", 100).Return(tC.hasDirectInternetAccess)
-			mockHttpChecker.On("HasInternetAccessViaProxy", 100).Return(tC.hasInternetAccessViaProxy)
-			assert.Equal(t, tC.hasInternetAccess, internetChecker.HasInternetAccess())
+			mockHttpChecker.On("CheckDirectInternetAccess", mock.Anything, 100).Return(errorUnless(tC.hasDirectInternetAccess))
+			mockHttpChecker.On("CheckInternetAccessViaProxy", mock.Anything, 100).Return(errorUnless(tC.hasInternetAccessViaProxy))
+			assert.Equal(t, tC.hasInternetAccess, internetChecker.HasInternetAccess(ctx))
 		})
 	}
 }
+
+func TestInternetCheckerReportsBothPaths(t *testing.T) {
+	setupInternetChecker(t)
+	mockHttpChecker.On("CheckDirectInternetAccess", mock.Anything, 100).Return(errors.New("connection refused"))
+	mockHttpChecker.On("CheckInternetAccessViaProxy", mock.Anything, 100).Return(nil)
+
+	paths := internetChecker.CheckInternetAccess(ctx)
+	assert.False(t, paths.Direct.Ok)
+	assert.ErrorContains(t, paths.Direct.Err, "connection refused")
+	assert.True(t, paths.Proxy.Ok)
+	assert.NoError(t, paths.Proxy.Err)
+}
+
+func errorUnless(ok bool) error {
+	if ok {
+		return nil
+	}
+	return errors.New("no access")
+}
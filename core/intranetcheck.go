@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
+)
+
+// Checks the intranet targets concurrently after the configuration. Only
+// failures of required targets are reported as error, the configuration is
+// kept anyway.
+type IntranetVerifier struct {
+	Targets []model.IntranetTarget
+	Checker IntranetChecker
+}
+
+func (v *IntranetVerifier) Verify(ctx context.Context) error {
+	if len(v.Targets) == 0 {
+		return nil
+	}
+	defer timing.StartParallel("Checking intranet targets").End()
+
+	results := make([]model.IntranetCheckResult, len(v.Targets))
+	var wg sync.WaitGroup
+	for i, target := range v.Targets {
+		wg.Add(1)
+		go func(i int, target model.IntranetTarget) {
+			defer wg.Done()
+			results[i] = v.Checker.CheckIntranetTarget(ctx, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for _, result := range results {
+		switch {
+		case result.Ok():
+			progress.EmitMessage("%v", result)
+		case result.Target.Required:
+			failed = append(failed, result.String())
+		default:
+			log.FromContext(ctx).Warn("%v", result)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %v", ErrIntranetUnreachable, strings.Join(failed, "; "))
+	}
+	return nil
+}
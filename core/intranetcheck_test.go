@@ -0,0 +1,38 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+var gitServer = model.IntranetTarget{Target: "git.corp:22"}
+var artifactRepo = model.IntranetTarget{Target: "https://artifacts.corp/", ViaProxy: true, Required: true}
+
+func setupIntranetVerifier(t *testing.T, gitFault model.IntranetFault, artifactRepoFault model.IntranetFault) IntranetVerifier {
+	checker := mocks.NewIntranetChecker(t)
+	checker.On("CheckIntranetTarget", mock.Anything, gitServer).Return(model.IntranetCheckResult{Target: gitServer, Fault: gitFault, Err: errors.New("failed")})
+	checker.On("CheckIntranetTarget", mock.Anything, artifactRepo).Return(model.IntranetCheckResult{Target: artifactRepo, Fault: artifactRepoFault, Err: errors.New("failed")})
+	return IntranetVerifier{Targets: []model.IntranetTarget{gitServer, artifactRepo}, Checker: checker}
+}
+
+func TestOptionalIntranetTargetMayFail(t *testing.T) {
+	verifier := setupIntranetVerifier(t, model.FaultDns, model.FaultNone)
+	assert.NoError(t, verifier.Verify(ctx))
+}
+
+func TestRequiredIntranetTargetFailsWithHint(t *testing.T) {
+	verifier := setupIntranetVerifier(t, model.FaultNone, model.FaultProxy)
+	err := verifier.Verify(ctx)
+	assert.ErrorContains(t, err, "https://artifacts.corp/ via proxy")
+	assert.ErrorContains(t, err, "check Px proxy")
+}
+
+func TestNothingToVerifyWithoutTargets(t *testing.T) {
+	verifier := IntranetVerifier{}
+	assert.NoError(t, verifier.Verify(ctx))
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"org.samba/isetta/progress"
+	log "org.samba/isetta/simplelogger"
+)
+
+type JavaTruststore struct {
+	RunningAsRoot            bool
+	Pkcs12Truststore         string // optional, path of standalone truststore
+	CaCertificateExporter    CaCertificateExporter
+	JavaTruststoreConfigurer JavaTruststoreConfigurer
+}
+
+func (j *JavaTruststore) Configure(ctx context.Context) error {
+	if !j.RunningAsRoot {
+		return errors.New("to import certificates into the Java truststores 'isetta' needs to run as root. Try running via sudo")
+	}
+
+	progress.EmitMessage("Exporting corporate CA certificates from Windows")
+	caBundle, err := j.CaCertificateExporter.ExportCaCertificates(ctx)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Debug("Exported corporate CA certificates to %v", caBundle)
+
+	jdks := j.JavaTruststoreConfigurer.FindJdks(ctx)
+	if len(jdks) == 0 {
+		log.FromContext(ctx).Warn("No JDK found. Nothing to import")
+		return nil
+	}
+
+	for _, jdk := range jdks {
+		progress.EmitMessage("Importing corporate CA certificates into JDK %v", jdk)
+		err = j.JavaTruststoreConfigurer.ImportCaCertificates(ctx, jdk, caBundle)
+		if err != nil {
+			return fmt.Errorf("failed importing certificates into JDK %v: %w", jdk, err)
+		}
+	}
+
+	return j.createPkcs12TruststoreIfNeeded(ctx, jdks[0], caBundle)
+}
+
+func (j *JavaTruststore) createPkcs12TruststoreIfNeeded(ctx context.Context, jdk string, caBundle string) error {
+	if j.Pkcs12Truststore == "" {
+		log.FromContext(ctx).Trace("No standalone PKCS12 truststore configured")
+		return nil
+	}
+
+	progress.EmitMessage("Creating PKCS12 truststore %v", j.Pkcs12Truststore)
+	return j.JavaTruststoreConfigurer.CreatePkcs12Truststore(ctx, jdk, caBundle)
+}
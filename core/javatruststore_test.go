@@ -0,0 +1,79 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/mocks"
+)
+
+var mockCaCertificateExporter *mocks.CaCertificateExporter
+var mockJavaTruststoreConfigurer *mocks.JavaTruststoreConfigurer
+
+var javaTruststore JavaTruststore
+
+func setupJavaTruststore(t *testing.T) {
+	mockCaCertificateExporter = mocks.NewCaCertificateExporter(t)
+	mockJavaTruststoreConfigurer = mocks.NewJavaTruststoreConfigurer(t)
+
+	javaTruststore = JavaTruststore{
+		RunningAsRoot:            true,
+		CaCertificateExporter:    mockCaCertificateExporter,
+		JavaTruststoreConfigurer: mockJavaTruststoreConfigurer,
+	}
+}
+
+func TestJavaTruststoreRequiresRoot(t *testing.T) {
+	setupJavaTruststore(t)
+	javaTruststore.RunningAsRoot = false
+
+	assert.Error(t, javaTruststore.Configure(ctx))
+}
+
+func TestCertificatesAreImportedIntoAllJdks(t *testing.T) {
+	setupJavaTruststore(t)
+	mockCaCertificateExporter.On("ExportCaCertificates", mock.Anything).Return("/tmp/ca.pem", nil)
+	mockJavaTruststoreConfigurer.On("FindJdks", mock.Anything).Return([]string{"/usr/lib/jvm/jdk-17", "/usr/lib/jvm/jdk-21"})
+	mockJavaTruststoreConfigurer.On("ImportCaCertificates", mock.Anything, "/usr/lib/jvm/jdk-17", "/tmp/ca.pem").Return(nil)
+	mockJavaTruststoreConfigurer.On("ImportCaCertificates", mock.Anything, "/usr/lib/jvm/jdk-21", "/tmp/ca.pem").Return(nil)
+
+	assert.NoError(t, javaTruststore.Configure(ctx))
+	mockJavaTruststoreConfigurer.AssertNotCalled(t, "CreatePkcs12Truststore")
+}
+
+func TestPkcs12TruststoreIsCreatedWhenConfigured(t *testing.T) {
+	setupJavaTruststore(t)
+	javaTruststore.Pkcs12Truststore = "/tmp/truststore.p12"
+	mockCaCertificateExporter.On("ExportCaCertificates", mock.Anything).Return("/tmp/ca.pem", nil)
+	mockJavaTruststoreConfigurer.On("FindJdks", mock.Anything).Return([]string{"/usr/lib/jvm/jdk-17"})
+	mockJavaTruststoreConfigurer.On("ImportCaCertificates", mock.Anything, "/usr/lib/jvm/jdk-17", "/tmp/ca.pem").Return(nil)
+	mockJavaTruststoreConfigurer.On("CreatePkcs12Truststore", mock.Anything, "/usr/lib/jvm/jdk-17", "/tmp/ca.pem").Return(nil)
+
+	assert.NoError(t, javaTruststore.Configure(ctx))
+}
+
+func TestNoJdkFoundIsNotAnError(t *testing.T) {
+	setupJavaTruststore(t)
+	mockCaCertificateExporter.On("ExportCaCertificates", mock.Anything).Return("/tmp/ca.pem", nil)
+	mockJavaTruststoreConfigurer.On("FindJdks", mock.Anything).Return([]string{})
+
+	assert.NoError(t, javaTruststore.Configure(ctx))
+}
+
+func TestErrorWhenCertificateExportFailed(t *testing.T) {
+	setupJavaTruststore(t)
+	mockCaCertificateExporter.On("ExportCaCertificates", mock.Anything).Return("", errors.New("no certificate found"))
+
+	assert.Error(t, javaTruststore.Configure(ctx))
+}
+
+func TestErrorWhenImportFailed(t *testing.T) {
+	setupJavaTruststore(t)
+	mockCaCertificateExporter.On("ExportCaCertificates", mock.Anything).Return("/tmp/ca.pem", nil)
+	mockJavaTruststoreConfigurer.On("FindJdks", mock.Anything).Return([]string{"/usr/lib/jvm/jdk-17"})
+	mockJavaTruststoreConfigurer.On("ImportCaCertificates", mock.Anything, "/usr/lib/jvm/jdk-17", "/tmp/ca.pem").Return(errors.New("keytool failed"))
+
+	assert.Error(t, javaTruststore.Configure(ctx))
+}
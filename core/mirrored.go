@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
+	"org.samba/isetta/timing"
+)
+
+// WSL in mirrored networking mode shares the interfaces of Windows. There are
+// no P2P addresses, routes or portproxies to set up, Px is reachable via
+// 127.0.0.1. Only the nameserver and the proxy variables depend on the scenario.
+type Mirrored struct {
+	InternalDnsServer string
+	PublicDnsServer   string
+	PxProxyPort       int
+	DnsConfigurer     DnsConfigurer
+	HttpChecker       HttpChecker
+	EnvVarPrinter     EnvVarPrinter
+}
+
+func (m *Mirrored) Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Configuring mirrored networking").End()
+
+	if snapshot.Scenario == model.ScenarioViaProxy && !snapshot.PxProxyRunning {
+		return fmt.Errorf("Error: PX proxy is not running on Windows port %v", m.PxProxyPort)
+	}
+
+	err := m.activateDnsServer(ctx, snapshot.Scenario, tx)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Scenario == model.ScenarioViaProxy {
+		return m.checkAccessViaProxy(ctx)
+	}
+	m.EnvVarPrinter.WarnIfProxyVarSet(ctx)
+	return m.checkDirectAccess(ctx)
+}
+
+func (m *Mirrored) activateDnsServer(ctx context.Context, scenario model.Scenario, tx *model.Transaction) error {
+	dnsServer := m.InternalDnsServer
+	if scenario == model.ScenarioDirect {
+		dnsServer = m.PublicDnsServer
+	}
+
+	defer timing.Start("Activating DNS server").End()
+	err := registerResolvConfRollback(ctx, tx, m.DnsConfigurer)
+	if err != nil {
+		return err
+	}
+	return m.DnsConfigurer.ActivateDnsServer(ctx, dnsServer)
+}
+
+func (m *Mirrored) checkAccessViaProxy(ctx context.Context) error {
+	defer timing.Start("Checking access via proxy").End()
+	if m.HttpChecker.HasInternetAccessViaProxy(ctx) {
+		progress.EmitMessage("Done setting up Linux network via proxy")
+		return nil
+	}
+	return errors.New("failed setting up Linux network via proxy, Px is not reachable via 127.0.0.1")
+}
+
+func (m *Mirrored) checkDirectAccess(ctx context.Context) error {
+	defer timing.Start("Checking direct access").End()
+	if m.HttpChecker.HasDirectInternetAccess(ctx) {
+		progress.EmitMessage("Done setting up WSL network for direct internet access")
+		return nil
+	}
+	return errors.New("failed setting up WSL network for direct internet access")
+}
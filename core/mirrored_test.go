@@ -0,0 +1,67 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+var mirrored Mirrored
+
+func setupMirrored(t *testing.T) {
+	mockDnsConfigurer = mocks.NewDnsConfigurer(t)
+	mockHttpChecker = mocks.NewHttpChecker(t)
+	mockEnvVarPrinter = mocks.NewEnvVarPrinter(t)
+
+	mirrored = Mirrored{
+		InternalDnsServer: "10.0.0.1",
+		PublicDnsServer:   "8.8.8.8",
+		PxProxyPort:       3128,
+		DnsConfigurer:     mockDnsConfigurer,
+		HttpChecker:       mockHttpChecker,
+		EnvVarPrinter:     mockEnvVarPrinter,
+	}
+}
+
+// no P2P addresses, gateways or portproxies, the mocks fail on any other call
+func TestConfigureMirroredViaProxy(t *testing.T) {
+	setupMirrored(t)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "10.0.0.1").Return(nil)
+	mockHttpChecker.On("HasInternetAccessViaProxy", mock.Anything).Return(true)
+
+	snapshot := model.Snapshot{Scenario: model.ScenarioViaProxy, PxProxyRunning: true}
+	assert.NoError(t, mirrored.Configure(ctx, snapshot, &model.Transaction{}))
+}
+
+func TestConfigureMirroredFailsWithoutPx(t *testing.T) {
+	setupMirrored(t)
+
+	snapshot := model.Snapshot{Scenario: model.ScenarioViaProxy}
+	assert.ErrorContains(t, mirrored.Configure(ctx, snapshot, &model.Transaction{}), "PX proxy is not running")
+}
+
+func TestConfigureMirroredDirect(t *testing.T) {
+	setupMirrored(t)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "8.8.8.8").Return(nil)
+	mockEnvVarPrinter.On("WarnIfProxyVarSet", mock.Anything).Return()
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
+
+	snapshot := model.Snapshot{Scenario: model.ScenarioDirect}
+	assert.NoError(t, mirrored.Configure(ctx, snapshot, &model.Transaction{}))
+}
+
+func TestConfigureMirroredSplitTunnel(t *testing.T) {
+	setupMirrored(t)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "10.0.0.1").Return(nil)
+	mockEnvVarPrinter.On("WarnIfProxyVarSet", mock.Anything).Return()
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(false)
+
+	snapshot := model.Snapshot{Scenario: model.ScenarioSplitTunnel}
+	assert.Error(t, mirrored.Configure(ctx, snapshot, &model.Transaction{}))
+}
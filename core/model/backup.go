@@ -0,0 +1,9 @@
+package model
+
+// state of a file before isetta changed it
+type FileBackup struct {
+	Path    string
+	Content []byte
+	Existed bool   // if not, restoring removes the file
+	Symlink string // the target, if the file was a symlink
+}
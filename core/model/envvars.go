@@ -0,0 +1,14 @@
+package model
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// proxy related environment variables of a shell for the current network.
+// Both are empty when offline, the shell should be left as it is.
+// Without the proxy, Export restores variables isetta only appended to
+type EnvVarChanges struct {
+	Export []EnvVar
+	Unset  []string
+}
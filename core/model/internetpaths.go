@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// result of checking one way into the internet
+type PathCheck struct {
+	Ok      bool
+	Latency time.Duration // until the check succeeded or failed
+	Err     error         // why the path doesn't work, nil if Ok
+}
+
+// the direct and the proxy path are checked independently
+type InternetPaths struct {
+	Direct PathCheck
+	Proxy  PathCheck
+}
+
+func (a InternetPaths) Any() bool {
+	return a.Direct.Ok || a.Proxy.Ok
+}
+
+// Returns true if the proxy should be used. If both paths work, the faster one
+// wins with preferFaster, otherwise both are fine and decided is false.
+func (a InternetPaths) UseProxy(preferFaster bool) (useProxy bool, decided bool) {
+	switch {
+	case a.Direct.Ok && a.Proxy.Ok:
+		if !preferFaster {
+			return false, false
+		}
+		return a.Proxy.Latency < a.Direct.Latency, true
+	case a.Proxy.Ok:
+		return true, true
+	case a.Direct.Ok:
+		return false, true
+	default:
+		return false, false
+	}
+}
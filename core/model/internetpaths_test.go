@@ -0,0 +1,35 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseTheOnlyWorkingPath(t *testing.T) {
+	useProxy, decided := InternetPaths{Proxy: PathCheck{Ok: true}}.UseProxy(false)
+	assert.True(t, decided)
+	assert.True(t, useProxy)
+
+	useProxy, decided = InternetPaths{Direct: PathCheck{Ok: true}}.UseProxy(true)
+	assert.True(t, decided)
+	assert.False(t, useProxy)
+
+	_, decided = InternetPaths{}.UseProxy(true)
+	assert.False(t, decided)
+}
+
+func TestUseTheFasterPathIfBothWork(t *testing.T) {
+	access := InternetPaths{
+		Direct: PathCheck{Ok: true, Latency: 300 * time.Millisecond},
+		Proxy:  PathCheck{Ok: true, Latency: 100 * time.Millisecond},
+	}
+
+	_, decided := access.UseProxy(false)
+	assert.False(t, decided)
+
+	useProxy, decided := access.UseProxy(true)
+	assert.True(t, decided)
+	assert.True(t, useProxy)
+}
@@ -0,0 +1,63 @@
+package model
+
+import "fmt"
+
+// intranet service which is checked after the configuration
+type IntranetTarget struct {
+	Target   string // URL or host:port
+	ViaProxy bool
+	Required bool // a failure fails the run, the configuration is kept
+}
+
+func (t IntranetTarget) String() string {
+	if t.ViaProxy {
+		return t.Target + " via proxy"
+	}
+	return t.Target
+}
+
+// what's at fault if an intranet target can't be reached
+type IntranetFault int
+
+const (
+	FaultNone IntranetFault = iota
+	FaultDns
+	FaultRoute
+	FaultProxy
+	FaultService
+	FaultTls
+)
+
+func (f IntranetFault) Hint() string {
+	switch f {
+	case FaultDns:
+		return "DNS: the name doesn't resolve, check the nameservers in /etc/resolv.conf"
+	case FaultRoute:
+		return "route: the name resolves but the host is not reachable, check the default gateway or the routed subnets"
+	case FaultProxy:
+		return "proxy: the proxy is not reachable or can't reach the target, check Px proxy and its NO_PROXY settings"
+	case FaultService:
+		return "service: the host is reachable but the request failed"
+	case FaultTls:
+		return "TLS: the host is reachable but its certificate is not trusted, check the corporate CA certificates"
+	default:
+		return ""
+	}
+}
+
+type IntranetCheckResult struct {
+	Target IntranetTarget
+	Fault  IntranetFault // FaultNone if reachable
+	Err    error
+}
+
+func (r IntranetCheckResult) Ok() bool {
+	return r.Fault == FaultNone
+}
+
+func (r IntranetCheckResult) String() string {
+	if r.Ok() {
+		return fmt.Sprintf("%v is reachable", r.Target)
+	}
+	return fmt.Sprintf("%v is not reachable (%v): %v", r.Target, r.Fault.Hint(), r.Err)
+}
@@ -0,0 +1,18 @@
+package model
+
+// networking mode of WSL, see networkingMode in .wslconfig
+type NetworkingMode int
+
+const (
+	// WSL sits behind a NAT on the Hyper-V adapter, isetta connects both sides via P2P addresses
+	NetworkingModeNat NetworkingMode = iota
+	// WSL shares the interfaces of Windows, Windows' localhost is reachable as well
+	NetworkingModeMirrored
+)
+
+func (m NetworkingMode) String() string {
+	if m == NetworkingModeMirrored {
+		return "mirrored"
+	}
+	return "nat"
+}
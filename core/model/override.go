@@ -0,0 +1,23 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// scenario pinned by the user via 'isetta use' instead of detecting it
+type Override struct {
+	Scenario Scenario
+	Until    time.Time // zero: until cleared
+}
+
+func (o Override) ActiveAt(now time.Time) bool {
+	return o.Until.IsZero() || now.Before(o.Until)
+}
+
+func (o Override) String() string {
+	if o.Until.IsZero() {
+		return fmt.Sprintf("'%v' until cleared", o.Scenario)
+	}
+	return fmt.Sprintf("'%v' until %v", o.Scenario, o.Until.Format("2006-01-02 15:04"))
+}
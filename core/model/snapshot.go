@@ -0,0 +1,75 @@
+package model
+
+import "fmt"
+
+// types shared between the core and the adapters/ mocks
+
+type Scenario int
+
+const (
+	ScenarioOffline Scenario = iota
+	ScenarioViaProxy
+	ScenarioDirect
+	// VPN with split tunnel: internal network via VPN, internet direct
+	ScenarioSplitTunnel
+	// hotel or train Wi-Fi which requires a login first
+	ScenarioCaptivePortal
+)
+
+func (s Scenario) String() string {
+	switch s {
+	case ScenarioViaProxy:
+		return "proxy"
+	case ScenarioDirect:
+		return "direct"
+	case ScenarioSplitTunnel:
+		return "split-tunnel"
+	case ScenarioCaptivePortal:
+		return "captive-portal"
+	default:
+		return "offline"
+	}
+}
+
+// inverse of String()
+func ParseScenario(s string) (Scenario, error) {
+	for _, scenario := range []Scenario{ScenarioOffline, ScenarioViaProxy, ScenarioDirect, ScenarioSplitTunnel, ScenarioCaptivePortal} {
+		if scenario.String() == s {
+			return scenario, nil
+		}
+	}
+	return ScenarioOffline, fmt.Errorf("unknown scenario '%v'", s)
+}
+
+// result of a single scenario detector
+type ScenarioResult struct {
+	Scenario   Scenario
+	Confidence float64 // between 0 and 1, 0 means undecided
+	Detector   string  // name of the deciding detector
+}
+
+func (r ScenarioResult) Decided() bool {
+	return r.Confidence > 0
+}
+
+// Immutable result of probing the network. Later steps read it instead of
+// probing again. Probes which were not needed to decide the scenario are false.
+type Snapshot struct {
+	InternetAccess bool          // via any path
+	InternetPaths  InternetPaths // which paths work and how fast
+	RunningOnWsl2  bool
+	NetworkingMode NetworkingMode
+	Scenario       Scenario
+	// how sure the scenario detector was, see ScenarioResult
+	ScenarioConfidence float64
+	// proxy scenario, P2P addresses and internal DNS also for split tunnel
+	PxProxyRunning      bool
+	PxProxyReachable    bool
+	LinuxP2pIpUp        bool
+	WindowsP2pIpUp      bool
+	InternalDnsServerUp bool // reachable from within Linux
+	// direct scenario
+	PublicDnsServerUp bool // reachable from within Linux
+	// captive portal scenario
+	CaptivePortalLoginUrl string
+}
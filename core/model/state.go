@@ -0,0 +1,68 @@
+package model
+
+import "fmt"
+
+// Actual or desired state of the resources isetta manages. Resources which are
+// not managed in a scenario keep their zero value in the desired state.
+type NetworkState struct {
+	Nameservers                  []string // of resolv.conf or the global ones of systemd-resolved, in order
+	ResolvConfGenerationDisabled bool     // 'generateResolvConf = false' in wsl.conf
+	LinuxP2pAddress              InterfaceAddress
+	DefaultGateway               string
+	Routes                       []Route // to the internal subnets, in order of the config
+	WindowsP2pAddressSet         bool
+	PortProxies                  []PortProxy
+}
+
+// address of an interface, zero if not set
+type InterfaceAddress struct {
+	Cidr      string // e.g. 192.168.99.2/24
+	Broadcast string
+}
+
+func (a InterfaceAddress) String() string {
+	if a.Cidr == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%v brd %v", a.Cidr, a.Broadcast)
+}
+
+// route to a subnet in the main table, zero if there is none
+type Route struct {
+	Cidr           string
+	Gateway        string // empty for a route via a device only
+	InterfaceIndex int    // of the outgoing interface, only needed to restore the route
+}
+
+func (r Route) String() string {
+	if r.Cidr == "" {
+		return "none"
+	}
+	if r.Gateway == "" {
+		return fmt.Sprintf("%v dev %v", r.Cidr, r.InterfaceIndex)
+	}
+	return fmt.Sprintf("%v via %v", r.Cidr, r.Gateway)
+}
+
+// entry of the Windows portproxy table
+type PortProxy struct {
+	ListenAddress  string
+	ListenPort     int
+	ConnectAddress string
+	ConnectPort    int
+}
+
+func (p PortProxy) String() string {
+	return fmt.Sprintf("%v:%v -> %v:%v", p.ListenAddress, p.ListenPort, p.ConnectAddress, p.ConnectPort)
+}
+
+// difference between the desired and the actual state of a resource
+type Drift struct {
+	Resource string
+	Actual   string
+	Desired  string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%v: is '%v', should be '%v'", d.Resource, d.Actual, d.Desired)
+}
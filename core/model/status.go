@@ -0,0 +1,12 @@
+package model
+
+// current network state as seen by isetta, nothing is changed to determine it
+type Status struct {
+	Scenario      Scenario
+	InternetPaths InternetPaths
+	Override      Override
+	Pinned        bool    // Override is active
+	Drift         []Drift // empty when offline
+	// settings of .wslconfig which interfere with isetta
+	WslConfigConflicts []WslConfigConflict
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	log "org.samba/isetta/simplelogger"
+)
+
+// rolling back must also work when the run was aborted, so it gets its own deadline
+const rollbackTimeout = 30 * time.Second
+
+// Collects the compensating actions of mutating configuration steps. When a later
+// step fails, they are run in reverse order so the machine ends up in its
+// previous state instead of a half configured one.
+type Transaction struct {
+	compensations []compensation
+	deferred      []string
+}
+
+// a run without a user at the console can't confirm the elevation prompt of
+// gsudo, so the changes on the Windows side are left to an interactive run
+var ErrElevationDeferred = errors.New("changes on the Windows side need elevation via gsudo, which can't prompt in a non-interactive run")
+
+type compensation struct {
+	description string
+	undo        func(ctx context.Context) error
+}
+
+// Registers the action which undoes a step. Should be called right before the
+// step is applied, so partially applied steps are undone as well.
+func (t *Transaction) OnRollback(description string, undo func(ctx context.Context) error) {
+	t.compensations = append(t.compensations, compensation{description, undo})
+}
+
+// number of registered compensating actions
+func (t *Transaction) Len() int {
+	return len(t.compensations)
+}
+
+// Records a step which was skipped since it needs elevation. The steps
+// applied so far are kept
+func (t *Transaction) Defer(description string) {
+	if !slices.Contains(t.deferred, description) {
+		t.deferred = append(t.deferred, description)
+	}
+}
+
+// the steps left to an interactive run
+func (t *Transaction) Deferred() []string {
+	return t.deferred
+}
+
+func (t *Transaction) HasDeferred() bool {
+	return len(t.deferred) > 0
+}
+
+// Runs all compensating actions in reverse order, also when one of them fails.
+// Runs even if the context is already done, e.g. after Ctrl-C.
+func (t *Transaction) Rollback(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	errs := []error{}
+	for i := len(t.compensations) - 1; i >= 0; i-- {
+		c := t.compensations[i]
+		log.FromContext(ctx).Info("Rolling back: %v", c.description)
+		err := c.undo(ctx)
+		if err != nil {
+			log.FromContext(ctx).Warn("Rolling back '%v' failed: %v", c.description, err)
+			errs = append(errs, fmt.Errorf("%v: %w", c.description, err))
+		}
+	}
+	t.compensations = nil
+	return errors.Join(errs...)
+}
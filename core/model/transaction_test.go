@@ -0,0 +1,40 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackRunsInReverseOrder(t *testing.T) {
+	order := []string{}
+	tx := Transaction{}
+	tx.OnRollback("first", func(ctx context.Context) error { order = append(order, "first"); return nil })
+	tx.OnRollback("second", func(ctx context.Context) error { order = append(order, "second"); return nil })
+
+	assert.NoError(t, tx.Rollback(context.Background()))
+	assert.Equal(t, []string{"second", "first"}, order)
+	assert.Equal(t, 0, tx.Len())
+}
+
+func TestRollbackContinuesAfterFailure(t *testing.T) {
+	firstCalled := false
+	tx := Transaction{}
+	tx.OnRollback("first", func(ctx context.Context) error { firstCalled = true; return nil })
+	tx.OnRollback("second", func(ctx context.Context) error { return errors.New("boom") })
+
+	err := tx.Rollback(context.Background())
+	assert.ErrorContains(t, err, "second: boom")
+	assert.True(t, firstCalled)
+}
+
+func TestRollbackRunsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tx := Transaction{}
+	tx.OnRollback("undo", func(ctx context.Context) error { return ctx.Err() })
+
+	assert.NoError(t, tx.Rollback(ctx))
+}
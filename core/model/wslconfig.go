@@ -0,0 +1,19 @@
+package model
+
+import "fmt"
+
+// setting of .wslconfig which interferes with isetta
+type WslConfigConflict struct {
+	Key         string // e.g. autoProxy
+	Actual      string // e.g. 'true (default)' if not set
+	Recommended string
+	Reason      string
+	HintOnly    bool // e.g. security relevant, isetta never changes it
+}
+
+func (c WslConfigConflict) String() string {
+	if c.HintOnly {
+		return fmt.Sprintf("%v is '%v', consider '%v': %v", c.Key, c.Actual, c.Recommended, c.Reason)
+	}
+	return fmt.Sprintf("%v is '%v', should be '%v': %v", c.Key, c.Actual, c.Recommended, c.Reason)
+}
@@ -1,49 +1,170 @@
 package core
 
+import (
+	"context"
+
+	"org.samba/isetta/core/model"
+)
+
+// Ports which reach out to the network or run external commands take a context.
+// Once it is done (timeout, Ctrl-C), they return as soon as possible.
+
 type DnsConfigurer interface {
-	// check if given IP is the active DNS server in /etc/resolve.conf and update if needed
-	ActivateDnsServer(dnsServerIp string)
+	// check if given IP is the active DNS server in /etc/resolv.conf, or of
+	// systemd-resolved when it manages DNS, and update if needed
+	ActivateDnsServer(ctx context.Context, dnsServerIp string) error
 
 	// Ensure that in /etc/wsl.conf 'generateResolvConf' is set to 'false'
 	// Creates /etc/wsl.conf if not exists
-	DisableResolveAutoConfGeneration()
+	DisableResolveAutoConfGeneration(ctx context.Context) error
+
+	// writes resolv.conf with the given IP as only DNS server
+	ReplaceDnsServers(ctx context.Context, dnsServerIp string) error
+
+	// actual state, see model.NetworkState
+	Nameservers(ctx context.Context) ([]string, error)
+	IsResolvConfGenerationDisabled() (bool, error)
+
+	// current state of the files, for rolling back changes
+	BackupResolvConf(ctx context.Context) (model.FileBackup, error)
+	BackupWslConf() (model.FileBackup, error)
+	RestoreFile(ctx context.Context, backup model.FileBackup) error
 }
 
 type EnvVarPrinter interface {
-	PrintExportCommands()
+	PrintExportCommands(ctx context.Context)
 	PrintUnsetCommands()
-	WarnIfProxyVarSet()
+	// the variables behind the printed commands
+	ExportVars(ctx context.Context) []model.EnvVar
+	UnsetVars() []string
+	// variables which keep the user's value once isetta's part is removed
+	RestoredVars() []model.EnvVar
+	WarnIfProxyVarSet(ctx context.Context)
+	// http(s)_proxy variables are set in the current shell
+	IsProxyVarSet() bool
 }
 
 type LinuxPinger interface {
-	Ping(host string) bool
+	Ping(ctx context.Context, host string) bool
 }
 
 type LinuxConfigurer interface {
-	SetP2pInterface()
-	DeleteDefaultGateway()
-	AddDefaultGateway()
+	SetP2pInterface(ctx context.Context) error
+	RemoveP2pInterface(ctx context.Context) error
+	DeleteDefaultGateway(ctx context.Context)
+	AddDefaultGateway(ctx context.Context) error
+	// returns the P2P address, the zero value if not set
+	P2pAddress(ctx context.Context) (model.InterfaceAddress, error)
+	// returns the IP of the current default gateway, empty if none is set
+	DefaultGateway(ctx context.Context) (string, error)
+	// replaces the default gateway with the given one, an empty one only deletes it
+	RestoreDefaultGateway(ctx context.Context, gateway string) error
+	// routes the subnet via the given gateway, replaces an existing route
+	AddRoute(ctx context.Context, cidr string, gateway string) error
+	// returns the route to the subnet, the zero value if there is none
+	Route(ctx context.Context, cidr string) (model.Route, error)
+	// sets the route to the subnet as returned by Route, the zero value only deletes it
+	RestoreRoute(ctx context.Context, cidr string, route model.Route) error
 }
 
 type WindowsChecker interface {
-	IsPingable(host string) bool
-	IsPxProxyRunning() bool
-	IsRunningOnWsl2() bool
+	IsPingable(ctx context.Context, host string) bool
+	IsPxProxyRunning(ctx context.Context) bool
+	IsRunningOnWsl2(ctx context.Context) bool
+	HasP2pAddress(ctx context.Context, ip string) bool
+	PortProxies(ctx context.Context) ([]model.PortProxy, error)
+	// hints about the network Windows is connected to
+	DnsSuffixes(ctx context.Context) []string
+	NetworkAdapters(ctx context.Context) []string // names and descriptions of the adapters which are up
+	WifiSsids(ctx context.Context) []string
+	IsUrlReachable(ctx context.Context, url string) bool
+	// plain TCP connect from Windows, bypasses any proxy
+	IsTcpPortOpen(ctx context.Context, host string, port int) bool
 }
 
 type WindowsConfigurer interface {
-	Init()    // deferred construction and object setup,
-	Cleanup() // cleanup temporary resources
-	AddP2pAddress(successChecker func() bool) error
-	SetPortProxy(successChecker func() bool) error
+	Init(ctx context.Context) error // deferred construction and object setup,
+	Cleanup(ctx context.Context)    // cleanup temporary resources, also after the context is done
+	AddP2pAddress(ctx context.Context, successChecker func() bool) error
+	RemoveP2pAddress(ctx context.Context) error
+	// replaces only the portproxy of isetta, the ones of the user are kept
+	SetPortProxy(ctx context.Context, successChecker func() bool) error
+	// replaces the portproxy of isetta with the given previous entries, none removes it
+	RestorePortProxy(ctx context.Context, previous []model.PortProxy) error
 }
 
 type HttpChecker interface {
-	HasDirectInternetAccess(timeoutInMilliseconds ...int) bool
-	HasInternetAccessViaProxy(timeoutInMilliseconds ...int) bool
-	IsPxProxyReachable() bool
+	HasDirectInternetAccess(ctx context.Context, timeoutInMilliseconds ...int) bool
+	HasInternetAccessViaProxy(ctx context.Context, timeoutInMilliseconds ...int) bool
+	// like the Has... variants, but tell why the access failed
+	CheckDirectInternetAccess(ctx context.Context, timeoutInMilliseconds int) error
+	CheckInternetAccessViaProxy(ctx context.Context, timeoutInMilliseconds int) error
+	IsPxProxyReachable(ctx context.Context) bool
+	// direct access without proxy, any HTTP response counts
+	IsUrlReachable(ctx context.Context, url string) bool
+	// returns the login URL if a captive portal intercepts the connection
+	CaptivePortalLoginUrl(ctx context.Context) (string, bool)
 }
 
 type NetworkConfigurer interface {
-	Configure() error
-}
\ No newline at end of file
+	// mutating steps register their compensating actions in the transaction
+	Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error
+}
+
+type NetworkReconciler interface {
+	// applies the minimal set of changes to get from the actual to the desired state
+	NetworkConfigurer
+	// compares the actual with the desired state of the scenario, without changing anything
+	Verify(ctx context.Context, scenario model.Scenario) ([]model.Drift, error)
+}
+
+type IntranetChecker interface {
+	CheckIntranetTarget(ctx context.Context, target model.IntranetTarget) model.IntranetCheckResult
+}
+
+// %USERPROFILE%\.wslconfig, the settings of the WSL VM. Changes only apply
+// once WSL was restarted via 'wsl --shutdown'
+type WslConfig interface {
+	// settings which interfere with isetta, empty if there are none
+	Conflicts(ctx context.Context) ([]model.WslConfigConflict, error)
+	// sets the recommended values, keeps the rest of the file. Returns the corrected conflicts
+	ApplyRecommended(ctx context.Context) ([]model.WslConfigConflict, error)
+	// warns if .wslconfig sets a networking mode which is not active yet
+	WarnIfModePending(ctx context.Context)
+}
+
+// persists the scenario pinned via 'isetta use'
+type OverrideStore interface {
+	// found is false if there is no override
+	Load() (override model.Override, found bool, err error)
+	Save(override model.Override) error
+	Clear() error
+}
+
+type ScenarioDetector interface {
+	Name() string
+	// a confidence of 0 means the detector can't decide
+	DetectScenario(ctx context.Context) model.ScenarioResult
+}
+
+type NetworkDetector interface {
+	// probes the network, see model.Snapshot
+	Detect(ctx context.Context) model.Snapshot
+	DetectScenario(ctx context.Context) model.Scenario
+	CheckInternetAccess(ctx context.Context) model.InternetPaths
+}
+
+type CaCertificateExporter interface {
+	// exports the corporate CA certificates from the Windows certificate store
+	// into a PEM bundle, returns the path of the bundle
+	ExportCaCertificates(ctx context.Context) (string, error)
+}
+
+type JavaTruststoreConfigurer interface {
+	// returns the home directories of all installed JDKs
+	FindJdks(ctx context.Context) []string
+	// (re-)imports all certificates of the PEM bundle into the 'cacerts' of the given JDK
+	ImportCaCertificates(ctx context.Context, jdkHome string, caBundlePath string) error
+	// creates a standalone PKCS12 truststore from the PEM bundle using the keytool of the given JDK
+	CreatePkcs12Truststore(ctx context.Context, jdkHome string, caBundlePath string) error
+}
@@ -0,0 +1,397 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
+)
+
+// Compares the actual state of the managed resources with the desired state of
+// the scenario and corrects the drift, e.g. a stale portproxy, a wrong broadcast
+// address, a missing route or an additional nameserver. The proxy variables are
+// no state, they are printed for each shell and never written to a file.
+type Reconciler struct {
+	InternalDnsServer string
+	PublicDnsServer   string
+	LinuxP2pIp        string
+	WindowsP2pIp      string
+	SubnetMask        string
+	PxProxyPort       int
+	InternalCidrs     []string // routed via the P2P interface with a split tunnel
+	NetworkingMode    model.NetworkingMode
+	DnsConfigurer     DnsConfigurer
+	LinuxConfigurer   LinuxConfigurer
+	WindowsChecker    WindowsChecker
+	WindowsConfigurer WindowsConfigurer
+	LinuxPinger       LinuxPinger
+	HttpChecker       HttpChecker
+}
+
+// a drift together with the change correcting it
+type change struct {
+	drift   model.Drift
+	windows bool // requires elevated rights on Windows
+	apply   func(ctx context.Context, tx *model.Transaction) error
+}
+
+func (r *Reconciler) DesiredState(scenario model.Scenario) model.NetworkState {
+	// WSL shares the interfaces of Windows, only the nameserver differs
+	if r.NetworkingMode == model.NetworkingModeMirrored {
+		nameserver := r.InternalDnsServer
+		if scenario == model.ScenarioDirect {
+			nameserver = r.PublicDnsServer
+		}
+		return model.NetworkState{
+			Nameservers:                  []string{nameserver},
+			ResolvConfGenerationDisabled: true,
+		}
+	}
+
+	if scenario == model.ScenarioViaProxy {
+		return model.NetworkState{
+			Nameservers:                  []string{r.InternalDnsServer},
+			ResolvConfGenerationDisabled: true,
+			LinuxP2pAddress:              p2pAddress(r.LinuxP2pIp, r.SubnetMask),
+			DefaultGateway:               r.WindowsP2pIp,
+			WindowsP2pAddressSet:         true,
+			PortProxies: []model.PortProxy{
+				{ListenAddress: r.WindowsP2pIp, ListenPort: r.PxProxyPort, ConnectAddress: "127.0.0.1", ConnectPort: r.PxProxyPort},
+			},
+		}
+	}
+
+	// the default gateway of WSL is kept, the internal subnets are routed via the P2P interface
+	if scenario == model.ScenarioSplitTunnel {
+		return model.NetworkState{
+			Nameservers:                  []string{r.InternalDnsServer},
+			ResolvConfGenerationDisabled: true,
+			LinuxP2pAddress:              p2pAddress(r.LinuxP2pIp, r.SubnetMask),
+			Routes:                       r.internalRoutes(),
+			WindowsP2pAddressSet:         true,
+		}
+	}
+
+	// with direct access, the network setup of WSL is used as is
+	return model.NetworkState{
+		Nameservers:                  []string{r.PublicDnsServer},
+		ResolvConfGenerationDisabled: true,
+	}
+}
+
+func (r *Reconciler) internalRoutes() []model.Route {
+	routes := []model.Route{}
+	for _, cidr := range r.InternalCidrs {
+		routes = append(routes, model.Route{Cidr: canonicalCidr(cidr), Gateway: r.WindowsP2pIp})
+	}
+	return routes
+}
+
+// the interface of a route isn't compared, only where it leads to
+func (r *Reconciler) actualRoutes(ctx context.Context) ([]model.Route, error) {
+	routes := []model.Route{}
+	for _, cidr := range r.InternalCidrs {
+		route, err := r.LinuxConfigurer.Route(ctx, cidr)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, model.Route{Cidr: route.Cidr, Gateway: route.Gateway})
+	}
+	return routes, nil
+}
+
+// only resources which are managed in the scenario are read
+func (r *Reconciler) ActualState(ctx context.Context, scenario model.Scenario) (model.NetworkState, error) {
+	defer timing.Start("Reading actual network state").End()
+	var err error
+	state := model.NetworkState{}
+
+	state.Nameservers, err = r.DnsConfigurer.Nameservers(ctx)
+	if err != nil {
+		return state, err
+	}
+	state.ResolvConfGenerationDisabled, err = r.DnsConfigurer.IsResolvConfGenerationDisabled()
+	if err != nil {
+		return state, err
+	}
+	if scenario == model.ScenarioDirect || r.NetworkingMode == model.NetworkingModeMirrored {
+		return state, nil
+	}
+
+	state.LinuxP2pAddress, err = r.LinuxConfigurer.P2pAddress(ctx)
+	if err != nil {
+		return state, err
+	}
+	state.WindowsP2pAddressSet = r.WindowsChecker.HasP2pAddress(ctx, r.WindowsP2pIp)
+	if scenario == model.ScenarioSplitTunnel {
+		state.Routes, err = r.actualRoutes(ctx)
+		return state, err
+	}
+
+	state.DefaultGateway, err = r.LinuxConfigurer.DefaultGateway(ctx)
+	if err != nil {
+		return state, err
+	}
+	portProxies, err := r.WindowsChecker.PortProxies(ctx)
+	state.PortProxies = ownPortProxies(portProxies, r.WindowsP2pIp, r.PxProxyPort)
+	return state, err
+}
+
+func (r *Reconciler) Verify(ctx context.Context, scenario model.Scenario) ([]model.Drift, error) {
+	defer timing.Start("Verifying network state").End()
+	changes, err := r.plan(ctx, scenario)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []model.Drift{}
+	for _, c := range changes {
+		drifts = append(drifts, c.drift)
+	}
+	return drifts, nil
+}
+
+func (r *Reconciler) Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Reconciling network state").End()
+	changes, err := r.plan(ctx, snapshot.Scenario)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		log.FromContext(ctx).Debug("Actual network state matches the desired one")
+		return nil
+	}
+
+	err = r.apply(ctx, changes, tx)
+	if err != nil {
+		return err
+	}
+
+	// post condition, the deferred changes on the Windows side remain
+	remaining, err := r.plan(ctx, snapshot.Scenario)
+	if err != nil {
+		return err
+	}
+	drifts := []model.Drift{}
+	for _, c := range remaining {
+		if !c.windows || !tx.HasDeferred() {
+			drifts = append(drifts, c.drift)
+		}
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("drift remains after reconciling: %v", joinDrifts(drifts))
+	}
+	return nil
+}
+
+func (r *Reconciler) plan(ctx context.Context, scenario model.Scenario) ([]change, error) {
+	if scenario == model.ScenarioOffline {
+		return nil, errors.New("no desired network state when offline")
+	}
+
+	desired := r.DesiredState(scenario)
+	actual, err := r.ActualState(ctx, scenario)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read actual network state: %w", err)
+	}
+
+	// order matters, e.g. the portproxy can only be checked via a working default gateway
+	changes := []change{}
+	if desired.ResolvConfGenerationDisabled && !actual.ResolvConfGenerationDisabled {
+		changes = append(changes, r.disableResolvConfGeneration())
+	}
+	if !slices.Equal(desired.Nameservers, actual.Nameservers) {
+		changes = append(changes, r.replaceNameservers(desired.Nameservers, actual.Nameservers))
+	}
+	if desired.LinuxP2pAddress.Cidr != "" && desired.LinuxP2pAddress != actual.LinuxP2pAddress {
+		changes = append(changes, r.setLinuxP2pAddress(desired.LinuxP2pAddress, actual.LinuxP2pAddress))
+	}
+	if desired.WindowsP2pAddressSet && !actual.WindowsP2pAddressSet {
+		changes = append(changes, r.addWindowsP2pAddress())
+	}
+	if desired.DefaultGateway != "" && desired.DefaultGateway != actual.DefaultGateway {
+		changes = append(changes, r.setDefaultGateway(desired.DefaultGateway, actual.DefaultGateway))
+	}
+	for i, route := range desired.Routes {
+		if i >= len(actual.Routes) || route != actual.Routes[i] {
+			changes = append(changes, r.setRoute(route, actualRoute(actual.Routes, i)))
+		}
+	}
+	if desired.PortProxies != nil && !slices.Equal(desired.PortProxies, actual.PortProxies) {
+		changes = append(changes, r.setPortProxy(desired.PortProxies, actual.PortProxies))
+	}
+	return changes, nil
+}
+
+// gsudo is only set up if a change on the Windows side is needed. If it
+// can't elevate, the changes on the Windows side are deferred
+func (r *Reconciler) apply(ctx context.Context, changes []change, tx *model.Transaction) error {
+	windowsInitialized := false
+	windowsDeferred := false
+	for _, c := range changes {
+		if c.windows && !windowsInitialized {
+			defer r.WindowsConfigurer.Cleanup(ctx)
+			deferred, err := initWindowsSide(ctx, r.WindowsConfigurer, tx, "correcting the Windows side")
+			if err != nil {
+				return err
+			}
+			windowsInitialized = true
+			windowsDeferred = deferred
+		}
+		if c.windows && windowsDeferred {
+			progress.EmitMessage("Deferring correction of %v", c.drift)
+			continue
+		}
+
+		progress.EmitMessage("Correcting %v", c.drift)
+
+		err := c.apply(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reconciler) disableResolvConfGeneration() change {
+	return change{
+		drift: model.Drift{Resource: "generateResolvConf in wsl.conf", Actual: "true", Desired: "false"},
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			backup, err := r.DnsConfigurer.BackupWslConf()
+			err = registerFileRollback(tx, backup, err, r.DnsConfigurer)
+			if err != nil {
+				return err
+			}
+			return r.DnsConfigurer.DisableResolveAutoConfGeneration(ctx)
+		},
+	}
+}
+
+func (r *Reconciler) replaceNameservers(desired []string, actual []string) change {
+	return change{
+		drift: model.Drift{Resource: "nameservers in resolv.conf", Actual: strings.Join(actual, ", "), Desired: strings.Join(desired, ", ")},
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			err := registerResolvConfRollback(ctx, tx, r.DnsConfigurer)
+			if err != nil {
+				return err
+			}
+			return r.DnsConfigurer.ReplaceDnsServers(ctx, desired[0])
+		},
+	}
+}
+
+func (r *Reconciler) setLinuxP2pAddress(desired model.InterfaceAddress, actual model.InterfaceAddress) change {
+	return change{
+		drift: model.Drift{Resource: "Linux P2P address", Actual: actual.String(), Desired: desired.String()},
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			if actual.Cidr == "" {
+				tx.OnRollback(fmt.Sprintf("removing Linux P2P address %v", r.LinuxP2pIp), r.LinuxConfigurer.RemoveP2pInterface)
+			}
+			return r.LinuxConfigurer.SetP2pInterface(ctx)
+		},
+	}
+}
+
+func (r *Reconciler) addWindowsP2pAddress() change {
+	return change{
+		drift:   model.Drift{Resource: "Windows P2P address", Actual: "none", Desired: r.WindowsP2pIp},
+		windows: true,
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			tx.OnRollback(fmt.Sprintf("removing Windows P2P address %v", r.WindowsP2pIp), onWindowsRollback(r.WindowsConfigurer, r.WindowsConfigurer.RemoveP2pAddress))
+			return r.WindowsConfigurer.AddP2pAddress(ctx, func() bool { return r.LinuxPinger.Ping(ctx, r.WindowsP2pIp) })
+		},
+	}
+}
+
+func (r *Reconciler) setDefaultGateway(desired string, actual string) change {
+	return change{
+		drift: model.Drift{Resource: "default gateway", Actual: actual, Desired: desired},
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			err := registerDefaultGatewayRollback(ctx, tx, r.LinuxConfigurer)
+			if err != nil {
+				return err
+			}
+			r.LinuxConfigurer.DeleteDefaultGateway(ctx)
+			return r.LinuxConfigurer.AddDefaultGateway(ctx)
+		},
+	}
+}
+
+func (r *Reconciler) setRoute(desired model.Route, actual model.Route) change {
+	return change{
+		drift: model.Drift{Resource: "route to " + desired.Cidr, Actual: actual.String(), Desired: desired.String()},
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			err := registerRouteRollback(ctx, tx, r.LinuxConfigurer, desired.Cidr)
+			if err != nil {
+				return err
+			}
+			return r.LinuxConfigurer.AddRoute(ctx, desired.Cidr, desired.Gateway)
+		},
+	}
+}
+
+func actualRoute(routes []model.Route, i int) model.Route {
+	if i < len(routes) {
+		return routes[i]
+	}
+	return model.Route{}
+}
+
+func (r *Reconciler) setPortProxy(desired []model.PortProxy, actual []model.PortProxy) change {
+	return change{
+		drift:   model.Drift{Resource: "Windows portproxy", Actual: joinPortProxies(actual), Desired: joinPortProxies(desired)},
+		windows: true,
+		apply: func(ctx context.Context, tx *model.Transaction) error {
+			onPortProxyRollback(tx, r.WindowsConfigurer, actual)
+			return r.WindowsConfigurer.SetPortProxy(ctx, func() bool { return r.HttpChecker.HasInternetAccessViaProxy(ctx) })
+		},
+	}
+}
+
+// computes the address like 'ip addr' shows it. IP and subnet mask are validated by the config
+func p2pAddress(ip string, subnetMask string) model.InterfaceAddress {
+	parsedIp := net.ParseIP(ip).To4()
+	mask := net.IPMask(net.ParseIP(subnetMask).To4())
+	if parsedIp == nil || mask == nil {
+		return model.InterfaceAddress{}
+	}
+
+	ones, _ := mask.Size()
+	broadcast := make(net.IP, len(parsedIp))
+	for i := range parsedIp {
+		broadcast[i] = parsedIp[i] | ^mask[i]
+	}
+	return model.InterfaceAddress{Cidr: fmt.Sprintf("%v/%v", parsedIp, ones), Broadcast: broadcast.String()}
+}
+
+// e.g. 10.1.2.3/8 as 10.0.0.0/8, like the kernel reports the route. The CIDRs are validated by the config
+func canonicalCidr(cidr string) string {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return subnet.String()
+}
+
+func joinPortProxies(portProxies []model.PortProxy) string {
+	entries := []string{}
+	for _, p := range portProxies {
+		entries = append(entries, p.String())
+	}
+	return strings.Join(entries, ", ")
+}
+
+func joinDrifts(drifts []model.Drift) string {
+	entries := []string{}
+	for _, d := range drifts {
+		entries = append(entries, d.String())
+	}
+	return strings.Join(entries, "; ")
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+var reconciler Reconciler
+
+var desiredPortProxies = []model.PortProxy{
+	{ListenAddress: "192.168.99.1", ListenPort: 3128, ConnectAddress: "127.0.0.1", ConnectPort: 3128},
+}
+
+func setupReconciler(t *testing.T) {
+	mockDnsConfigurer = mocks.NewDnsConfigurer(t)
+	mockLinuxConfigurer = mocks.NewLinuxConfigurer(t)
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinConfigurer = mocks.NewWindowsConfigurer(t)
+	mockLinuxPinger = mocks.NewLinuxPinger(t)
+	mockHttpChecker = mocks.NewHttpChecker(t)
+
+	reconciler = Reconciler{
+		InternalDnsServer: "42.42.42.42",
+		PublicDnsServer:   "8.8.8.8",
+		LinuxP2pIp:        "192.168.99.2",
+		WindowsP2pIp:      "192.168.99.1",
+		SubnetMask:        "255.255.255.0",
+		PxProxyPort:       3128,
+		DnsConfigurer:     mockDnsConfigurer,
+		LinuxConfigurer:   mockLinuxConfigurer,
+		WindowsChecker:    mockWinChecker,
+		WindowsConfigurer: mockWinConfigurer,
+		LinuxPinger:       mockLinuxPinger,
+		HttpChecker:       mockHttpChecker,
+	}
+}
+
+// actual state of the proxy scenario which matches the desired one
+func setupProxyState(nameservers []string, broadcast string, portProxies []model.PortProxy) {
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return(nameservers, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockLinuxConfigurer.On("P2pAddress", mock.Anything).Return(model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: broadcast}, nil)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("192.168.99.1", nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(true)
+	mockWinChecker.On("PortProxies", mock.Anything).Return(portProxies, nil)
+}
+
+func TestDesiredStateOfDirectScenarioOnlyManagesDns(t *testing.T) {
+	setupReconciler(t)
+	assert.Equal(t, model.NetworkState{
+		Nameservers:                  []string{"8.8.8.8"},
+		ResolvConfGenerationDisabled: true,
+	}, reconciler.DesiredState(model.ScenarioDirect))
+}
+
+func TestNoDriftInSplitTunnelScenarioIgnoresProxyResources(t *testing.T) {
+	setupReconciler(t)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockLinuxConfigurer.On("P2pAddress", mock.Anything).Return(model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(true)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioSplitTunnel)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func setupSplitTunnelState() {
+	reconciler.InternalCidrs = []string{"10.0.0.0/8"}
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockLinuxConfigurer.On("P2pAddress", mock.Anything).Return(model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(true)
+}
+
+func TestMissingRouteToInternalSubnetIsDrift(t *testing.T) {
+	setupReconciler(t)
+	setupSplitTunnelState()
+	mockLinuxConfigurer.On("Route", mock.Anything, "10.0.0.0/8").Return(model.Route{}, nil)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioSplitTunnel)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Drift{{Resource: "route to 10.0.0.0/8", Actual: "none", Desired: "10.0.0.0/8 via 192.168.99.1"}}, drifts)
+}
+
+func TestRouteOfVpnIsReplacedAndRestoredOnRollback(t *testing.T) {
+	setupReconciler(t)
+	setupSplitTunnelState()
+	vpnRoute := model.Route{Cidr: "10.0.0.0/8", Gateway: "172.28.64.1", InterfaceIndex: 2}
+	mockLinuxConfigurer.On("Route", mock.Anything, "10.0.0.0/8").Return(vpnRoute, nil).Twice()
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, "10.0.0.0/8", "192.168.99.1").Return(nil)
+	// post condition
+	mockLinuxConfigurer.On("Route", mock.Anything, "10.0.0.0/8").Return(model.Route{Cidr: "10.0.0.0/8", Gateway: "192.168.99.1", InterfaceIndex: 2}, nil).Once()
+	mockLinuxConfigurer.On("RestoreRoute", mock.Anything, "10.0.0.0/8", vpnRoute).Return(nil)
+
+	tx := &model.Transaction{}
+	assert.NoError(t, reconciler.Configure(ctx, model.Snapshot{Scenario: model.ScenarioSplitTunnel}, tx))
+	assert.NoError(t, tx.Rollback(ctx))
+}
+
+// P2P addresses, gateway and portproxy are neither read nor desired
+func TestNoDriftInMirroredProxyScenario(t *testing.T) {
+	setupReconciler(t)
+	reconciler.NetworkingMode = model.NetworkingModeMirrored
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioViaProxy)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestDesiredLinuxP2pAddress(t *testing.T) {
+	assert.Equal(t, model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, p2pAddress("192.168.99.2", "255.255.255.0"))
+}
+
+func TestNoDriftInProxyScenario(t *testing.T) {
+	setupReconciler(t)
+	setupProxyState([]string{"42.42.42.42"}, "192.168.99.255", desiredPortProxies)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioViaProxy)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestDriftInProxyScenario(t *testing.T) {
+	setupReconciler(t)
+	stalePortProxies := []model.PortProxy{{ListenAddress: "192.168.99.1", ListenPort: 3128, ConnectAddress: "127.0.0.1", ConnectPort: 8080}}
+	setupProxyState([]string{"42.42.42.42", "8.8.8.8"}, "192.168.99.0", stalePortProxies)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioViaProxy)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Drift{
+		{Resource: "nameservers in resolv.conf", Actual: "42.42.42.42, 8.8.8.8", Desired: "42.42.42.42"},
+		{Resource: "Linux P2P address", Actual: "192.168.99.2/24 brd 192.168.99.0", Desired: "192.168.99.2/24 brd 192.168.99.255"},
+		{Resource: "Windows portproxy", Actual: "192.168.99.1:3128 -> 127.0.0.1:8080", Desired: "192.168.99.1:3128 -> 127.0.0.1:3128"},
+	}, drifts)
+}
+
+func TestPortProxiesOfTheUserAreNoDrift(t *testing.T) {
+	setupReconciler(t)
+	portProxies := append([]model.PortProxy{
+		{ListenAddress: "0.0.0.0", ListenPort: 8080, ConnectAddress: "172.28.70.5", ConnectPort: 8080},
+		{ListenAddress: "192.168.99.1", ListenPort: 2222, ConnectAddress: "127.0.0.1", ConnectPort: 22},
+	}, desiredPortProxies...)
+	setupProxyState([]string{"42.42.42.42"}, "192.168.99.255", portProxies)
+
+	drifts, err := reconciler.Verify(ctx, model.ScenarioViaProxy)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestVerifyFailsOffline(t *testing.T) {
+	setupReconciler(t)
+	_, err := reconciler.Verify(ctx, model.ScenarioOffline)
+	assert.Error(t, err)
+}
+
+func TestNothingIsChangedWithoutDrift(t *testing.T) {
+	setupReconciler(t)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"8.8.8.8"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+
+	assert.NoError(t, reconciler.Configure(ctx, model.Snapshot{Scenario: model.ScenarioDirect}, &model.Transaction{}))
+}
+
+func TestExtraNameserverIsRemoved(t *testing.T) {
+	setupReconciler(t)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"8.8.8.8", "1.1.1.1"}, nil).Once()
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ReplaceDnsServers", mock.Anything, "8.8.8.8").Return(nil)
+	// post condition
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"8.8.8.8"}, nil).Once()
+
+	tx := &model.Transaction{}
+	assert.NoError(t, reconciler.Configure(ctx, model.Snapshot{Scenario: model.ScenarioDirect}, tx))
+	assert.Equal(t, 1, tx.Len())
+}
+
+func TestStalePortProxyIsReplaced(t *testing.T) {
+	setupReconciler(t)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"42.42.42.42"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockLinuxConfigurer.On("P2pAddress", mock.Anything).Return(model.InterfaceAddress{Cidr: "192.168.99.2/24", Broadcast: "192.168.99.255"}, nil)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("192.168.99.1", nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(true)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil).Once()
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(nil)
+	// post condition
+	mockWinChecker.On("PortProxies", mock.Anything).Return(desiredPortProxies, nil).Once()
+
+	tx := &model.Transaction{}
+	assert.NoError(t, reconciler.Configure(ctx, model.Snapshot{Scenario: model.ScenarioViaProxy}, tx))
+	// restores the missing portproxy, i.e. removes it again
+	assert.Equal(t, 1, tx.Len())
+}
+
+func TestErrorWhenDriftRemains(t *testing.T) {
+	setupReconciler(t)
+	mockDnsConfigurer.On("Nameservers", mock.Anything).Return([]string{"1.1.1.1"}, nil)
+	mockDnsConfigurer.On("IsResolvConfGenerationDisabled").Return(true, nil)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ReplaceDnsServers", mock.Anything, "8.8.8.8").Return(nil)
+
+	err := reconciler.Configure(ctx, model.Snapshot{Scenario: model.ScenarioDirect}, &model.Transaction{})
+	assert.ErrorContains(t, err, "nameservers in resolv.conf")
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"org.samba/isetta/core/model"
+	log "org.samba/isetta/simplelogger"
+)
+
+// compensating actions shared by the network configurers
+
+func registerFileRollback(tx *model.Transaction, backup model.FileBackup, err error, dnsConfigurer DnsConfigurer) error {
+	if err != nil {
+		return fmt.Errorf("unable to back up file before changing it: %w", err)
+	}
+	tx.OnRollback("restoring "+backup.Path, func(ctx context.Context) error {
+		return dnsConfigurer.RestoreFile(ctx, backup)
+	})
+	return nil
+}
+
+func registerResolvConfRollback(ctx context.Context, tx *model.Transaction, dnsConfigurer DnsConfigurer) error {
+	backup, err := dnsConfigurer.BackupResolvConf(ctx)
+	return registerFileRollback(tx, backup, err, dnsConfigurer)
+}
+
+func registerDefaultGatewayRollback(ctx context.Context, tx *model.Transaction, linuxConfigurer LinuxConfigurer) error {
+	gateway, err := linuxConfigurer.DefaultGateway(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to determine current default gateway: %w", err)
+	}
+	tx.OnRollback(fmt.Sprintf("restoring default gateway '%v'", gateway), func(ctx context.Context) error {
+		return linuxConfigurer.RestoreDefaultGateway(ctx, gateway)
+	})
+	return nil
+}
+
+// a route which existed before, e.g. one of a VPN, is restored instead of deleted
+func registerRouteRollback(ctx context.Context, tx *model.Transaction, linuxConfigurer LinuxConfigurer, cidr string) error {
+	previous, err := linuxConfigurer.Route(ctx, cidr)
+	if err != nil {
+		return fmt.Errorf("unable to determine current route to %v: %w", cidr, err)
+	}
+	tx.OnRollback(fmt.Sprintf("restoring route '%v'", previous), func(ctx context.Context) error {
+		return linuxConfigurer.RestoreRoute(ctx, cidr, previous)
+	})
+	return nil
+}
+
+// gsudo is already cleaned up when rolling back, so it's set up again
+func onWindowsRollback(windowsConfigurer WindowsConfigurer, undo func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		defer windowsConfigurer.Cleanup(ctx)
+		err := windowsConfigurer.Init(ctx)
+		if err != nil {
+			return err
+		}
+		return undo(ctx)
+	}
+}
+
+// An address which was there before, e.g. when only Px was unreachable, is
+// kept on rollback. Should be called before the address is added
+func registerWindowsP2pAddressRollback(ctx context.Context, tx *model.Transaction, windowsChecker WindowsChecker, windowsConfigurer WindowsConfigurer, ip string) {
+	if windowsChecker.HasP2pAddress(ctx, ip) {
+		log.FromContext(ctx).Debug("Windows P2P address %v exists already, it is kept on rollback", ip)
+		return
+	}
+	tx.OnRollback(fmt.Sprintf("removing Windows P2P address %v", ip), onWindowsRollback(windowsConfigurer, windowsConfigurer.RemoveP2pAddress))
+}
+
+// reads the current portproxy of isetta, it is restored on rollback
+func registerPortProxyRollback(ctx context.Context, tx *model.Transaction, windowsChecker WindowsChecker, windowsConfigurer WindowsConfigurer, listenAddress string, listenPort int) error {
+	portProxies, err := windowsChecker.PortProxies(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read current portproxy: %w", err)
+	}
+	onPortProxyRollback(tx, windowsConfigurer, ownPortProxies(portProxies, listenAddress, listenPort))
+	return nil
+}
+
+func onPortProxyRollback(tx *model.Transaction, windowsConfigurer WindowsConfigurer, previous []model.PortProxy) {
+	tx.OnRollback(fmt.Sprintf("restoring Windows portproxy '%v'", joinPortProxies(previous)), onWindowsRollback(windowsConfigurer, func(ctx context.Context) error {
+		return windowsConfigurer.RestorePortProxy(ctx, previous)
+	}))
+}
+
+// the portproxies of the user listen on other addresses or ports, isetta neither changes nor restores them
+func ownPortProxies(portProxies []model.PortProxy, listenAddress string, listenPort int) []model.PortProxy {
+	own := []model.PortProxy{}
+	for _, p := range portProxies {
+		if p.ListenAddress == listenAddress && p.ListenPort == listenPort {
+			own = append(own, p)
+		}
+	}
+	return own
+}
+
+// Sets up the Windows side. A non-interactive run can't elevate, the step is
+// deferred then instead of failing the whole configuration
+func initWindowsSide(ctx context.Context, windowsConfigurer WindowsConfigurer, tx *model.Transaction, description string) (deferred bool, err error) {
+	err = windowsConfigurer.Init(ctx)
+	if errors.Is(err, model.ErrElevationDeferred) {
+		log.FromContext(ctx).Warn("Deferring %v: %v", description, err)
+		tx.Defer(description)
+		return true, nil
+	}
+	return false, err
+}
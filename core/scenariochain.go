@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+
+	"org.samba/isetta/core/model"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
+)
+
+// Asks the detectors in order. The first result which reaches MinConfidence
+// wins, later detectors are not asked anymore. If no detector is confident
+// enough, the most confident result wins. Offline if nobody decides.
+type ScenarioChain struct {
+	Detectors     []ScenarioDetector
+	MinConfidence float64
+}
+
+func (c *ScenarioChain) Name() string {
+	return "chain"
+}
+
+func (c *ScenarioChain) DetectScenario(ctx context.Context) model.ScenarioResult {
+	best := model.ScenarioResult{Scenario: model.ScenarioOffline}
+	for _, detector := range c.Detectors {
+		result := detectWithTiming(ctx, detector)
+		result.Detector = detector.Name()
+		if !result.Decided() {
+			log.FromContext(ctx).Debug("Scenario detector '%v' is undecided", detector.Name())
+			continue
+		}
+
+		log.FromContext(ctx).Debug("Scenario detector '%v' detected '%v' with confidence %.2f", detector.Name(), result.Scenario, result.Confidence)
+		if result.Confidence >= c.MinConfidence {
+			return result
+		}
+		if result.Confidence > best.Confidence {
+			best = result
+		}
+	}
+	return best
+}
+
+func detectWithTiming(ctx context.Context, detector ScenarioDetector) model.ScenarioResult {
+	defer timing.Start("Scenario detector: " + detector.Name()).End()
+	return detector.DetectScenario(ctx)
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+func mockScenarioDetector(t *testing.T, name string, result model.ScenarioResult) *mocks.ScenarioDetector {
+	detector := mocks.NewScenarioDetector(t)
+	detector.On("Name").Return(name).Maybe()
+	detector.On("DetectScenario", mock.Anything).Return(result).Maybe()
+	return detector
+}
+
+func TestFirstConfidentDetectorWins(t *testing.T) {
+	later := mockScenarioDetector(t, "later", model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 0.9})
+	chain := ScenarioChain{
+		MinConfidence: 0.5,
+		Detectors: []ScenarioDetector{
+			mockScenarioDetector(t, "undecided", model.ScenarioResult{}),
+			mockScenarioDetector(t, "first", model.ScenarioResult{Scenario: model.ScenarioViaProxy, Confidence: 0.7}),
+			later,
+		},
+	}
+
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioViaProxy, Confidence: 0.7, Detector: "first"}, chain.DetectScenario(ctx))
+	later.AssertNotCalled(t, "DetectScenario", mock.Anything)
+}
+
+func TestMostConfidentDetectorWinsBelowMinConfidence(t *testing.T) {
+	chain := ScenarioChain{
+		MinConfidence: 0.9,
+		Detectors: []ScenarioDetector{
+			mockScenarioDetector(t, "weak", model.ScenarioResult{Scenario: model.ScenarioViaProxy, Confidence: 0.3}),
+			mockScenarioDetector(t, "stronger", model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 0.6}),
+		},
+	}
+
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 0.6, Detector: "stronger"}, chain.DetectScenario(ctx))
+}
+
+func TestOfflineWhenNoDetectorDecides(t *testing.T) {
+	chain := ScenarioChain{
+		MinConfidence: 0.5,
+		Detectors: []ScenarioDetector{
+			mockScenarioDetector(t, "undecided", model.ScenarioResult{}),
+		},
+	}
+
+	assert.Equal(t, model.ScenarioOffline, chain.DetectScenario(ctx).Scenario)
+}
+
+// a VPN adapter means split tunnel, the office Wi-Fi proxy and the home Wi-Fi direct access
+func detectorsWithConfiguredScenarios() []ScenarioDetector {
+	return []ScenarioDetector{
+		&VpnAdapterDetector{Adapters: []string{"AnyConnect"}, Scenario: model.ScenarioSplitTunnel, WindowsChecker: mockWinChecker},
+		&SsidDetector{Ssids: []string{"Corp-WiFi"}, Scenario: model.ScenarioViaProxy, WindowsChecker: mockWinChecker},
+		&SsidDetector{Ssids: []string{"Home-WiFi"}, Scenario: model.ScenarioDirect, WindowsChecker: mockWinChecker},
+	}
+}
+
+func TestChainPicksSplitTunnelForVpnAdapter(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("NetworkAdapters", mock.Anything).Return([]string{"Cisco AnyConnect Secure Mobility Client"})
+	chain := ScenarioChain{MinConfidence: 0.5, Detectors: detectorsWithConfiguredScenarios()}
+
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioSplitTunnel, Confidence: 0.9, Detector: DetectorVpnAdapter}, chain.DetectScenario(ctx))
+}
+
+func TestChainPicksDirectForHomeWifi(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("NetworkAdapters", mock.Anything).Return([]string{"Wi-Fi"})
+	mockWinChecker.On("WifiSsids", mock.Anything).Return([]string{"Home-WiFi"})
+	chain := ScenarioChain{MinConfidence: 0.5, Detectors: detectorsWithConfiguredScenarios()}
+
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 0.7, Detector: DetectorSsid}, chain.DetectScenario(ctx))
+}
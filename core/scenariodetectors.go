@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"org.samba/isetta/core/model"
+	log "org.samba/isetta/simplelogger"
+)
+
+// names of the detectors, used to select and order them in the config
+const (
+	DetectorOverride    = "override"
+	DetectorDnsSuffix   = "dns_suffix"
+	DetectorVpnAdapter  = "vpn_adapter"
+	DetectorSsid        = "ssid"
+	DetectorIntranetUrl = "intranet_url"
+	DetectorDns         = "dns"
+	// not configurable, always asked first
+	DetectorPinned = "pinned"
+)
+
+// explicit choice of the user
+type OverrideDetector struct {
+	Scenario string // empty if there is no override
+}
+
+func (d *OverrideDetector) Name() string {
+	return DetectorOverride
+}
+
+func (d *OverrideDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	if d.Scenario == "" {
+		return model.ScenarioResult{}
+	}
+	scenario, err := model.ParseScenario(d.Scenario)
+	if err != nil {
+		log.FromContext(ctx).Warn("Ignoring scenario override: %v", err)
+		return model.ScenarioResult{}
+	}
+	return model.ScenarioResult{Scenario: scenario, Confidence: 1}
+}
+
+// scenario pinned via 'isetta use'
+type PinnedScenarioDetector struct {
+	Store OverrideStore
+}
+
+func (d *PinnedScenarioDetector) Name() string {
+	return DetectorPinned
+}
+
+func (d *PinnedScenarioDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	override, found, err := d.Store.Load()
+	if err != nil {
+		log.FromContext(ctx).Warn("Ignoring pinned scenario: %v", err)
+		return model.ScenarioResult{}
+	}
+	if !found {
+		return model.ScenarioResult{}
+	}
+	if !override.ActiveAt(time.Now()) {
+		log.FromContext(ctx).Debug("Pinned scenario %v expired", override)
+		return model.ScenarioResult{}
+	}
+
+	// shown on every run, so nobody forgets about it
+	log.FromContext(ctx).Warn("Scenario is pinned to %v. Run 'isetta use auto' to detect it again", override)
+	return model.ScenarioResult{Scenario: override.Scenario, Confidence: 1}
+}
+
+// The following detectors recognize the corporate network. If they do, they
+// report their configured scenario, e.g. proxy on the office Wi-Fi and
+// split-tunnel for the VPN adapter. If they don't, they are undecided since the
+// machine might still be connected to it.
+
+// connection specific DNS suffix of a Windows network adapter, e.g. corp.example.com
+type DnsSuffixDetector struct {
+	Suffixes       []string
+	Scenario       model.Scenario // reported on a match
+	WindowsChecker WindowsChecker
+}
+
+func (d *DnsSuffixDetector) Name() string {
+	return DetectorDnsSuffix
+}
+
+func (d *DnsSuffixDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	for _, actual := range d.WindowsChecker.DnsSuffixes(ctx) {
+		if matchesAny(actual, d.Suffixes, strings.HasSuffix) {
+			log.FromContext(ctx).Debug("Found corporate DNS suffix %v", actual)
+			return model.ScenarioResult{Scenario: d.Scenario, Confidence: 0.8}
+		}
+	}
+	return model.ScenarioResult{}
+}
+
+// VPN adapter which is up, matched by (parts of) its name or description
+type VpnAdapterDetector struct {
+	Adapters       []string
+	Scenario       model.Scenario // reported on a match
+	WindowsChecker WindowsChecker
+}
+
+func (d *VpnAdapterDetector) Name() string {
+	return DetectorVpnAdapter
+}
+
+func (d *VpnAdapterDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	for _, actual := range d.WindowsChecker.NetworkAdapters(ctx) {
+		if matchesAny(actual, d.Adapters, strings.Contains) {
+			log.FromContext(ctx).Debug("Found VPN adapter %v", actual)
+			return model.ScenarioResult{Scenario: d.Scenario, Confidence: 0.9}
+		}
+	}
+	return model.ScenarioResult{}
+}
+
+// corporate Wi-Fi
+type SsidDetector struct {
+	Ssids          []string
+	Scenario       model.Scenario // reported on a match
+	WindowsChecker WindowsChecker
+}
+
+func (d *SsidDetector) Name() string {
+	return DetectorSsid
+}
+
+func (d *SsidDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	for _, actual := range d.WindowsChecker.WifiSsids(ctx) {
+		if matchesAny(actual, d.Ssids, strings.EqualFold) {
+			log.FromContext(ctx).Debug("Connected to corporate Wi-Fi %v", actual)
+			return model.ScenarioResult{Scenario: d.Scenario, Confidence: 0.7}
+		}
+	}
+	return model.ScenarioResult{}
+}
+
+// intranet URL which can be reached from Windows
+type IntranetUrlDetector struct {
+	Url            string
+	Scenario       model.Scenario // reported on a match
+	WindowsChecker WindowsChecker
+}
+
+func (d *IntranetUrlDetector) Name() string {
+	return DetectorIntranetUrl
+}
+
+func (d *IntranetUrlDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	if d.Url != "" && d.WindowsChecker.IsUrlReachable(ctx, d.Url) {
+		log.FromContext(ctx).Debug("Intranet URL %v is reachable", d.Url)
+		return model.ScenarioResult{Scenario: d.Scenario, Confidence: 0.9}
+	}
+	return model.ScenarioResult{}
+}
+
+// Test-NetConnection only gives up after about 20s if the connection is
+// blocked, e.g. in the proxy scenario. Includes the start of PowerShell
+const defaultDirectAccessProbeTimeout = 5 * time.Second
+
+// The original detection: a reachable internal DNS server decides for the proxy
+// scenario, a reachable public DNS server for direct access. Always decides.
+// With SplitTunnel, direct internet access next to a reachable internal DNS
+// server decides for the split tunnel scenario.
+type DnsDetector struct {
+	InternalDnsServer string
+	PublicDnsServer   string
+	SplitTunnel       bool
+	PublicHost        string // probed for direct internet access from Windows
+	PublicPort        int
+	// bounds the direct access probe, default: defaultDirectAccessProbeTimeout
+	DirectAccessProbeTimeout time.Duration
+	WindowsChecker           WindowsChecker
+}
+
+func (d *DnsDetector) Name() string {
+	return DetectorDns
+}
+
+func (d *DnsDetector) DetectScenario(ctx context.Context) model.ScenarioResult {
+	// there is no need to wait for the public DNS server if the internal one is reachable
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	internalDnsPingable := probe(func() bool { return d.WindowsChecker.IsPingable(ctx, d.InternalDnsServer) })
+	publicDnsPingable := probe(func() bool { return d.WindowsChecker.IsPingable(ctx, d.PublicDnsServer) })
+	directInternetAccess := probe(func() bool {
+		if !d.SplitTunnel {
+			return false
+		}
+		ctx, cancel := context.WithTimeout(ctx, d.directAccessProbeTimeout())
+		defer cancel()
+		return d.WindowsChecker.IsTcpPortOpen(ctx, d.PublicHost, d.PublicPort)
+	})
+
+	if <-internalDnsPingable {
+		log.FromContext(ctx).Debug("Internal DNS server is reachable")
+		if <-directInternetAccess {
+			log.FromContext(ctx).Debug("%v:%v is directly reachable", d.PublicHost, d.PublicPort)
+			return model.ScenarioResult{Scenario: model.ScenarioSplitTunnel, Confidence: 0.9}
+		}
+		return model.ScenarioResult{Scenario: model.ScenarioViaProxy, Confidence: 0.9}
+	} else if <-publicDnsPingable {
+		log.FromContext(ctx).Debug("Public DNS server is reachable")
+		return model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 0.8}
+	}
+	return model.ScenarioResult{Scenario: model.ScenarioOffline, Confidence: 0.6}
+}
+
+func (d *DnsDetector) directAccessProbeTimeout() time.Duration {
+	if d.DirectAccessProbeTimeout > 0 {
+		return d.DirectAccessProbeTimeout
+	}
+	return defaultDirectAccessProbeTimeout
+}
+
+// case-insensitive
+func matchesAny(actual string, wanted []string, match func(string, string) bool) bool {
+	for _, w := range wanted {
+		if match(strings.ToLower(actual), strings.ToLower(w)) {
+			return true
+		}
+	}
+	return false
+}
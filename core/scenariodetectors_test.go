@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+func TestOverrideDetector(t *testing.T) {
+	assert.False(t, (&OverrideDetector{}).DetectScenario(ctx).Decided())
+	assert.False(t, (&OverrideDetector{Scenario: "moon"}).DetectScenario(ctx).Decided())
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 1},
+		(&OverrideDetector{Scenario: "direct"}).DetectScenario(ctx))
+}
+
+func TestDnsSuffixDetector(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("DnsSuffixes", mock.Anything).Return([]string{"fritz.box", "EMEA.Corp.Example.com"})
+	detector := DnsSuffixDetector{Suffixes: []string{"corp.example.com"}, Scenario: model.ScenarioViaProxy, WindowsChecker: mockWinChecker}
+
+	assert.Equal(t, model.ScenarioViaProxy, detector.DetectScenario(ctx).Scenario)
+}
+
+func TestVpnAdapterDetector(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("NetworkAdapters", mock.Anything).Return([]string{"Wi-Fi", "Intel(R) Wi-Fi 6 AX201"})
+	detector := VpnAdapterDetector{Adapters: []string{"AnyConnect"}, WindowsChecker: mockWinChecker}
+
+	assert.False(t, detector.DetectScenario(ctx).Decided())
+}
+
+func TestSsidDetector(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("WifiSsids", mock.Anything).Return([]string{"Corp-WiFi"})
+	detector := SsidDetector{Ssids: []string{"corp-wifi"}, Scenario: model.ScenarioViaProxy, WindowsChecker: mockWinChecker}
+
+	assert.Equal(t, model.ScenarioViaProxy, detector.DetectScenario(ctx).Scenario)
+}
+
+func TestIntranetUrlDetector(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("IsUrlReachable", mock.Anything, "https://intranet.corp/").Return(false)
+	detector := IntranetUrlDetector{Url: "https://intranet.corp/", WindowsChecker: mockWinChecker}
+
+	assert.False(t, detector.DetectScenario(ctx).Decided())
+}
+
+func TestDnsDetectorDetectsSplitTunnel(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("IsPingable", mock.Anything, "42.42.42.42").Return(true)
+	mockWinChecker.On("IsPingable", mock.Anything, "8.8.8.8").Return(true).Maybe()
+	mockWinChecker.On("IsTcpPortOpen", mock.Anything, "www.google.com", 443).Return(true)
+	detector := DnsDetector{
+		InternalDnsServer: "42.42.42.42",
+		PublicDnsServer:   "8.8.8.8",
+		SplitTunnel:       true,
+		PublicHost:        "www.google.com",
+		PublicPort:        443,
+		WindowsChecker:    mockWinChecker,
+	}
+
+	assert.Equal(t, model.ScenarioSplitTunnel, detector.DetectScenario(ctx).Scenario)
+}
+
+func TestDnsDetectorDoesNotWaitForBlockedDirectAccess(t *testing.T) {
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockWinChecker.On("IsPingable", mock.Anything, "42.42.42.42").Return(true)
+	mockWinChecker.On("IsPingable", mock.Anything, "8.8.8.8").Return(false).Maybe()
+	// like Test-NetConnection in the proxy scenario, only stops when aborted
+	mockWinChecker.On("IsTcpPortOpen", mock.Anything, "www.google.com", 443).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(false)
+	detector := DnsDetector{
+		InternalDnsServer:        "42.42.42.42",
+		PublicDnsServer:          "8.8.8.8",
+		SplitTunnel:              true,
+		PublicHost:               "www.google.com",
+		PublicPort:               443,
+		DirectAccessProbeTimeout: 50 * time.Millisecond,
+		WindowsChecker:           mockWinChecker,
+	}
+
+	start := time.Now()
+	assert.Equal(t, model.ScenarioViaProxy, detector.DetectScenario(ctx).Scenario)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPinnedScenarioDetector(t *testing.T) {
+	store := mocks.NewOverrideStore(t)
+	detector := PinnedScenarioDetector{Store: store}
+
+	store.On("Load").Return(model.Override{Scenario: model.ScenarioDirect, Until: time.Now().Add(time.Hour)}, true, nil).Once()
+	assert.Equal(t, model.ScenarioResult{Scenario: model.ScenarioDirect, Confidence: 1}, detector.DetectScenario(ctx))
+
+	store.On("Load").Return(model.Override{Scenario: model.ScenarioDirect, Until: time.Now().Add(-time.Hour)}, true, nil).Once()
+	assert.False(t, detector.DetectScenario(ctx).Decided())
+}
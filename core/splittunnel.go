@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
+)
+
+// VPN with split tunnel: the internal subnets are routed via the P2P interface
+// to Windows and from there into the VPN. The internet is accessed directly
+// via the default gateway of WSL, no proxy involved.
+type SplitTunnel struct {
+	LinuxP2pIp        string
+	WindowsP2pIp      string
+	InternalDnsServer string
+	InternalCidrs     []string
+	IntranetTestUrl   string
+	WindowsChecker    WindowsChecker
+	WindowsConfigurer WindowsConfigurer
+	DnsConfigurer     DnsConfigurer
+	LinuxPinger       LinuxPinger
+	LinuxConfigurer   LinuxConfigurer
+	HttpChecker       HttpChecker
+	EnvVarPrinter     EnvVarPrinter
+}
+
+func (s *SplitTunnel) Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Configuring split tunnel").End()
+
+	err := s.activateDnsServer(ctx, tx)
+	if err != nil {
+		return err
+	}
+	s.EnvVarPrinter.WarnIfProxyVarSet(ctx)
+
+	err = s.setupLinuxP2pInterfaceIfNeeded(ctx, snapshot, tx)
+	if err != nil {
+		return err
+	}
+
+	if !snapshot.WindowsP2pIpUp && !s.isWindowsP2pIpUp(ctx) {
+		err = s.addWindowsP2pAddress(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.routeInternalCidrs(ctx, snapshot, tx)
+	if err != nil {
+		return err
+	}
+
+	// without the Windows P2P address, the intranet can't be reached yet
+	if tx.HasDeferred() {
+		progress.EmitMessage("Done setting up the Linux side, the Windows side is deferred")
+		return nil
+	}
+	return s.checkAccess(ctx)
+}
+
+func (s *SplitTunnel) activateDnsServer(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Activating internal DNS server").End()
+	err := registerResolvConfRollback(ctx, tx, s.DnsConfigurer)
+	if err != nil {
+		return err
+	}
+	return s.DnsConfigurer.ActivateDnsServer(ctx, s.InternalDnsServer)
+}
+
+func (s *SplitTunnel) setupLinuxP2pInterfaceIfNeeded(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	if snapshot.LinuxP2pIpUp {
+		return nil
+	}
+
+	defer timing.Start("Setting up Linux P2P interface").End()
+	log.FromContext(ctx).Debug("Adding address %v to Linux", s.LinuxP2pIp)
+	tx.OnRollback(fmt.Sprintf("removing Linux P2P address %v", s.LinuxP2pIp), s.LinuxConfigurer.RemoveP2pInterface)
+	err := s.LinuxConfigurer.SetP2pInterface(ctx)
+	if err != nil {
+		return err
+	}
+
+	// post condition
+	if !s.LinuxPinger.Ping(ctx, s.LinuxP2pIp) {
+		return fmt.Errorf("failed to add P2P address %v to Linux", s.LinuxP2pIp)
+	}
+	return nil
+}
+
+func (s *SplitTunnel) isWindowsP2pIpUp(ctx context.Context) bool {
+	if s.LinuxPinger.Ping(ctx, s.WindowsP2pIp) {
+		log.FromContext(ctx).Debug("Windows P2P address %v is up", s.WindowsP2pIp)
+		return true
+	}
+	log.FromContext(ctx).Debug("Windows P2P address %v is not up", s.WindowsP2pIp)
+	return false
+}
+
+// temporary Windows resources are cleaned up even if the context is done meanwhile
+func (s *SplitTunnel) addWindowsP2pAddress(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Configuring Windows side").End()
+	defer s.WindowsConfigurer.Cleanup(ctx)
+	deferred, err := initWindowsSide(ctx, s.WindowsConfigurer, tx, "adding the Windows P2P address")
+	if err != nil || deferred {
+		return err
+	}
+
+	log.FromContext(ctx).Debug("Adding Windows P2p address %v", s.WindowsP2pIp)
+	registerWindowsP2pAddressRollback(ctx, tx, s.WindowsChecker, s.WindowsConfigurer, s.WindowsP2pIp)
+	return s.WindowsConfigurer.AddP2pAddress(ctx, func() bool { return s.LinuxPinger.Ping(ctx, s.WindowsP2pIp) })
+}
+
+// The default gateway stays as it is, only the internal subnets go to Windows.
+// AddRoute replaces an existing route, e.g. of the VPN, it is restored on rollback
+func (s *SplitTunnel) routeInternalCidrs(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	if snapshot.InternalDnsServerUp && len(s.InternalCidrs) == 0 {
+		return nil
+	}
+
+	defer timing.Start("Routing internal subnets").End()
+	for _, cidr := range s.InternalCidrs {
+		cidr := cidr
+		log.FromContext(ctx).Debug("Routing %v via %v", cidr, s.WindowsP2pIp)
+		err := registerRouteRollback(ctx, tx, s.LinuxConfigurer, cidr)
+		if err != nil {
+			return err
+		}
+		err = s.LinuxConfigurer.AddRoute(ctx, cidr, s.WindowsP2pIp)
+		if err != nil {
+			return err
+		}
+	}
+
+	// post condition, the routes lead to the Windows P2P address
+	if tx.HasDeferred() {
+		return nil
+	}
+	if !s.LinuxPinger.Ping(ctx, s.InternalDnsServer) {
+		return fmt.Errorf("internal DNS server %v can't be reached from within Linux. Is it part of the internal subnets?", s.InternalDnsServer)
+	}
+	return nil
+}
+
+// both, the intranet and the internet must work
+func (s *SplitTunnel) checkAccess(ctx context.Context) error {
+	defer timing.Start("Checking split tunnel access").End()
+	if s.IntranetTestUrl != "" && !s.HttpChecker.IsUrlReachable(ctx, s.IntranetTestUrl) {
+		return fmt.Errorf("failed setting up WSL network for split tunnel, intranet URL %v is not reachable", s.IntranetTestUrl)
+	}
+	if !s.HttpChecker.HasDirectInternetAccess(ctx) {
+		return errors.New("failed setting up WSL network for split tunnel, no direct internet access")
+	}
+	progress.EmitMessage("Done setting up WSL network for split tunnel")
+	return nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/mocks"
+)
+
+var splitTunnel SplitTunnel
+
+func setupSplitTunnel(t *testing.T) {
+	mockWinConfigurer = mocks.NewWindowsConfigurer(t)
+	mockWinChecker = mocks.NewWindowsChecker(t)
+	mockDnsConfigurer = mocks.NewDnsConfigurer(t)
+	mockLinuxPinger = mocks.NewLinuxPinger(t)
+	mockLinuxConfigurer = mocks.NewLinuxConfigurer(t)
+	mockHttpChecker = mocks.NewHttpChecker(t)
+	mockEnvVarPrinter = mocks.NewEnvVarPrinter(t)
+
+	splitTunnel = SplitTunnel{
+		LinuxP2pIp:        "192.168.99.2",
+		WindowsP2pIp:      "192.168.99.1",
+		InternalDnsServer: "42.42.42.42",
+		InternalCidrs:     []string{"10.0.0.0/8", "42.42.42.42/32"},
+		IntranetTestUrl:   "https://intranet.corp/",
+		WindowsChecker:    mockWinChecker,
+		WindowsConfigurer: mockWinConfigurer,
+		DnsConfigurer:     mockDnsConfigurer,
+		LinuxPinger:       mockLinuxPinger,
+		LinuxConfigurer:   mockLinuxConfigurer,
+		HttpChecker:       mockHttpChecker,
+		EnvVarPrinter:     mockEnvVarPrinter,
+	}
+
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "42.42.42.42").Return(nil)
+	mockEnvVarPrinter.On("WarnIfProxyVarSet", mock.Anything).Return()
+}
+
+func TestConfigureSplitTunnel(t *testing.T) {
+	setupSplitTunnel(t)
+	mockLinuxConfigurer.On("Route", mock.Anything, mock.Anything).Return(model.Route{}, nil)
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, "10.0.0.0/8", "192.168.99.1").Return(nil)
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, "42.42.42.42/32", "192.168.99.1").Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(true)
+	mockHttpChecker.On("IsUrlReachable", mock.Anything, "https://intranet.corp/").Return(true)
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
+
+	tx := &model.Transaction{}
+	snapshot := model.Snapshot{Scenario: model.ScenarioSplitTunnel, LinuxP2pIpUp: true, WindowsP2pIpUp: true}
+	assert.NoError(t, splitTunnel.Configure(ctx, snapshot, tx))
+	// resolv.conf and both routes
+	assert.Equal(t, 3, tx.Len())
+}
+
+func TestSplitTunnelAddsP2pAddresses(t *testing.T) {
+	setupSplitTunnel(t)
+	splitTunnel.InternalCidrs = nil
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "192.168.99.2").Return(true)
+	mockLinuxPinger.On("Ping", mock.Anything, "192.168.99.1").Return(false).Once()
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "192.168.99.1").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(true)
+	mockHttpChecker.On("IsUrlReachable", mock.Anything, "https://intranet.corp/").Return(true)
+	mockHttpChecker.On("HasDirectInternetAccess", mock.Anything).Return(true)
+
+	assert.NoError(t, splitTunnel.Configure(ctx, model.Snapshot{Scenario: model.ScenarioSplitTunnel}, &model.Transaction{}))
+}
+
+func TestSplitTunnelFailsWithoutIntranetAccess(t *testing.T) {
+	setupSplitTunnel(t)
+	mockLinuxConfigurer.On("Route", mock.Anything, mock.Anything).Return(model.Route{}, nil)
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, mock.Anything, "192.168.99.1").Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(true)
+	mockHttpChecker.On("IsUrlReachable", mock.Anything, "https://intranet.corp/").Return(false)
+
+	snapshot := model.Snapshot{Scenario: model.ScenarioSplitTunnel, LinuxP2pIpUp: true, WindowsP2pIpUp: true}
+	assert.ErrorContains(t, splitTunnel.Configure(ctx, snapshot, &model.Transaction{}), "intranet URL")
+}
+
+func TestSplitTunnelRoutesAreDeletedOnRollback(t *testing.T) {
+	setupSplitTunnel(t)
+	splitTunnel.InternalCidrs = []string{"10.0.0.0/8"}
+	mockLinuxConfigurer.On("Route", mock.Anything, "10.0.0.0/8").Return(model.Route{}, nil)
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, "10.0.0.0/8", "192.168.99.1").Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false)
+	mockLinuxConfigurer.On("RestoreRoute", mock.Anything, "10.0.0.0/8", model.Route{}).Return(nil)
+	mockDnsConfigurer.On("RestoreFile", mock.Anything, mock.Anything).Return(nil)
+
+	tx := &model.Transaction{}
+	snapshot := model.Snapshot{Scenario: model.ScenarioSplitTunnel, LinuxP2pIpUp: true, WindowsP2pIpUp: true}
+	assert.Error(t, splitTunnel.Configure(ctx, snapshot, tx))
+	assert.NoError(t, tx.Rollback(context.Background()))
+}
+
+func TestExistingRouteIsRestoredOnRollback(t *testing.T) {
+	setupSplitTunnel(t)
+	splitTunnel.InternalCidrs = []string{"10.0.0.0/8"}
+	vpnRoute := model.Route{Cidr: "10.0.0.0/8", Gateway: "172.28.64.1", InterfaceIndex: 2}
+	mockLinuxConfigurer.On("Route", mock.Anything, "10.0.0.0/8").Return(vpnRoute, nil)
+	mockLinuxConfigurer.On("AddRoute", mock.Anything, "10.0.0.0/8", "192.168.99.1").Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false)
+	mockLinuxConfigurer.On("RestoreRoute", mock.Anything, "10.0.0.0/8", vpnRoute).Return(nil)
+	mockDnsConfigurer.On("RestoreFile", mock.Anything, mock.Anything).Return(nil)
+
+	tx := &model.Transaction{}
+	snapshot := model.Snapshot{Scenario: model.ScenarioSplitTunnel, LinuxP2pIpUp: true, WindowsP2pIpUp: true}
+	assert.Error(t, splitTunnel.Configure(ctx, snapshot, tx))
+	assert.NoError(t, tx.Rollback(context.Background()))
+}
@@ -1,10 +1,14 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/progress"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
 type ViaProxy struct {
@@ -20,31 +24,44 @@ type ViaProxy struct {
 	HttpChecker       HttpChecker
 }
 
-func (p *ViaProxy) Configure() error {
-	err := p.checkPxProxyRunning()
+// Positive results of the snapshot are trusted. Negative results of checks which
+// might have been fixed by an earlier configuration step are probed again.
+func (p *ViaProxy) Configure(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Configuring access via proxy").End()
+
+	err := p.checkPxProxyRunning(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = p.activateDnsServer(ctx, tx)
 	if err != nil {
 		return err
 	}
 
-	p.DnsConfigurer.ActivateDnsServer(p.InternalDnsServer)
-	err = p.setupLinuxP2pInterfaceIfNeeded()
+	err = p.setupLinuxP2pInterfaceIfNeeded(ctx, snapshot, tx)
 	if err != nil {
 		return err
 	}
 
-	if !p.isWindowsSideOk() {
-		err := p.configureWindowsSide()
+	if !p.isWindowsSideOk(ctx, snapshot) {
+		err := p.configureWindowsSide(ctx, tx)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = p.configureDefaultGatewayIfNeeded()
+	err = p.configureDefaultGatewayIfNeeded(ctx, snapshot, tx)
 	if err != nil {
 		return err
 	}
 
-	err = p.checkAccessViaProxy()
+	// without the Windows side, the proxy can't be reached yet
+	if tx.HasDeferred() {
+		progress.EmitMessage("Done setting up the Linux side, the Windows side is deferred")
+		return nil
+	}
+	err = p.checkAccessViaProxy(ctx)
 	if err != nil {
 		return err
 	}
@@ -53,9 +70,9 @@ func (p *ViaProxy) Configure() error {
 }
 
 // directly check on Windows if PX proxy is running at all
-func (p *ViaProxy) checkPxProxyRunning() error {
-	if p.WindowsChecker.IsPxProxyRunning() {
-		log.Logger.Debug("PX proxy is running on Windows port %v", p.PxProxyPort)
+func (p *ViaProxy) checkPxProxyRunning(ctx context.Context, snapshot model.Snapshot) error {
+	if snapshot.PxProxyRunning {
+		log.FromContext(ctx).Debug("PX proxy is running on Windows port %v", p.PxProxyPort)
 		return nil
 	} else {
 		msg := fmt.Sprintf("Error: PX proxy is not running on Windows port %v", p.PxProxyPort)
@@ -63,13 +80,27 @@ func (p *ViaProxy) checkPxProxyRunning() error {
 	}
 }
 
-func (p *ViaProxy) setupLinuxP2pInterfaceIfNeeded() error {
-	if !p.isLinuxP2pIpUp() {
-		log.Logger.Debug("Adding address %v to Linux", p.LinuxP2pIp)
-		p.LinuxConfigurer.SetP2pInterface()
+func (p *ViaProxy) activateDnsServer(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Activating internal DNS server").End()
+	err := registerResolvConfRollback(ctx, tx, p.DnsConfigurer)
+	if err != nil {
+		return err
+	}
+	return p.DnsConfigurer.ActivateDnsServer(ctx, p.InternalDnsServer)
+}
+
+func (p *ViaProxy) setupLinuxP2pInterfaceIfNeeded(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	defer timing.Start("Setting up Linux P2P interface").End()
+	if !snapshot.LinuxP2pIpUp {
+		log.FromContext(ctx).Debug("Adding address %v to Linux", p.LinuxP2pIp)
+		tx.OnRollback(fmt.Sprintf("removing Linux P2P address %v", p.LinuxP2pIp), p.LinuxConfigurer.RemoveP2pInterface)
+		err := p.LinuxConfigurer.SetP2pInterface(ctx)
+		if err != nil {
+			return err
+		}
 
 		// post condition
-		if !p.isLinuxP2pIpUp() {
+		if !p.isLinuxP2pIpUp(ctx) {
 			return fmt.Errorf("failed to add P2P address %v to Linux", p.LinuxP2pIp)
 		}
 	}
@@ -77,54 +108,71 @@ func (p *ViaProxy) setupLinuxP2pInterfaceIfNeeded() error {
 	return nil
 }
 
-func (p *ViaProxy) isLinuxP2pIpUp() bool {
-	if p.LinuxPinger.Ping(p.LinuxP2pIp) {
-		log.Logger.Debug("Linux P2P address %v is up", p.LinuxP2pIp)
+func (p *ViaProxy) isLinuxP2pIpUp(ctx context.Context) bool {
+	if p.LinuxPinger.Ping(ctx, p.LinuxP2pIp) {
+		log.FromContext(ctx).Debug("Linux P2P address %v is up", p.LinuxP2pIp)
 		return true
 	} else {
-		log.Logger.Debug("Linux P2P address %v is not up", p.LinuxP2pIp)
+		log.FromContext(ctx).Debug("Linux P2P address %v is not up", p.LinuxP2pIp)
 		return false
 	}
 }
 
-func (p *ViaProxy) isWindowsSideOk() bool {
-	return p.isWindowsP2pIpUp() && p.IsPxProxyReachable()
+func (p *ViaProxy) isWindowsSideOk(ctx context.Context, snapshot model.Snapshot) bool {
+	if snapshot.WindowsP2pIpUp && snapshot.PxProxyReachable {
+		log.FromContext(ctx).Debug("Windows P2P address %v is up and Px proxy is reachable", p.WindowsP2pIp)
+		return true
+	}
+
+	// Windows P2P address can only be reached once the Linux P2P address is up
+	defer timing.Start("Checking Windows side").End()
+	return p.isWindowsP2pIpUp(ctx) && p.IsPxProxyReachable(ctx)
 }
 
-func (p *ViaProxy) isWindowsP2pIpUp() bool {
-	if p.LinuxPinger.Ping(p.WindowsP2pIp) {
-		log.Logger.Debug("Windows P2P address %v is up", p.WindowsP2pIp)
+func (p *ViaProxy) isWindowsP2pIpUp(ctx context.Context) bool {
+	if p.LinuxPinger.Ping(ctx, p.WindowsP2pIp) {
+		log.FromContext(ctx).Debug("Windows P2P address %v is up", p.WindowsP2pIp)
 		return true
 	} else {
-		log.Logger.Debug("Windows P2P address %v is not up", p.WindowsP2pIp)
+		log.FromContext(ctx).Debug("Windows P2P address %v is not up", p.WindowsP2pIp)
 		return false
 	}
 }
 
-func (p *ViaProxy) IsPxProxyReachable() bool {
-	if p.HttpChecker.IsPxProxyReachable() {
-		log.Logger.Debug("Px Proxy is reachable from within Linux")
+func (p *ViaProxy) IsPxProxyReachable(ctx context.Context) bool {
+	if p.HttpChecker.IsPxProxyReachable(ctx) {
+		log.FromContext(ctx).Debug("Px Proxy is reachable from within Linux")
 		return true
 	} else {
-		log.Logger.Debug("Px Proxy not reachable from Linux side")
+		log.FromContext(ctx).Debug("Px Proxy not reachable from Linux side")
 		return false
 	}
 }
 
-func (p *ViaProxy) configureWindowsSide() error {
-	p.WindowsConfigurer.Init()
-	defer p.WindowsConfigurer.Cleanup()
+// temporary Windows resources are cleaned up even if the context is done meanwhile
+func (p *ViaProxy) configureWindowsSide(ctx context.Context, tx *model.Transaction) error {
+	defer timing.Start("Configuring Windows side").End()
+	defer p.WindowsConfigurer.Cleanup(ctx)
+	deferred, err := initWindowsSide(ctx, p.WindowsConfigurer, tx, "adding the Windows P2P address and the portproxy")
+	if err != nil || deferred {
+		return err
+	}
 
-	log.Logger.Debug("Adding Windows P2p address %v", p.WindowsP2pIp)
-	windowsIpReachableFromWslChecker := func() bool { return p.LinuxPinger.Ping(p.WindowsP2pIp) }
-	err := p.WindowsConfigurer.AddP2pAddress(windowsIpReachableFromWslChecker)
+	log.FromContext(ctx).Debug("Adding Windows P2p address %v", p.WindowsP2pIp)
+	registerWindowsP2pAddressRollback(ctx, tx, p.WindowsChecker, p.WindowsConfigurer, p.WindowsP2pIp)
+	windowsIpReachableFromWslChecker := func() bool { return p.LinuxPinger.Ping(ctx, p.WindowsP2pIp) }
+	err = p.WindowsConfigurer.AddP2pAddress(ctx, windowsIpReachableFromWslChecker)
 	if err != nil {
 		return err
 	}
 
-	err = p.WindowsConfigurer.SetPortProxy(func() bool {
-        return p.HttpChecker.HasInternetAccessViaProxy()
-    })
+	err = registerPortProxyRollback(ctx, tx, p.WindowsChecker, p.WindowsConfigurer, p.WindowsP2pIp, p.PxProxyPort)
+	if err != nil {
+		return err
+	}
+	err = p.WindowsConfigurer.SetPortProxy(ctx, func() bool {
+		return p.HttpChecker.HasInternetAccessViaProxy(ctx)
+	})
 
 	if err != nil {
 		return err
@@ -133,13 +181,30 @@ func (p *ViaProxy) configureWindowsSide() error {
 	return nil
 }
 
-func (p *ViaProxy) configureDefaultGatewayIfNeeded() error {
-	if !p.isInternalDnsServerUp() {
-		log.Logger.Debug("Configuring default gateway")
-		p.LinuxConfigurer.DeleteDefaultGateway()
-		p.LinuxConfigurer.AddDefaultGateway()
+func (p *ViaProxy) configureDefaultGatewayIfNeeded(ctx context.Context, snapshot model.Snapshot, tx *model.Transaction) error {
+	if snapshot.InternalDnsServerUp {
+		log.FromContext(ctx).Debug("Internal DNS %v can be reached from within Linux. Default gateway works", p.InternalDnsServer)
+		return nil
+	}
+
+	defer timing.Start("Checking default gateway").End()
+	if !p.isInternalDnsServerUp(ctx) {
+		log.FromContext(ctx).Debug("Configuring default gateway")
+		err := registerDefaultGatewayRollback(ctx, tx, p.LinuxConfigurer)
+		if err != nil {
+			return err
+		}
+		p.LinuxConfigurer.DeleteDefaultGateway(ctx)
+		err = p.LinuxConfigurer.AddDefaultGateway(ctx)
+		if err != nil {
+			return err
+		}
 
-		if !p.isInternalDnsServerUp() {
+		// the gateway is the Windows P2P address, which is missing yet
+		if tx.HasDeferred() {
+			return nil
+		}
+		if !p.isInternalDnsServerUp(ctx) {
 			return errors.New("failed to adjust default gateway 🤔")
 		}
 	}
@@ -147,19 +212,20 @@ func (p *ViaProxy) configureDefaultGatewayIfNeeded() error {
 	return nil
 }
 
-func (p *ViaProxy) isInternalDnsServerUp() bool {
-	if p.LinuxPinger.Ping(p.InternalDnsServer) {
-		log.Logger.Debug("Internal DNS %v can be reached from within Linux. Default gateway works", p.InternalDnsServer)
+func (p *ViaProxy) isInternalDnsServerUp(ctx context.Context) bool {
+	if p.LinuxPinger.Ping(ctx, p.InternalDnsServer) {
+		log.FromContext(ctx).Debug("Internal DNS %v can be reached from within Linux. Default gateway works", p.InternalDnsServer)
 		return true
 	} else {
-		log.Logger.Debug("Internal DNS %v can't be reached from within Linux", p.InternalDnsServer)
+		log.FromContext(ctx).Debug("Internal DNS %v can't be reached from within Linux", p.InternalDnsServer)
 		return false
 	}
 }
 
-func (p *ViaProxy) checkAccessViaProxy() error {
-	if p.HttpChecker.HasInternetAccessViaProxy() {
-		log.Logger.Info("Done setting up Linux network via proxy")
+func (p *ViaProxy) checkAccessViaProxy(ctx context.Context) error {
+	defer timing.Start("Checking access via proxy").End()
+	if p.HttpChecker.HasInternetAccessViaProxy(ctx) {
+		progress.EmitMessage("Done setting up Linux network via proxy")
 		return nil
 	} else {
 		return errors.New("failed setting up Linux network via proxy")
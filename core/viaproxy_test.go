@@ -6,14 +6,15 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"org.samba/isetta/core/model"
 	"org.samba/isetta/mocks"
 )
 
 var viaProxy ViaProxy
 
 func setupViaProxy(t *testing.T) {
-	mockWinChecker = mocks.NewWindowsChecker(t)
 	mockWinConfigurer = mocks.NewWindowsConfigurer(t)
+	mockWinChecker = mocks.NewWindowsChecker(t)
 	mockHttpChecker = mocks.NewHttpChecker(t)
 	mockDnsConfigurer = mocks.NewDnsConfigurer(t)
 	mockEnvVarPrinter = mocks.NewEnvVarPrinter(t)
@@ -37,140 +38,231 @@ func setupViaProxy(t *testing.T) {
 func TestConfigureAccessViaProxy(t *testing.T) {
 	setupViaProxy(t)
 
-	// PX proxy is active on Windows
-	mockWinChecker.On("IsPxProxyRunning").Return(true)
-
 	// set internal DNS server in resolve.conf
-	mockDnsConfigurer.On("ActivateDnsServer", "42.42.42.42").Return()
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "42.42.42.42").Return(nil)
 
 	// Linux P2P IP is not up, but after SetP2pInterface was called, it will be up
-	mockLinuxPinger.On("Ping", "linux-ip").Return(false).Once()
-	mockLinuxConfigurer.On("SetP2pInterface").Return()
-	mockLinuxPinger.On("Ping", "linux-ip").Return(true).Once()
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "linux-ip").Return(true).Once()
 
 	// Windows IP can't be reached...
-	mockLinuxPinger.On("Ping", "windows-ip").Return(false)
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(false)
 
 	// ...need to configure Windows side
-	mockWinConfigurer.On("Init").Return()
-	mockWinConfigurer.On("Cleanup").Return()
-	mockWinConfigurer.On("AddP2pAddress", mock.Anything).Return(nil)
-	mockWinConfigurer.On("SetPortProxy", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil)
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(nil)
 
 	// internal DNS can't be reached, first need to configure
 	// default gateway. After that, it can be reached
-	mockLinuxPinger.On("Ping", "42.42.42.42").Return(false).Once()
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false).Once()
 	// default gateway on Linux side
-	mockLinuxConfigurer.On("DeleteDefaultGateway").Return()
-	mockLinuxConfigurer.On("AddDefaultGateway").Return()
-	mockLinuxPinger.On("Ping", "42.42.42.42").Return(true).Once()
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything).Return()
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(true).Once()
 
 	// cool, setup worked
-	mockHttpChecker.On("HasInternetAccessViaProxy").Return(true)
-	assert.NoError(t, viaProxy.Configure())
+	mockHttpChecker.On("HasInternetAccessViaProxy", mock.Anything).Return(true)
+
+	// PX proxy is active on Windows, nothing else works yet
+	assert.NoError(t, viaProxy.Configure(ctx, model.Snapshot{PxProxyRunning: true}, &model.Transaction{}))
 }
 
 func TestCheckHasAccessViaProxy(t *testing.T) {
 	setupViaProxy(t)
-	mockHttpChecker.On("HasInternetAccessViaProxy").Return(true)
-	assert.NoError(t, viaProxy.checkAccessViaProxy())
+	mockHttpChecker.On("HasInternetAccessViaProxy", mock.Anything).Return(true)
+	assert.NoError(t, viaProxy.checkAccessViaProxy(ctx))
 }
 
 func TestCheckHasNoAccessViaProxy(t *testing.T) {
 	setupViaProxy(t)
-	mockHttpChecker.On("HasInternetAccessViaProxy").Return(false)
-	assert.Error(t, viaProxy.checkAccessViaProxy())
+	mockHttpChecker.On("HasInternetAccessViaProxy", mock.Anything).Return(false)
+	assert.Error(t, viaProxy.checkAccessViaProxy(ctx))
 }
 
 func TestCheckPxProxyIsRunning(t *testing.T) {
 	setupViaProxy(t)
-	mockWinChecker.On("IsPxProxyRunning").Return(true)
-	assert.NoError(t, viaProxy.checkPxProxyRunning())
+	assert.NoError(t, viaProxy.checkPxProxyRunning(ctx, model.Snapshot{PxProxyRunning: true}))
 }
 
 func TestCheckPxProxyIsNotRunning(t *testing.T) {
 	setupViaProxy(t)
-	mockWinChecker.On("IsPxProxyRunning").Return(false)
-	assert.Error(t, viaProxy.checkPxProxyRunning())
+	assert.Error(t, viaProxy.checkPxProxyRunning(ctx, model.Snapshot{PxProxyRunning: false}))
 }
 
 func TestWindowsSideOk1(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "windows-ip").Return(true)
-	mockHttpChecker.On("IsPxProxyReachable").Return(true)
-	assert.Equal(t, true, viaProxy.isWindowsSideOk())
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(true)
+	mockHttpChecker.On("IsPxProxyReachable", mock.Anything).Return(true)
+	assert.Equal(t, true, viaProxy.isWindowsSideOk(ctx, model.Snapshot{}))
 }
 
 func TestWindowsSideOk2(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "windows-ip").Return(false)
-	assert.Equal(t, false, viaProxy.isWindowsSideOk())
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(false)
+	assert.Equal(t, false, viaProxy.isWindowsSideOk(ctx, model.Snapshot{}))
 }
 
 func TestWindowsSideOk3(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "windows-ip").Return(true)
-	mockHttpChecker.On("IsPxProxyReachable").Return(false)
-	assert.Equal(t, false, viaProxy.isWindowsSideOk())
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(true)
+	mockHttpChecker.On("IsPxProxyReachable", mock.Anything).Return(false)
+	assert.Equal(t, false, viaProxy.isWindowsSideOk(ctx, model.Snapshot{}))
 }
 
 func TestSetupWslPspInterfaceIsNotNeeded(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "linux-ip").Return(true)
-	viaProxy.setupLinuxP2pInterfaceIfNeeded()
+	viaProxy.setupLinuxP2pInterfaceIfNeeded(ctx, model.Snapshot{LinuxP2pIpUp: true}, &model.Transaction{})
 	mockLinuxConfigurer.AssertNotCalled(t, "SetP2pInterface")
 }
 
 func TestSetupWslPspInterfaceIfNeeded(t *testing.T) {
 	setupViaProxy(t)
 
-	mockLinuxPinger.On("Ping", "linux-ip").Return(false).Once()
-	mockLinuxConfigurer.On("SetP2pInterface").Return()
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(nil)
 	// SetP2pInterface fixed it, now ping is successful
-	mockLinuxPinger.On("Ping", "linux-ip").Return(true).Once()
-	viaProxy.setupLinuxP2pInterfaceIfNeeded()
+	mockLinuxPinger.On("Ping", mock.Anything, "linux-ip").Return(true).Once()
+	viaProxy.setupLinuxP2pInterfaceIfNeeded(ctx, model.Snapshot{LinuxP2pIpUp: false}, &model.Transaction{})
+}
+
+func TestFailureToSetLinuxP2pAddressIsReported(t *testing.T) {
+	setupViaProxy(t)
+
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(errors.New("interface missing: eth0"))
+	err := viaProxy.setupLinuxP2pInterfaceIfNeeded(ctx, model.Snapshot{LinuxP2pIpUp: false}, &model.Transaction{})
+	assert.ErrorContains(t, err, "interface missing: eth0")
+	mockLinuxPinger.AssertNotCalled(t, "Ping", mock.Anything, "linux-ip")
 }
 
 func TestSuccessfullyConfigureAccessViaProxy(t *testing.T) {
 	setupViaProxy(t)
-	mockWinConfigurer.On("Init").Return()
-	mockWinConfigurer.On("Cleanup").Return()
-	mockWinConfigurer.On("AddP2pAddress", mock.Anything).Return(nil)
-	mockWinConfigurer.On("SetPortProxy", mock.Anything).Return(nil)
-	assert.NoError(t, viaProxy.configureWindowsSide())
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil)
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(nil)
+	assert.NoError(t, viaProxy.configureWindowsSide(ctx, &model.Transaction{}))
 }
 
 func TestConfigureAccessViaProxyHasError1(t *testing.T) {
 	setupViaProxy(t)
-	mockWinConfigurer.On("Init").Return()
-	mockWinConfigurer.On("Cleanup").Return()
-	mockWinConfigurer.On("AddP2pAddress", mock.Anything).Return(errors.New(""))
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(errors.New(""))
 
-	assert.Error(t, viaProxy.configureWindowsSide())
+	assert.Error(t, viaProxy.configureWindowsSide(ctx, &model.Transaction{}))
 	mockWinConfigurer.AssertNotCalled(t, "AddP2pAddress")
 }
 
+func TestWindowsSideIsCleanedUpWhenInitFails(t *testing.T) {
+	setupViaProxy(t)
+	mockWinConfigurer.On("Init", mock.Anything).Return(errors.New("aborted"))
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+
+	assert.Error(t, viaProxy.configureWindowsSide(ctx, &model.Transaction{}))
+	mockWinConfigurer.AssertCalled(t, "Cleanup", mock.Anything)
+}
+
+func TestAddedWindowsP2pAddressIsRolledBack(t *testing.T) {
+	setupViaProxy(t)
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil)
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(errors.New(""))
+	mockWinConfigurer.On("RestorePortProxy", mock.Anything, []model.PortProxy{}).Return(nil)
+	mockWinConfigurer.On("RemoveP2pAddress", mock.Anything).Return(nil)
+
+	tx := &model.Transaction{}
+	assert.Error(t, viaProxy.configureWindowsSide(ctx, tx))
+	assert.NoError(t, tx.Rollback(ctx))
+	mockWinConfigurer.AssertNumberOfCalls(t, "Init", 3)
+	mockWinConfigurer.AssertNumberOfCalls(t, "Cleanup", 3)
+}
+
+func TestExistingWindowsP2pAddressAndPortProxyAreRestored(t *testing.T) {
+	setupViaProxy(t)
+	previous := []model.PortProxy{model.PortProxy{ListenAddress: "windows-ip", ListenPort: 3128, ConnectAddress: "127.0.0.1", ConnectPort: 3128}}
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	// only Px was unreachable, the address was there already
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(true)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinChecker.On("PortProxies", mock.Anything).Return(append(previous, model.PortProxy{ListenAddress: "0.0.0.0", ListenPort: 8080, ConnectAddress: "127.0.0.1", ConnectPort: 80}), nil)
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(errors.New(""))
+	mockWinConfigurer.On("RestorePortProxy", mock.Anything, previous).Return(nil)
+
+	tx := &model.Transaction{}
+	assert.Error(t, viaProxy.configureWindowsSide(ctx, tx))
+	assert.NoError(t, tx.Rollback(ctx))
+	mockWinConfigurer.AssertNotCalled(t, "RemoveP2pAddress", mock.Anything)
+}
+
 func TestConfigureAccessViaProxyHasError2(t *testing.T) {
 	setupViaProxy(t)
-	mockWinConfigurer.On("Init").Return()
-	mockWinConfigurer.On("Cleanup").Return()
-	mockWinConfigurer.On("AddP2pAddress", mock.Anything).Return(nil)
-	mockWinConfigurer.On("SetPortProxy", mock.Anything).Return(errors.New(""))
+	mockWinConfigurer.On("Init", mock.Anything).Return(nil)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockWinChecker.On("HasP2pAddress", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("AddP2pAddress", mock.Anything, mock.Anything).Return(nil)
+	mockWinChecker.On("PortProxies", mock.Anything).Return([]model.PortProxy{}, nil)
+	mockWinConfigurer.On("SetPortProxy", mock.Anything, mock.Anything).Return(errors.New(""))
 
-	assert.Error(t, viaProxy.configureWindowsSide())
+	assert.Error(t, viaProxy.configureWindowsSide(ctx, &model.Transaction{}))
 }
 
 func TestErrorWhenSettingP2pAddressFailed(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "linux-ip").Return(false)
-	mockLinuxConfigurer.On("SetP2pInterface").Return()
-	assert.Error(t, viaProxy.setupLinuxP2pInterfaceIfNeeded())
+	mockLinuxPinger.On("Ping", mock.Anything, "linux-ip").Return(false)
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(nil)
+	assert.Error(t, viaProxy.setupLinuxP2pInterfaceIfNeeded(ctx, model.Snapshot{LinuxP2pIpUp: false}, &model.Transaction{}))
 }
 
 func TestErrorWhenDefaultGatewayConfigFailed(t *testing.T) {
 	setupViaProxy(t)
-	mockLinuxPinger.On("Ping", "42.42.42.42").Return(false)
-	mockLinuxConfigurer.On("DeleteDefaultGateway").Return()
-	mockLinuxConfigurer.On("AddDefaultGateway").Return()
-	assert.Error(t, viaProxy.configureDefaultGatewayIfNeeded())
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything).Return()
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
+	assert.Error(t, viaProxy.configureDefaultGatewayIfNeeded(ctx, model.Snapshot{InternalDnsServerUp: false}, &model.Transaction{}))
+}
+
+func TestWindowsSideIsOkAccordingToSnapshot(t *testing.T) {
+	setupViaProxy(t)
+	assert.Equal(t, true, viaProxy.isWindowsSideOk(ctx, model.Snapshot{WindowsP2pIpUp: true, PxProxyReachable: true}))
+}
+
+func TestDefaultGatewayIsOkAccordingToSnapshot(t *testing.T) {
+	setupViaProxy(t)
+	assert.NoError(t, viaProxy.configureDefaultGatewayIfNeeded(ctx, model.Snapshot{InternalDnsServerUp: true}, &model.Transaction{}))
+}
+
+func TestWindowsSideIsDeferredWithoutElevation(t *testing.T) {
+	setupViaProxy(t)
+	mockDnsConfigurer.On("BackupResolvConf", mock.Anything).Return(model.FileBackup{}, nil)
+	mockDnsConfigurer.On("ActivateDnsServer", mock.Anything, "42.42.42.42").Return(nil)
+	mockLinuxConfigurer.On("SetP2pInterface", mock.Anything).Return(nil)
+	mockLinuxPinger.On("Ping", mock.Anything, "linux-ip").Return(true)
+	mockLinuxPinger.On("Ping", mock.Anything, "windows-ip").Return(false)
+	mockWinConfigurer.On("Init", mock.Anything).Return(model.ErrElevationDeferred)
+	mockWinConfigurer.On("Cleanup", mock.Anything).Return()
+	mockLinuxPinger.On("Ping", mock.Anything, "42.42.42.42").Return(false)
+	mockLinuxConfigurer.On("DefaultGateway", mock.Anything).Return("172.28.64.1", nil)
+	mockLinuxConfigurer.On("DeleteDefaultGateway", mock.Anything).Return()
+	mockLinuxConfigurer.On("AddDefaultGateway", mock.Anything).Return(nil)
+
+	tx := &model.Transaction{}
+	assert.NoError(t, viaProxy.Configure(ctx, model.Snapshot{PxProxyRunning: true}, tx))
+
+	assert.Equal(t, []string{"adding the Windows P2P address and the portproxy"}, tx.Deferred())
+	mockWinConfigurer.AssertNotCalled(t, "AddP2pAddress", mock.Anything, mock.Anything)
+	mockLinuxConfigurer.AssertCalled(t, "AddDefaultGateway", mock.Anything)
+	mockHttpChecker.AssertNotCalled(t, "HasInternetAccessViaProxy", mock.Anything)
 }
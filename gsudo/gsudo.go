@@ -1,109 +1,175 @@
 package gsudo
 
 import (
+	"context"
 	_ "embed"
 	"errors"
+	"fmt"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
 	"time"
 
+	"org.samba/isetta/cmdrunner"
 	"org.samba/isetta/helper"
+	"org.samba/isetta/progress"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
 //go:embed resources/gsudo.exe
 var gsudoBinary []byte
 
 type Gsudo struct {
+	// Windows already runs isetta elevated, e.g. the scheduled task. The
+	// commands run directly, without gsudo and its prompt
+	Elevated bool
+
 	windowsTempDirPath    string
 	windowsTempDirWslPath string
 	gsudoWslPath          string
 	gsudoWindowsPath      string
 }
 
-func (gsudo *Gsudo) Init() {
-	gsudo.setupPaths()
-	gsudo.copyGsudoBinary()
-	gsudo.preflightCheck()
+// cleanup must also work when the run was aborted, so it gets its own deadline
+const cleanupTimeout = 10 * time.Second
+
+func (gsudo *Gsudo) Init(ctx context.Context) error {
+	defer timing.Start("Setting up gsudo").End()
+	err := gsudo.setupPaths(ctx)
+	if err != nil {
+		return err
+	}
+	if gsudo.Elevated {
+		log.FromContext(ctx).Debug("Running elevated already, gsudo is not needed")
+		return nil
+	}
+	err = gsudo.copyGsudoBinary(ctx)
+	if err != nil {
+		return err
+	}
+	return gsudo.preflightCheck(ctx)
 }
 
-func (gsudo *Gsudo) setupPaths() {
-	gsudo.windowsTempDirPath = getWindowsTempDir()
-	gsudo.windowsTempDirWslPath = windowsPathToWsl(gsudo.windowsTempDirPath)
+func (gsudo *Gsudo) setupPaths(ctx context.Context) error {
+	var err error
+	gsudo.windowsTempDirPath, err = getWindowsTempDir(ctx)
+	if err != nil {
+		return err
+	}
+	gsudo.windowsTempDirWslPath, err = windowsPathToWsl(ctx, gsudo.windowsTempDirPath)
+	if err != nil {
+		return err
+	}
 	gsudo.gsudoWslPath = path.Join(gsudo.windowsTempDirWslPath, "gsudo-isetta.exe")
-	log.Logger.Trace("gsudo WSL path: %v", gsudo.gsudoWslPath)
+	log.FromContext(ctx).Trace("gsudo WSL path: %v", gsudo.gsudoWslPath)
 	gsudo.gsudoWindowsPath = gsudo.windowsTempDirPath + "\\gsudo-isetta.exe" // concat since no Windows join available
-	log.Logger.Trace("gsudo Windows path: %v", gsudo.gsudoWindowsPath)
+	log.FromContext(ctx).Trace("gsudo Windows path: %v", gsudo.gsudoWindowsPath)
+	return nil
 }
 
-func (gsudo *Gsudo) copyGsudoBinary() {
-	log.Logger.Debug("Making gsudo available at %v", gsudo.gsudoWslPath)
+func (gsudo *Gsudo) copyGsudoBinary(ctx context.Context) error {
+	log.FromContext(ctx).Debug("Making gsudo available at %v", gsudo.gsudoWslPath)
 	configFunc := func() bool {
 		err := os.WriteFile(gsudo.gsudoWslPath, gsudoBinary, 0775)
 		return err == nil
 	}
 
-	err := helper.Retry(helper.RetryParams{
+	return helper.Retry(ctx, helper.RetryParams{
 		Description: "Writing gsudo binary",
 		Attempts:    10,
 		Sleep:       1 * time.Second,
 		Func:        configFunc,
 	})
-	helper.AssertNoError2(err)
 }
 
-func (gsudo *Gsudo) preflightCheck() {
+func (gsudo *Gsudo) preflightCheck(ctx context.Context) error {
+	defer timing.Start("gsudo preflight check").End()
 	fullCommand := []string{gsudo.gsudoWslPath, "--help"}
 	fullCommandStr := strings.Join(fullCommand, " ")
-	log.Logger.Trace("Preflight check. Executing command '%v'", fullCommandStr)
-	out, err := exec.Command(fullCommand[0], fullCommand[1:]...).CombinedOutput()
-	helper.AssertNoError(err, "Preflight check failed. Failed to run: %v, output was: %v", fullCommandStr, string(out))
-	log.Logger.Trace("Preflight check was successful")
+	log.FromContext(ctx).Trace("Preflight check. Executing command '%v'", fullCommandStr)
+	out, err := cmdrunner.Run(ctx, fullCommand[0], fullCommand[1:]...)
+	if err != nil {
+		return fmt.Errorf("preflight check failed. Failed to run: %v, output was: %v, error was: %w", fullCommandStr, string(out), err)
+	}
+	log.FromContext(ctx).Trace("Preflight check was successful")
+	return nil
+}
+
+// the Windows temp dir gsudo runs in, as Windows and as WSL path. Set up by Init
+func (gsudo *Gsudo) TempDir() (windowsPath string, wslPath string) {
+	return gsudo.windowsTempDirPath, gsudo.windowsTempDirWslPath
 }
 
-// should be called via 'defer' to cleanup the binary
-func (gsudo *Gsudo) Cleanup() {
-	log.Logger.Trace("Resetting cache")
-	gsudo.run("--reset-timestamp")
-	log.Logger.Trace("Removing gsudo binary from %v", gsudo.gsudoWslPath)
+// should be called via 'defer' to cleanup the binary. Runs even if the context of
+// the run was cancelled, e.g. via Ctrl-C
+func (gsudo *Gsudo) Cleanup(ctx context.Context) {
+	if gsudo.gsudoWslPath == "" || gsudo.Elevated {
+		log.FromContext(ctx).Trace("gsudo was not set up, nothing to clean up")
+		return
+	}
+
+	defer timing.Start("Cleaning up gsudo").End()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	log.FromContext(ctx).Trace("Resetting cache")
+	gsudo.run(ctx, "--reset-timestamp", false)
+	log.FromContext(ctx).Trace("Removing gsudo binary from %v", gsudo.gsudoWslPath)
 	os.Remove(gsudo.gsudoWslPath)
 }
 
 // executed elevated Windows command. By default it is expected that the command executes with
-// exit code 0 (success). Error checking can also be deactivating by passing 'false' as second argument
-func (gsudo *Gsudo) RunElevated(command string, checkError ...bool) string {
-	gsudo.tryActivateCache()
-	return gsudo.run(command, checkError...)
+// exit code 0 (success), otherwise an error is returned. Checking the exit code can also be
+// deactivated by passing 'false' as second argument
+func (gsudo *Gsudo) RunElevated(ctx context.Context, command string, checkError ...bool) (string, error) {
+	defer timing.Start("Running elevated command").End()
+	if gsudo.Elevated {
+		return gsudo.runDirectly(ctx, command, checkError...)
+	}
+	err := gsudo.tryActivateCache(ctx)
+	if err != nil {
+		return "", err
+	}
+	return gsudo.run(ctx, command, checkError...)
 }
 
-func (gsudo *Gsudo) tryActivateCache() {
-	log.Logger.Trace("Trying activate gsudo cache")
-	statusOutput := gsudo.run("status")
+func (gsudo *Gsudo) tryActivateCache(ctx context.Context) error {
+	defer timing.Start("Activating gsudo credential cache").End()
+	log.FromContext(ctx).Trace("Trying activate gsudo cache")
+	statusOutput, err := gsudo.run(ctx, "status")
+	if err != nil {
+		return err
+	}
 
-	if gsudo.isCacheActive(statusOutput) {
-		log.Logger.Trace("Credential cache is active. Won't start a new session.")
-	} else {
-		log.Logger.Debug("Credential cache not active, starting it (will prompt for admin credentials)")
-		gsudo.run("cache on --pid 0 --duration 00:00:30")
-		gsudo.waitForCacheActive()
+	if gsudo.isCacheActive(ctx, statusOutput) {
+		log.FromContext(ctx).Trace("Credential cache is active. Won't start a new session.")
+		return nil
 	}
+	log.FromContext(ctx).Trace("Credential cache not active, starting it")
+	progress.EmitWaitingForElevation("starting the gsudo credential cache")
+	_, err = gsudo.run(ctx, "cache on --pid 0 --duration 00:00:30")
+	if err != nil {
+		return err
+	}
+	return gsudo.waitForCacheActive(ctx)
 }
 
-func (gsudo *Gsudo) waitForCacheActive()  {
+func (gsudo *Gsudo) waitForCacheActive(ctx context.Context) error {
 	checkFunc := func() bool {
-		statusOutput := gsudo.run("status")
-		return gsudo.isCacheActive(statusOutput)
+		statusOutput, err := gsudo.run(ctx, "status")
+		return err == nil && gsudo.isCacheActive(ctx, statusOutput)
 	}
 
-	err := helper.Retry(helper.RetryParams{
+	err := helper.Retry(ctx, helper.RetryParams{
 		Description: "Credential cache started",
 		Attempts:    10,
 		Sleep:       250 * time.Millisecond,
 		Func:        checkFunc,
 	})
-	helper.AssertNoError2(err)	
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
 
 // Execute gsudo
@@ -113,26 +179,41 @@ func (gsudo *Gsudo) waitForCacheActive()  {
 // - gsudo path + passed in command need to be single string (otherwise multi args don't work)
 // - the command needs to run inside an existing Windows dir (prevent cmd.exe warnings)
 //
-// 'checkError' is an optional bool which controls if execution errors should
-// stop program flow
-func (gsudo *Gsudo) run(command string, checkError ...bool) string {
+// 'checkError' is an optional bool which controls if execution errors are
+// returned. Commands killed due to a cancelled context return the error of
+// the context
+func (gsudo *Gsudo) run(ctx context.Context, command string, checkError ...bool) (string, error) {
+	return gsudo.runInCmd(ctx, gsudo.gsudoWindowsPath+" "+command, checkError...)
+}
+
+// the elevated token of isetta is passed on to cmd.exe
+func (gsudo *Gsudo) runDirectly(ctx context.Context, command string, checkError ...bool) (string, error) {
+	return gsudo.runInCmd(ctx, command, checkError...)
+}
+
+func (gsudo *Gsudo) runInCmd(ctx context.Context, cmdCommand string, checkError ...bool) (string, error) {
 	checkError2, err := isCheckError(checkError)
-	helper.AssertNoError2(err)
-	cmdCommand := gsudo.gsudoWindowsPath + " " + command
+	if err != nil {
+		return "", err
+	}
 	fullCommand := []string{"cmd.exe", "/c", cmdCommand}
-	log.Logger.Trace("Executing command '%v'", strings.Join(fullCommand, " "))
+	log.FromContext(ctx).Trace("Executing command '%v'", strings.Join(fullCommand, " "))
 
-	cmd := exec.Command(fullCommand[0], fullCommand[1:]...)
-	cmd.Dir = gsudo.windowsTempDirWslPath // prevent warnings, see comment above
-	outBytes, err := cmd.CombinedOutput()
+	// the working dir prevents warnings, see comment above
+	outBytes, err := cmdrunner.RunIn(ctx, gsudo.windowsTempDirWslPath, fullCommand[0], fullCommand[1:]...)
 	out := string(outBytes)
 
+	if ctx.Err() != nil {
+		log.FromContext(ctx).Debug("Aborted: %v", strings.Join(fullCommand, " "))
+		return out, ctx.Err()
+	}
+
 	if checkError2 && err != nil {
-		log.Logger.Error("Error running: %v, error was: %v, output was: %v", fullCommand, err, out)
+		return out, fmt.Errorf("error running: %v, output was: %v, error was: %w", fullCommand, strings.TrimSpace(out), err)
 	}
 
-	log.Logger.Trace("Output was: %v", out)
-	return out
+	log.FromContext(ctx).Trace("Output was: %v", out)
+	return out, nil
 }
 
 // determine default argument
@@ -147,24 +228,26 @@ func isCheckError(checkError []bool) (bool, error) {
 	return myCheckError, nil
 }
 
-func (gsudo *Gsudo) isCacheActive(statusOutput string) bool {
+func (gsudo *Gsudo) isCacheActive(ctx context.Context, statusOutput string) bool {
 	searchString := "Available for this process: True"
-	log.Logger.Trace("Looking for search string '%v' in output", searchString)
+	log.FromContext(ctx).Trace("Looking for search string '%v' in output", searchString)
 	return strings.Contains(statusOutput, searchString)
 }
 
-func getWindowsTempDir() string {
-	cmd := exec.Command("cmd.exe", "/c", "echo %TEMP%")
-	cmd.Dir = "/mnt/c/"
-	out, err := cmd.CombinedOutput()
-	helper.AssertNoError(err, "failed to determine Windows temp dir. Output was: %v", string(out))
+func getWindowsTempDir(ctx context.Context) (string, error) {
+	out, err := cmdrunner.RunIn(ctx, "/mnt/c/", "cmd.exe", "/c", "echo %TEMP%")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine Windows temp dir. Output was: %v, error was: %w", string(out), err)
+	}
 
 	windowsTempDir := strings.TrimRight(string(out), "\r\n")
-	return windowsTempDir
+	return windowsTempDir, nil
 }
 
-func windowsPathToWsl(p string) string {
-	out, err := exec.Command("wslpath", "-u", p).Output()
-	helper.AssertNoError2(err)
-	return strings.Trim(string(out), "\n\r")
+func windowsPathToWsl(ctx context.Context, p string) (string, error) {
+	out, err := cmdrunner.Run(ctx, "wslpath", "-u", p)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert %v to a WSL path. Output was: %v, error was: %w", p, string(out), err)
+	}
+	return strings.Trim(string(out), "\n\r"), nil
 }
@@ -3,6 +3,7 @@
 package gsudo
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path"
@@ -17,8 +18,8 @@ var (
 )
 
 func init() {
-	winTempDir = getWindowsTempDir()
-	winTempDirInWsl := windowsPathToWsl(winTempDir)
+	winTempDir, _ = getWindowsTempDir(context.Background())
+	winTempDirInWsl, _ := windowsPathToWsl(context.Background(), winTempDir)
 	gsudoWslPath = path.Join(winTempDirInWsl, "gsudo-isetta.exe")
 	os.Remove(gsudoWslPath)
 }
@@ -27,7 +28,7 @@ func TestBinaryIsCopiedAndRemoved(t *testing.T) {
 	assert.False(t, fileExists(gsudoWslPath), "binary should initially not exist")
 
 	gsudo := Gsudo{}
-	gsudo.Init()
+	assert.NoError(t, gsudo.Init(context.Background()))
 	assert.True(t, fileExists(gsudoWslPath), "binary should exist")
 
 	gsudo.Cleanup()
@@ -36,22 +37,29 @@ func TestBinaryIsCopiedAndRemoved(t *testing.T) {
 
 func TestGsudoRunsViaCmdCall(t *testing.T) {
 	gsudo := Gsudo{}
-	gsudo.Init()
+	assert.NoError(t, gsudo.Init(context.Background()))
 	defer gsudo.Cleanup()
 
-	out := gsudo.run("status")
+	out, err := gsudo.run(context.Background(), "status")
+	assert.NoError(t, err)
 	assert.Contains(t, out, "Total active cache sessions")
 }
 
 func TestGsudoMultiArgumentWorks(t *testing.T) {
 	gsudo := Gsudo{}
-	gsudo.Init()
+	assert.NoError(t, gsudo.Init(context.Background()))
 	defer gsudo.Cleanup()
 
-	out := gsudo.run("config CopyEnvironmentVariables False")
+	out, err := gsudo.run(context.Background(), "config CopyEnvironmentVariables False")
+	assert.NoError(t, err)
 	assert.Contains(t, out, "CopyEnvironmentVariables = \"False\"")
 }
 
+func TestCleanupWithoutInitIsNoop(t *testing.T) {
+	gsudo := Gsudo{}
+	gsudo.Cleanup()
+}
+
 func TestSessionCacheIsNotActive(t *testing.T) {
 	statusOutput := `
 Credentials Cache:
@@ -60,7 +68,7 @@ Credentials Cache:
   Total active cache sessions: 0`
 
 	gsudo := Gsudo{}
-	assert.False(t, gsudo.isCacheActive(statusOutput), "Session cache is not active")
+	assert.False(t, gsudo.isCacheActive(context.Background(), statusOutput), "Session cache is not active")
 }
 
 func TestSessionCacheIsActive(t *testing.T) {
@@ -72,7 +80,7 @@ func TestSessionCacheIsActive(t *testing.T) {
 	  ProtectedPrefix\Administrators\gsudo_E47586A56563B34C06B7A20DB10A05A83245A9FEFB0D62C7187EEB48B157A9F1`
 
 	gsudo := Gsudo{}
-	assert.True(t, gsudo.isCacheActive(statusOutput), "Session cache is active")
+	assert.True(t, gsudo.isCacheActive(context.Background(), statusOutput), "Session cache is active")
 }
 
 func TestTooLongDefaultArgArray(t *testing.T) {
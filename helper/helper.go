@@ -1,11 +1,13 @@
 package helper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
 )
 
 type RetryParams struct {
@@ -15,17 +17,30 @@ type RetryParams struct {
 	Func        func() bool   // function which should be retried until success
 }
 
-func Retry(p RetryParams) error {
+// stops retrying as soon as the context is done
+func Retry(ctx context.Context, p RetryParams) error {
+	step := timing.Start(p.Description)
+	defer step.End()
+
 	for i := 0; i < p.Attempts; i++ {
 		if i > 0 {
-			log.Logger.Trace("%v: Trying %vst time, backing off for %v", p.Description, i, p.Sleep)
-			time.Sleep(p.Sleep)
+			step.AddRetry()
+			log.FromContext(ctx).Trace("%v: Trying %vst time, backing off for %v", p.Description, i, p.Sleep)
+			select {
+			case <-time.After(p.Sleep):
+			case <-ctx.Done():
+				return fmt.Errorf("%v: %w", p.Description, ctx.Err())
+			}
 			p.Sleep *= 2
 		}
 
+		if ctx.Err() != nil {
+			return fmt.Errorf("%v: %w", p.Description, ctx.Err())
+		}
+
 		isSuccessful := p.Func()
 		if isSuccessful {
-			log.Logger.Debug("%v: Success", p.Description)
+			log.FromContext(ctx).Debug("%v: Success", p.Description)
 			return nil
 		}
 	}
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -8,7 +9,7 @@ import (
 )
 
 func TestRetryExhausted(t *testing.T) {
-	err := Retry(RetryParams{
+	err := Retry(context.Background(), RetryParams{
 		Description: "foo",
 		Attempts: 1,
 		Sleep: 1 * time.Millisecond,
@@ -30,7 +31,7 @@ func TestRetrySuccessful(t *testing.T) {
 		}
 	}
 
-	err := Retry(RetryParams{
+	err := Retry(context.Background(), RetryParams{
 		Description: "retry was successful",
 		Attempts: 2,
 		Sleep: 1 * time.Millisecond,
@@ -38,4 +39,23 @@ func TestRetrySuccessful(t *testing.T) {
 	})
 
 	assert.NoError(t, err, "retry was successful")
-}
\ No newline at end of file
+}
+
+func TestRetryStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cnt := 0
+
+	err := Retry(ctx, RetryParams{
+		Description: "cancelled",
+		Attempts: 10,
+		Sleep: 1 * time.Millisecond,
+		Func: func() bool {
+			cnt++
+			cancel()
+			return false
+		},
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, cnt)
+}
@@ -0,0 +1,176 @@
+package inifile
+
+// minimal editor for the INI files of WSL, e.g. .wslconfig or wsl.conf.
+// Unlike a full parser, it keeps comments, the order of the keys and the
+// formatting of all lines it doesn't change. Section and key names are
+// compared case-insensitively, like WSL does.
+//
+// usage:
+//
+//	file := inifile.Parse(content)
+//	changed := file.Set("network", "generateResolvConf", "false")
+//	os.WriteFile(path, file.Bytes(), 0644)
+import (
+	"strings"
+)
+
+type File struct {
+	lines []string
+	// line ending of the file, CRLF for files written on Windows
+	newline string
+}
+
+func Parse(content []byte) *File {
+	text := string(content)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+	text = strings.TrimSuffix(text, newline)
+
+	file := &File{newline: newline}
+	if text != "" {
+		file.lines = strings.Split(text, newline)
+	}
+	return file
+}
+
+func (f *File) Bytes() []byte {
+	if len(f.lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(f.lines, f.newline) + f.newline)
+}
+
+// the value of the key, found is false if the key is not set
+func (f *File) Get(section string, key string) (value string, found bool) {
+	i := f.findKey(section, key)
+	if i < 0 {
+		return "", false
+	}
+	_, value, _ = parseKeyValue(f.lines[i])
+	return value, true
+}
+
+// Replaces the value of an existing key, keeping the spacing around '='.
+// A missing key is appended to its section, a missing section to the file,
+// both in the style of the existing keys.
+// Returns false if the key had this value already
+func (f *File) Set(section string, key string, value string) bool {
+	if i := f.findKey(section, key); i >= 0 {
+		_, actual, _ := parseKeyValue(f.lines[i])
+		if actual == value {
+			return false
+		}
+		f.lines[i] = replaceValue(f.lines[i], value)
+		return true
+	}
+
+	line := key + f.separator() + value
+	start, end := f.findSection(section)
+	if start < 0 {
+		if len(f.lines) > 0 && strings.TrimSpace(f.lines[len(f.lines)-1]) != "" {
+			f.lines = append(f.lines, "")
+		}
+		f.lines = append(f.lines, "["+section+"]", line)
+		return true
+	}
+
+	// after the last key of the section, blank lines and comments before the next section stay there
+	insertAt := start + 1
+	for i := start + 1; i < end; i++ {
+		if _, _, ok := parseKeyValue(f.lines[i]); ok {
+			insertAt = i + 1
+		}
+	}
+	f.lines = append(f.lines[:insertAt], append([]string{line}, f.lines[insertAt:]...)...)
+	return true
+}
+
+// Removes the key, the rest of its section stays. Returns false if the key
+// wasn't set
+func (f *File) Delete(section string, key string) bool {
+	i := f.findKey(section, key)
+	if i < 0 {
+		return false
+	}
+	f.lines = append(f.lines[:i], f.lines[i+1:]...)
+	return true
+}
+
+// 'key = value' if the file uses spaces around '=', 'key=value' otherwise
+func (f *File) separator() string {
+	for _, line := range f.lines {
+		if _, _, ok := parseKeyValue(line); ok {
+			if strings.Contains(line, " =") {
+				return " = "
+			}
+			return "="
+		}
+	}
+	return "="
+}
+
+// line index of the key inside the section, -1 if missing. The last
+// occurrence wins, like for most INI readers
+func (f *File) findKey(section string, key string) int {
+	start, end := f.findSection(section)
+	found := -1
+	for i := start + 1; start >= 0 && i < end; i++ {
+		name, _, ok := parseKeyValue(f.lines[i])
+		if ok && strings.EqualFold(name, key) {
+			found = i
+		}
+	}
+	return found
+}
+
+// line index of the section header and of the next section header (or the
+// end of the file), -1 if the section is missing
+func (f *File) findSection(section string) (start int, end int) {
+	start = -1
+	for i, line := range f.lines {
+		name, isHeader := parseSectionHeader(line)
+		if !isHeader {
+			continue
+		}
+		if start >= 0 {
+			return start, i
+		}
+		if strings.EqualFold(name, section) {
+			start = i
+		}
+	}
+	return start, len(f.lines)
+}
+
+func parseSectionHeader(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "[") || !strings.HasSuffix(trimmed, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(trimmed[1 : len(trimmed)-1]), true
+}
+
+func isComment(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";")
+}
+
+func parseKeyValue(line string) (key string, value string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || isComment(trimmed) {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(trimmed, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+func replaceValue(line string, value string) string {
+	i := strings.Index(line, "=")
+	rest := line[i+1:]
+	leadingSpace := rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+	return line[:i+1] + leadingSpace + value
+}
@@ -0,0 +1,103 @@
+package inifile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const wslConfig = `# settings of the WSL VM
+[wsl2]
+memory = 8GB
+autoProxy=true
+
+# experimental features
+[experimental]
+sparseVhd=true
+`
+
+func TestGetIsCaseInsensitive(t *testing.T) {
+	file := Parse([]byte(wslConfig))
+
+	value, found := file.Get("WSL2", "autoproxy")
+	assert.True(t, found)
+	assert.Equal(t, "true", value)
+
+	_, found = file.Get("wsl2", "sparseVhd")
+	assert.False(t, found)
+}
+
+func TestSetKeepsFormatting(t *testing.T) {
+	file := Parse([]byte(wslConfig))
+
+	assert.True(t, file.Set("wsl2", "memory", "4GB"))
+	assert.True(t, file.Set("wsl2", "autoProxy", "false"))
+	assert.False(t, file.Set("experimental", "sparseVhd", "true"))
+
+	assert.Equal(t, `# settings of the WSL VM
+[wsl2]
+memory = 4GB
+autoProxy=false
+
+# experimental features
+[experimental]
+sparseVhd=true
+`, string(file.Bytes()))
+}
+
+func TestSetAppendsMissingKeyToItsSection(t *testing.T) {
+	file := Parse([]byte(wslConfig))
+
+	assert.True(t, file.Set("wsl2", "dnsTunneling", "false"))
+
+	assert.Equal(t, `# settings of the WSL VM
+[wsl2]
+memory = 8GB
+autoProxy=true
+dnsTunneling = false
+
+# experimental features
+[experimental]
+sparseVhd=true
+`, string(file.Bytes()))
+}
+
+func TestSetAppendsMissingSection(t *testing.T) {
+	file := Parse([]byte("[boot]\r\nsystemd=true\r\n"))
+
+	assert.True(t, file.Set("network", "generateResolvConf", "false"))
+
+	assert.Equal(t, "[boot]\r\nsystemd=true\r\n\r\n[network]\r\ngenerateResolvConf=false\r\n", string(file.Bytes()))
+}
+
+func TestSetFollowsTheStyleOfTheFile(t *testing.T) {
+	file := Parse([]byte("[boot]\nsystemd = true\n"))
+
+	assert.True(t, file.Set("network", "generateResolvConf", "false"))
+
+	assert.Equal(t, "[boot]\nsystemd = true\n\n[network]\ngenerateResolvConf = false\n", string(file.Bytes()))
+}
+
+func TestSetOnEmptyFile(t *testing.T) {
+	file := Parse(nil)
+
+	assert.True(t, file.Set("network", "generateResolvConf", "false"))
+
+	assert.Equal(t, "[network]\ngenerateResolvConf=false\n", string(file.Bytes()))
+}
+
+func TestDeleteKeepsTheRestOfTheSection(t *testing.T) {
+	file := Parse([]byte(wslConfig))
+
+	assert.True(t, file.Delete("wsl2", "memory"))
+	assert.False(t, file.Delete("wsl2", "memory"))
+
+	assert.Equal(t, `# settings of the WSL VM
+[wsl2]
+autoProxy=true
+
+# experimental features
+[experimental]
+sparseVhd=true
+`, string(file.Bytes()))
+}
@@ -1,28 +1,56 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"org.samba/isetta/adapter/dnsconfig"
-	"org.samba/isetta/adapter/envvars"
-	"org.samba/isetta/adapter/httpchecker"
-	"org.samba/isetta/adapter/linux"
-	"org.samba/isetta/adapter/windows"
+	"org.samba/isetta/cmdrunner"
 	"org.samba/isetta/config"
-	"org.samba/isetta/core"
-	"org.samba/isetta/gsudo"
 	"org.samba/isetta/helper"
+	"org.samba/isetta/pkg/isetta"
+	"org.samba/isetta/progress"
 	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/timing"
+	"org.samba/isetta/userdir"
 )
 
 const version = "0.5.1"
 
+// exit code of 'isetta verify' when the network state drifted from the desired one
+const exitCodeDrift = 2
+
+// expected conditions, not failures of isetta
+const (
+	exitCodeOffline       = 3
+	exitCodeCaptivePortal = 4
+	// the Windows side is left to the next interactive run
+	exitCodeElevationDeferred = 5
+)
+
 func main() {
 	envSettings := flag.Bool("env-settings", false, "Prints environment config. Handy if called via 'source'")
 	printVersion := flag.Bool("version", false, "Print isetta version")
+	showTimings := flag.Bool("timings", false, "Prints the duration of each step at the end")
+	timingsJson := flag.String("timings-json", "", "Appends a machine-readable timing report (one JSON line per run) to the given file")
+	javaTruststore := flag.Bool("java-truststore", false, "Imports the corporate CA certificates into the truststores of the installed JDKs")
+	keepPartialState := flag.Bool("keep-partial-state", false, "Does not roll back the changes of a failed configuration, for debugging")
+	record := flag.String("record", "", "Records all external commands with their outputs and exit codes into the given directory, e.g. for a bug report")
+	replay := flag.String("replay", "", "Answers external commands from a recording in the given directory instead of running them")
+	timeout := flag.Duration("timeout", 0, "Aborts the whole run after the given duration, e.g. '90s' or '2m'. 0 means no timeout")
+	nonInteractive := flag.Bool(strings.TrimPrefix(isetta.NonInteractiveFlag, "-"), false, "Defers changes on the Windows side instead of prompting via gsudo and always logs into the log file. Used by 'isetta autostart' and 'isetta windows-task'")
+	windowsElevated := flag.Bool(strings.TrimPrefix(isetta.WindowsElevatedFlag, "-"), false, "Changes the Windows side without gsudo, since Windows runs isetta elevated already. Used by 'isetta windows-task'")
+	flag.Usage = usage
 	flag.Parse()
+	command := flag.Arg(0)
 
 	if *printVersion {
 		fmt.Printf("Isetta version %v\n", version)
@@ -30,80 +58,413 @@ func main() {
 	}
 
 	conf := config.FromConfigFile("$HOME", log.GetValidLogLevels())
-	log.Logger.CurrentLogLevel = log.Levels[conf.General.LogLevel]
-	handler := setupDependencies(conf)
+	if *nonInteractive {
+		// nobody watches the console of a run at WSL start
+		conf.General.LogFile = true
+	}
+	setupLogger(conf)
+	// the environment settings are 'source'd on every shell start, they stay quiet
+	setupProgress(conf, !*envSettings && !*nonInteractive && command != "use" && command != "wslconfig" && command != "restore-file" && command != "autostart" && command != "windows-task")
+	runner, closeRunner := setupRunner(*record, *replay)
+	defer closeRunner()
+	client, err := isetta.New(isetta.Options{Config: conf, Runner: runner, KeepPartialState: *keepPartialState, NonInteractive: *nonInteractive, WindowsElevated: *windowsElevated, DryRun: *replay != ""})
+	helper.AssertNoError2(err)
+	ctx, cancel := setupContext(*timeout)
+	defer cancel()
 
+	drifted := false
 	if *envSettings {
-		handler.PrintEnvVars()
+		err = client.PrintEnvVars(ctx)
+	} else if *javaTruststore {
+		err = client.ImportJavaTruststore(ctx)
+	} else if command == "verify" {
+		drifted, err = verify(ctx, client)
+	} else if command == "use" {
+		err = use(client, flag.Args()[1:])
+	} else if command == "status" {
+		err = status(ctx, client)
+	} else if command == "wslconfig" {
+		err = wslConfig(ctx, client, flag.Args()[1:])
+	} else if command == "restore-file" {
+		err = restoreFile(ctx, client, flag.Args()[1:])
+	} else if command == "autostart" {
+		err = autostart(ctx, client, flag.Args()[1:])
+	} else if command == "windows-task" {
+		err = windowsTask(ctx, client, flag.Args()[1:])
+	} else if command == "" {
+		err = client.Configure(ctx)
 	} else {
-		err := handler.ConfigureNetwork()
-		helper.AssertNoError2(err)
+		err = fmt.Errorf("unknown command '%v', see 'isetta -help'", command)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("aborted, %w. The configuration might be incomplete, run isetta again", context.Cause(ctx))
+	}
+	reportTimings(*showTimings, *timingsJson)
+	exitOnExpectedCondition(err)
+	helper.AssertNoError2(err)
+	if drifted {
+		os.Exit(exitCodeDrift)
 	}
 }
 
-func setupDependencies(conf config.Config) core.Handler {
-	envVarprinter := envvars.ConsoleEnvVarPrinter{
-		WindowsIp:   conf.Network.P2p.WindowsIp,
-		PxProxyPort: conf.Network.PxProxyPort,
-		NoProxy:     conf.Network.NoProxy,
+func exitOnExpectedCondition(err error) {
+	if errors.Is(err, isetta.ErrOffline) {
+		log.Logger.Warn("%v", err)
+		os.Exit(exitCodeOffline)
+	}
+	if errors.Is(err, isetta.ErrCaptivePortal) {
+		log.Logger.Warn("%v", err)
+		os.Exit(exitCodeCaptivePortal)
+	}
+	if errors.Is(err, isetta.ErrElevationDeferred) {
+		log.Logger.Warn("%v", err)
+		os.Exit(exitCodeElevationDeferred)
 	}
+}
 
-	windowsChecker := windows.WindowsCheckerImpl{PxProxyPort: conf.Network.PxProxyPort}
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: isetta [flags] [command]\n\n")
+	fmt.Fprintf(out, "Without a command, the network is configured. Exits with %v when offline, with %v behind a captive portal and with %v if -non-interactive deferred changes on the Windows side\n\n", exitCodeOffline, exitCodeCaptivePortal, exitCodeElevationDeferred)
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  verify\tReports drift from the desired network state without changing anything. Exits with %v on drift\n", exitCodeDrift)
+	fmt.Fprintf(out, "  status\tShows the scenario, the working internet paths, the drift and conflicting .wslconfig settings\n")
+	fmt.Fprintf(out, "  use proxy|direct|split-tunnel|auto [--for 2h]\n\tPins the scenario instead of detecting it, 'auto' detects it again. Without arguments, shows the pinned scenario\n")
+	fmt.Fprintf(out, "  wslconfig [apply]\n\tShows the .wslconfig settings which interfere with isetta, 'apply' sets the recommended values\n")
+	fmt.Fprintf(out, "  restore-file [number]\n\tLists the backups of the files isetta changed, e.g. /etc/resolv.conf. With a number, restores that backup\n")
+	fmt.Fprintf(out, "  autostart enable|disable\n\tRuns 'isetta -non-interactive' at every start of the WSL distro, via systemd if it is running, otherwise via /etc/wsl.conf\n")
+	fmt.Fprintf(out, "  windows-task install|uninstall|status\n\tA Windows scheduled task which runs 'isetta -non-interactive' in this distro on logon and on network changes\n\n")
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
 
-	windowsConfigurer := windows.WindowsConfigurerImpl{
-		WindowsIp:   conf.Network.P2p.WindowsIp,
-		SubnetMask:  conf.Network.P2p.SubnetMask,
-		PxProxyPort: conf.Network.PxProxyPort,
-		Gsudo:       &gsudo.Gsudo{},
+// 'use <scenario> [--for <duration>]', shows the pinned scenario without arguments
+func use(client *isetta.Client, args []string) error {
+	if len(args) == 0 {
+		override, active, err := client.ActiveOverride()
+		if err != nil {
+			return err
+		}
+		if active {
+			fmt.Printf("Scenario is pinned to %v\n", override)
+		} else {
+			fmt.Println("Scenario is detected automatically")
+		}
+		return nil
 	}
 
-	linuxPinger := linux.LinuxPingerImpl{}
+	useFlags := flag.NewFlagSet("use", flag.ExitOnError)
+	duration := useFlags.Duration("for", 0, "Pins the scenario only for the given duration, e.g. '2h'. 0 means until cleared")
+	useFlags.Parse(args[1:])
+	return client.Use(args[0], *duration)
+}
 
-	linuxConfigurer := linux.LinuxConfigurerImpl{
-		WindowsIp:  conf.Network.P2p.WindowsIp,
-		LinuxIp:    conf.Network.P2p.LinuxIp,
-		SubnetMask: conf.Network.P2p.SubnetMask,
+// prints the drift to stdout, returns true if there is any
+func verify(ctx context.Context, client *isetta.Client) (bool, error) {
+	drifts, err := client.Verify(ctx)
+	if err != nil {
+		return false, err
 	}
 
-	dnsConfigurer := dnsconfig.DnsConfigurerImpl{}
-	httpchecker, err := httpchecker.New(conf.General.InternetAccessTestUrl, config.GetProxyUrl(conf))
-	helper.AssertNoError2(err)
+	if len(drifts) == 0 {
+		fmt.Println("Network state is as desired")
+		return false, nil
+	}
+	for _, drift := range drifts {
+		fmt.Printf("Drift: %v\n", drift)
+	}
+	return true, nil
+}
+
+func status(ctx context.Context, client *isetta.Client) error {
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Scenario: %v\n", status.Scenario)
+	if status.Pinned {
+		fmt.Printf("Pinned: %v\n", status.Override)
+	}
+	fmt.Printf("Direct internet access: %v\n", formatPathCheck(status.InternetPaths.Direct))
+	fmt.Printf("Internet access via proxy: %v\n", formatPathCheck(status.InternetPaths.Proxy))
+	for _, drift := range status.Drift {
+		fmt.Printf("Drift: %v\n", drift)
+	}
+	printWslConfigConflicts(status.WslConfigConflicts)
+	return nil
+}
+
+func formatPathCheck(check isetta.PathCheck) string {
+	if check.Ok {
+		return fmt.Sprintf("works (%v)", check.Latency.Round(time.Millisecond))
+	}
+	if check.Err != nil {
+		return fmt.Sprintf("fails: %v", check.Err)
+	}
+	return "fails"
+}
+
+func printWslConfigConflicts(conflicts []isetta.WslConfigConflict) {
+	applicable := false
+	for _, conflict := range conflicts {
+		log.Logger.Warn(".wslconfig: %v", conflict)
+		applicable = applicable || !conflict.HintOnly
+	}
+	if applicable {
+		log.Logger.Warn("Run 'isetta wslconfig apply' to set the recommended values")
+	}
+}
+
+// 'wslconfig' shows the conflicting settings, 'wslconfig apply' corrects them
+func wslConfig(ctx context.Context, client *isetta.Client, args []string) error {
+	if len(args) == 0 {
+		conflicts, err := client.WslConfigConflicts(ctx)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) == 0 {
+			fmt.Println("No .wslconfig settings interfere with isetta")
+		}
+		printWslConfigConflicts(conflicts)
+		return nil
+	}
+	if args[0] != "apply" {
+		return fmt.Errorf("unknown wslconfig command '%v', only 'apply' is supported", args[0])
+	}
+
+	applied, err := client.ApplyWslConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println(".wslconfig already has the recommended values")
+		return nil
+	}
+	for _, setting := range applied {
+		fmt.Printf("Set %v=%v\n", setting.Key, setting.Recommended)
+	}
+	fmt.Println("Run 'wsl.exe --shutdown' from Windows to restart WSL, the settings only apply afterwards. This closes all WSL sessions")
+	return nil
+}
+
+// 'restore-file' lists the backups, 'restore-file <number>' restores one of them
+func restoreFile(ctx context.Context, client *isetta.Client, args []string) error {
+	backups, err := client.FileBackups()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		if len(backups) == 0 {
+			fmt.Println("There are no backups")
+			return nil
+		}
+		for i, backup := range backups {
+			fmt.Printf("%3d  %v  %v\n", i+1, backup.Time.Local().Format(time.DateTime), backup.Path)
+		}
+		fmt.Println("Run 'sudo isetta restore-file <number>' to restore one of them")
+		return nil
+	}
 
-	directAccess := core.DirectAccess{
-		PublicDnsServer: conf.Dns.PublicServer,
-		DnsConfigurer:   dnsConfigurer,
-		LinuxPinger:     &linuxPinger,
-		LinuxConfigurer: &linuxConfigurer,
-		HttpChecker:     &httpchecker,
-		EnvVarPrinter:   &envVarprinter,
-	}
-
-	viaproxy := core.ViaProxy{
-		// static
-		LinuxP2pIp:        conf.Network.P2p.LinuxIp,
-		WindowsP2pIp:      conf.Network.P2p.WindowsIp,
-		PxProxyPort:       conf.Network.PxProxyPort,
-		InternalDnsServer: conf.Dns.InternalServer,
-		// objects
-		WindowsChecker:    &windowsChecker,
-		WindowsConfigurer: &windowsConfigurer,
-		DnsConfigurer:     &dnsConfigurer,
-		LinuxPinger:       &linuxPinger,
-		LinuxConfigurer:   &linuxConfigurer,
-		HttpChecker:       &httpchecker,
-	}
-
-	handler := core.Handler{
-		RunningAsRoot:     os.Geteuid() == 0,
-		InternalDnsServer: conf.Dns.InternalServer,
-		PublicDnsServer:   conf.Dns.PublicServer,
-		WindowsChecker:    &windowsChecker,
-		DnsConfigurer:     &dnsConfigurer,
-		EnvVarPrinter:     &envVarprinter,
-		DirectAccess:      &directAccess,
-		ViaProxy:          &viaproxy,
-		InternetChecker:   core.NewInternetChecker(&httpchecker),
-	}
-
-	return handler
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < 1 || number > len(backups) {
+		return fmt.Errorf("invalid backup '%v', run 'isetta restore-file' to list the backups", args[0])
+	}
+	return client.RestoreFile(ctx, backups[number-1])
+}
+
+// 'autostart enable' or 'autostart disable'
+func autostart(ctx context.Context, client *isetta.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: isetta autostart enable|disable")
+	}
+
+	switch args[0] {
+	case "enable":
+		path, err := client.EnableAutostart(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("isetta runs at every start of the WSL distro, see %v. Changes on the Windows side are left to the next 'sudo isetta'\n", path)
+		return nil
+	case "disable":
+		err := client.DisableAutostart(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("isetta no longer runs at the start of the WSL distro")
+		return nil
+	default:
+		return fmt.Errorf("unknown autostart command '%v', only 'enable' and 'disable' are supported", args[0])
+	}
+}
+
+// 'windows-task install', 'windows-task uninstall' or 'windows-task status'
+func windowsTask(ctx context.Context, client *isetta.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: isetta windows-task install|uninstall|status")
+	}
+
+	switch args[0] {
+	case "install":
+		err := client.InstallWindowsTask(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("The Windows scheduled task 'isetta' runs isetta on logon and on network changes")
+		return nil
+	case "uninstall":
+		err := client.UninstallWindowsTask(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("The Windows scheduled task 'isetta' is removed")
+		return nil
+	case "status":
+		status, err := client.WindowsTaskStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if !status.Installed {
+			fmt.Println("The Windows scheduled task 'isetta' is not installed")
+			return nil
+		}
+		fmt.Printf("State: %v\n", status.State)
+		if status.LastRun.IsZero() {
+			fmt.Println("Last run: never")
+		} else {
+			fmt.Printf("Last run: %v, exit code %v\n", status.LastRun.Format(time.DateTime), status.LastResult)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown windows-task command '%v', only 'install', 'uninstall' and 'status' are supported", args[0])
+	}
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// The context is cancelled on SIGINT/SIGTERM or once the timeout is exceeded.
+// The current step is aborted, temporary Windows resources are still cleaned up.
+// A second signal terminates isetta immediately.
+func setupContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		log.Logger.Warn("Received %v, aborting and cleaning up. Repeat to terminate immediately", sig)
+		cancelCause(errInterrupted)
+	}()
+
+	cancel := func() {
+		signal.Stop(signals)
+		close(signals)
+		cancelCause(nil)
+	}
+	if timeout <= 0 {
+		return ctx, cancel
+	}
+
+	ctx, cancelTimeout := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timeout of %v exceeded", timeout))
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+func reportTimings(showTimings bool, timingsJson string) {
+	if showTimings {
+		timing.Default.PrintTree(os.Stderr)
+	}
+
+	if timingsJson != "" {
+		f, err := os.OpenFile(timingsJson, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Logger.Warn("Unable to write timing report to %v: %v", timingsJson, err)
+			return
+		}
+		defer f.Close()
+
+		hostname, _ := os.Hostname()
+		err = timing.Default.WriteJson(f, version, hostname)
+		if err != nil {
+			log.Logger.Warn("Unable to write timing report to %v: %v", timingsJson, err)
+		}
+	}
+}
+
+func setupLogger(conf config.Config) {
+	options := log.Options{
+		Level:  log.Levels[conf.General.LogLevel],
+		Format: conf.General.LogFormat,
+	}
+
+	if conf.General.LogFile {
+		logFilePath := conf.General.LogFilePath
+		if logFilePath == "" {
+			stateDir, err := userdir.StateDir()
+			helper.AssertNoError(err, "unable to determine state directory")
+			logFilePath = filepath.Join(stateDir, "isetta.log")
+		}
+		options.File = &log.FileOptions{
+			Path:       logFilePath,
+			Level:      log.Levels[conf.General.LogFileLevel],
+			MaxSize:    int64(conf.General.LogFileMaxSizeKb) * 1024,
+			MaxBackups: conf.General.LogFileMaxBackups,
+		}
+	}
+
+	logger, err := log.New(options)
+	log.Logger = logger
+	if err != nil {
+		log.Logger.Warn("Logging to console only. %v", err)
+	}
+}
+
+// nil keeps running the commands. The returned function closes a recording
+func setupRunner(recordDir string, replayDir string) (cmdrunner.Runner, func()) {
+	if recordDir != "" && replayDir != "" {
+		log.Logger.Error("-record and -replay can't be combined")
+	}
+	if replayDir != "" {
+		replayer, err := cmdrunner.NewReplayer(replayDir)
+		helper.AssertNoError2(err)
+		log.Logger.Warn("Replaying the commands recorded in %v as a dry run, files are read from this box but not changed. Pings and HTTP requests which weren't recorded fail", replayDir)
+		return replayer, func() {}
+	}
+	if recordDir != "" {
+		recorder, err := cmdrunner.NewRecorder(cmdrunner.ExecRunner{}, recordDir)
+		helper.AssertNoError2(err)
+		log.Logger.Info("Recording the commands into %v", recordDir)
+		return recorder, func() { recorder.Close() }
+	}
+	return nil, func() {}
+}
+
+// progress events always go to the log, to the console only if requested
+func setupProgress(conf config.Config, console bool) {
+	progress.Subscribe(progress.LogObserver{})
+	if !console {
+		return
+	}
+
+	switch conf.General.Progress {
+	case "auto":
+		// the status line goes to the terminal itself, so it stays out of the
+		// logs on stderr, e.g. if they are redirected to a file
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+		if err != nil {
+			progress.Subscribe(progress.NewPlainObserver(os.Stderr))
+			return
+		}
+		progress.Subscribe(progress.NewConsoleObserver(tty))
+	case "plain":
+		progress.Subscribe(progress.NewPlainObserver(os.Stderr))
+	}
 }
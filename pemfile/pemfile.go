@@ -0,0 +1,28 @@
+package pemfile
+
+// reads the certificates of PEM files, e.g. the corporate CA bundle. Shared by
+// the adapters which hand the bundle on to tools.
+import (
+	"encoding/pem"
+	"os"
+)
+
+// the DER bytes of all CERTIFICATE blocks, other blocks like keys are skipped
+func Certificates(path string) ([][]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := [][]byte{}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return certificates, nil
+		}
+		if block.Type == "CERTIFICATE" {
+			certificates = append(certificates, block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package pemfile
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificates(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("one")})
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("ignored")})...)
+	content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("two")})...)
+	os.WriteFile(bundle, content, 0644)
+
+	certificates, err := Certificates(bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("one"), []byte("two")}, certificates)
+}
+
+func TestCertificatesOfMissingFile(t *testing.T) {
+	_, err := Certificates(filepath.Join(t.TempDir(), "ca.pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
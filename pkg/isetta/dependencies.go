@@ -0,0 +1,292 @@
+package isetta
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"org.samba/isetta/adapter/dnsconfig"
+	"org.samba/isetta/adapter/envvars"
+	"org.samba/isetta/adapter/httpchecker"
+	"org.samba/isetta/adapter/java"
+	"org.samba/isetta/adapter/linux"
+	"org.samba/isetta/adapter/statefile"
+	"org.samba/isetta/adapter/windows"
+	"org.samba/isetta/adapter/wslconfig"
+	"org.samba/isetta/config"
+	"org.samba/isetta/core"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/gsudo"
+	log "org.samba/isetta/simplelogger"
+)
+
+func setupDependencies(ctx context.Context, conf config.Config, stateDir string, options Options) (core.Handler, error) {
+	networkingMode := detectNetworkingMode(ctx, conf)
+	mirrored := networkingMode == model.NetworkingModeMirrored
+
+	envVarprinter := envvars.ConsoleEnvVarPrinter{
+		WindowsIp:        conf.Network.P2p.WindowsIp,
+		PxProxyPort:      conf.Network.PxProxyPort,
+		Mirrored:         mirrored,
+		NoProxy:          conf.Network.NoProxy,
+		JavaTruststore:   conf.Certificates.JavaPkcs12Truststore,
+		CaBundle:         conf.Certificates.CaBundle,
+		CaBundleEnvVars:  conf.Certificates.CaBundleEnvVars,
+		CombinedCaBundle: filepath.Join(stateDir, "ca-bundle.pem"),
+	}
+
+	linuxPinger := linux.LinuxPingerImpl{}
+
+	linuxConfigurer := linux.LinuxConfigurerImpl{
+		WindowsIp:  conf.Network.P2p.WindowsIp,
+		LinuxIp:    conf.Network.P2p.LinuxIp,
+		SubnetMask: conf.Network.P2p.SubnetMask,
+		Interface:  conf.Network.LinuxInterface,
+	}
+
+	wslAdapter := windows.WslAdapter{
+		Name:           conf.Network.WindowsAdapter,
+		WindowsIp:      conf.Network.P2p.WindowsIp,
+		DefaultGateway: linuxConfigurer.DefaultGateway,
+	}
+
+	windowsChecker := windows.WindowsCheckerImpl{
+		PxProxyPort: conf.Network.PxProxyPort,
+		Adapter:     &wslAdapter,
+	}
+
+	windowsConfigurer := windows.WindowsConfigurerImpl{
+		WindowsIp:      conf.Network.P2p.WindowsIp,
+		SubnetMask:     conf.Network.P2p.SubnetMask,
+		PxProxyPort:    conf.Network.PxProxyPort,
+		Adapter:        &wslAdapter,
+		Gsudo:          &gsudo.Gsudo{Elevated: options.WindowsElevated},
+		NonInteractive: options.NonInteractive,
+	}
+
+	dnsConfigurer := dnsconfig.DnsConfigurerImpl{}
+	httpchecker, err := httpchecker.New(conf.General.InternetAccessTestUrl, config.GetProxyUrl(conf, mirrored))
+	if err != nil {
+		return core.Handler{}, err
+	}
+	httpchecker.CaptivePortalTestUrl = conf.General.CaptivePortalTestUrl
+
+	directAccess := core.DirectAccess{
+		PublicDnsServer: conf.Dns.PublicServer,
+		DnsConfigurer:   dnsConfigurer,
+		LinuxPinger:     &linuxPinger,
+		LinuxConfigurer: &linuxConfigurer,
+		HttpChecker:     &httpchecker,
+		EnvVarPrinter:   &envVarprinter,
+	}
+
+	viaproxy := core.ViaProxy{
+		// static
+		LinuxP2pIp:        conf.Network.P2p.LinuxIp,
+		WindowsP2pIp:      conf.Network.P2p.WindowsIp,
+		PxProxyPort:       conf.Network.PxProxyPort,
+		InternalDnsServer: conf.Dns.InternalServer,
+		// objects
+		WindowsChecker:    &windowsChecker,
+		WindowsConfigurer: &windowsConfigurer,
+		DnsConfigurer:     &dnsConfigurer,
+		LinuxPinger:       &linuxPinger,
+		LinuxConfigurer:   &linuxConfigurer,
+		HttpChecker:       &httpchecker,
+	}
+
+	splitTunnel := core.SplitTunnel{
+		LinuxP2pIp:        conf.Network.P2p.LinuxIp,
+		WindowsP2pIp:      conf.Network.P2p.WindowsIp,
+		InternalDnsServer: conf.Dns.InternalServer,
+		InternalCidrs:     conf.SplitTunnel.InternalCidrs,
+		IntranetTestUrl:   conf.SplitTunnel.IntranetTestUrl,
+		WindowsChecker:    &windowsChecker,
+		WindowsConfigurer: &windowsConfigurer,
+		DnsConfigurer:     &dnsConfigurer,
+		LinuxPinger:       &linuxPinger,
+		LinuxConfigurer:   &linuxConfigurer,
+		HttpChecker:       &httpchecker,
+		EnvVarPrinter:     &envVarprinter,
+	}
+
+	reconciler := core.Reconciler{
+		InternalDnsServer: conf.Dns.InternalServer,
+		PublicDnsServer:   conf.Dns.PublicServer,
+		LinuxP2pIp:        conf.Network.P2p.LinuxIp,
+		WindowsP2pIp:      conf.Network.P2p.WindowsIp,
+		SubnetMask:        conf.Network.P2p.SubnetMask,
+		PxProxyPort:       conf.Network.PxProxyPort,
+		InternalCidrs:     conf.SplitTunnel.InternalCidrs,
+		NetworkingMode:    networkingMode,
+		DnsConfigurer:     &dnsConfigurer,
+		LinuxConfigurer:   &linuxConfigurer,
+		WindowsChecker:    &windowsChecker,
+		WindowsConfigurer: &windowsConfigurer,
+		LinuxPinger:       &linuxPinger,
+		HttpChecker:       &httpchecker,
+	}
+
+	runningAsRoot := os.Geteuid() == 0
+
+	overrideStore := statefile.OverrideStoreImpl{Path: filepath.Join(stateDir, "override.json")}
+
+	scenarioChain, err := setupScenarioChain(conf, &windowsChecker, &overrideStore)
+	if err != nil {
+		return core.Handler{}, err
+	}
+
+	detector := core.Detector{
+		RunningAsRoot:     runningAsRoot,
+		InternalDnsServer: conf.Dns.InternalServer,
+		PublicDnsServer:   conf.Dns.PublicServer,
+		LinuxP2pIp:        conf.Network.P2p.LinuxIp,
+		WindowsP2pIp:      conf.Network.P2p.WindowsIp,
+		NetworkingMode:    networkingMode,
+		WindowsChecker:    &windowsChecker,
+		LinuxPinger:       &linuxPinger,
+		HttpChecker:       &httpchecker,
+		InternetChecker:   core.NewInternetChecker(&httpchecker),
+		ScenarioDetector:  scenarioChain,
+	}
+
+	configurers := map[model.Scenario]core.NetworkConfigurer{
+		model.ScenarioViaProxy:    &viaproxy,
+		model.ScenarioDirect:      &directAccess,
+		model.ScenarioSplitTunnel: &splitTunnel,
+	}
+	if mirrored {
+		mirroredConfigurer := core.Mirrored{
+			InternalDnsServer: conf.Dns.InternalServer,
+			PublicDnsServer:   conf.Dns.PublicServer,
+			PxProxyPort:       conf.Network.PxProxyPort,
+			DnsConfigurer:     &dnsConfigurer,
+			HttpChecker:       &httpchecker,
+			EnvVarPrinter:     &envVarprinter,
+		}
+		for scenario := range configurers {
+			configurers[scenario] = &mirroredConfigurer
+		}
+	}
+
+	handler := core.Handler{
+		RunningAsRoot:    runningAsRoot,
+		PublicDnsServer:  conf.Dns.PublicServer,
+		PreferFasterPath: conf.General.PreferFasterPath,
+		DnsConfigurer:    &dnsConfigurer,
+		EnvVarPrinter:    &envVarprinter,
+		Configurers:      configurers,
+		NetworkDetector:  &detector,
+		Reconciler:       &reconciler,
+		OverrideStore:    &overrideStore,
+		WslConfig:        &wslconfig.WslConfigImpl{NetworkingMode: networkingMode},
+		IntranetVerifier: core.IntranetVerifier{
+			Targets: intranetTargets(conf.IntranetChecks),
+			Checker: &httpchecker,
+		},
+	}
+
+	return handler, nil
+}
+
+// cheap, reading .wslconfig is left to the commands which need it
+func detectNetworkingMode(ctx context.Context, conf config.Config) model.NetworkingMode {
+	mode := wslconfig.DetectNetworkingMode(ctx, conf.Network.NetworkingMode)
+	log.FromContext(ctx).Debug("WSL networking mode: %v", mode)
+	return mode
+}
+
+func intranetTargets(checks []config.IntranetCheck) []model.IntranetTarget {
+	targets := make([]model.IntranetTarget, 0, len(checks))
+	for _, check := range checks {
+		targets = append(targets, model.IntranetTarget{
+			Target:   check.Target,
+			ViaProxy: check.Via == "proxy",
+			Required: check.Required,
+		})
+	}
+	return targets
+}
+
+// the scenario pinned via 'isetta use' first, then the detectors in the configured order
+func setupScenarioChain(conf config.Config, windowsChecker core.WindowsChecker, overrideStore core.OverrideStore) (*core.ScenarioChain, error) {
+	detection := conf.Detection
+	chain := core.ScenarioChain{
+		MinConfidence: detection.MinConfidence,
+		Detectors:     []core.ScenarioDetector{&core.PinnedScenarioDetector{Store: overrideStore}},
+	}
+	for _, name := range detection.Detectors {
+		scenario, err := matchScenario(detection, name)
+		if err != nil {
+			return nil, err
+		}
+		var detector core.ScenarioDetector
+		switch name {
+		case core.DetectorOverride:
+			detector = &core.OverrideDetector{Scenario: detection.Override}
+		case core.DetectorDnsSuffix:
+			detector = &core.DnsSuffixDetector{Suffixes: detection.DnsSuffixes, Scenario: scenario, WindowsChecker: windowsChecker}
+		case core.DetectorVpnAdapter:
+			detector = &core.VpnAdapterDetector{Adapters: detection.VpnAdapters, Scenario: scenario, WindowsChecker: windowsChecker}
+		case core.DetectorSsid:
+			detector = &core.SsidDetector{Ssids: detection.Ssids, Scenario: scenario, WindowsChecker: windowsChecker}
+		case core.DetectorIntranetUrl:
+			detector = &core.IntranetUrlDetector{Url: detection.IntranetUrl, Scenario: scenario, WindowsChecker: windowsChecker}
+		case core.DetectorDns:
+			publicHost, publicPort, err := config.GetInternetAccessTestAddress(conf)
+			if err != nil {
+				return nil, err
+			}
+			detector = &core.DnsDetector{
+				InternalDnsServer: conf.Dns.InternalServer,
+				PublicDnsServer:   conf.Dns.PublicServer,
+				SplitTunnel:       conf.SplitTunnel.Enabled,
+				PublicHost:        publicHost,
+				PublicPort:        publicPort,
+				WindowsChecker:    windowsChecker,
+			}
+		}
+		chain.Detectors = append(chain.Detectors, detector)
+	}
+	return &chain, nil
+}
+
+// scenario the detector reports when it recognizes the network. The other
+// detectors decide the scenario themselves
+func matchScenario(detection config.Detection, detector string) (model.Scenario, error) {
+	names := map[string]string{
+		core.DetectorDnsSuffix:   detection.DnsSuffixScenario,
+		core.DetectorVpnAdapter:  detection.VpnAdapterScenario,
+		core.DetectorSsid:        detection.SsidScenario,
+		core.DetectorIntranetUrl: detection.IntranetUrlScenario,
+	}
+	name, found := names[detector]
+	if !found {
+		return model.ScenarioOffline, nil
+	}
+	return model.ParseScenario(name)
+}
+
+func setupJavaTruststore(conf config.Config) core.JavaTruststore {
+	return core.JavaTruststore{
+		RunningAsRoot:    os.Geteuid() == 0,
+		Pkcs12Truststore: conf.Certificates.JavaPkcs12Truststore,
+		CaCertificateExporter: &windows.CaCertificateExporterImpl{
+			SubjectFilters: conf.Certificates.WindowsCaSubjects,
+			CaBundlePath:   conf.Certificates.CaBundle,
+		},
+		JavaTruststoreConfigurer: &java.JavaTruststoreConfigurerImpl{
+			AliasPrefix:          conf.Certificates.JavaAliasPrefix,
+			StorePassword:        conf.Certificates.JavaTruststorePassword,
+			Pkcs12TruststorePath: conf.Certificates.JavaPkcs12Truststore,
+		},
+	}
+}
+
+// the task runs elevated, so its runs change the Windows side without a prompt
+func setupScheduledTask() *windows.ScheduledTask {
+	return &windows.ScheduledTask{
+		Gsudo: &gsudo.Gsudo{},
+		Flags: []string{NonInteractiveFlag, WindowsElevatedFlag},
+	}
+}
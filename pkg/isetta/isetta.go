@@ -0,0 +1,232 @@
+package isetta
+
+// embeddable API of isetta, e.g. for tools which bootstrap a development
+// environment. The CLI is a thin user of it.
+//
+// usage:
+//
+//	conf, err := config.Default()
+//	conf.Dns.InternalServer = "10.0.0.1"
+//	client, err := isetta.New(isetta.Options{Config: conf})
+//	...
+//	snapshot, err := client.Detect(ctx)
+//
+// Each client logs, runs external commands and writes files via its own
+// logger, runner and backup store, which it passes down in the context of its
+// calls. Several clients can be used in one process.
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"org.samba/isetta/adapter/autostart"
+	"org.samba/isetta/adapter/windows"
+	"org.samba/isetta/cmdrunner"
+	"org.samba/isetta/config"
+	"org.samba/isetta/core"
+	"org.samba/isetta/core/model"
+	"org.samba/isetta/safefile"
+	log "org.samba/isetta/simplelogger"
+	"org.samba/isetta/userdir"
+)
+
+type (
+	Snapshot          = model.Snapshot
+	Scenario          = model.Scenario
+	InternetPaths     = model.InternetPaths
+	PathCheck         = model.PathCheck
+	Status            = model.Status
+	Drift             = model.Drift
+	Override          = model.Override
+	EnvVar            = model.EnvVar
+	EnvVarChanges     = model.EnvVarChanges
+	WslConfigConflict = model.WslConfigConflict
+	FileBackup        = safefile.Backup
+	WindowsTaskStatus = windows.TaskStatus
+)
+
+var (
+	// no network at all, nothing was configured
+	ErrOffline = core.ErrOffline
+	// the user has to log into the captive portal first
+	ErrCaptivePortal = core.ErrCaptivePortal
+	// a non-interactive run left the changes on the Windows side to the next interactive one
+	ErrElevationDeferred = core.ErrElevationDeferred
+	// the network was configured, but a required intranet target can't be reached
+	ErrIntranetUnreachable = core.ErrIntranetUnreachable
+)
+
+type Options struct {
+	// e.g. from config.Load or config.Default, validated by New
+	Config config.Config
+	// optional, the logger of this client. Default: the process-wide one
+	Logger *log.Sl
+	// optional, runs the external commands of this client, e.g. to record or
+	// replay them. Default: executes them
+	Runner cmdrunner.Runner
+	// optional, where the pinned scenario is kept. Default: the user's state directory
+	StateDir string
+	// does not roll back the changes of a failed configuration, for debugging
+	KeepPartialState bool
+	// defers the changes on the Windows side instead of prompting via gsudo,
+	// e.g. at WSL start
+	NonInteractive bool
+	// Windows runs isetta elevated already, e.g. the scheduled task. The
+	// Windows side is changed without gsudo, also when NonInteractive
+	WindowsElevated bool
+	// changed files are only logged, not written, e.g. while replaying a recording
+	DryRun bool
+}
+
+// command line flags of the runs started by autostart and the scheduled task
+const (
+	NonInteractiveFlag  = autostart.NonInteractiveFlag
+	WindowsElevatedFlag = "-windows-elevated"
+)
+
+type Client struct {
+	conf    config.Config
+	handler core.Handler
+	logger  *log.Sl          // nil for the process-wide logger
+	runner  cmdrunner.Runner // nil for cmdrunner.Default
+	store   safefile.Store
+}
+
+func New(options Options) (*Client, error) {
+	conf := options.Config
+	err := config.Validate(&conf, log.GetValidLogLevels())
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	client := &Client{
+		conf:   conf,
+		logger: options.Logger,
+		runner: options.Runner,
+		store:  safefile.Store{Dir: conf.General.BackupDir, Keep: conf.General.BackupsToKeep, DryRun: options.DryRun},
+	}
+
+	stateDir := options.StateDir
+	if stateDir == "" {
+		stateDir, err = userdir.StateDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine state directory: %w", err)
+		}
+	}
+
+	client.handler, err = setupDependencies(client.context(context.Background()), conf, stateDir, options)
+	if err != nil {
+		return nil, err
+	}
+	client.handler.KeepPartialState = options.KeepPartialState
+	return client, nil
+}
+
+// passes the logger, the runner and the backup store of the client down to
+// the adapters
+func (c *Client) context(ctx context.Context) context.Context {
+	if c.logger != nil {
+		ctx = log.WithLogger(ctx, *c.logger)
+	}
+	if c.runner != nil {
+		ctx = cmdrunner.WithRunner(ctx, c.runner)
+	}
+	return safefile.WithStore(ctx, c.store)
+}
+
+// Detects the scenario and the state of the network, changes nothing
+func (c *Client) Detect(ctx context.Context) (Snapshot, error) {
+	return c.handler.Detect(c.context(ctx))
+}
+
+// Configures the network for the detected scenario. Requires root. Changes
+// are rolled back on failure
+func (c *Client) Configure(ctx context.Context) error {
+	return c.handler.ConfigureNetwork(c.context(ctx))
+}
+
+// The proxy variables a shell should export or unset for the current network
+func (c *Client) EnvVars(ctx context.Context) (EnvVarChanges, error) {
+	ctx = c.context(ctx)
+	changes := c.handler.EnvVars(ctx)
+	return changes, ctx.Err()
+}
+
+// Prints the export or unset commands of EnvVars to stdout, to be 'source'd
+func (c *Client) PrintEnvVars(ctx context.Context) error {
+	ctx = c.context(ctx)
+	c.handler.PrintEnvVars(ctx)
+	return ctx.Err()
+}
+
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	return c.handler.Status(c.context(ctx))
+}
+
+// Returns the drift from the desired network state, changes nothing
+func (c *Client) Verify(ctx context.Context) ([]Drift, error) {
+	return c.handler.VerifyNetwork(c.context(ctx))
+}
+
+// The .wslconfig settings which interfere with isetta, also part of the Status
+func (c *Client) WslConfigConflicts(ctx context.Context) ([]WslConfigConflict, error) {
+	return c.handler.WslConfig.Conflicts(c.context(ctx))
+}
+
+// Sets the recommended values in .wslconfig and returns the corrected
+// settings. They only apply after 'wsl --shutdown'
+func (c *Client) ApplyWslConfig(ctx context.Context) ([]WslConfigConflict, error) {
+	return c.handler.ApplyWslConfig(c.context(ctx))
+}
+
+// Backups of the files isetta changed, e.g. /etc/resolv.conf, the newest first
+func (c *Client) FileBackups() ([]FileBackup, error) {
+	return c.store.Backups()
+}
+
+// Restores a file from its backup. Its current content is backed up as well
+func (c *Client) RestoreFile(ctx context.Context, backup FileBackup) error {
+	return c.store.Restore(c.context(ctx), backup)
+}
+
+// Runs isetta non-interactively at every start of the WSL distro. Returns the
+// file which starts it, the systemd unit or /etc/wsl.conf. Requires root
+func (c *Client) EnableAutostart(ctx context.Context) (string, error) {
+	return autostart.New().Enable(c.context(ctx))
+}
+
+func (c *Client) DisableAutostart(ctx context.Context) error {
+	return autostart.New().Disable(c.context(ctx))
+}
+
+// Registers a Windows scheduled task which runs isetta in this distro on
+// logon and on network changes, e.g. connecting to the VPN. Replaces an
+// existing task. Prompts for elevation via gsudo
+func (c *Client) InstallWindowsTask(ctx context.Context) error {
+	return setupScheduledTask().Install(c.context(ctx))
+}
+
+func (c *Client) UninstallWindowsTask(ctx context.Context) error {
+	return setupScheduledTask().Uninstall(c.context(ctx))
+}
+
+func (c *Client) WindowsTaskStatus(ctx context.Context) (WindowsTaskStatus, error) {
+	return setupScheduledTask().Status(c.context(ctx))
+}
+
+// Pins the scenario for the given duration, 0 means until cleared. "auto"
+// detects the scenario again
+func (c *Client) Use(scenario string, duration time.Duration) error {
+	return c.handler.UseScenario(scenario, duration)
+}
+
+// the pinned scenario and whether it is still active
+func (c *Client) ActiveOverride() (Override, bool, error) {
+	return c.handler.ActiveOverride()
+}
+
+// Imports the corporate CA certificates into the truststores of the installed JDKs
+func (c *Client) ImportJavaTruststore(ctx context.Context) error {
+	ctx = c.context(ctx)
+	truststore := setupJavaTruststore(c.conf)
+	return truststore.Configure(ctx)
+}
@@ -0,0 +1,25 @@
+package isetta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/config"
+)
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	conf, err := config.Default()
+	assert.NoError(t, err)
+	_, err = New(Options{Config: conf})
+	assert.ErrorContains(t, err, "invalid config")
+}
+
+func TestNewWithDefaultConfig(t *testing.T) {
+	conf, err := config.Default()
+	assert.NoError(t, err)
+	conf.Dns.InternalServer = "10.0.0.1"
+
+	client, err := New(Options{Config: conf, StateDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.Equal(t, "169.254.254.1", client.conf.Network.P2p.WindowsIp)
+}
@@ -0,0 +1,137 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// deeper steps are only shown while running, e.g. single PowerShell calls
+const DefaultMaxDepth = 2
+
+// On a terminal, finished steps are listed and the innermost running step is
+// shown on a status line which is updated in place. Otherwise every event is
+// written as a plain line.
+type ConsoleObserver struct {
+	MaxDepth int
+	w        io.Writer
+	tty      bool
+	mu       sync.Mutex
+	running  []runningStep
+	status   bool // status line is currently shown
+}
+
+type runningStep struct {
+	name    string
+	attempt int
+}
+
+func NewConsoleObserver(f *os.File) *ConsoleObserver {
+	return &ConsoleObserver{MaxDepth: DefaultMaxDepth, w: f, tty: isTerminal(f)}
+}
+
+func NewPlainObserver(w io.Writer) *ConsoleObserver {
+	return &ConsoleObserver{MaxDepth: DefaultMaxDepth, w: w}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// messages are shown by the log already, see LogObserver
+func (c *ConsoleObserver) Notify(e Event) {
+	if e.Kind == Message {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tty {
+		c.render(e)
+	} else {
+		c.writePlain(e)
+	}
+}
+
+func (c *ConsoleObserver) writePlain(e Event) {
+	switch e.Kind {
+	case StepStarted:
+		if e.Depth <= c.MaxDepth {
+			fmt.Fprintf(c.w, "%vStarted: %v\n", indent(e.Depth), e)
+		}
+	case StepFinished:
+		if e.Depth <= c.MaxDepth {
+			fmt.Fprintf(c.w, "%vFinished: %v\n", indent(e.Depth), e)
+		}
+	case RetryAttempt:
+		fmt.Fprintf(c.w, "%vRetrying: %v\n", indent(min(e.Depth, c.MaxDepth)), e)
+	default:
+		fmt.Fprintln(c.w, e)
+	}
+}
+
+func (c *ConsoleObserver) render(e Event) {
+	c.clearStatus()
+
+	switch e.Kind {
+	case StepStarted:
+		c.running = append(c.running, runningStep{name: e.Step, attempt: 1})
+	case StepFinished:
+		c.removeRunning(e.Step)
+		if e.Depth <= c.MaxDepth {
+			fmt.Fprintf(c.w, "%v✓ %v\n", indent(e.Depth), e)
+		}
+	case RetryAttempt:
+		for i := len(c.running) - 1; i >= 0; i-- {
+			if c.running[i].name == e.Step {
+				c.running[i].attempt = e.Attempt
+				break
+			}
+		}
+	case WaitingForElevation:
+		fmt.Fprintf(c.w, "! %v\n", e)
+	default:
+		fmt.Fprintf(c.w, "• %v\n", e)
+	}
+
+	c.drawStatus()
+}
+
+// parallel steps can finish in any order
+func (c *ConsoleObserver) removeRunning(name string) {
+	for i := len(c.running) - 1; i >= 0; i-- {
+		if c.running[i].name == name {
+			c.running = append(c.running[:i], c.running[i+1:]...)
+			return
+		}
+	}
+}
+
+// the cursor stays at the start of the status line, so interleaved log
+// output overwrites it instead of being appended
+func (c *ConsoleObserver) drawStatus() {
+	if len(c.running) == 0 {
+		return
+	}
+	step := c.running[len(c.running)-1]
+	line := "… " + step.name
+	if step.attempt > 1 {
+		line += fmt.Sprintf(" (attempt %d)", step.attempt)
+	}
+	fmt.Fprintf(c.w, "%v\r", line)
+	c.status = true
+}
+
+func (c *ConsoleObserver) clearStatus() {
+	if c.status {
+		fmt.Fprint(c.w, "\r\033[K")
+		c.status = false
+	}
+}
+
+func indent(depth int) string {
+	return strings.Repeat("  ", max(depth-1, 0))
+}
@@ -0,0 +1,55 @@
+package progress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlainLinesWithoutTerminal(t *testing.T) {
+	var out bytes.Buffer
+	observer := NewPlainObserver(&out)
+
+	observer.Notify(Event{Kind: StepStarted, Step: "Configuring access via proxy", Depth: 1})
+	observer.Notify(Event{Kind: StepStarted, Step: "Running Powershell command", Depth: 3})
+	observer.Notify(Event{Kind: RetryAttempt, Step: "Setting Windows portproxy", Depth: 3, Attempt: 2})
+	observer.Notify(Event{Kind: WaitingForElevation, Reason: "Setting Windows portproxy"})
+	observer.Notify(Event{Kind: StepFinished, Step: "Configuring access via proxy", Depth: 1, Duration: 1500 * time.Millisecond})
+
+	assert.Equal(t, `Started: Configuring access via proxy
+  Retrying: Setting Windows portproxy (attempt 2)
+Waiting for elevation, confirm the UAC prompt on Windows: Setting Windows portproxy
+Finished: Configuring access via proxy (1.5s)
+`, out.String())
+}
+
+func TestTerminalShowsInnermostRunningStep(t *testing.T) {
+	var out bytes.Buffer
+	observer := &ConsoleObserver{MaxDepth: DefaultMaxDepth, w: &out, tty: true}
+
+	observer.Notify(Event{Kind: StepStarted, Step: "Configuring Windows side", Depth: 1})
+	observer.Notify(Event{Kind: StepStarted, Step: "Setting Windows portproxy", Depth: 2})
+	observer.Notify(Event{Kind: RetryAttempt, Step: "Setting Windows portproxy", Depth: 2, Attempt: 3})
+	assert.Contains(t, out.String(), "… Setting Windows portproxy (attempt 3)\r")
+
+	out.Reset()
+	observer.Notify(Event{Kind: StepFinished, Step: "Setting Windows portproxy", Depth: 2, Duration: 2 * time.Second})
+	assert.Equal(t, "\r\033[K  ✓ Setting Windows portproxy (2.0s)\n… Configuring Windows side\r", out.String())
+
+	out.Reset()
+	observer.Notify(Event{Kind: StepFinished, Step: "Configuring Windows side", Depth: 1, Duration: 2 * time.Second})
+	assert.Equal(t, "\r\033[K✓ Configuring Windows side (2.0s)\n", out.String())
+}
+
+func TestMessagesAreLeftToTheLog(t *testing.T) {
+	var out bytes.Buffer
+	observer := &ConsoleObserver{MaxDepth: DefaultMaxDepth, w: &out, tty: true}
+
+	observer.Notify(Event{Kind: StepStarted, Step: "Configuring Windows side", Depth: 1})
+	out.Reset()
+	observer.Notify(Event{Kind: Message, Text: "Configuring network for scenario 'proxy'"})
+
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,22 @@
+package progress
+
+import (
+	log "org.samba/isetta/simplelogger"
+)
+
+// writes the events to the log, e.g. to keep them in the log file. Messages
+// are the info lines of isetta, they reach the console this way
+type LogObserver struct{}
+
+func (LogObserver) Notify(e Event) {
+	switch e.Kind {
+	case StepStarted, StepFinished:
+		log.Logger.Trace("%v: %v", e.Kind, e)
+	case RetryAttempt:
+		log.Logger.Trace("Retrying %v", e)
+	case Message:
+		log.Logger.Info("%v", e)
+	default:
+		log.Logger.Debug("%v", e)
+	}
+}
@@ -0,0 +1,138 @@
+package progress
+
+// typed progress events of an isetta run, e.g. for a live step list on the
+// console. Steps started via the timing package are reported automatically.
+//
+// usage:
+//
+//	progress.Subscribe(progress.NewConsoleObserver(os.Stderr))
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Kind int
+
+const (
+	StepStarted Kind = iota
+	StepFinished
+	RetryAttempt
+	// the user has to confirm a UAC prompt on the Windows side
+	WaitingForElevation
+	ScenarioDetected
+	// a step of isetta itself, e.g. "Configuring network for scenario 'proxy'"
+	Message
+)
+
+func (k Kind) String() string {
+	switch k {
+	case StepStarted:
+		return "step_started"
+	case StepFinished:
+		return "step_finished"
+	case RetryAttempt:
+		return "retry_attempt"
+	case WaitingForElevation:
+		return "waiting_for_elevation"
+	case ScenarioDetected:
+		return "scenario_detected"
+	case Message:
+		return "message"
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// step and retry events
+	Step     string
+	Depth    int           // 1 for top-level steps
+	Duration time.Duration // finished steps only
+	Attempt  int           // retries only, the second attempt is 2
+	// scenario events
+	Scenario   string
+	Detector   string
+	Confidence float64
+	// elevation events
+	Reason string
+	// message events
+	Text string
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case StepStarted:
+		return e.Step
+	case StepFinished:
+		return fmt.Sprintf("%v (%.1fs)", e.Step, e.Duration.Seconds())
+	case RetryAttempt:
+		return fmt.Sprintf("%v (attempt %d)", e.Step, e.Attempt)
+	case WaitingForElevation:
+		return fmt.Sprintf("Waiting for elevation, confirm the UAC prompt on Windows: %v", e.Reason)
+	case ScenarioDetected:
+		return fmt.Sprintf("Detected scenario '%v' (detector '%v', confidence %.2f)", e.Scenario, e.Detector, e.Confidence)
+	case Message:
+		return e.Text
+	}
+	return e.Kind.String()
+}
+
+type Observer interface {
+	Notify(e Event)
+}
+
+// adapts a plain function, e.g. for tests
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Notify(e Event) {
+	f(e)
+}
+
+// passes events synchronously to all subscribed observers
+type Dispatcher struct {
+	mu        sync.RWMutex
+	observers []Observer
+}
+
+// global dispatcher
+var Default = &Dispatcher{}
+
+func Subscribe(o Observer) {
+	Default.Subscribe(o)
+}
+
+func Emit(e Event) {
+	Default.Emit(e)
+}
+
+func (d *Dispatcher) Subscribe(o Observer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observers = append(d.observers, o)
+}
+
+func (d *Dispatcher) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, o := range d.observers {
+		o.Notify(e)
+	}
+}
+
+func EmitScenarioDetected(scenario string, detector string, confidence float64) {
+	Emit(Event{Kind: ScenarioDetected, Scenario: scenario, Detector: detector, Confidence: confidence})
+}
+
+func EmitWaitingForElevation(reason string) {
+	Emit(Event{Kind: WaitingForElevation, Reason: reason})
+}
+
+func EmitMessage(format string, v ...any) {
+	Emit(Event{Kind: Message, Text: fmt.Sprintf(format, v...)})
+}
@@ -0,0 +1,371 @@
+package safefile
+
+// writes the system files isetta manages, e.g. /etc/resolv.conf or
+// /etc/wsl.conf. A change replaces the file atomically, so a crash or Ctrl-C
+// never leaves a half written file behind. The previous content is kept as a
+// timestamped backup first, see 'isetta restore-file'. A dry run, e.g. while
+// replaying a recording, only logs the changes.
+//
+// usage:
+//
+//	err := safefile.Write(ctx, "/etc/resolv.conf", content, 0644)
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "org.samba/isetta/simplelogger"
+)
+
+const (
+	DefaultDir  = "/var/lib/isetta/backups"
+	DefaultKeep = 10
+)
+
+// sortable, without characters which are special in file names
+const timeFormat = "2006-01-02T15-04-05.000000000"
+const backupSuffix = ".bak"
+
+// the backup of a symlink holds its target instead of the content
+const linkSuffix = ".link"
+
+// Backups are kept per file in a directory mirroring its path, e.g.
+// <Dir>/etc/resolv.conf/2024-05-01T10-15-00.000000000.bak
+type Store struct {
+	Dir    string
+	Keep   int  // backups per file, older ones are removed
+	DryRun bool // files are neither written nor removed
+}
+
+// global store, used without one in the context, e.g. by tests
+var Default = Store{Dir: DefaultDir, Keep: DefaultKeep}
+
+type contextKey struct{}
+
+// the store of a run, e.g. of an isetta.Client
+func WithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, contextKey{}, store)
+}
+
+func FromContext(ctx context.Context) Store {
+	if store, ok := ctx.Value(contextKey{}).(Store); ok {
+		return store
+	}
+	return Default
+}
+
+type Backup struct {
+	Path string // of the backed up file, e.g. /etc/resolv.conf
+	File string // the backup itself
+	Time time.Time
+	Link bool // restoring recreates the symlink
+}
+
+func Write(ctx context.Context, path string, content []byte, perm os.FileMode) error {
+	return FromContext(ctx).Write(ctx, path, content, perm)
+}
+
+func Remove(ctx context.Context, path string) error {
+	return FromContext(ctx).Remove(ctx, path)
+}
+
+func ReplaceSymlink(ctx context.Context, path string, content []byte, perm os.FileMode) error {
+	return FromContext(ctx).ReplaceSymlink(ctx, path, content, perm)
+}
+
+func Symlink(ctx context.Context, target string, path string) error {
+	return FromContext(ctx).Symlink(ctx, target, path)
+}
+
+func Backups(ctx context.Context) ([]Backup, error) {
+	return FromContext(ctx).Backups()
+}
+
+func Restore(ctx context.Context, backup Backup) error {
+	return FromContext(ctx).Restore(ctx, backup)
+}
+
+// Backs up the current content and replaces it atomically. Symlinks are
+// followed, the file they point to is replaced. An existing file keeps its
+// permissions, 'perm' applies to new files. Nothing is written if the content
+// is unchanged
+func (s Store) Write(ctx context.Context, path string, content []byte, perm os.FileMode) error {
+	target := resolveSymlinks(path)
+	current, err := os.ReadFile(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	exists := err == nil
+	if exists && bytes.Equal(current, content) {
+		log.FromContext(ctx).Trace("%v is unchanged", path)
+		return nil
+	}
+	if s.DryRun {
+		log.FromContext(ctx).Info("Dry run, not writing %v", path)
+		return nil
+	}
+
+	if exists {
+		err = s.backup(ctx, path, current)
+		if err != nil {
+			return err
+		}
+		if info, statErr := os.Stat(target); statErr == nil {
+			perm = info.Mode().Perm()
+		}
+	}
+	return writeAtomic(target, content, perm)
+}
+
+// backs up the file before removing it, a missing file is fine
+func (s Store) Remove(ctx context.Context, path string) error {
+	current, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	if s.DryRun {
+		log.FromContext(ctx).Info("Dry run, not removing %v", path)
+		return nil
+	}
+	err = s.backup(ctx, path, current)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Replaces a symlink with a regular file instead of writing through it. The
+// target of the link is backed up, restoring recreates the link. Anything but
+// a symlink is written as usual
+func (s Store) ReplaceSymlink(ctx context.Context, path string, content []byte, perm os.FileMode) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return s.Write(ctx, path, content, perm)
+	}
+	if s.DryRun {
+		log.FromContext(ctx).Info("Dry run, not replacing symlink %v", path)
+		return nil
+	}
+	err = s.backupAs(ctx, path, []byte(target), linkSuffix)
+	if err != nil {
+		return err
+	}
+	// the rename replaces the link itself, not the file it points to
+	return writeAtomic(path, content, perm)
+}
+
+// Points path to target, e.g. to restore a replaced symlink. The current file
+// or link is backed up first. Nothing changes if path already links to target
+func (s Store) Symlink(ctx context.Context, target string, path string) error {
+	current, err := os.Readlink(path)
+	if err == nil && current == target {
+		log.FromContext(ctx).Trace("%v already links to %v", path, target)
+		return nil
+	}
+	if s.DryRun {
+		log.FromContext(ctx).Info("Dry run, not linking %v to %v", path, target)
+		return nil
+	}
+
+	err = s.backupLinkOrFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	return linkAtomic(target, path)
+}
+
+// a missing file needs no backup
+func (s Store) backupLinkOrFile(ctx context.Context, path string) error {
+	if target, err := os.Readlink(path); err == nil {
+		return s.backupAs(ctx, path, []byte(target), linkSuffix)
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	return s.backup(ctx, path, content)
+}
+
+// all backups, the newest first
+func (s Store) Backups() ([]Backup, error) {
+	backups := []Backup{}
+	err := filepath.WalkDir(s.Dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && file == s.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		backup, ok := s.parseBackup(file, entry)
+		if ok {
+			backups = append(backups, backup)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backups in %v: %w", s.Dir, err)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// the current content is backed up as well, so restoring can be undone
+func (s Store) Restore(ctx context.Context, backup Backup) error {
+	content, err := os.ReadFile(backup.File)
+	if err != nil {
+		return fmt.Errorf("unable to read backup %v: %w", backup.File, err)
+	}
+	log.FromContext(ctx).Info("Restoring %v from the backup of %v", backup.Path, backup.Time.Local().Format(time.DateTime))
+	if backup.Link {
+		return s.Symlink(ctx, string(content), backup.Path)
+	}
+	return s.Write(ctx, backup.Path, content, 0644)
+}
+
+func (s Store) parseBackup(file string, entry os.DirEntry) (Backup, bool) {
+	if entry.IsDir() || !isBackup(entry.Name()) {
+		return Backup{}, false
+	}
+	link := strings.HasSuffix(entry.Name(), linkSuffix)
+	backupTime, err := time.Parse(timeFormat, strings.TrimSuffix(strings.TrimSuffix(entry.Name(), backupSuffix), linkSuffix))
+	if err != nil {
+		return Backup{}, false
+	}
+	rel, err := filepath.Rel(s.Dir, filepath.Dir(file))
+	if err != nil {
+		return Backup{}, false
+	}
+	return Backup{Path: "/" + filepath.ToSlash(rel), File: file, Time: backupTime, Link: link}, true
+}
+
+func isBackup(name string) bool {
+	return strings.HasSuffix(name, backupSuffix) || strings.HasSuffix(name, linkSuffix)
+}
+
+func (s Store) backup(ctx context.Context, path string, content []byte) error {
+	return s.backupAs(ctx, path, content, backupSuffix)
+}
+
+func (s Store) backupAs(ctx context.Context, path string, content []byte, suffix string) error {
+	dir := filepath.Join(s.Dir, path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create backup directory %v: %w", dir, err)
+	}
+
+	file := filepath.Join(dir, time.Now().UTC().Format(timeFormat)+suffix)
+	err = writeAtomic(file, content, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to back up %v: %w", path, err)
+	}
+	log.FromContext(ctx).Debug("Backed up %v to %v", path, file)
+	s.prune(ctx, dir)
+	return nil
+}
+
+// best effort, a leftover backup doesn't hurt
+func (s Store) prune(ctx context.Context, dir string) {
+	if s.Keep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.FromContext(ctx).Debug("Unable to prune backups in %v: %v", dir, err)
+		return
+	}
+
+	// ReadDir sorts by name, which is the order of the timestamps
+	backups := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && isBackup(entry.Name()) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	for len(backups) > s.Keep {
+		err = os.Remove(filepath.Join(dir, backups[0]))
+		if err != nil {
+			log.FromContext(ctx).Debug("Unable to remove old backup %v: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func resolveSymlinks(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return target
+}
+
+// writes a temporary file next to the target and renames it, the rename is
+// atomic within a file system
+func writeAtomic(path string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".isetta-*")
+	if err != nil {
+		return fmt.Errorf("unable to write %v: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %v: %w", path, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// creates the link next to path and renames it over path
+func linkAtomic(target string, path string) error {
+	dir := filepath.Dir(path)
+	tmp := filepath.Join(dir, fmt.Sprintf(".%v.isetta-%v", filepath.Base(path), time.Now().UnixNano()))
+	err := os.Symlink(target, tmp)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to link %v to %v: %w", path, target, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// persists the rename, not supported by all file systems
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
@@ -0,0 +1,172 @@
+package safefile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+func setupStore(t *testing.T, keep int) (Store, string) {
+	return Store{Dir: t.TempDir(), Keep: keep}, t.TempDir()
+}
+
+func TestWriteBacksUpThePreviousContent(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	path := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0640)
+
+	assert.NoError(t, store.Write(ctx, path, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "nameserver 8.8.8.8\n", string(content))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	backups, err := store.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, path, backups[0].Path)
+	backupContent, _ := os.ReadFile(backups[0].File)
+	assert.Equal(t, "nameserver 1.1.1.1\n", string(backupContent))
+}
+
+func TestUnchangedContentIsNotWritten(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	path := filepath.Join(dir, "wsl.conf")
+	os.WriteFile(path, []byte("[boot]\n"), 0644)
+
+	assert.NoError(t, store.Write(ctx, path, []byte("[boot]\n"), 0644))
+
+	backups, _ := store.Backups()
+	assert.Empty(t, backups)
+}
+
+func TestNewFileNeedsNoBackup(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	path := filepath.Join(dir, "wsl.conf")
+
+	assert.NoError(t, store.Write(ctx, path, []byte("[boot]\n"), 0644))
+
+	assert.FileExists(t, path)
+	backups, _ := store.Backups()
+	assert.Empty(t, backups)
+}
+
+func TestSymlinkIsFollowed(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	target := filepath.Join(dir, "target.conf")
+	link := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(target, []byte("nameserver 1.1.1.1\n"), 0644)
+	os.Symlink(target, link)
+
+	assert.NoError(t, store.Write(ctx, link, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	destination, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, target, destination)
+	content, _ := os.ReadFile(target)
+	assert.Equal(t, "nameserver 8.8.8.8\n", string(content))
+}
+
+func TestOldBackupsArePruned(t *testing.T) {
+	store, dir := setupStore(t, 2)
+	path := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(path, []byte("0"), 0644)
+
+	for _, content := range []string{"1", "2", "3"} {
+		assert.NoError(t, store.Write(ctx, path, []byte(content), 0644))
+	}
+
+	backups, err := store.Backups()
+	assert.NoError(t, err)
+	assert.Len(t, backups, 2)
+	newest, _ := os.ReadFile(backups[0].File)
+	oldest, _ := os.ReadFile(backups[1].File)
+	assert.Equal(t, "2", string(newest))
+	assert.Equal(t, "1", string(oldest))
+}
+
+func TestRestoreBacksUpTheCurrentContent(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	path := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644)
+	store.Write(ctx, path, []byte("nameserver 8.8.8.8\n"), 0644)
+	backups, _ := store.Backups()
+
+	assert.NoError(t, store.Restore(ctx, backups[0]))
+
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "nameserver 1.1.1.1\n", string(content))
+	backups, _ = store.Backups()
+	assert.Len(t, backups, 2)
+}
+
+func TestRemoveBacksUpTheFile(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	path := filepath.Join(dir, "wsl.conf")
+	os.WriteFile(path, []byte("[network]\n"), 0644)
+
+	assert.NoError(t, store.Remove(ctx, path))
+	assert.NoError(t, store.Remove(ctx, path))
+
+	assert.NoFileExists(t, path)
+	backups, _ := store.Backups()
+	assert.Len(t, backups, 1)
+}
+
+func TestNoBackupsWithoutBackupDir(t *testing.T) {
+	store := Store{Dir: filepath.Join(t.TempDir(), "missing")}
+
+	backups, err := store.Backups()
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestDryRunChangesNothing(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	store.DryRun = true
+	path := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644)
+
+	assert.NoError(t, store.Write(ctx, path, []byte("nameserver 8.8.8.8\n"), 0644))
+	assert.NoError(t, store.Write(ctx, filepath.Join(dir, "wsl.conf"), []byte("[network]\n"), 0644))
+	assert.NoError(t, store.Remove(ctx, path))
+
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "nameserver 1.1.1.1\n", string(content))
+	assert.NoFileExists(t, filepath.Join(dir, "wsl.conf"))
+	backups, _ := store.Backups()
+	assert.Empty(t, backups)
+}
+
+func TestReplacedSymlinkIsRestored(t *testing.T) {
+	store, dir := setupStore(t, 10)
+	target := filepath.Join(dir, "stub-resolv.conf")
+	link := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(target, []byte("nameserver 127.0.0.53\n"), 0644)
+	os.Symlink(target, link)
+
+	assert.NoError(t, store.ReplaceSymlink(ctx, link, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	info, _ := os.Lstat(link)
+	assert.True(t, info.Mode().IsRegular())
+	content, _ := os.ReadFile(target)
+	assert.Equal(t, "nameserver 127.0.0.53\n", string(content))
+	backups, _ := store.Backups()
+	assert.Len(t, backups, 1)
+	assert.True(t, backups[0].Link)
+
+	assert.NoError(t, store.Restore(ctx, backups[0]))
+
+	destination, err := os.Readlink(link)
+	assert.NoError(t, err)
+	assert.Equal(t, target, destination)
+	backups, _ = store.Backups()
+	assert.Len(t, backups, 2)
+	assert.False(t, backups[0].Link)
+}
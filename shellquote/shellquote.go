@@ -0,0 +1,22 @@
+package shellquote
+
+// quotes values for a POSIX shell. Shared by the adapters which write commands
+// a shell parses, e.g. sourced exports or the WSL boot command.
+import "strings"
+
+// Quote returns the value as a single shell word. It is single quoted if needed
+// so that the shell doesn't split it or expand $ or backticks.
+func Quote(value string) string {
+	if value != "" && strings.IndexFunc(value, isUnsafeRune) == -1 {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func isUnsafeRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-_./:,@%+=", r)
+}
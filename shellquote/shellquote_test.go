@@ -0,0 +1,15 @@
+package shellquote
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "/home/me/ca.pem", Quote("/home/me/ca.pem"))
+	assert.Equal(t, "'/home/my dir/ca.pem'", Quote("/home/my dir/ca.pem"))
+	assert.Equal(t, "'/tmp/$HOME/`id`'", Quote("/tmp/$HOME/`id`"))
+	assert.Equal(t, `'it'\''s'`, Quote("it's"))
+	assert.Equal(t, "''", Quote(""))
+}
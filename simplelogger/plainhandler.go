@@ -0,0 +1,50 @@
+package simplelogger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+var plainPrefixes = map[slog.Level]string{
+	slogLevels[LevelTrace]: "Trace:",
+	slogLevels[LevelDebug]: "Debug:",
+	slogLevels[LevelInfo]:  "Info:",
+	slogLevels[LevelWarn]:  "Warn:",
+	// errors terminate isetta
+	slogLevels[LevelError]: "Fatal:",
+}
+
+// slog handler printing the human friendly "Info: some message" lines
+type plainHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (h *plainHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	line := fmt.Sprintf("%v %v", plainPrefixes[r.Level], r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		line += fmt.Sprintf(" %v=%v", a.Key, a.Value)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, line)
+	return err
+}
+
+// attributes and groups are not used by isetta
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *plainHandler) WithGroup(string) slog.Handler {
+	return h
+}
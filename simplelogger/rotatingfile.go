@@ -0,0 +1,85 @@
+package simplelogger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"org.samba/isetta/userdir"
+)
+
+// log file which is rotated once it exceeds 'maxSize' bytes.
+// Rotated files are named <path>.1 (newest) to <path>.<maxBackups> (oldest)
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	err := userdir.MkdirAll(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	return r, r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	// the log file is shared between runs via sudo and runs as the normal user
+	return userdir.Chown(r.path)
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+
+	if r.maxBackups < 1 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(backupName(r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupName(r.path, i), backupName(r.path, i+1))
+		}
+		err := os.Rename(r.path, backupName(r.path, 1))
+		if err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func backupName(path string, index int) string {
+	return fmt.Sprintf("%v.%d", path, index)
+}
@@ -2,9 +2,15 @@ package simplelogger
 
 // after a lot of investigation decided to roll my own simplelogger.
 // wanted basic logger + log levels - nothing more, so there you go.
+//
+// Output is based on log/slog and goes to stderr, so it does not mix with
+// the output of "-env-settings" which is meant to be 'source'd.
+// Optionally, everything is additionally written to a rotating log file.
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 
 	"golang.org/x/exp/maps"
@@ -38,12 +44,58 @@ var Levels = map[string]LogLevel{
 	"error": LevelError,
 }
 
-// global logger
+var slogLevels = map[LogLevel]slog.Level{
+	LevelTrace: slog.LevelDebug - 4,
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+const (
+	// previous format of this logger, e.g. "Info: Detecting network connection"
+	FormatPlain = "plain"
+	FormatText  = "text"
+	FormatJson  = "json"
+)
+
+var Formats = []string{FormatPlain, FormatText, FormatJson}
+
+// global logger, used without one in the context
 var Logger = NewSimpleLogger(LevelTrace)
 
+type contextKey struct{}
+
+// the logger of a run, e.g. of an isetta.Client
+func WithLogger(ctx context.Context, logger Sl) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+func FromContext(ctx context.Context) Sl {
+	if logger, ok := ctx.Value(contextKey{}).(Sl); ok {
+		return logger
+	}
+	return Logger
+}
+
 type Sl struct {
 	CurrentLogLevel LogLevel
-	logger *log.Logger
+	FileLogLevel    LogLevel
+	console         *slog.Logger
+	file            *slog.Logger // nil if file logging is disabled
+}
+
+type Options struct {
+	Level  LogLevel
+	Format string // one of Formats
+	File   *FileOptions // optional
+}
+
+type FileOptions struct {
+	Path       string
+	Level      LogLevel
+	MaxSize    int64 // in bytes
+	MaxBackups int
 }
 
 func GetValidLogLevels() []string {
@@ -53,47 +105,105 @@ func GetValidLogLevels() []string {
 func NewSimpleLogger(logLevel LogLevel) Sl {
 	return Sl{
 		CurrentLogLevel: logLevel,
-		logger: log.New(os.Stdout, "", 0),
+		console:         slog.New(newHandler(os.Stderr, FormatPlain)),
 	}
 }
 
-func (sl Sl) Trace(format string, v ...any) {
-	if sl.CurrentLogLevel <= LevelTrace {
-		sl.logger.Println("Trace:", buildOutput(format, v...))
+func New(options Options) (Sl, error) {
+	sl := Sl{
+		CurrentLogLevel: options.Level,
+		console:         slog.New(newHandler(os.Stderr, options.Format)),
 	}
+
+	if options.File != nil {
+		file, err := newRotatingFile(options.File.Path, options.File.MaxSize, options.File.MaxBackups)
+		if err != nil {
+			return sl, fmt.Errorf("unable to open log file %v: %w", options.File.Path, err)
+		}
+		sl.FileLogLevel = options.File.Level
+		sl.file = slog.New(newHandler(file, FormatJson))
+	}
+	return sl, nil
 }
 
-func (sl Sl)Debug(format string, v ...any) {
-	if sl.CurrentLogLevel <= LevelDebug {
-		sl.logger.Println("Debug:", buildOutput(format, v...))
+func newHandler(w io.Writer, format string) slog.Handler {
+	options := &slog.HandlerOptions{
+		// filtering is done by Sl itself
+		Level:       slogLevels[LevelTrace],
+		ReplaceAttr: replaceLevelName,
+	}
+
+	switch format {
+	case FormatText:
+		return slog.NewTextHandler(w, options)
+	case FormatJson:
+		return slog.NewJSONHandler(w, options)
+	default:
+		return &plainHandler{w: w}
 	}
 }
 
-func (sl Sl)Info(format string, v ...any) {
-	if sl.CurrentLogLevel <= LevelInfo {
-		sl.logger.Println("Info:", buildOutput(format, v...))
+// use the names of 'Levels' instead of slog's built-in ones
+func replaceLevelName(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		a.Value = slog.StringValue(levelName(a.Value.Any().(slog.Level)))
 	}
+	return a
 }
 
-func (sl Sl)Warn(format string, v ...any) {
-	if sl.CurrentLogLevel <= LevelWarn {
-		sl.logger.Println("Warn:", buildOutput(format, v...))
+func levelName(level slog.Level) string {
+	for logLevel, slogLevel := range slogLevels {
+		if slogLevel == level {
+			for name, l := range Levels {
+				if l == logLevel {
+					return name
+				}
+			}
+		}
 	}
+	return level.String()
+}
+
+func (sl Sl) Trace(format string, v ...any) {
+	sl.output(LevelTrace, buildOutput(format, v...))
+}
+
+func (sl Sl)Debug(format string, v ...any) {
+	sl.output(LevelDebug, buildOutput(format, v...))
+}
+
+func (sl Sl)Info(format string, v ...any) {
+	sl.output(LevelInfo, buildOutput(format, v...))
+}
+
+func (sl Sl)Warn(format string, v ...any) {
+	sl.output(LevelWarn, buildOutput(format, v...))
 }
 
 func (sl Sl)Error(format string, v ...any) {
 	if sl.CurrentLogLevel <= LevelError {
-		sl.logger.Fatalln("Fatal:", buildOutput(format, v...))
+		sl.output(LevelError, buildOutput(format, v...))
+		os.Exit(1)
 	}
 }
 
 func (sl Sl)Error2(err error) {
 	if sl.CurrentLogLevel <= LevelError {
-		sl.logger.Fatalln("Fatal:", err)
+		sl.output(LevelError, err.Error())
+		os.Exit(1)
 	}
 }
 
+func (sl Sl) output(level LogLevel, msg string) {
+	msg = Redact(msg)
+	if sl.CurrentLogLevel <= level {
+		sl.console.Log(context.Background(), slogLevels[level], msg)
+	}
+	if sl.file != nil && sl.FileLogLevel <= level {
+		sl.file.Log(context.Background(), slogLevels[level], msg)
+	}
+}
 
 func buildOutput(format string, v ...any)  string {
 	return fmt.Sprintf(format, v...)
-}
\ No newline at end of file
+}
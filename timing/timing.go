@@ -0,0 +1,215 @@
+package timing
+
+// records how long the individual steps of an isetta run take.
+// Steps nest: a step started while another one is running becomes its child.
+//
+// usage:
+//
+//	defer timing.Start("Configuring Windows side").End()
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"org.samba/isetta/progress"
+)
+
+type Step struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Retries  int
+	Steps    []*Step
+	parent   *Step
+	profile  *Profile
+	parallel bool
+	done     bool
+}
+
+type Profile struct {
+	mu      sync.Mutex
+	root    *Step
+	current *Step
+	// optional, receives the started and finished steps and retries
+	Events *progress.Dispatcher
+}
+
+// global profile, reports to the global progress dispatcher
+var Default = newDefaultProfile()
+
+func newDefaultProfile() *Profile {
+	p := NewProfile("isetta")
+	p.Events = progress.Default
+	return p
+}
+
+func NewProfile(name string) *Profile {
+	p := &Profile{}
+	p.root = &Step{Name: name, Start: time.Now(), profile: p}
+	p.current = p.root
+	return p
+}
+
+func Start(name string) *Step {
+	return Default.Start(name)
+}
+
+// starts a step whose sub steps run concurrently. Since the nesting of
+// concurrent steps can't be determined, they are all attached directly to it
+func StartParallel(name string) *Step {
+	return Default.StartParallel(name)
+}
+
+func (p *Profile) Start(name string) *Step {
+	return p.start(name, false)
+}
+
+func (p *Profile) StartParallel(name string) *Step {
+	return p.start(name, true)
+}
+
+func (p *Profile) start(name string, parallel bool) *Step {
+	p.mu.Lock()
+	step := &Step{Name: name, Start: time.Now(), parent: p.current, profile: p, parallel: parallel}
+	p.current.Steps = append(p.current.Steps, step)
+	if !p.current.parallel {
+		p.current = step
+	}
+	p.mu.Unlock()
+
+	// observers are notified outside the lock, they might start steps themselves
+	p.emit(progress.Event{Kind: progress.StepStarted, Step: name, Depth: step.depth(), Time: step.Start})
+	return step
+}
+
+func (s *Step) End() {
+	s.profile.end(s)
+}
+
+// counts an additional attempt, e.g. in a retry loop
+func (s *Step) AddRetry() {
+	s.profile.mu.Lock()
+	s.Retries++
+	attempt := s.Retries + 1
+	s.profile.mu.Unlock()
+
+	s.profile.emit(progress.Event{Kind: progress.RetryAttempt, Step: s.Name, Depth: s.depth(), Attempt: attempt})
+}
+
+func (p *Profile) end(step *Step) {
+	p.mu.Lock()
+	if step.done {
+		p.mu.Unlock()
+		return
+	}
+	step.done = true
+	step.Duration = time.Since(step.Start)
+	if p.current == step {
+		p.current = step.parent
+	}
+	p.mu.Unlock()
+
+	p.emit(progress.Event{Kind: progress.StepFinished, Step: step.Name, Depth: step.depth(), Duration: step.Duration})
+}
+
+func (p *Profile) emit(e progress.Event) {
+	if p.Events != nil {
+		p.Events.Emit(e)
+	}
+}
+
+// 1 for top-level steps. The parents of a step never change
+func (s *Step) depth() int {
+	depth := 0
+	for step := s; step.parent != nil; step = step.parent {
+		depth++
+	}
+	return depth
+}
+
+// finishes the root step and returns it
+func (p *Profile) Finish() *Step {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.root.Duration = time.Since(p.root.Start)
+	return p.root
+}
+
+// human readable tree of all steps
+func (p *Profile) PrintTree(w io.Writer) {
+	root := p.Finish()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(w, "Timings:")
+	printStep(w, root, 1)
+}
+
+func printStep(w io.Writer, step *Step, depth int) {
+	line := fmt.Sprintf("%v%8.3fs  %v", strings.Repeat("  ", depth), step.Duration.Seconds(), step.Name)
+	if step.Retries > 0 {
+		line += fmt.Sprintf(" (%d retries)", step.Retries)
+	}
+	if !step.done && step.parent != nil {
+		line += " (not finished)"
+	}
+	fmt.Fprintln(w, line)
+
+	for _, child := range step.Steps {
+		printStep(w, child, depth+1)
+	}
+}
+
+type Report struct {
+	Version    string     `json:"version"`
+	Hostname   string     `json:"hostname"`
+	Start      time.Time  `json:"start"`
+	DurationMs int64      `json:"duration_ms"`
+	Steps      []jsonStep `json:"steps"`
+}
+
+type jsonStep struct {
+	Name       string     `json:"name"`
+	DurationMs int64      `json:"duration_ms"`
+	Retries    int        `json:"retries,omitempty"`
+	Steps      []jsonStep `json:"steps,omitempty"`
+}
+
+// writes the profile as a single JSON line, so reports of many runs
+// can be collected in one file
+func (p *Profile) WriteJson(w io.Writer, version string, hostname string) error {
+	root := p.Finish()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := Report{
+		Version:    version,
+		Hostname:   hostname,
+		Start:      root.Start,
+		DurationMs: root.Duration.Milliseconds(),
+		Steps:      toJsonSteps(root.Steps),
+	}
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(line))
+	return err
+}
+
+func toJsonSteps(steps []*Step) []jsonStep {
+	result := []jsonStep{}
+	for _, step := range steps {
+		result = append(result, jsonStep{
+			Name:       step.Name,
+			DurationMs: step.Duration.Milliseconds(),
+			Retries:    step.Retries,
+			Steps:      toJsonSteps(step.Steps),
+		})
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package timing
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"org.samba/isetta/progress"
+)
+
+func TestStepsAreNested(t *testing.T) {
+	profile := NewProfile("isetta")
+
+	outer := profile.Start("Configuring network")
+	inner := profile.Start("Setting Windows portproxy")
+	inner.AddRetry()
+	inner.AddRetry()
+	inner.End()
+	outer.End()
+	profile.Start("Checking access").End()
+
+	root := profile.Finish()
+	assert.Len(t, root.Steps, 2)
+	assert.Equal(t, "Configuring network", root.Steps[0].Name)
+	assert.Equal(t, "Setting Windows portproxy", root.Steps[0].Steps[0].Name)
+	assert.Equal(t, 2, root.Steps[0].Steps[0].Retries)
+	assert.Equal(t, "Checking access", root.Steps[1].Name)
+}
+
+func TestEndingAStepTwiceIsIgnored(t *testing.T) {
+	profile := NewProfile("isetta")
+
+	outer := profile.Start("outer")
+	inner := profile.Start("inner")
+	inner.End()
+	inner.End()
+	profile.Start("sibling").End()
+	outer.End()
+
+	assert.Len(t, profile.Finish().Steps[0].Steps, 2)
+}
+
+func TestPrintTree(t *testing.T) {
+	profile := NewProfile("isetta")
+	step := profile.Start("Setting Windows portproxy")
+	step.AddRetry()
+	step.End()
+	profile.Start("Never finished")
+
+	var out bytes.Buffer
+	profile.PrintTree(&out)
+
+	assert.Regexp(t, "(?m)^Timings:$", out.String())
+	assert.Regexp(t, `(?m)^  +\d+\.\d{3}s  isetta$`, out.String())
+	assert.Regexp(t, `(?m)^    +\d+\.\d{3}s  Setting Windows portproxy \(1 retries\)$`, out.String())
+	assert.Regexp(t, `(?m)Never finished \(not finished\)$`, out.String())
+}
+
+func TestWriteJson(t *testing.T) {
+	profile := NewProfile("isetta")
+	profile.Start("Checking internet access").End()
+
+	var out bytes.Buffer
+	assert.NoError(t, profile.WriteJson(&out, "1.0.0", "laptop"))
+
+	var report Report
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, "1.0.0", report.Version)
+	assert.Equal(t, "laptop", report.Hostname)
+	assert.Equal(t, "Checking internet access", report.Steps[0].Name)
+}
+
+func TestStepsOfParallelStepAreFlat(t *testing.T) {
+	profile := NewProfile("isetta")
+
+	parallel := profile.StartParallel("Detecting network")
+	probe1 := profile.Start("probe 1")
+	probe2 := profile.Start("probe 2")
+	probe1.End()
+	probe2.End()
+	parallel.End()
+	profile.Start("after").End()
+
+	root := profile.Finish()
+	assert.Len(t, root.Steps, 2)
+	assert.Len(t, root.Steps[0].Steps, 2)
+	assert.Equal(t, "after", root.Steps[1].Name)
+}
+
+func TestStepsAreReportedAsProgressEvents(t *testing.T) {
+	profile := NewProfile("isetta")
+	profile.Events = &progress.Dispatcher{}
+	var events []progress.Event
+	profile.Events.Subscribe(progress.ObserverFunc(func(e progress.Event) { events = append(events, e) }))
+
+	outer := profile.Start("Configuring network")
+	inner := profile.Start("Setting Windows portproxy")
+	inner.AddRetry()
+	inner.End()
+	inner.End()
+	outer.End()
+
+	kinds := []progress.Kind{}
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []progress.Kind{progress.StepStarted, progress.StepStarted, progress.RetryAttempt, progress.StepFinished, progress.StepFinished}, kinds)
+	assert.Equal(t, 2, events[1].Depth)
+	assert.Equal(t, 2, events[2].Attempt)
+	assert.Equal(t, "Setting Windows portproxy", events[3].Step)
+	assert.Equal(t, 1, events[4].Depth)
+}
@@ -0,0 +1,99 @@
+package userdir
+
+// isetta usually runs via sudo. Per-user files (logs, state) should nevertheless
+// end up in the home of the calling user and be owned by that user.
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// returns the isetta state directory, usually ~/.local/state/isetta
+func StateDir() (string, error) {
+	if xdgStateHome := os.Getenv("XDG_STATE_HOME"); xdgStateHome != "" {
+		return filepath.Join(xdgStateHome, "isetta"), nil
+	}
+
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "isetta"), nil
+}
+
+// home directory of the calling user, also when running via sudo
+func HomeDir() (string, error) {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && os.Geteuid() == 0 {
+		u, err := user.Lookup(sudoUser)
+		if err == nil {
+			return u.HomeDir, nil
+		}
+	}
+	return os.UserHomeDir()
+}
+
+// The variables sudo sets for the calling user, e.g. 'HOME=/home/peter'.
+// Runs without sudo, e.g. at WSL start, pass them along to find the same files
+func SudoEnv() ([]string, error) {
+	var u *user.User
+	var err error
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		u, err = user.Lookup(sudoUser)
+	} else {
+		u, err = user.Current()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine the calling user: %w", err)
+	}
+	return []string{
+		"HOME=" + u.HomeDir,
+		"SUDO_USER=" + u.Username,
+		"SUDO_UID=" + u.Uid,
+		"SUDO_GID=" + u.Gid,
+	}, nil
+}
+
+// creates the directory including parents. All directories created, e.g. a
+// missing ~/.local/state as well, are owned by the calling user
+func MkdirAll(dir string) error {
+	missing := missingDirs(filepath.Clean(dir))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, d := range missing {
+		if err := Chown(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the directory and its ancestors which don't exist yet, outermost first
+func missingDirs(dir string) []string {
+	var missing []string
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return missing
+}
+
+// hands files created via sudo back to the calling user. Does nothing without sudo
+func Chown(path string) error {
+	uid, errUid := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, errGid := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if errUid != nil || errGid != nil || os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
@@ -0,0 +1,62 @@
+package userdir
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateDirHonoursXdgStateHome(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+
+	dir, err := StateDir()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/state/isetta", dir)
+}
+
+func TestStateDirDefaultsToLocalState(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("SUDO_USER", "")
+	t.Setenv("HOME", "/home/peter")
+
+	dir, err := StateDir()
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/peter/.local/state/isetta", dir)
+}
+
+func TestMkdirAll(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+
+	assert.NoError(t, MkdirAll(dir))
+	assert.DirExists(t, dir)
+}
+
+func TestMkdirAllHandsCreatedParentsToTheCallingUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	t.Setenv("SUDO_UID", "4242")
+	t.Setenv("SUDO_GID", "4243")
+	existing := t.TempDir()
+	dir := filepath.Join(existing, "a", "b")
+
+	assert.NoError(t, MkdirAll(dir))
+	for path, uid := range map[string]uint32{existing: 0, filepath.Join(existing, "a"): 4242, dir: 4242} {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, uid, info.Sys().(*syscall.Stat_t).Uid, path)
+	}
+}
+
+func TestSudoEnvOfCallingUser(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+	u, _ := user.Current()
+
+	env, err := SudoEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HOME=" + u.HomeDir, "SUDO_USER=" + u.Username, "SUDO_UID=" + u.Uid, "SUDO_GID=" + u.Gid}, env)
+}